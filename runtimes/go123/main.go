@@ -1,49 +1,215 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
+const (
+	// transactionHeader and traceParentHeader correlate a run with the
+	// invoker request that triggered it
+	transactionHeader = "X-OW-Transaction-Id"
+	traceParentHeader = "traceparent"
+
+	// annotationsKey is the reserved result key actions put custom
+	// activation annotations under
+	annotationsKey = "__ow_annotations"
+)
+
 var (
 	compiledBinary string
+	actionDir      string // build directory holding compiledBinary
 	actionEnv      map[string]string
+	initParams     map[string]interface{} // bound params, overridden by run params
 	actionMu       sync.RWMutex
+
+	// cleanEnv starts actions from an empty environment instead of inheriting
+	// the runtime's own (default on, set CLEAN_ENV=false to inherit)
+	cleanEnv = os.Getenv("CLEAN_ENV") != "false"
+
+	// maxSourceBytes caps the size of action source accepted by /init
+	// (set MAX_SOURCE_BYTES, default 8 MiB)
+	maxSourceBytes = envInt("MAX_SOURCE_BYTES", 8<<20)
+
+	// maxEnvVars and maxEnvBytes bound the env /init sets for the action, so
+	// it can't exceed exec limits (E2BIG) when runs start
+	// (set MAX_ENV_VARS, default 1000, and MAX_ENV_BYTES, default 256 KiB)
+	maxEnvVars  = envInt("MAX_ENV_VARS", 1000)
+	maxEnvBytes = envInt("MAX_ENV_BYTES", 256<<10)
+
+	// buildSlots limits concurrent compilations so a burst of inits doesn't
+	// saturate the CPU (set MAX_CONCURRENT_BUILDS, default 2)
+	buildSlots = make(chan struct{}, envInt("MAX_CONCURRENT_BUILDS", 2))
+
+	// buildQueueTimeout bounds how long an init waits for a build slot
+	// (set BUILD_QUEUE_TIMEOUT_SECONDS, default 60)
+	buildQueueTimeout = time.Duration(envInt("BUILD_QUEUE_TIMEOUT_SECONDS", 60)) * time.Second
+
+	// buildMemoryMB caps the address space of go build and its compiler
+	// subprocesses so huge sources fail the init instead of OOMing the
+	// runtime (set BUILD_MEMORY_LIMIT_MB, default unlimited)
+	buildMemoryMB = envInt("BUILD_MEMORY_LIMIT_MB", 0)
+
+	// staleTempAge is how old a leftover action-* build directory must be
+	// before startup reclaims it (set STALE_TEMP_MINUTES, default 60)
+	staleTempAge = time.Duration(envInt("STALE_TEMP_MINUTES", 60)) * time.Minute
+
+	// binaryCacheDir holds compiled binaries keyed by their build inputs so
+	// re-inits with the same source skip compilation
+	// (set BINARY_CACHE_DIR, default go-binary-cache in the temp dir)
+	binaryCacheDir = envString("BINARY_CACHE_DIR", filepath.Join(os.TempDir(), "go-binary-cache"))
+
+	// maxCachedBinaries caps the binary cache, evicting the least recently
+	// used binaries beyond it (set MAX_CACHED_BINARIES, default 32)
+	maxCachedBinaries = envInt("MAX_CACHED_BINARIES", 32)
+
+	// killWaitTimeout bounds how long a killed action is waited on to be
+	// reaped (set KILL_WAIT_SECONDS, default 5)
+	killWaitTimeout = time.Duration(envInt("KILL_WAIT_SECONDS", 5)) * time.Second
+
+	// resultWrapKey is the key non-JSON action output is wrapped under
+	// (set RESULT_WRAP_KEY, default "body")
+	resultWrapKey = envString("RESULT_WRAP_KEY", "body")
+
+	// goBinary is the go toolchain actions are compiled with, for pinning a
+	// specific Go version (set GO_BINARY, default go on PATH)
+	goBinary = envString("GO_BINARY", "go")
+
+	// readHeaderTimeout bounds how long a client may take to send request
+	// headers, so a slow client can't hold the runtime's connection open
+	// (set READ_HEADER_TIMEOUT_SECONDS, default 10)
+	readHeaderTimeout = time.Duration(envInt("READ_HEADER_TIMEOUT_SECONDS", 10)) * time.Second
+
+	// readTimeout bounds reading a whole request, body included
+	// (set READ_TIMEOUT_SECONDS, default 60)
+	readTimeout = time.Duration(envInt("READ_TIMEOUT_SECONDS", 60)) * time.Second
+
+	// writeTimeout bounds handling and writing a response. It is generous
+	// so /init has room to compile; /run lifts it, being bounded by the
+	// action's own deadline instead (set WRITE_TIMEOUT_SECONDS, default 300)
+	writeTimeout = time.Duration(envInt("WRITE_TIMEOUT_SECONDS", 300)) * time.Second
+
+	// minCompileBudget is the least time before the activation deadline an
+	// init needs to start compiling (set MIN_COMPILE_SECONDS, default 2)
+	minCompileBudget = time.Duration(envInt("MIN_COMPILE_SECONDS", 2)) * time.Second
+
+	// goVersion is the toolchain's `go version` output, reported by /health
+	goVersion string
 )
 
+// envInt reads a positive integer from the environment, or returns fallback
+func envInt(key string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// envString reads a non-empty string from the environment, or returns fallback
+func envString(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// cleanEnvPasslist holds runtime variables actions still need in clean mode
+var cleanEnvPasslist = []string{"PATH", "HOME", "TMPDIR", "LANG", "TZ"}
+
+// baseEnv returns the environment an action process starts from
+func baseEnv() []string {
+	if !cleanEnv {
+		return os.Environ()
+	}
+
+	env := make([]string, 0, len(cleanEnvPasslist))
+	for _, key := range cleanEnvPasslist {
+		if value, ok := os.LookupEnv(key); ok {
+			env = append(env, fmt.Sprintf("%s=%s", key, value))
+		}
+	}
+	return env
+}
+
 type InitRequest struct {
 	Value struct {
-		Code   string            `json:"code"`
-		Binary bool              `json:"binary"`
-		Main   string            `json:"main"`
-		Env    map[string]string `json:"env"`
+		Code        string                 `json:"code"`
+		Binary      bool                   `json:"binary"`
+		Main        string                 `json:"main"`
+		Env         map[string]string      `json:"env"`
+		InitParams  map[string]interface{} `json:"init_params"`
+		BuildFlags  []string               `json:"build_flags"`
+		GoReplaces  map[string]string      `json:"go_replaces"` // module path -> module@version or local directory
+		Diagnostics bool                   `json:"diagnostics"` // return parsed compile errors
+		Deadline    int64                  `json:"deadline"`    // unix ms the activation must finish by
 	} `json:"value"`
 }
 
 type RunRequest struct {
-	Value      map[string]interface{} `json:"value"`
-	Activation struct {
-		ID          string `json:"activationId"`
-		Namespace   string `json:"namespace"`
-		ActionName  string `json:"action_name"`
-		APIHost     string `json:"api_host"`
-		APIKey      string `json:"api_key"`
-		Deadline    int64  `json:"deadline"`
+	Value         map[string]interface{} `json:"value"`
+	RawInput      bool                   `json:"raw_input"`
+	RawBody       []byte                 `json:"raw_body"`       // base64 in JSON, passed verbatim on stdin when RawInput is set
+	StdinFormat   string                 `json:"stdin_format"`   // json (default), json-line or length-prefixed
+	StdinProtocol string                 `json:"stdin_protocol"` // env (default) or actionloop
+	Activation    struct {
+		ID         string `json:"activationId"`
+		Namespace  string `json:"namespace"`
+		ActionName string `json:"action_name"`
+		APIHost    string `json:"api_host"`
+		APIKey     string `json:"api_key"`
+		Deadline   int64  `json:"deadline"`
 	} `json:"activation"`
 }
 
+type InitResponse struct {
+	OK          bool  `json:"ok"`
+	CompileMs   int64 `json:"compileMs"`
+	BinaryBytes int64 `json:"binaryBytes"`
+	CacheHit    bool  `json:"cacheHit"` // binary reused from the build cache
+}
+
+// StreamChunk is one NDJSON line of a streamed run: partial chunks followed
+// by a final result or error
+type StreamChunk struct {
+	Type     string                 `json:"type"` // "chunk", "result" or "error"
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	ExitCode int                    `json:"exitCode,omitempty"`
+}
+
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error       string       `json:"error"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	ExitCode    int          `json:"exitCode,omitempty"` // set when the action process exited non-zero
+}
+
+// Diagnostic is one compiler error located in the action source
+type Diagnostic struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column,omitempty"`
+	Message string `json:"message"`
 }
 
 func initHandler(w http.ResponseWriter, r *http.Request) {
@@ -69,72 +235,238 @@ func initHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create temp directory for compilation
-	tmpDir, err := os.MkdirTemp("", "action-*")
+	if len(req.Value.Code) > maxSourceBytes {
+		fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Action code exceeds maximum size of %d bytes", maxSourceBytes)})
+		return
+	}
+
+	if err := validateEnv(req.Value.Env); err != nil {
+		fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	flags, err := buildFlags(req.Value.BuildFlags)
 	if err != nil {
 		fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to create temp directory: " + err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	// Write code to file
-	srcFile := filepath.Join(tmpDir, "main.go")
-	if err := os.WriteFile(srcFile, []byte(req.Value.Code), 0644); err != nil {
-		os.RemoveAll(tmpDir)
+	replaces, err := goReplaces(req.Value.GoReplaces)
+	if err != nil {
 		fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to write code: " + err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
 		return
 	}
 
-	// Initialize go.mod
-	modCmd := exec.Command("go", "mod", "init", "action")
-	modCmd.Dir = tmpDir
-	if err := modCmd.Run(); err != nil {
-		os.RemoveAll(tmpDir)
+	// Create temp directory for compilation
+	tmpDir, err := os.MkdirTemp("", "action-*")
+	if err != nil {
 		fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadGateway)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to initialize module: " + err.Error()})
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to create temp directory: " + err.Error()})
 		return
 	}
 
-	// Compile the code
+	// Reuse a binary built earlier from the same source, flags and replaces
 	binaryPath := filepath.Join(tmpDir, "action")
-	var compileErr bytes.Buffer
-	buildCmd := exec.Command("go", "build", "-o", binaryPath, srcFile)
-	buildCmd.Dir = tmpDir
-	buildCmd.Stderr = &compileErr
+	cacheKey := buildCacheKey(req.Value.Code, flags, replaces)
+	cacheHit := restoreCachedBinary(cacheKey, binaryPath)
+	metrics.observeBuildCache(cacheHit)
+
+	var compileDuration time.Duration
+	if !cacheHit {
+		// Budget the compile against the activation deadline, failing fast
+		// rather than leaving the run no time
+		compileCtx := context.Background()
+		if req.Value.Deadline > 0 {
+			deadline := time.UnixMilli(req.Value.Deadline)
+			if time.Until(deadline) < minCompileBudget {
+				os.RemoveAll(tmpDir)
+				fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusGatewayTimeout)
+				json.NewEncoder(w).Encode(ErrorResponse{Error: "Insufficient time to compile within deadline"})
+				return
+			}
+			var cancelCompile context.CancelFunc
+			compileCtx, cancelCompile = context.WithDeadline(compileCtx, deadline)
+			defer cancelCompile()
+		}
+
+		// Write code to file
+		srcFile := filepath.Join(tmpDir, "main.go")
+		if err := os.WriteFile(srcFile, []byte(req.Value.Code), 0644); err != nil {
+			os.RemoveAll(tmpDir)
+			fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to write code: " + err.Error()})
+			return
+		}
+
+		// Wait for a build slot before module setup, which resolves
+		// dependencies and is as heavy as the build, giving up at the request
+		// or activation deadline
+		queueCtx, cancelQueue := context.WithTimeout(r.Context(), buildQueueTimeout)
+		defer cancelQueue()
+		if compileDeadline, ok := compileCtx.Deadline(); ok {
+			var cancelDeadline context.CancelFunc
+			queueCtx, cancelDeadline = context.WithDeadline(queueCtx, compileDeadline)
+			defer cancelDeadline()
+		}
+		select {
+		case buildSlots <- struct{}{}:
+		case <-queueCtx.Done():
+			os.RemoveAll(tmpDir)
+			fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+			w.Header().Set("Content-Type", "application/json")
+			if compileCtx.Err() != nil {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				json.NewEncoder(w).Encode(ErrorResponse{Error: "Insufficient time to compile within deadline"})
+				return
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: fmt.Sprintf("Timed out waiting for a build slot (%d concurrent builds allowed)", cap(buildSlots))})
+			return
+		}
+		releaseSlot := sync.OnceFunc(func() { <-buildSlots })
+		defer releaseSlot()
+
+		// Initialize go.mod. Module setup counts against the compile budget
+		modCmd := exec.CommandContext(compileCtx, goBinary, "mod", "init", "action")
+		modCmd.Dir = tmpDir
+		if err := modCmd.Run(); err != nil {
+			os.RemoveAll(tmpDir)
+			fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+			w.Header().Set("Content-Type", "application/json")
+			if compileCtx.Err() != nil {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				json.NewEncoder(w).Encode(ErrorResponse{Error: "Compilation did not finish within deadline"})
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to initialize module: " + err.Error()})
+			return
+		}
 
-	if err := buildCmd.Run(); err != nil {
+		// Apply replace directives and resolve the modules they satisfy
+		if len(replaces) > 0 {
+			var modErr bytes.Buffer
+			editArgs := append([]string{"mod", "edit"}, replaces...)
+			for _, args := range [][]string{editArgs, {"mod", "tidy"}} {
+				cmd := exec.CommandContext(compileCtx, goBinary, args...)
+				cmd.Dir = tmpDir
+				cmd.Stderr = &modErr
+				if err := cmd.Run(); err != nil {
+					os.RemoveAll(tmpDir)
+					fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+					w.Header().Set("Content-Type", "application/json")
+					if compileCtx.Err() != nil {
+						w.WriteHeader(http.StatusGatewayTimeout)
+						json.NewEncoder(w).Encode(ErrorResponse{Error: "Compilation did not finish within deadline"})
+						return
+					}
+					w.WriteHeader(http.StatusBadGateway)
+					errMsg := strings.TrimSpace(modErr.String())
+					if errMsg == "" {
+						errMsg = err.Error()
+					}
+					json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to apply module replaces: " + errMsg})
+					return
+				}
+			}
+		}
+
+		// Compile the code
+		var compileErr bytes.Buffer
+		buildArgs := append([]string{"build"}, flags...)
+		buildArgs = append(buildArgs, "-o", binaryPath, srcFile)
+		buildCmd := buildCommand(compileCtx, buildArgs...)
+		buildCmd.Dir = tmpDir
+		buildCmd.Stderr = &compileErr
+
+		compileStart := time.Now()
+		buildErr := buildCmd.Run()
+		releaseSlot()
+		if err := buildErr; err != nil {
+			os.RemoveAll(tmpDir)
+			fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+			w.Header().Set("Content-Type", "application/json")
+			if compileCtx.Err() != nil {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				json.NewEncoder(w).Encode(ErrorResponse{Error: "Compilation did not finish within deadline"})
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
+			errMsg := strings.TrimSpace(compileErr.String())
+			if errMsg == "" {
+				errMsg = err.Error()
+			}
+			resp := ErrorResponse{Error: "Compilation failed: " + errMsg}
+			if buildMemoryMB > 0 && exceededMemory(compileErr.String(), err) {
+				resp.Error = fmt.Sprintf("Compilation failed: compile exceeded memory limit of %d MB", buildMemoryMB)
+			}
+			if req.Value.Diagnostics {
+				resp.Diagnostics = parseDiagnostics(compileErr.String())
+			}
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+		compileDuration = time.Since(compileStart)
+		metrics.observeCompile(compileDuration)
+		storeCachedBinary(cacheKey, binaryPath)
+	}
+
+	binaryInfo, err := os.Stat(binaryPath)
+	if err != nil {
 		os.RemoveAll(tmpDir)
 		fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadGateway)
-		errMsg := strings.TrimSpace(compileErr.String())
-		if errMsg == "" {
-			errMsg = err.Error()
-		}
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Compilation failed: " + errMsg})
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to stat compiled binary: " + err.Error()})
 		return
 	}
 
-	// Store compiled binary path and environment
+	// Store compiled binary path and environment, replacing everything a
+	// previous init left behind when the container is reused for another action
 	actionMu.Lock()
+	previousDir := actionDir
 	compiledBinary = binaryPath
+	actionDir = tmpDir
 	actionEnv = req.Value.Env
 	if actionEnv == nil {
 		actionEnv = make(map[string]string)
 	}
+	initParams = req.Value.InitParams
 	actionMu.Unlock()
 
+	if previousDir != "" {
+		if err := os.RemoveAll(previousDir); err != nil {
+			fmt.Printf("Failed to remove previous action dir %s: %v\n", previousDir, err)
+		}
+	}
+
 	fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]bool{"ok": true})
+	json.NewEncoder(w).Encode(InitResponse{
+		OK:          true,
+		CompileMs:   compileDuration.Milliseconds(),
+		BinaryBytes: binaryInfo.Size(),
+		CacheHit:    cacheHit,
+	})
 }
 
 func runHandler(w http.ResponseWriter, r *http.Request) {
@@ -143,9 +475,16 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Runs may legitimately outlast the server's write timeout; the action
+	// deadline bounds them instead
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to lift write deadline for run: %v\n", err)
+	}
+
 	actionMu.RLock()
 	binary := compiledBinary
 	env := actionEnv
+	bound := initParams
 	actionMu.RUnlock()
 
 	if binary == "" {
@@ -160,6 +499,7 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		req.Value = make(map[string]interface{})
 	}
+	req.Value = mergeParams(bound, req.Value)
 
 	// Prepare parameters as JSON
 	paramsJSON, err := json.Marshal(req.Value)
@@ -171,13 +511,22 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Set up command with environment variables
+	// Set up command with environment variables. The action gets its own
+	// process group so a timeout kills any children it spawned too, and Wait
+	// gives up on output pipes held open by escaped descendants
 	cmd := exec.Command(binary)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.WaitDelay = killWaitTimeout
 
-	// Set action environment
-	cmd.Env = os.Environ()
+	// Set action environment, expanding activation templates per run
+	cmd.Env = baseEnv()
+	envTemplates := strings.NewReplacer(
+		"${OW_NAMESPACE}", req.Activation.Namespace,
+		"${OW_ACTION_NAME}", req.Activation.ActionName,
+		"${OW_ACTIVATION_ID}", req.Activation.ID,
+	)
 	for k, v := range env {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, envTemplates.Replace(v)))
 	}
 
 	// Set OpenWhisk environment variables
@@ -189,13 +538,39 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 	cmd.Env = append(cmd.Env, fmt.Sprintf("__OW_DEADLINE=%d", req.Activation.Deadline))
 	cmd.Env = append(cmd.Env, fmt.Sprintf("__OW_ACTIVATION_BODY=%s", string(paramsJSON)))
 
-	// Set stdin with parameters
-	cmd.Stdin = bytes.NewReader(paramsJSON)
+	// Correlate the action's logs with the invoker's request
+	transactionID := r.Header.Get(transactionHeader)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("__OW_TRANSACTION_ID=%s", transactionID))
+	if traceParent := r.Header.Get(traceParentHeader); traceParent != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("TRACEPARENT=%s", traceParent))
+	}
+	logPrefix := ""
+	if transactionID != "" {
+		logPrefix = "[" + transactionID + "] "
+	}
 
-	// Capture stdout and stderr
-	var stdout, stderr bytes.Buffer
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	// Set stdin with raw bytes for binary actions, framed parameters otherwise
+	if req.RawInput {
+		cmd.Stdin = bytes.NewReader(req.RawBody)
+	} else {
+		payload, err := stdinPayload(&req, paramsJSON)
+		if err != nil {
+			fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+			return
+		}
+		stdin, err := frameStdin(payload, req.StdinFormat)
+		if err != nil {
+			fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+			return
+		}
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
 
 	// Set timeout (default 60 seconds if no deadline)
 	timeout := 60 * time.Second
@@ -207,53 +582,73 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Stream partial results as NDJSON when requested
+	if r.URL.Query().Get("stream") == "1" {
+		runStreaming(w, cmd, timeout, logPrefix)
+		return
+	}
+
+	// Capture stdout and stderr
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
 	// Run with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	cmd.SysProcAttr = nil
-
+	// Start before waiting in the background, so a timeout never races the
+	// start for the process it has to kill
 	errChan := make(chan error, 1)
-	go func() {
-		errChan <- cmd.Run()
-	}()
+	if err := cmd.Start(); err != nil {
+		errChan <- err
+	} else {
+		go func() {
+			errChan <- cmd.Wait()
+		}()
+	}
 
 	var runErr error
+	timedOut := false
 	select {
 	case <-ctx.Done():
-		cmd.Process.Kill()
+		timedOut = true
+		killProcessGroup(cmd)
+		// Reap the action so it doesn't linger as a zombie, without hanging
+		// on one that won't die
+		select {
+		case <-errChan:
+		case <-time.After(killWaitTimeout):
+			fmt.Fprintf(os.Stderr, "Action not reaped within %v of being killed\n", killWaitTimeout)
+		}
 		runErr = fmt.Errorf("action timed out after %v", timeout)
 	case runErr = <-errChan:
 	}
 
 	// Print stderr as logs
-	if stderr.Len() > 0 {
-		fmt.Print(stderr.String())
-	}
+	printLogs(stderr.String(), logPrefix)
 
 	// Print activation marker
 	fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
 
 	// Handle execution errors
 	if runErr != nil {
+		if timedOut {
+			reportStatus(w, http.StatusGatewayTimeout)
+		}
 		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusBadGateway)
-		json.NewEncoder(w).Encode(ErrorResponse{Error: "Action execution failed: " + runErr.Error()})
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Action execution failed: " + runErr.Error(), ExitCode: exitCode(runErr)})
 		return
 	}
 
 	// Parse stdout as JSON result
-	var result map[string]interface{}
-	stdoutStr := strings.TrimSpace(stdout.String())
-	if stdoutStr != "" {
-		if err := json.Unmarshal([]byte(stdoutStr), &result); err != nil {
-			// If not valid JSON, wrap stdout as string result
-			result = map[string]interface{}{
-				"body": stdoutStr,
-			}
-		}
-	} else {
-		result = make(map[string]interface{})
+	result := parseResult(stdout.String())
+	if err := validateAnnotations(result); err != nil {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid annotations: " + err.Error()})
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -261,24 +656,553 @@ func runHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(result)
 }
 
+// diagnosticPattern matches go build error lines: file.go:line[:col]: message
+var diagnosticPattern = regexp.MustCompile(`^(.+\.go):(\d+)(?::(\d+))?: (.+)$`)
+
+// parseDiagnostics extracts structured errors from go build output, skipping
+// package headers and continuation lines
+func parseDiagnostics(output string) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, line := range strings.Split(output, "\n") {
+		match := diagnosticPattern.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+
+		lineNum, _ := strconv.Atoi(match[2])
+		column, _ := strconv.Atoi(match[3])
+		diagnostics = append(diagnostics, Diagnostic{
+			File:    filepath.Base(match[1]),
+			Line:    lineNum,
+			Column:  column,
+			Message: match[4],
+		})
+	}
+	return diagnostics
+}
+
+// allowedBuildFlags lists the go build flags an action may request
+var allowedBuildFlags = map[string]bool{
+	"-trimpath":      true,
+	"-ldflags=-s -w": true,
+}
+
+// buildTagPattern restricts -tags values to comma-separated identifiers
+var buildTagPattern = regexp.MustCompile(`^[A-Za-z0-9_.]+(,[A-Za-z0-9_.]+)*$`)
+
+// buildFlags validates requested build flags against the allowlist
+func buildFlags(requested []string) ([]string, error) {
+	flags := make([]string, 0, len(requested))
+	for _, flag := range requested {
+		if tags, ok := strings.CutPrefix(flag, "-tags="); ok {
+			if !buildTagPattern.MatchString(tags) {
+				return nil, fmt.Errorf("Invalid build tags: %q", tags)
+			}
+		} else if !allowedBuildFlags[flag] {
+			return nil, fmt.Errorf("Build flag not allowed: %q", flag)
+		}
+		flags = append(flags, flag)
+	}
+	return flags, nil
+}
+
+// modulePathPattern matches a module path with an optional @version suffix
+var modulePathPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._~/-]*(@[A-Za-z0-9._+-]+)?$`)
+
+// goReplaces validates requested replace directives and returns them as
+// go mod edit -replace arguments in a stable order. Targets must be either
+// module@version or an absolute directory containing a go.mod
+func goReplaces(requested map[string]string) ([]string, error) {
+	paths := make([]string, 0, len(requested))
+	for path := range requested {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	args := make([]string, 0, len(paths))
+	for _, path := range paths {
+		target := requested[path]
+		if !modulePathPattern.MatchString(path) {
+			return nil, fmt.Errorf("Invalid replaced module path: %q", path)
+		}
+		if filepath.IsAbs(target) {
+			if _, err := os.Stat(filepath.Join(target, "go.mod")); err != nil {
+				return nil, fmt.Errorf("Replace target %q is not a module directory", target)
+			}
+		} else if !modulePathPattern.MatchString(target) || !strings.Contains(target, "@") {
+			return nil, fmt.Errorf("Replace target %q must be module@version or an absolute directory", target)
+		}
+		args = append(args, fmt.Sprintf("-replace=%s=%s", path, target))
+	}
+	return args, nil
+}
+
+// stdinPayload builds what the action reads on stdin: the params alone for
+// the env protocol, where activation metadata only arrives as __OW_* env
+// vars, or the actionloop object carrying both the params and the metadata
+func stdinPayload(req *RunRequest, paramsJSON []byte) ([]byte, error) {
+	switch req.StdinProtocol {
+	case "", "env":
+		return paramsJSON, nil
+	case "actionloop":
+		return json.Marshal(map[string]interface{}{
+			"value":      json.RawMessage(paramsJSON),
+			"activation": req.Activation,
+		})
+	default:
+		return nil, fmt.Errorf("Unknown stdin protocol: %q", req.StdinProtocol)
+	}
+}
+
+// frameStdin frames the params JSON for the action's stdin protocol:
+// json writes it as-is, json-line appends a newline, and length-prefixed
+// writes a 4-byte big-endian length before the payload
+func frameStdin(payload []byte, format string) ([]byte, error) {
+	switch format {
+	case "", "json":
+		return payload, nil
+	case "json-line":
+		return append(payload, '\n'), nil
+	case "length-prefixed":
+		framed := make([]byte, 4, 4+len(payload))
+		binary.BigEndian.PutUint32(framed, uint32(len(payload)))
+		return append(framed, payload...), nil
+	default:
+		return nil, fmt.Errorf("Unknown stdin format: %q", format)
+	}
+}
+
+// mergeParams layers run-time params over bound init params
+func mergeParams(bound, run map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(bound)+len(run))
+	for k, v := range bound {
+		merged[k] = v
+	}
+	for k, v := range run {
+		merged[k] = v
+	}
+	return merged
+}
+
+// parseResult parses action output as a JSON object, wrapping anything else
+func parseResult(output string) map[string]interface{} {
+	output = strings.TrimSpace(output)
+	if output == "" {
+		return make(map[string]interface{})
+	}
+
+	var result map[string]interface{}
+	if err := json.Unmarshal([]byte(output), &result); err != nil {
+		// If not valid JSON, wrap stdout as string result
+		result = map[string]interface{}{
+			resultWrapKey: output,
+		}
+	}
+	return result
+}
+
+// validateEnv checks an action env against MAX_ENV_VARS and MAX_ENV_BYTES,
+// counting each var as exec sees it: KEY=value plus a terminator
+func validateEnv(env map[string]string) error {
+	if len(env) > maxEnvVars {
+		return fmt.Errorf("Action env has %d variables, exceeding the limit of %d", len(env), maxEnvVars)
+	}
+	size := 0
+	for key, value := range env {
+		size += len(key) + len(value) + 2
+	}
+	if size > maxEnvBytes {
+		return fmt.Errorf("Action env is %d bytes, exceeding the limit of %d", size, maxEnvBytes)
+	}
+	return nil
+}
+
+// validateAnnotations checks the annotations an action returned under
+// annotationsKey: an object whose values are strings, numbers or booleans.
+// The invoker merges them into the activation's annotations
+func validateAnnotations(result map[string]interface{}) error {
+	raw, ok := result[annotationsKey]
+	if !ok {
+		return nil
+	}
+	annotations, ok := raw.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s must be an object", annotationsKey)
+	}
+	for key, value := range annotations {
+		switch value.(type) {
+		case string, float64, bool:
+		default:
+			return fmt.Errorf("%s.%s must be a string, number or boolean", annotationsKey, key)
+		}
+	}
+	return nil
+}
+
+// buildCacheKey hashes everything that determines the compiled binary.
+// Builds replacing modules with local directories, whose contents can change
+// between inits, get no key and are never cached
+func buildCacheKey(code string, flags, replaces []string) string {
+	h := sha256.New()
+	h.Write([]byte(code))
+	// Binaries built by another toolchain aren't interchangeable
+	h.Write([]byte("\x00go:" + goVersion))
+	for _, flag := range flags {
+		h.Write([]byte("\x00flag:" + flag))
+	}
+	for _, replace := range replaces {
+		if strings.Contains(replace, "=/") {
+			return ""
+		}
+		h.Write([]byte("\x00replace:" + replace))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// restoreCachedBinary places the cached binary for key at binaryPath,
+// reporting whether there was one
+func restoreCachedBinary(key, binaryPath string) bool {
+	if key == "" {
+		return false
+	}
+	cached := filepath.Join(binaryCacheDir, key)
+	if _, err := os.Stat(cached); err != nil {
+		return false
+	}
+	if err := linkOrCopy(cached, binaryPath); err != nil {
+		return false
+	}
+	// The modification time orders eviction, so a hit marks it recently used
+	now := time.Now()
+	os.Chtimes(cached, now, now)
+	return true
+}
+
+// storeCachedBinary adds a freshly built binary to the cache. Failures only
+// cost a future recompile, so they are logged and otherwise ignored
+func storeCachedBinary(key, binaryPath string) {
+	if key == "" {
+		return
+	}
+	if err := os.MkdirAll(binaryCacheDir, 0755); err != nil {
+		fmt.Printf("Failed to create binary cache: %v\n", err)
+		return
+	}
+	// Link under a temp name and rename so readers never see a partial binary
+	tmp := filepath.Join(binaryCacheDir, key+".tmp")
+	os.Remove(tmp)
+	if err := linkOrCopy(binaryPath, tmp); err != nil {
+		fmt.Printf("Failed to cache binary: %v\n", err)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(binaryCacheDir, key)); err != nil {
+		os.Remove(tmp)
+		fmt.Printf("Failed to cache binary: %v\n", err)
+		return
+	}
+	if removed, err := evictCachedBinaries(binaryCacheDir, maxCachedBinaries); err != nil {
+		fmt.Printf("Failed to evict cached binaries: %v\n", err)
+	} else if removed > 0 {
+		fmt.Printf("Evicted %d cached binaries\n", removed)
+	}
+}
+
+// evictCachedBinaries removes the least recently used binaries in dir beyond
+// the newest max. Binaries already restored for an action are hard links or
+// copies, so evicting them doesn't disturb a running action
+func evictCachedBinaries(dir string, max int) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	type cachedBinary struct {
+		path    string
+		modTime time.Time
+	}
+	var binaries []cachedBinary
+	for _, entry := range entries {
+		// Skip binaries still being linked into place
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".tmp") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		binaries = append(binaries, cachedBinary{filepath.Join(dir, entry.Name()), info.ModTime()})
+	}
+	if len(binaries) <= max {
+		return 0, nil
+	}
+
+	sort.Slice(binaries, func(i, j int) bool { return binaries[i].modTime.Before(binaries[j].modTime) })
+	removed := 0
+	for _, cached := range binaries[:len(binaries)-max] {
+		if err := os.Remove(cached.path); err != nil {
+			fmt.Printf("Failed to evict cached binary %s: %v\n", cached.path, err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// linkOrCopy hard-links src to dst, copying when they are on different
+// filesystems
+func linkOrCopy(src, dst string) error {
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// exitCode returns the exit code of an action process that exited non-zero,
+// -1 if it was killed by a signal, or 0 for other failures
+func exitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return 0
+}
+
+// printLogs writes the action's stderr as activation logs, prefixing each
+// line with the transaction so it can be tied to the invoker's traces
+func printLogs(logs, prefix string) {
+	if logs == "" {
+		return
+	}
+	if prefix == "" {
+		fmt.Print(logs)
+		return
+	}
+	for _, line := range strings.SplitAfter(logs, "\n") {
+		if line != "" {
+			fmt.Print(prefix + line)
+		}
+	}
+	if !strings.HasSuffix(logs, "\n") {
+		fmt.Println()
+	}
+}
+
+// runStreaming runs the action and forwards each stdout line as an NDJSON
+// chunk as it arrives. The last line the action prints is its final result
+func runStreaming(w http.ResponseWriter, cmd *exec.Cmd, timeout time.Duration, logPrefix string) {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	stdout, err := cmd.StdoutPipe()
+	if err == nil {
+		err = cmd.Start()
+	}
+	if err != nil {
+		fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Action execution failed: " + err.Error()})
+		return
+	}
+
+	var timedOut atomic.Bool
+	timer := time.AfterFunc(timeout, func() {
+		timedOut.Store(true)
+		killProcessGroup(cmd)
+	})
+	defer timer.Stop()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	encoder := json.NewEncoder(w)
+
+	// Hold each line back until the next arrives so the last becomes the result
+	var last string
+	haveLast := false
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		if haveLast {
+			encoder.Encode(StreamChunk{Type: "chunk", Data: parseResult(last)})
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+		last = scanner.Text()
+		haveLast = true
+	}
+
+	runErr := cmd.Wait()
+	if timedOut.Load() {
+		runErr = fmt.Errorf("action timed out after %v", timeout)
+	}
+
+	// Print stderr as logs
+	printLogs(stderr.String(), logPrefix)
+	fmt.Println("XXX_THE_END_OF_A_WHISK_ACTIVATION_XXX")
+
+	if runErr != nil {
+		if timedOut.Load() {
+			reportStatus(w, http.StatusGatewayTimeout)
+		} else {
+			reportStatus(w, http.StatusBadGateway)
+		}
+		encoder.Encode(StreamChunk{Type: "error", Error: "Action execution failed: " + runErr.Error(), ExitCode: exitCode(runErr)})
+		return
+	}
+	encoder.Encode(StreamChunk{Type: "result", Data: parseResult(last)})
+}
+
+// killProcessGroup kills a started action along with every process in its
+// group, falling back to the action alone if the group can't be signalled
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
+
 func healthHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	body := map[string]string{"status": "ok"}
+	if goVersion != "" {
+		body["goVersion"] = goVersion
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(body)
+}
+
+// probeToolchain checks that the configured go binary exists and runs,
+// recording its version
+func probeToolchain() error {
+	path, err := exec.LookPath(goBinary)
+	if err != nil {
+		return fmt.Errorf("go binary %q not found: %w", goBinary, err)
+	}
+
+	out, err := exec.Command(path, "version").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("go version failed: %w: %s", err, strings.TrimSpace(string(out)))
+	}
+
+	goVersion = strings.TrimSpace(string(out))
+	fmt.Printf("Using %s (%s)\n", goVersion, path)
+	return nil
+}
+
+// buildCommand returns a go command for args, run under an address space
+// limit and a matching GOMEMLIMIT when a build memory limit is configured.
+// The build is killed when ctx is done
+func buildCommand(ctx context.Context, args ...string) *exec.Cmd {
+	if buildMemoryMB <= 0 {
+		return exec.CommandContext(ctx, goBinary, args...)
+	}
+
+	script := fmt.Sprintf(`ulimit -v %d && exec "$@"`, buildMemoryMB*1024)
+	cmd := exec.CommandContext(ctx, "sh", append([]string{"-c", script, "sh", goBinary}, args...)...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("GOMEMLIMIT=%dMiB", buildMemoryMB))
+	return cmd
+}
+
+// exceededMemory reports whether a failed build ran out of memory, either
+// hitting the address space limit or being killed by the OOM killer
+func exceededMemory(stderr string, err error) bool {
+	for _, marker := range []string{"out of memory", "cannot allocate memory", "failed to reserve"} {
+		if strings.Contains(stderr, marker) {
+			return true
+		}
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
+			return true
+		}
+	}
+	return false
+}
+
+// reclaimStaleTempDirs removes action-* build directories under dir left by
+// a runtime killed mid-build, skipping any modified within maxAge
+func reclaimStaleTempDirs(dir string, maxAge time.Duration) (int, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "action-*"))
+	if err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	cutoff := time.Now().Add(-maxAge)
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || !info.IsDir() || info.ModTime().After(cutoff) {
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			fmt.Printf("Failed to remove stale temp dir %s: %v\n", path, err)
+			continue
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// newServer returns the runtime's HTTP server on addr, with timeouts so a
+// slow client can't hold the single runtime's connection open
+func newServer(addr string) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+	}
 }
 
 func main() {
-	http.HandleFunc("/init", initHandler)
-	http.HandleFunc("/run", runHandler)
+	if removed, err := reclaimStaleTempDirs(os.TempDir(), staleTempAge); err != nil {
+		fmt.Printf("Failed to scan for stale temp dirs: %v\n", err)
+	} else if removed > 0 {
+		fmt.Printf("Removed %d stale temp dirs\n", removed)
+	}
+
+	// A runtime without a toolchain can't init anything, so exit rather
+	// than serve and fail every init
+	if err := probeToolchain(); err != nil {
+		fmt.Printf("Go toolchain unavailable: %v\n", err)
+		os.Exit(1)
+	}
+
+	http.HandleFunc("/init", instrument("init", initHandler))
+	http.HandleFunc("/run", instrument("run", runHandler))
 	http.HandleFunc("/health", healthHandler)
+	http.HandleFunc("/metrics", metricsHandler)
+
+	server := newServer(":8080")
 
 	fmt.Println("OpenWhisk Go 1.23 runtime listening on port 8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	if err := server.ListenAndServe(); err != nil {
 		fmt.Printf("Server error: %v\n", err)
 	}
 }
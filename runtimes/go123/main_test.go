@@ -0,0 +1,984 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	goruntime "runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// helloAction is a minimal action echoing its params
+const helloAction = `package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	params, _ := io.ReadAll(os.Stdin)
+	fmt.Printf("{\"params\":%s}", params)
+}
+`
+
+// postInit sends an init payload to initHandler with the binary cache in a
+// fresh directory, so every test compiles from scratch
+func postInit(t *testing.T, value map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	binaryCacheDir = t.TempDir()
+	return sendInit(t, value)
+}
+
+// sendInit sends an init payload to initHandler, keeping the binary cache
+func sendInit(t *testing.T, value map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{"value": value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	initHandler(rec, httptest.NewRequest(http.MethodPost, "/init", bytes.NewReader(body)))
+	return rec
+}
+
+// decodeError decodes an ErrorResponse from a recorded response
+func decodeError(t *testing.T, rec *httptest.ResponseRecorder) ErrorResponse {
+	t.Helper()
+	var resp ErrorResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return resp
+}
+
+func TestProbeToolchainWithoutGoOnPath(t *testing.T) {
+	t.Setenv("PATH", t.TempDir())
+
+	err := probeToolchain()
+	if err == nil || !strings.Contains(err.Error(), `go binary "go" not found`) {
+		t.Fatalf("probeToolchain() = %v, want a go binary not found error", err)
+	}
+}
+
+func TestProbeToolchainWithBrokenGo(t *testing.T) {
+	dir := t.TempDir()
+	script := "#!/bin/sh\necho 'go: corrupt install' >&2\nexit 1\n"
+	if err := os.WriteFile(filepath.Join(dir, "go"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	err := probeToolchain()
+	if err == nil || !strings.Contains(err.Error(), "go version failed") || !strings.Contains(err.Error(), "corrupt install") {
+		t.Fatalf("probeToolchain() = %v, want a go version failed error", err)
+	}
+}
+
+func TestInitRejectsTightDeadlineBeforeCompiling(t *testing.T) {
+	rec := postInit(t, map[string]interface{}{
+		"code":     helloAction,
+		"deadline": time.Now().Add(minCompileBudget / 2).UnixMilli(),
+	})
+
+	if rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusGatewayTimeout, rec.Body)
+	}
+	if resp := decodeError(t, rec); resp.Error != "Insufficient time to compile within deadline" {
+		t.Fatalf("error = %q", resp.Error)
+	}
+	if entries, _ := os.ReadDir(binaryCacheDir); len(entries) != 0 {
+		t.Fatalf("rejected init left %d cache entries", len(entries))
+	}
+}
+
+func TestInitCompilesWithinDeadline(t *testing.T) {
+	rec := postInit(t, map[string]interface{}{
+		"code":     helloAction,
+		"deadline": time.Now().Add(2 * time.Minute).UnixMilli(),
+	})
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusOK, rec.Body)
+	}
+}
+
+// postRun sends a run payload through the instrumented run handler
+func postRun(t *testing.T, query string, value map[string]interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(map[string]interface{}{"value": value})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	instrument("run", runHandler)(rec, httptest.NewRequest(http.MethodPost, "/run"+query, bytes.NewReader(body)))
+	return rec
+}
+
+// errorCount reads the error counter for an endpoint and class
+func errorCount(endpoint, class string) uint64 {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	return metrics.errors[endpoint+"/"+class]
+}
+
+func TestMetricsExposeInitAndRunSeries(t *testing.T) {
+	if rec := postInit(t, map[string]interface{}{"code": helloAction}); rec.Code != http.StatusOK {
+		t.Fatalf("init status = %d (%s)", rec.Code, rec.Body)
+	}
+	if rec := postRun(t, "", map[string]interface{}{"name": "metrics"}); rec.Code != http.StatusOK {
+		t.Fatalf("run status = %d (%s)", rec.Code, rec.Body)
+	}
+
+	rec := httptest.NewRecorder()
+	metricsHandler(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rec.Body.String()
+	for _, series := range []string{
+		`runtime_requests_total{endpoint="run",result="ok"}`,
+		`runtime_build_cache_total{result="miss"}`,
+		"runtime_compile_duration_seconds_count",
+		"runtime_run_duration_seconds_count",
+	} {
+		if !strings.Contains(body, series) {
+			t.Errorf("/metrics missing %s", series)
+		}
+	}
+}
+
+func TestErrorClassTimeout(t *testing.T) {
+	if class := errorClass(http.StatusGatewayTimeout); class != "timeout" {
+		t.Fatalf("errorClass(504) = %q, want timeout", class)
+	}
+}
+
+func TestStreamedRunErrorCountsAsError(t *testing.T) {
+	failing := `package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Println("{\"progress\":1}")
+	os.Exit(3)
+}
+`
+	if rec := postInit(t, map[string]interface{}{"code": failing}); rec.Code != http.StatusOK {
+		t.Fatalf("init status = %d (%s)", rec.Code, rec.Body)
+	}
+
+	before := errorCount("run", "action_failed")
+	rec := postRun(t, "?stream=1", nil)
+	if !strings.Contains(rec.Body.String(), `"type":"error"`) {
+		t.Fatalf("stream did not end in an error chunk: %s", rec.Body)
+	}
+	if after := errorCount("run", "action_failed"); after != before+1 {
+		t.Fatalf("action_failed errors = %d, want %d", after, before+1)
+	}
+}
+
+func TestBuildFlagsAllowlist(t *testing.T) {
+	flags, err := buildFlags([]string{"-trimpath", "-ldflags=-s -w", "-tags=netgo,osusergo"})
+	if err != nil {
+		t.Fatalf("buildFlags() = %v", err)
+	}
+	if got := strings.Join(flags, " "); got != "-trimpath -ldflags=-s -w -tags=netgo,osusergo" {
+		t.Fatalf("flags = %q", got)
+	}
+
+	for _, flag := range []string{"-toolexec=/bin/sh", "-ldflags=-X main.x=1", "-tags=a b", "-o=/etc/passwd"} {
+		if _, err := buildFlags([]string{flag}); err == nil {
+			t.Errorf("buildFlags(%q) accepted a disallowed flag", flag)
+		}
+	}
+}
+
+func TestInitAppliesAllowedBuildFlags(t *testing.T) {
+	rec := postInit(t, map[string]interface{}{
+		"code":        helloAction,
+		"build_flags": []string{"-trimpath", "-ldflags=-s -w"},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusOK, rec.Body)
+	}
+
+	rec = postInit(t, map[string]interface{}{
+		"code":        helloAction,
+		"build_flags": []string{"-toolexec=/bin/sh"},
+	})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusBadRequest, rec.Body)
+	}
+	if resp := decodeError(t, rec); !strings.Contains(resp.Error, "Build flag not allowed") {
+		t.Fatalf("error = %q", resp.Error)
+	}
+}
+
+func TestFrameStdin(t *testing.T) {
+	payload := []byte(`{"a":1}`)
+	tests := []struct {
+		format string
+		want   []byte
+	}{
+		{format: "", want: payload},
+		{format: "json", want: payload},
+		{format: "json-line", want: []byte("{\"a\":1}\n")},
+		{format: "length-prefixed", want: append([]byte{0, 0, 0, 7}, payload...)},
+	}
+
+	for _, tt := range tests {
+		got, err := frameStdin(payload, tt.format)
+		if err != nil {
+			t.Fatalf("frameStdin(%q) = %v", tt.format, err)
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("frameStdin(%q) = %q, want %q", tt.format, got, tt.want)
+		}
+	}
+
+	if _, err := frameStdin(payload, "xml"); err == nil {
+		t.Fatal("frameStdin accepted an unknown format")
+	}
+}
+
+func TestInitReturnsCompileDiagnostics(t *testing.T) {
+	broken := "package main\n\nfunc main() {\n\tundefinedCall()\n}\n"
+
+	rec := postInit(t, map[string]interface{}{"code": broken, "diagnostics": true})
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusBadGateway, rec.Body)
+	}
+
+	resp := decodeError(t, rec)
+	if len(resp.Diagnostics) != 1 {
+		t.Fatalf("diagnostics = %+v, want one", resp.Diagnostics)
+	}
+	d := resp.Diagnostics[0]
+	if d.File != "main.go" || d.Line != 4 || d.Column != 2 || !strings.Contains(d.Message, "undefined: undefinedCall") {
+		t.Fatalf("diagnostic = %+v", d)
+	}
+
+	rec = postInit(t, map[string]interface{}{"code": broken})
+	if resp := decodeError(t, rec); resp.Diagnostics != nil || !strings.Contains(resp.Error, "undefined: undefinedCall") {
+		t.Fatalf("without diagnostics got %+v", resp)
+	}
+}
+
+func TestParseDiagnosticsSkipsHeaders(t *testing.T) {
+	output := "# action\n./main.go:3:2: undefined: x\n./main.go:7: missing return\n\tnote: continuation\n"
+
+	got := parseDiagnostics(output)
+	want := []Diagnostic{
+		{File: "main.go", Line: 3, Column: 2, Message: "undefined: x"},
+		{File: "main.go", Line: 7, Message: "missing return"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("parseDiagnostics() = %+v, want %+v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("diagnostic %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestInitCapsConcurrentBuilds(t *testing.T) {
+	// A fake toolchain that records how many module setups and builds run at
+	// once
+	dir := t.TempDir()
+	active := filepath.Join(dir, "active")
+	countLog := filepath.Join(dir, "counts")
+	if err := os.Mkdir(active, 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := `#!/bin/sh
+touch "$ACTIVE_DIR/$$"
+ls "$ACTIVE_DIR" | wc -l >> "$COUNT_LOG"
+sleep 0.2
+rm "$ACTIVE_DIR/$$"
+while [ $# -gt 0 ]; do
+	[ "$1" = -o ] && : > "$2"
+	shift
+done
+`
+	fakeGo := filepath.Join(dir, "go")
+	if err := os.WriteFile(fakeGo, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ACTIVE_DIR", active)
+	t.Setenv("COUNT_LOG", countLog)
+
+	savedBinary, savedSlots := goBinary, buildSlots
+	goBinary, buildSlots = fakeGo, make(chan struct{}, 2)
+	binaryCacheDir = t.TempDir()
+	defer func() { goBinary, buildSlots = savedBinary, savedSlots }()
+
+	const inits = 6
+	var wg sync.WaitGroup
+	codes := make([]int, inits)
+	for i := 0; i < inits; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			body, _ := json.Marshal(map[string]interface{}{
+				"value": map[string]interface{}{"code": helloAction + "// " + strconv.Itoa(i)},
+			})
+			rec := httptest.NewRecorder()
+			initHandler(rec, httptest.NewRequest(http.MethodPost, "/init", bytes.NewReader(body)))
+			codes[i] = rec.Code
+		}(i)
+	}
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("init %d status = %d", i, code)
+		}
+	}
+
+	counts, err := os.ReadFile(countLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Fields(string(counts))
+	// Each init runs go mod init, then go build
+	if len(lines) != 2*inits {
+		t.Fatalf("recorded %d toolchain runs, want %d", len(lines), 2*inits)
+	}
+	for _, line := range lines {
+		if n, _ := strconv.Atoi(line); n > cap(buildSlots) {
+			t.Fatalf("%d toolchain runs at once, want at most %d", n, cap(buildSlots))
+		}
+	}
+}
+
+func TestReclaimStaleTempDirs(t *testing.T) {
+	dir := t.TempDir()
+	old := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{"action-stale", "action-fresh", "other-stale"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"action-stale", "other-stale"} {
+		if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := reclaimStaleTempDirs(dir, time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("reclaimStaleTempDirs() = %d, %v, want 1", removed, err)
+	}
+	for name, kept := range map[string]bool{"action-stale": false, "action-fresh": true, "other-stale": true} {
+		if _, err := os.Stat(filepath.Join(dir, name)); (err == nil) != kept {
+			t.Errorf("%s kept = %v, want %v", name, err == nil, kept)
+		}
+	}
+}
+
+func TestBuildCacheCountsMissThenHit(t *testing.T) {
+	cacheCounts := func() (uint64, uint64) {
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+		return metrics.cacheHits, metrics.cacheMisses
+	}
+	hits, misses := cacheCounts()
+
+	rec := postInit(t, map[string]interface{}{"code": helloAction})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", rec.Code, rec.Body)
+	}
+	if h, m := cacheCounts(); h != hits || m != misses+1 {
+		t.Fatalf("after first init hits, misses = %d, %d, want %d, %d", h, m, hits, misses+1)
+	}
+
+	rec = sendInit(t, map[string]interface{}{"code": helloAction})
+	var resp InitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || !resp.CacheHit {
+		t.Fatalf("second init = %s, want a cache hit", rec.Body)
+	}
+	if h, m := cacheCounts(); h != hits+1 || m != misses+1 {
+		t.Fatalf("after second init hits, misses = %d, %d, want %d, %d", h, m, hits+1, misses+1)
+	}
+}
+
+func TestRunTimeoutKillsActionChildren(t *testing.T) {
+	spawner := `package main
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+func main() {
+	child := exec.Command("sleep", "60")
+	child.Start()
+	os.WriteFile(os.Getenv("PID_FILE"), []byte(strconv.Itoa(child.Process.Pid)), 0644)
+	time.Sleep(time.Minute)
+}
+`
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	rec := postInit(t, map[string]interface{}{
+		"code": spawner,
+		"env":  map[string]string{"PID_FILE": pidFile},
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("init status = %d (%s)", rec.Code, rec.Body)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"value":      map[string]interface{}{},
+		"activation": map[string]interface{}{"deadline": (time.Now().Unix() + 2) * 1000},
+	})
+	rec = httptest.NewRecorder()
+	runHandler(rec, httptest.NewRequest(http.MethodPost, "/run", bytes.NewReader(body)))
+	if rec.Code != http.StatusBadGateway || !strings.Contains(rec.Body.String(), "timed out") {
+		t.Fatalf("run = %d %s, want a timeout", rec.Code, rec.Body)
+	}
+
+	raw, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("action never reported its child: %v", err)
+	}
+	pid, _ := strconv.Atoi(string(raw))
+
+	// The orphaned child is reaped by init once killed
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+		if err != nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("child %d still present after timeout: %s", pid, stat)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestServerTimesOutSlowHeaders(t *testing.T) {
+	saved := readHeaderTimeout
+	readHeaderTimeout = 200 * time.Millisecond
+	defer func() { readHeaderTimeout = saved }()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newServer(ln.Addr().String())
+	go server.Serve(ln)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Start a request and never finish its headers
+	if _, err := conn.Write([]byte("POST /init HTTP/1.1\r\nHost: runtime\r\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	start := time.Now()
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("connection not closed by the server: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("slow client held the connection for %v", elapsed)
+	}
+}
+
+func TestInitRejectsOversizedEnv(t *testing.T) {
+	tooMany := make(map[string]string, maxEnvVars+1)
+	for i := 0; i <= maxEnvVars; i++ {
+		tooMany["VAR_"+strconv.Itoa(i)] = "x"
+	}
+	tooBig := map[string]string{"BIG": strings.Repeat("x", maxEnvBytes)}
+
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{name: "count", env: tooMany, want: "variables, exceeding the limit"},
+		{name: "size", env: tooBig, want: "bytes, exceeding the limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := postInit(t, map[string]interface{}{"code": helloAction, "env": tt.env})
+			if rec.Code != http.StatusRequestEntityTooLarge {
+				t.Fatalf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+			}
+			if resp := decodeError(t, rec); !strings.Contains(resp.Error, tt.want) {
+				t.Fatalf("error = %q, want it to mention %q", resp.Error, tt.want)
+			}
+		})
+	}
+}
+
+func TestBinaryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	savedMax := maxCachedBinaries
+	maxCachedBinaries = 2
+	defer func() { maxCachedBinaries = savedMax }()
+	binaryCacheDir = t.TempDir()
+
+	// Cached binaries are hard links, so each needs its own file for its
+	// own modification time
+	build := func(key string) string {
+		path := filepath.Join(t.TempDir(), key)
+		if err := os.WriteFile(path, []byte(key), 0755); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	// Store a and b oldest first, then use a before storing c
+	base := time.Now().Add(-time.Hour)
+	for i, key := range []string{"a", "b"} {
+		storeCachedBinary(key, build(key))
+		at := base.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(binaryCacheDir, key), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !restoreCachedBinary("a", filepath.Join(t.TempDir(), "restored")) {
+		t.Fatal("a missing before the cap was reached")
+	}
+	storeCachedBinary("c", build("c"))
+
+	for key, kept := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, err := os.Stat(filepath.Join(binaryCacheDir, key)); (err == nil) != kept {
+			t.Errorf("%s kept = %v, want %v", key, err == nil, kept)
+		}
+	}
+}
+
+// probeAction reports what it was started with: its stdin and environment.
+// It also logs a line to stderr
+const probeAction = `package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	stdin, _ := io.ReadAll(os.Stdin)
+	fmt.Fprintln(os.Stderr, "probe ran")
+	json.NewEncoder(os.Stdout).Encode(map[string]interface{}{
+		"stdin": stdin,
+		"env":   os.Environ(),
+	})
+}
+`
+
+// probeResult is what probeAction reports
+type probeResult struct {
+	Stdin []byte
+	Env   []string
+}
+
+// getenv looks up a variable in the probed environment
+func (p probeResult) getenv(key string) (string, bool) {
+	for _, kv := range p.Env {
+		if k, v, _ := strings.Cut(kv, "="); k == key {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// sendRun sends a whole run request, with headers, to runHandler
+func sendRun(t *testing.T, req map[string]interface{}, header http.Header) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	httpReq := httptest.NewRequest(http.MethodPost, "/run", bytes.NewReader(body))
+	for key, values := range header {
+		httpReq.Header[key] = values
+	}
+	rec := httptest.NewRecorder()
+	runHandler(rec, httpReq)
+	return rec
+}
+
+// runProbe runs the initialized probeAction and decodes its report
+func runProbe(t *testing.T, req map[string]interface{}) probeResult {
+	t.Helper()
+	rec := sendRun(t, req, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("run status = %d (%s)", rec.Code, rec.Body)
+	}
+	var result probeResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body, err)
+	}
+	return result
+}
+
+// initAction initializes an action, failing the test unless it compiles
+func initAction(t *testing.T, value map[string]interface{}) {
+	t.Helper()
+	if rec := postInit(t, value); rec.Code != http.StatusOK {
+		t.Fatalf("init status = %d (%s)", rec.Code, rec.Body)
+	}
+}
+
+// captureStdout returns what fn prints to the runtime's stdout
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = saved }()
+
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- string(data)
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
+
+func TestInitReportsCompileStats(t *testing.T) {
+	rec := postInit(t, map[string]interface{}{"code": helloAction})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s)", rec.Code, rec.Body)
+	}
+	var resp InitResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.OK || resp.CompileMs <= 0 || resp.BinaryBytes <= 0 || resp.CacheHit {
+		t.Errorf("init response = %+v, want non-zero compile stats", resp)
+	}
+}
+
+func TestRunPassesRawInputVerbatim(t *testing.T) {
+	initAction(t, map[string]interface{}{"code": probeAction})
+
+	raw := []byte{0x89, 'P', 'N', 'G', 0, 0xff, '{'}
+	result := runProbe(t, map[string]interface{}{
+		"value":      map[string]interface{}{"ignored": true},
+		"raw_input":  true,
+		"raw_body":   raw,
+		"activation": map[string]interface{}{"activationId": "act-1"},
+	})
+	if !bytes.Equal(result.Stdin, raw) {
+		t.Errorf("stdin = %q, want %q", result.Stdin, raw)
+	}
+	if id, _ := result.getenv("__OW_ACTIVATION_ID"); id != "act-1" {
+		t.Errorf("__OW_ACTIVATION_ID = %q, want act-1", id)
+	}
+}
+
+func TestActionloopProtocolSendsValueAndActivation(t *testing.T) {
+	initAction(t, map[string]interface{}{"code": probeAction})
+
+	result := runProbe(t, map[string]interface{}{
+		"value":          map[string]interface{}{"n": 1},
+		"stdin_protocol": "actionloop",
+		"activation":     map[string]interface{}{"activationId": "act-1", "namespace": "ns", "action_name": "probe"},
+	})
+	var stdin struct {
+		Value      map[string]interface{}
+		Activation map[string]interface{}
+	}
+	if err := json.Unmarshal(result.Stdin, &stdin); err != nil {
+		t.Fatalf("stdin %q is not a JSON object: %v", result.Stdin, err)
+	}
+	if stdin.Value["n"] != 1.0 {
+		t.Errorf("value = %v, want the params", stdin.Value)
+	}
+	if stdin.Activation["activationId"] != "act-1" || stdin.Activation["namespace"] != "ns" || stdin.Activation["action_name"] != "probe" {
+		t.Errorf("activation = %v, want the activation metadata", stdin.Activation)
+	}
+}
+
+func TestEnvTemplatesExpandPerActivation(t *testing.T) {
+	initAction(t, map[string]interface{}{
+		"code": probeAction,
+		"env":  map[string]string{"LOG_PREFIX": "${OW_NAMESPACE}/${OW_ACTION_NAME}/${OW_ACTIVATION_ID}"},
+	})
+
+	for _, activation := range []map[string]interface{}{
+		{"activationId": "act-1", "namespace": "alpha", "action_name": "probe"},
+		{"activationId": "act-2", "namespace": "beta", "action_name": "probe"},
+	} {
+		result := runProbe(t, map[string]interface{}{"value": map[string]interface{}{}, "activation": activation})
+		want := fmt.Sprintf("%s/probe/%s", activation["namespace"], activation["activationId"])
+		if prefix, _ := result.getenv("LOG_PREFIX"); prefix != want {
+			t.Errorf("LOG_PREFIX = %q, want %q", prefix, want)
+		}
+	}
+}
+
+func TestCleanEnvHidesHostVars(t *testing.T) {
+	t.Setenv("HOST_SECRET", "secret")
+	initAction(t, map[string]interface{}{"code": probeAction, "env": map[string]string{"ACTION_VAR": "set"}})
+
+	saved := cleanEnv
+	defer func() { cleanEnv = saved }()
+	for _, clean := range []bool{true, false} {
+		cleanEnv = clean
+		result := runProbe(t, map[string]interface{}{"value": map[string]interface{}{}})
+
+		if _, ok := result.getenv("HOST_SECRET"); ok == clean {
+			t.Errorf("clean env %v: HOST_SECRET present = %v", clean, ok)
+		}
+		for _, key := range []string{"PATH", "ACTION_VAR", "__OW_ACTIVATION_ID"} {
+			if _, ok := result.getenv(key); !ok {
+				t.Errorf("clean env %v: %s missing", clean, key)
+			}
+		}
+	}
+}
+
+func TestInitParamsMergedUnderRunParams(t *testing.T) {
+	initAction(t, map[string]interface{}{
+		"code":        helloAction,
+		"init_params": map[string]interface{}{"bound": "init", "shared": "init"},
+	})
+
+	rec := postRun(t, "", map[string]interface{}{"shared": "run"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("run status = %d (%s)", rec.Code, rec.Body)
+	}
+	var result struct{ Params map[string]interface{} }
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Params["bound"] != "init" || result.Params["shared"] != "run" {
+		t.Errorf("params = %v, want the bound param kept and overridden by the run param", result.Params)
+	}
+}
+
+func TestInitRejectsOversizedSource(t *testing.T) {
+	saved := maxSourceBytes
+	maxSourceBytes = len(helloAction)
+	defer func() { maxSourceBytes = saved }()
+
+	rec := postInit(t, map[string]interface{}{"code": helloAction + "\n"})
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusRequestEntityTooLarge, rec.Body)
+	}
+	want := fmt.Sprintf("Action code exceeds maximum size of %d bytes", maxSourceBytes)
+	if resp := decodeError(t, rec); resp.Error != want {
+		t.Errorf("error = %q, want %q", resp.Error, want)
+	}
+}
+
+func TestInitBuildsWithLocalReplace(t *testing.T) {
+	module := t.TempDir()
+	files := map[string]string{
+		"go.mod":   "module example.com/greet\n\ngo 1.21\n",
+		"greet.go": "package greet\n\nfunc Hello() string { return \"hello from replace\" }\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(module, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	action := `package main
+
+import (
+	"fmt"
+
+	"example.com/greet"
+)
+
+func main() {
+	fmt.Printf("{\"greeting\":%q}", greet.Hello())
+}
+`
+	initAction(t, map[string]interface{}{
+		"code":        action,
+		"go_replaces": map[string]string{"example.com/greet": module},
+	})
+
+	rec := postRun(t, "", map[string]interface{}{})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "hello from replace") {
+		t.Errorf("run = %d %s, want the replaced module's greeting", rec.Code, rec.Body)
+	}
+}
+
+func TestNonJSONOutputWrappedUnderConfiguredKey(t *testing.T) {
+	saved := resultWrapKey
+	resultWrapKey = "output"
+	defer func() { resultWrapKey = saved }()
+
+	result := parseResult("plain text\n")
+	if len(result) != 1 || result["output"] != "plain text" {
+		t.Errorf("parseResult() = %v, want the output under %q", result, "output")
+	}
+	if result := parseResult(`{"ok":true}`); result["ok"] != true {
+		t.Errorf("parseResult() = %v, want JSON output unwrapped", result)
+	}
+}
+
+func TestBuildMemoryLimitFailsCleanly(t *testing.T) {
+	if goruntime.GOOS != "linux" {
+		t.Skip("build memory limits use ulimit -v, which is Linux only here")
+	}
+	saved := buildMemoryMB
+	buildMemoryMB = 256
+	defer func() { buildMemoryMB = saved }()
+
+	// A large table for the compiler to chew on
+	var source strings.Builder
+	source.WriteString("package main\n\nvar table = []string{\n")
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&source, "\t%q,\n", strings.Repeat("x", i%64))
+	}
+	source.WriteString("}\n\nfunc main() { println(len(table)) }\n")
+
+	rec := postInit(t, map[string]interface{}{"code": source.String()})
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusBadGateway, rec.Body)
+	}
+	want := fmt.Sprintf("Compilation failed: compile exceeded memory limit of %d MB", buildMemoryMB)
+	if resp := decodeError(t, rec); resp.Error != want {
+		t.Errorf("error = %q, want %q", resp.Error, want)
+	}
+}
+
+func TestTransactionHeaderPrefixesActionLogs(t *testing.T) {
+	initAction(t, map[string]interface{}{"code": probeAction})
+
+	header := http.Header{}
+	header.Set(transactionHeader, "tx-1")
+	header.Set(traceParentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	var rec *httptest.ResponseRecorder
+	logs := captureStdout(t, func() {
+		rec = sendRun(t, map[string]interface{}{"value": map[string]interface{}{}}, header)
+	})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("run status = %d (%s)", rec.Code, rec.Body)
+	}
+
+	if !strings.Contains(logs, "[tx-1] probe ran\n") {
+		t.Errorf("logs %q don't carry the transaction ID", logs)
+	}
+	var result probeResult
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := result.getenv("__OW_TRANSACTION_ID"); id != "tx-1" {
+		t.Errorf("__OW_TRANSACTION_ID = %q, want tx-1", id)
+	}
+	if traceParent, _ := result.getenv("TRACEPARENT"); traceParent != header.Get(traceParentHeader) {
+		t.Errorf("TRACEPARENT = %q, want the traceparent header", traceParent)
+	}
+}
+
+func TestRunReportsActionExitCode(t *testing.T) {
+	initAction(t, map[string]interface{}{"code": "package main\n\nimport \"os\"\n\nfunc main() { os.Exit(3) }\n"})
+
+	rec := postRun(t, "", map[string]interface{}{})
+	if rec.Code != http.StatusBadGateway {
+		t.Fatalf("status = %d, want %d (%s)", rec.Code, http.StatusBadGateway, rec.Body)
+	}
+	if resp := decodeError(t, rec); resp.ExitCode != 3 {
+		t.Errorf("exit code = %d, want 3 (%s)", resp.ExitCode, resp.Error)
+	}
+}
+
+func TestGoBinaryStubInvoked(t *testing.T) {
+	dir := t.TempDir()
+	calls := filepath.Join(dir, "calls")
+	script := `#!/bin/sh
+echo "$@" >> "$CALLS"
+[ "$1" = version ] && echo "go version go0.0-stub linux/arm64"
+while [ $# -gt 0 ]; do
+	[ "$1" = -o ] && : > "$2"
+	shift
+done
+exit 0
+`
+	stub := filepath.Join(dir, "go-stub")
+	if err := os.WriteFile(stub, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CALLS", calls)
+
+	savedBinary, savedVersion := goBinary, goVersion
+	goBinary = stub
+	defer func() { goBinary, goVersion = savedBinary, savedVersion }()
+
+	if err := probeToolchain(); err != nil {
+		t.Fatalf("probeToolchain: %v", err)
+	}
+	initAction(t, map[string]interface{}{"code": helloAction})
+
+	recorded, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatalf("stub never invoked: %v", err)
+	}
+	for _, want := range []string{"version", "mod init action", "build "} {
+		if !strings.Contains(string(recorded), want) {
+			t.Errorf("stub calls %q missing %q", recorded, want)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	healthHandler(rec, httptest.NewRequest(http.MethodGet, "/health", nil))
+	if !strings.Contains(rec.Body.String(), "go0.0-stub") {
+		t.Errorf("health = %s, want the stub's go version", rec.Body)
+	}
+}
+
+func TestActionAnnotationsPassedThroughAndValidated(t *testing.T) {
+	initAction(t, map[string]interface{}{"code": `package main
+
+import "fmt"
+
+func main() {
+	fmt.Print("{\"ok\":true,\"__ow_annotations\":{\"cost\":2,\"tier\":\"gold\",\"cached\":false}}")
+}
+`})
+	rec := postRun(t, "", map[string]interface{}{})
+	var result map[string]interface{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &result); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("run = %d %s", rec.Code, rec.Body)
+	}
+	annotations, _ := result[annotationsKey].(map[string]interface{})
+	if annotations["cost"] != 2.0 || annotations["tier"] != "gold" || annotations["cached"] != false {
+		t.Errorf("annotations = %v, want the action's", result[annotationsKey])
+	}
+
+	for _, invalid := range []string{
+		`{"__ow_annotations":"cost=2"}`,
+		`{"__ow_annotations":{"nested":{"cost":2}}}`,
+		`{"__ow_annotations":{"list":[1,2]}}`,
+	} {
+		if err := validateAnnotations(parseResult(invalid)); err == nil {
+			t.Errorf("validateAnnotations(%s) = nil, want an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the histogram upper bounds in seconds, covering fast
+// runs through slow cold compiles
+var durationBuckets = []float64{0.005, 0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// histogram is a cumulative Prometheus-style histogram
+type histogram struct {
+	counts []uint64 // per bucket, non-cumulative
+	sum    float64
+	count  uint64
+}
+
+func (h *histogram) observe(seconds float64) {
+	if h.counts == nil {
+		h.counts = make([]uint64, len(durationBuckets))
+	}
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += seconds
+	h.count++
+}
+
+// runtimeMetrics holds the series served on /metrics. The runtime has no
+// dependencies, so it writes the Prometheus text format itself
+type runtimeMetrics struct {
+	mu       sync.Mutex
+	requests map[string]uint64 // "endpoint/result" -> count
+	errors   map[string]uint64 // "endpoint/class" -> count
+	compile  histogram
+	run      histogram
+
+	cacheHits   uint64 // inits that reused a cached binary
+	cacheMisses uint64 // inits that compiled
+}
+
+var metrics = &runtimeMetrics{
+	requests: make(map[string]uint64),
+	errors:   make(map[string]uint64),
+}
+
+// observeCompile records a successful compilation's duration
+func (m *runtimeMetrics) observeCompile(d time.Duration) {
+	m.mu.Lock()
+	m.compile.observe(d.Seconds())
+	m.mu.Unlock()
+}
+
+// observeBuildCache counts an init's build cache lookup
+func (m *runtimeMetrics) observeBuildCache(hit bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if hit {
+		m.cacheHits++
+	} else {
+		m.cacheMisses++
+	}
+}
+
+// errorClass maps a handler's error status to the class it is counted under
+func errorClass(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusRequestEntityTooLarge:
+		return "too_large"
+	case http.StatusBadGateway:
+		return "action_failed"
+	case http.StatusServiceUnavailable:
+		return "unavailable"
+	case http.StatusGatewayTimeout:
+		return "timeout"
+	default:
+		return "internal"
+	}
+}
+
+// statusRecorder captures the status code a handler writes
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	reported int // status to count instead, set by reportStatus
+}
+
+// reportStatus sets the status a response is counted under when it differs
+// from the one written, as for streamed runs that fail after sending 200 or
+// runs that time out
+func reportStatus(w http.ResponseWriter, status int) {
+	if rec, ok := w.(*statusRecorder); ok {
+		rec.reported = status
+	}
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush keeps streamed runs flushing through the recorder
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// instrument counts an endpoint's requests by result and error class, and
+// records /run durations
+func instrument(endpoint string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		next(rec, r)
+		elapsed := time.Since(start)
+
+		status := rec.status
+		if rec.reported != 0 {
+			status = rec.reported
+		}
+
+		metrics.mu.Lock()
+		defer metrics.mu.Unlock()
+
+		if status < 400 {
+			metrics.requests[endpoint+"/ok"]++
+		} else {
+			metrics.requests[endpoint+"/error"]++
+			metrics.errors[endpoint+"/"+errorClass(status)]++
+		}
+		if endpoint == "run" {
+			metrics.run.observe(elapsed.Seconds())
+		}
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	var b strings.Builder
+	b.WriteString("# HELP runtime_requests_total Init and run requests by result.\n")
+	b.WriteString("# TYPE runtime_requests_total counter\n")
+	writeLabeled(&b, "runtime_requests_total", "result", metrics.requests)
+
+	b.WriteString("# HELP runtime_errors_total Failed init and run requests by error class.\n")
+	b.WriteString("# TYPE runtime_errors_total counter\n")
+	writeLabeled(&b, "runtime_errors_total", "class", metrics.errors)
+
+	b.WriteString("# HELP runtime_build_cache_total Init build cache lookups by result.\n")
+	b.WriteString("# TYPE runtime_build_cache_total counter\n")
+	fmt.Fprintf(&b, "runtime_build_cache_total{result=\"hit\"} %d\n", metrics.cacheHits)
+	fmt.Fprintf(&b, "runtime_build_cache_total{result=\"miss\"} %d\n", metrics.cacheMisses)
+
+	writeHistogram(&b, "runtime_compile_duration_seconds", "Action compilation time.", &metrics.compile)
+	writeHistogram(&b, "runtime_run_duration_seconds", "Action run request time.", &metrics.run)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, b.String())
+}
+
+// writeLabeled writes one sample per "endpoint/value" key in sorted order
+func writeLabeled(b *strings.Builder, name, label string, values map[string]uint64) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		endpoint, value, _ := strings.Cut(key, "/")
+		fmt.Fprintf(b, "%s{endpoint=%q,%s=%q} %d\n", name, endpoint, label, value, values[key])
+	}
+}
+
+func writeHistogram(b *strings.Builder, name, help string, h *histogram) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+
+	var cumulative uint64
+	for i, bound := range durationBuckets {
+		if h.counts != nil {
+			cumulative += h.counts[i]
+		}
+		fmt.Fprintf(b, "%s_bucket{le=\"%g\"} %d\n", name, bound, cumulative)
+	}
+	fmt.Fprintf(b, "%s_bucket{le=\"+Inf\"} %d\n", name, h.count)
+	fmt.Fprintf(b, "%s_sum %g\n%s_count %d\n", name, h.sum, name, h.count)
+}
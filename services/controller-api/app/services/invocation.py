@@ -79,7 +79,8 @@ class InvocationService:
         result_only: bool = False,
         timeout_ms: int = 60000,
         subject: str = "anonymous",
-        cause: Optional[str] = None
+        cause: Optional[str] = None,
+        sequence_index: Optional[int] = None
     ) -> dict[str, Any]:
         """
         Invoke an OpenWhisk action.
@@ -93,6 +94,7 @@ class InvocationService:
             timeout_ms: Maximum execution time in milliseconds
             subject: Email/ID of invoking user
             cause: Parent activation ID for sequences
+            sequence_index: Position of this action within its parent sequence
 
         Returns:
             If blocking=True, result_only=False: Full activation record
@@ -163,7 +165,8 @@ class InvocationService:
             params=params,
             start_time=start_time,
             subject=subject,
-            cause=cause
+            cause=cause,
+            sequence_index=sequence_index
         )
 
         # Select invoker and publish message
@@ -271,7 +274,8 @@ class InvocationService:
                     blocking=True,
                     result_only=False,
                     subject=subject,
-                    cause=sequence_activation_id
+                    cause=sequence_activation_id,
+                    sequence_index=idx
                 )
 
                 # Extract result for next action
@@ -546,8 +550,10 @@ class InvocationService:
         """
         Select an invoker for action execution.
 
-        Currently uses simple random selection. Future enhancements:
-        - Check invoker health from heartbeats
+        Picks at random among invokers whose latest heartbeat doesn't report
+        them overloaded. If every invoker is overloaded, one of them is still
+        picked so the invocation queues rather than fails. Without heartbeats,
+        falls back to the default invoker set. Future enhancements:
         - Load balancing based on queue depth
         - Affinity for warm container reuse
         - Resource-aware scheduling
@@ -555,12 +561,22 @@ class InvocationService:
         Returns:
             Invoker ID to use for invocation
         """
-        # TODO: Implement invoker health checking from heartbeats
         # TODO: Implement smart load balancing
-
-        # Simple random selection for now
-        invoker_count = 3  # Default number of invokers
-        invoker_id = f"invoker{random.randint(0, invoker_count - 1)}"
+        try:
+            heartbeats = self.messaging.get_invoker_health()
+        except Exception as e:
+            logger.warning(f"Could not read invoker heartbeats: {e}")
+            heartbeats = []
+
+        available = [hb["invoker_id"] for hb in heartbeats if not hb.get("overloaded")]
+        if available:
+            invoker_id = random.choice(available)
+        elif heartbeats:
+            logger.warning("All invokers report overloaded, queueing anyway")
+            invoker_id = random.choice([hb["invoker_id"] for hb in heartbeats])
+        else:
+            invoker_count = 3  # Default number of invokers
+            invoker_id = f"invoker{random.randint(0, invoker_count - 1)}"
 
         logger.debug(f"Selected invoker: {invoker_id}")
         return invoker_id
@@ -573,7 +589,8 @@ class InvocationService:
         params: dict[str, Any],
         start_time: int,
         subject: str,
-        cause: Optional[str]
+        cause: Optional[str],
+        sequence_index: Optional[int] = None
     ) -> None:
         """
         Create initial activation record with 'pending' status.
@@ -586,6 +603,7 @@ class InvocationService:
             start_time: Start time in epoch milliseconds
             subject: Invoking user
             cause: Parent activation ID
+            sequence_index: Position within the parent sequence, if any
         """
         # Build fully qualified action name
         ns = self.db.namespace[action.namespace_id]
@@ -595,6 +613,23 @@ class InvocationService:
         else:
             fqn = f"/{ns.name}/{action.name}"
 
+        annotations = {
+            "path": fqn,
+            "kind": action.exec_kind,
+            "limits": {
+                "timeout": action.limits_timeout,
+                "memory": action.limits_memory,
+                "logs": action.limits_logs,
+                "concurrency": action.limits_concurrency
+            }
+        }
+
+        # Record the sequence chain so it can be reconstructed from children
+        if cause:
+            annotations["causedBy"] = cause
+        if sequence_index is not None:
+            annotations["sequenceIndex"] = sequence_index
+
         # Create activation record
         self.db.activation.insert(
             activation_id=activation_id,
@@ -609,16 +644,7 @@ class InvocationService:
             response_success=True,
             response_result=None,
             logs=[],
-            annotations={
-                "path": fqn,
-                "kind": action.exec_kind,
-                "limits": {
-                    "timeout": action.limits_timeout,
-                    "memory": action.limits_memory,
-                    "logs": action.limits_logs,
-                    "concurrency": action.limits_concurrency
-                }
-            },
+            annotations=annotations,
             cause=cause,
             publish=action.publish
         )
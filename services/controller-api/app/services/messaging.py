@@ -316,6 +316,7 @@ class MessagingService:
                                 msg_data.get("active_containers", 0)
                             ),
                             "status": msg_data.get("status", "unknown"),
+                            "overloaded": msg_data.get("overloaded") == "true",
                         }
                     )
 
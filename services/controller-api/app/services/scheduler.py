@@ -35,6 +35,7 @@ class InvokerInfo:
     last_heartbeat: datetime
     capacity: InvokerCapacity
     status: str  # healthy, unhealthy, draining
+    overloaded: bool = False  # concurrency above the invoker's high watermark
 
 
 class SchedulerService:
@@ -133,6 +134,7 @@ class SchedulerService:
                     "invoker_id": str,
                     "timestamp": str (ISO format),
                     "status": str,
+                    "overloaded": bool,
                     "capacity": {
                         "total_memory": int,
                         "available_memory": int,
@@ -147,6 +149,7 @@ class SchedulerService:
             invoker_id = heartbeat["invoker_id"]
             timestamp = datetime.fromisoformat(heartbeat["timestamp"])
             status = heartbeat.get("status", "healthy")
+            overloaded = heartbeat.get("overloaded") in (True, "true")
             capacity_data = heartbeat.get("capacity", {})
 
             capacity = InvokerCapacity(
@@ -163,11 +166,13 @@ class SchedulerService:
                     invoker_id=invoker_id,
                     last_heartbeat=timestamp,
                     capacity=capacity,
-                    status=status
+                    status=status,
+                    overloaded=overloaded
                 )
 
             logger.debug(
                 f"Updated invoker {invoker_id}: status={status}, "
+                f"overloaded={overloaded}, "
                 f"available_memory={capacity.available_memory}MB"
             )
 
@@ -183,7 +188,7 @@ class SchedulerService:
         Select optimal invoker for action execution.
 
         Selection criteria:
-        1. Invoker must be healthy
+        1. Invoker must be healthy and not report itself overloaded
         2. Invoker must have enough available memory
         3. Invoker must support required runtime
         4. Prefer invoker with warm container for this runtime
@@ -199,13 +204,14 @@ class SchedulerService:
             healthy_invokers = [
                 info for info in self._invokers.values()
                 if info.status == "healthy"
+                and not info.overloaded
                 and info.capacity.available_memory >= memory_required
                 and action_kind in info.capacity.supported_runtimes
             ]
 
             if not healthy_invokers:
                 logger.warning(
-                    f"No healthy invoker available for {action_kind} "
+                    f"No healthy, non-overloaded invoker available for {action_kind} "
                     f"with {memory_required}MB memory"
                 )
                 return None
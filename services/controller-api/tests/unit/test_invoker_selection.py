@@ -0,0 +1,66 @@
+"""Unit tests for routing invocations around overloaded invokers."""
+
+from __future__ import annotations
+
+from datetime import datetime
+
+from app.services.invocation import InvocationService
+from app.services.scheduler import SchedulerService
+
+
+class FakeMessaging:
+    """Messaging stand-in serving fixed invoker heartbeats."""
+
+    def __init__(self, heartbeats: list[dict]) -> None:
+        self.heartbeats = heartbeats
+
+    def get_invoker_health(self) -> list[dict]:
+        return self.heartbeats
+
+
+def heartbeat(invoker_id: str, overloaded: bool) -> dict:
+    """Build a scheduler heartbeat for an invoker with room for any action."""
+    return {
+        "invoker_id": invoker_id,
+        "timestamp": datetime.utcnow().isoformat(),
+        "status": "healthy",
+        "overloaded": overloaded,
+        "capacity": {
+            "total_memory": 4096,
+            "available_memory": 4096 if overloaded else 1024,
+            "supported_runtimes": ["go:1.23"],
+        },
+    }
+
+
+def test_invocation_skips_overloaded_invokers() -> None:
+    messaging = FakeMessaging([
+        {"invoker_id": "invoker0", "overloaded": True},
+        {"invoker_id": "invoker1", "overloaded": False},
+    ])
+    service = InvocationService(None, messaging, None)
+
+    for _ in range(20):
+        assert service._select_invoker() == "invoker1"
+
+
+def test_invocation_queues_when_all_invokers_overloaded() -> None:
+    messaging = FakeMessaging([
+        {"invoker_id": "invoker0", "overloaded": True},
+        {"invoker_id": "invoker1", "overloaded": True},
+    ])
+    service = InvocationService(None, messaging, None)
+
+    assert service._select_invoker() in ("invoker0", "invoker1")
+
+
+def test_scheduler_skips_overloaded_invokers() -> None:
+    scheduler = SchedulerService(FakeMessaging([]))
+    # The overloaded invoker has more free memory and would win otherwise
+    scheduler.update_invoker_status(heartbeat("invoker0", overloaded=True))
+    scheduler.update_invoker_status(heartbeat("invoker1", overloaded=False))
+
+    assert scheduler.select_invoker("go:1.23", 256) == "invoker1"
+
+    scheduler.update_invoker_status(heartbeat("invoker1", overloaded=True))
+    assert scheduler.select_invoker("go:1.23", 256) is None
@@ -0,0 +1,118 @@
+"""Unit tests for annotating sequence component activations."""
+
+from __future__ import annotations
+
+from types import SimpleNamespace
+from typing import Any
+
+from app.services.invocation import InvocationService
+
+
+class FakeTable:
+    """Table stand-in keyed by row ID that records inserts."""
+
+    def __init__(self, rows: dict[int, Any] | None = None) -> None:
+        self.rows = rows or {}
+        self.inserted: list[dict[str, Any]] = []
+
+    def __getitem__(self, row_id: int) -> Any:
+        return self.rows[row_id]
+
+    def insert(self, **fields: Any) -> None:
+        self.inserted.append(fields)
+
+
+class FakeDB:
+    """Database stand-in with a single namespace and no packages."""
+
+    def __init__(self) -> None:
+        self.namespace = FakeTable({1: SimpleNamespace(name="guest")})
+        self.package = FakeTable()
+        self.activation = FakeTable()
+
+    def commit(self) -> None:
+        pass
+
+
+class FakeMessaging:
+    """Messaging stand-in recording published invocations."""
+
+    def __init__(self) -> None:
+        self.published: list[dict[str, Any]] = []
+
+    def get_invoker_health(self) -> list[dict]:
+        return []
+
+    def publish_invocation(self, invoker_id: str, message: dict[str, Any]) -> None:
+        self.published.append(message)
+
+
+def action(name: str, **fields: Any) -> SimpleNamespace:
+    """Build an action row in the guest namespace's default package."""
+    row = {
+        "id": name,
+        "name": name,
+        "namespace_id": 1,
+        "package_id": None,
+        "version": "0.0.1",
+        "exec_kind": "go:1.23",
+        "exec_image": None,
+        "exec_binary": False,
+        "exec_main": "main",
+        "exec_code_hash": f"hash-{name}",
+        "exec_components": None,
+        "limits_timeout": 60000,
+        "limits_memory": 256,
+        "limits_logs": 10,
+        "limits_concurrency": 1,
+        "parameters": {},
+        "publish": False,
+    }
+    row.update(fields)
+    return SimpleNamespace(**row)
+
+
+def test_sequence_components_annotated_with_parent_and_index() -> None:
+    actions = {
+        "pipeline": action("pipeline", exec_kind="sequence",
+                           exec_components=["/guest/a", "/guest/b", "/guest/c"]),
+        "a": action("a"),
+        "b": action("b"),
+        "c": action("c"),
+    }
+    db = FakeDB()
+    messaging = FakeMessaging()
+    service = InvocationService(None, messaging, db)
+    service._get_action = lambda namespace, name: actions.get(name)
+    # Every component succeeds immediately, counting the steps run
+    service._wait_for_result = lambda activation_id, timeout_ms: {
+        "activationId": activation_id,
+        "response": {"success": True, "result": {"steps": len(messaging.published)}},
+    }
+    service._update_activation_result = lambda activation_id, result: None
+
+    parent = service.invoke_action("guest", "pipeline", {"steps": 0})["activationId"]
+
+    children = db.activation.inserted
+    assert [child["action_name"] for child in children] == ["/guest/a", "/guest/b", "/guest/c"]
+    for index, child in enumerate(children):
+        assert child["activation_id"] != parent
+        assert child["cause"] == parent
+        assert child["annotations"]["causedBy"] == parent
+        assert child["annotations"]["sequenceIndex"] == index
+    assert [message["cause"] for message in messaging.published] == [parent] * 3
+    assert [message["params"] for message in messaging.published] == [
+        {"steps": 0}, {"steps": 1}, {"steps": 2}
+    ]
+
+
+def test_standalone_activation_not_annotated_as_sequence_component() -> None:
+    db = FakeDB()
+    service = InvocationService(None, FakeMessaging(), db)
+    service._get_action = lambda namespace, name: action(name)
+
+    service.invoke_action("guest", "a", {})
+
+    annotations = db.activation.inserted[0]["annotations"]
+    assert "causedBy" not in annotations
+    assert "sequenceIndex" not in annotations
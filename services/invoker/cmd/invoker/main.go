@@ -3,29 +3,45 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/docker/docker/client"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/admin"
 	"github.com/penguintechinc/penguinwhisk/invoker/internal/config"
 	"github.com/penguintechinc/penguinwhisk/invoker/internal/container"
 	"github.com/penguintechinc/penguinwhisk/invoker/internal/executor"
-	"github.com/penguintechinc/penguinwhisk/invoker/internal/logs"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/logging"
 	"github.com/penguintechinc/penguinwhisk/invoker/internal/messaging"
-	"github.com/penguintechinc/penguinwhisk/invoker/internal/proxy"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/runtime"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/sizing"
 	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
 )
 
+// shutdownFlushTimeout bounds how long shutdown waits for pending results
+const shutdownFlushTimeout = 10 * time.Second
+
 func main() {
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fmt.Fprintf(os.Stderr, "Failed to load configuration: %v\n", err)
+		os.Exit(1)
 	}
 
-	log.Printf("Starting invoker: %s", cfg.Invoker.ID)
+	// Create the logger shared by all components
+	logger, err := logging.New(cfg.Logging.Level, cfg.Logging.Format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create logger: %v\n", err)
+		os.Exit(1)
+	}
+	defer logger.Sync()
+	zap.ReplaceGlobals(logger)
+
+	logger.Info("Starting invoker", zap.String("invoker_id", cfg.Invoker.ID))
 
 	ctx := context.Background()
 
@@ -35,9 +51,9 @@ func main() {
 	})
 
 	if err := redisClient.Ping(ctx).Err(); err != nil {
-		log.Fatalf("Failed to connect to Redis: %v", err)
+		logger.Fatal("Failed to connect to Redis", zap.Error(err))
 	}
-	log.Println("Connected to Redis")
+	logger.Info("Connected to Redis")
 
 	// Create Docker client
 	dockerClient, err := client.NewClientWithOpts(
@@ -45,59 +61,159 @@ func main() {
 		client.WithAPIVersionNegotiation(),
 	)
 	if err != nil {
-		log.Fatalf("Failed to create Docker client: %v", err)
+		logger.Fatal("Failed to create Docker client", zap.Error(err))
 	}
 	defer dockerClient.Close()
-	log.Println("Connected to Docker daemon")
+	logger.Info("Connected to Docker daemon")
 
 	// Create ContainerManager
-	containerManager := container.NewManager(dockerClient, cfg.Docker.NetworkName, &container.ResourceLimits{
-		MemoryMB:  cfg.Resources.MemoryMB,
-		CPUShares: cfg.Resources.CPUShares,
-	})
+	containerManager, err := container.NewContainerManager(cfg, logger)
+	if err != nil {
+		logger.Fatal("Failed to create container manager", zap.Error(err))
+	}
+
+	// Create runtime registry
+	registry := runtime.DefaultRegistry()
+	for kind, rt := range cfg.Docker.ExecRuntimes {
+		registry.Register(runtime.ExecSpec(kind, rt.Image, rt.Command))
+		logger.Info("Registered exec runtime", zap.String("runtime", kind), zap.String("image", rt.Image))
+	}
+	for language, image := range cfg.Docker.RuntimeImages {
+		if err := registry.OverrideImage(language, image); err != nil {
+			logger.Fatal("Failed to override runtime image", zap.String("language", language), zap.Error(err))
+		}
+		logger.Info("Overriding runtime image", zap.String("language", language), zap.String("image", image))
+	}
+	for kind, digest := range cfg.Docker.ImageDigests {
+		if err := registry.Pin(kind, digest); err != nil {
+			logger.Fatal("Failed to pin runtime image", zap.String("runtime", kind), zap.Error(err))
+		}
+	}
 
 	// Create ContainerPool
-	pool := container.NewPool(containerManager, cfg.Pool.MaxSize, cfg.Pool.IdleTimeout)
+	pool := container.NewContainerPool(containerManager, container.PoolConfig{
+		MaxPoolSize:            cfg.Pool.MaxSize,
+		MaxTotalContainers:     cfg.Pool.MaxTotalContainers,
+		PrewarmConfig:          cfg.Pool.Prewarm,
+		MinWarm:                cfg.Pool.MinWarm,
+		IdleTimeout:            cfg.Pool.IdleTimeout,
+		CleanupInterval:        cfg.Pool.CleanupInterval,
+		CleanupJitter:          cfg.Pool.CleanupJitter,
+		PrewarmJitter:          cfg.Pool.PrewarmJitter,
+		PrewarmParallelism:     cfg.Pool.PrewarmParallelism,
+		KeepFailedContainers:   cfg.Pool.KeepFailedContainers,
+		FailedRetention:        cfg.Pool.FailedRetention,
+		RemoveFailedOnShutdown: cfg.Pool.RemoveFailedOnShutdown,
+		QuarantineFailureRatio: cfg.Pool.QuarantineRatio,
+		PredictiveWarming:      cfg.Pool.PredictiveWarming,
+		DemandWindow:           cfg.Pool.DemandWindow,
+		DemandAlpha:            cfg.Pool.DemandAlpha,
+		PinnedActions:          cfg.Pool.PinnedActions,
+		ReservedCPUs:           cfg.Pool.ReservedCPUs,
+		ActionMetrics:          cfg.Pool.ActionMetrics,
+		ShareByCodeHash:        cfg.Pool.ShareByCodeHash,
+		RuntimeImages:          registry.Images(),
+	}, logger)
 
 	// Create RuntimeProxy
-	runtimeProxy := proxy.NewRuntimeProxy()
+	runtimeProxy := runtime.NewRuntimeProxy(time.Duration(cfg.Invoker.ContainerTimeout)*time.Second, logger)
+	runtimeProxy.SetDockerClient(dockerClient)
+	runtimeProxy.SetInitTimeout(cfg.Invoker.InitTimeout)
+	runtimeProxy.SetResultWrapKey(cfg.Invoker.ResultWrapKey)
 
 	// Create LogCollector
-	logCollector := logs.NewLogCollector(dockerClient)
+	// Log reads share the container manager's bound on Docker API calls
+	logCollector := runtime.NewLogCollector(containerManager.DockerClient().Share(dockerClient))
+	logCollector.SetConcurrency(cfg.Invoker.LogConcurrency)
 
 	// Create Publisher
 	publisher := messaging.NewPublisher(redisClient)
+	publisher.SetRetention(cfg.Activations.Retention, cfg.Activations.NamespaceRetention)
+	publisher.SetCompressThreshold(cfg.Activations.CompressThreshold)
+	publisher.SetRecentActivations(messaging.NewRecentActivations(cfg.Activations.RecentCapacity))
 
 	// Create Executor
-	exec := executor.NewExecutor(pool, runtimeProxy, logCollector, publisher)
+	exec := executor.NewExecutor(pool, runtimeProxy, logCollector, publisher, registry, logger)
+	exec.SetImageAllowlist(container.NewImageAllowlist(cfg.Docker.ImageAllowlist))
+	exec.SetLimitsAnnotation(cfg.Invoker.LimitsAnnotation)
+	exec.SetRedactions(cfg.Invoker.Redactions)
+	exec.SetEnvLimits(cfg.Invoker.MaxEnvVars, cfg.Invoker.MaxEnvBytes)
+	if cfg.Invoker.CodeSigningKey != "" {
+		verifier, err := executor.LoadCodeVerifier(cfg.Invoker.CodeSigningKey)
+		if err != nil {
+			logger.Fatal("Failed to load code signing key", zap.Error(err))
+		}
+		exec.SetCodeVerifier(verifier)
+		logger.Info("Action code signing enforced")
+	}
+
+	// Create memory sizing advisor
+	var advisor *sizing.Advisor
+	if cfg.Invoker.MemorySuggestions {
+		advisor = sizing.NewAdvisor(redisClient, containerManager, cfg.Invoker.MemorySuggestionInterval, cfg.Invoker.MemoryHeadroom, logger)
+		exec.SetMemoryAdvisor(advisor)
+		advisor.Start(ctx)
+		logger.Info("Memory sizing advisor started")
+	}
 
 	// Create Consumer with Executor as handler
-	consumer := messaging.NewConsumer(redisClient, cfg.Invoker.ID, exec)
+	consumer, err := messaging.NewConsumer(cfg.Redis.URL, cfg.Invoker.ID, exec, logger)
+	if err != nil {
+		logger.Fatal("Failed to create consumer", zap.Error(err))
+	}
+	consumer.SetPublisher(publisher)
+	consumer.SetDedupTTL(cfg.Invoker.DedupTTL)
+	consumer.SetDeadlineGrace(time.Duration(cfg.Invoker.DeadlineGraceMs) * time.Millisecond)
+	consumer.SetStartPosition(cfg.Invoker.StartPosition)
+	consumer.SetLeaveGroupOnStop(cfg.Invoker.LeaveGroupOnStop)
+	if cfg.Invoker.Events {
+		events := messaging.NewEventEmitter(redisClient, cfg.Invoker.EventsChannel, cfg.Invoker.ID, logger)
+		consumer.SetEventEmitter(events)
+		pool.SetColdStartObserver(events)
+		logger.Info("Publishing lifecycle events", zap.String("channel", cfg.Invoker.EventsChannel))
+	}
+	if cfg.Invoker.MaxConcurrent > 0 {
+		capacity, err := messaging.NewCapacityLimiter(cfg.Invoker.MaxConcurrent, cfg.Invoker.Reservations)
+		if err != nil {
+			logger.Fatal("Invalid namespace reservations", zap.Error(err))
+		}
+		consumer.SetCapacityLimiter(capacity)
+	}
 
 	// Create HeartbeatPublisher
-	heartbeat := messaging.NewHeartbeatPublisher(redisClient, cfg.Invoker.ID, cfg.Invoker.HeartbeatInterval)
+	heartbeat := messaging.NewHeartbeatPublisher(redisClient, cfg.Invoker.ID, cfg.Invoker.HeartbeatInterval, logger)
+	heartbeat.SetLoadReporter(consumer, cfg.Invoker.MaxConcurrent, cfg.Invoker.HighWatermark)
 
 	// Start heartbeat publisher
 	heartbeat.Start(ctx)
-	log.Println("Heartbeat publisher started")
+	logger.Info("Heartbeat publisher started")
 
 	// Prewarm containers
 	if len(cfg.Pool.Prewarm) > 0 {
-		log.Printf("Prewarming containers: %v", cfg.Pool.Prewarm)
-		for runtime, count := range cfg.Pool.Prewarm {
-			for i := 0; i < count; i++ {
-				if err := pool.Prewarm(ctx, runtime); err != nil {
-					log.Printf("Failed to prewarm container for runtime %s: %v", runtime, err)
-				}
-			}
+		logger.Info("Prewarming containers",
+			zap.Any("prewarm", cfg.Pool.Prewarm),
+			zap.Int("parallelism", cfg.Pool.PrewarmParallelism))
+		// Partial failures leave the rest of the prewarmed pool in place
+		if err := pool.PrewarmContainers(ctx); err != nil {
+			logger.Error("Failed to prewarm some containers", zap.Error(err))
 		}
-		log.Println("Container prewarming complete")
+		logger.Info("Container prewarming complete")
 	}
 
+	// Start admin server
+	adminServer := admin.NewServer(cfg.Invoker.Port, cfg.Invoker.AdminToken, pool, consumer, logger)
+	adminServer.SetActivations(publisher)
+	go func() {
+		if err := adminServer.Start(); err != nil {
+			logger.Error("Admin server error", zap.Error(err))
+		}
+	}()
+	logger.Info("Admin server listening", zap.Int("port", cfg.Invoker.Port))
+
 	// Start consumer in a goroutine
 	consumerErrCh := make(chan error, 1)
 	go func() {
-		log.Println("Starting consumer...")
+		logger.Info("Starting consumer")
 		if err := consumer.Start(ctx); err != nil {
 			consumerErrCh <- err
 		}
@@ -110,25 +226,50 @@ func main() {
 	// Wait for shutdown signal or consumer error
 	select {
 	case sig := <-sigChan:
-		log.Printf("Received signal %v, shutting down...", sig)
+		logger.Info("Received signal, shutting down", zap.String("signal", sig.String()))
 	case err := <-consumerErrCh:
-		log.Printf("Consumer error: %v, shutting down...", err)
+		logger.Error("Consumer error, shutting down", zap.Error(err))
 	}
 
-	// Cleanup
-	log.Println("Stopping consumer...")
-	consumer.Stop()
+	// Cleanup: stop taking work, let in-flight invocations finish, then flush
+	// their results before the Redis connection goes away
+	logger.Info("Stopping admin server")
+	if err := adminServer.Shutdown(ctx); err != nil {
+		logger.Error("Error stopping admin server", zap.Error(err))
+	}
+
+	logger.Info("Stopping consumer")
+	if pending := consumer.Stop(); len(pending) > 0 {
+		logger.Info("Invocations pending at shutdown", zap.Strings("message_ids", pending))
+	}
 
-	log.Println("Stopping heartbeat publisher...")
+	logger.Info("Stopping heartbeat publisher")
 	heartbeat.Stop()
 
-	log.Println("Draining container pool...")
-	pool.Drain(ctx)
+	if advisor != nil {
+		logger.Info("Stopping memory sizing advisor")
+		advisor.Stop()
+	}
+
+	// Containers still around at the drain deadline are force-removed
+	logger.Info("Draining container pool", zap.Duration("timeout", cfg.Shutdown.DrainTimeout))
+	drainCtx, cancelDrain := context.WithTimeout(context.Background(), cfg.Shutdown.DrainTimeout)
+	if err := pool.Shutdown(drainCtx); err != nil {
+		logger.Error("Error draining container pool", zap.Error(err))
+	}
+	cancelDrain()
+
+	logger.Info("Flushing pending results")
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), shutdownFlushTimeout)
+	if err := publisher.Flush(flushCtx); err != nil {
+		logger.Error("Pending results not flushed before shutdown", zap.Error(err))
+	}
+	cancelFlush()
 
-	log.Println("Closing Redis connection...")
+	logger.Info("Closing Redis connection")
 	if err := redisClient.Close(); err != nil {
-		log.Printf("Error closing Redis connection: %v", err)
+		logger.Error("Error closing Redis connection", zap.Error(err))
 	}
 
-	log.Println("Invoker shutdown complete")
+	logger.Info("Invoker shutdown complete")
 }
@@ -3,8 +3,10 @@ module github.com/penguintechinc/penguinwhisk/invoker
 go 1.23
 
 require (
+	github.com/alicebob/miniredis/v2 v2.31.1
 	github.com/docker/docker v24.0.7
 	github.com/redis/go-redis/v9 v9.5.1
 	github.com/prometheus/client_golang v1.18.0
 	github.com/spf13/viper v1.17.0
+	go.uber.org/zap v1.26.0
 )
@@ -0,0 +1,238 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/container"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/messaging"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// Consumer is the invoker's message consumer as controlled by the admin API
+type Consumer interface {
+	IsReady() bool
+	IsPaused() bool
+	Pause()
+	Resume()
+}
+
+// ActivationReader looks up completed activations by ID
+type ActivationReader interface {
+	GetActivation(ctx context.Context, activationID string) (*messaging.ActivationResult, error)
+}
+
+// Server exposes invoker administration endpoints over HTTP
+type Server struct {
+	pool        *container.ContainerPool
+	consumer    Consumer
+	activations ActivationReader // nil until SetActivations is called
+	token       string
+	httpServer  *http.Server
+	logger      *zap.Logger
+}
+
+// NewServer creates an admin server listening on the given port
+// Admin endpoints require the token as a bearer token; an empty token
+// disables them
+func NewServer(port int, token string, pool *container.ContainerPool, consumer Consumer, logger *zap.Logger) *Server {
+	s := &Server{
+		pool:     pool,
+		consumer: consumer,
+		token:    token,
+		logger:   logger,
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /ready", s.handleReady)
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /admin/stats", s.requireToken(s.handleStats))
+	mux.HandleFunc("POST /admin/pause", s.requireToken(s.handlePause))
+	mux.HandleFunc("POST /admin/resume", s.requireToken(s.handleResume))
+	mux.HandleFunc("POST /admin/cleanup", s.requireToken(s.handleCleanup))
+	mux.HandleFunc("DELETE /admin/actions/{namespace}/{name}/containers", s.requireToken(s.handleRemoveActionContainers))
+	mux.HandleFunc("GET /activations/{id}", s.requireToken(s.handleGetActivation))
+
+	s.httpServer = &http.Server{
+		Addr:              fmt.Sprintf(":%d", port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	return s
+}
+
+// Start serves admin requests until Shutdown is called
+func (s *Server) Start() error {
+	if err := s.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return fmt.Errorf("admin server: %w", err)
+	}
+	return nil
+}
+
+// Shutdown gracefully stops the admin server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// requireToken rejects requests without the configured bearer token
+func (s *Server) requireToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			writeError(w, http.StatusForbidden, "admin endpoints are disabled")
+			return
+		}
+
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid admin token")
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+// handleReady reports readiness for load balancers and orchestrators
+func (s *Server) handleReady(w http.ResponseWriter, r *http.Request) {
+	body := map[string]bool{
+		"ready":  s.consumer.IsReady(),
+		"paused": s.consumer.IsPaused(),
+	}
+	if !body["ready"] {
+		writeJSON(w, http.StatusServiceUnavailable, body)
+		return
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// handleStats reports pool statistics, listing the containers of a runtime
+// when one is given with ?runtime=
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	body := map[string]any{
+		"pool": s.pool.GetPoolStats(),
+	}
+	if runtime := r.URL.Query().Get("runtime"); runtime != "" {
+		body["containers"] = s.pool.ListByRuntime(runtime)
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+// handlePause stops the invoker from picking up new invocations
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	s.consumer.Pause()
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+}
+
+// handleResume lets a paused invoker pick up new invocations again
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	s.consumer.Resume()
+	writeJSON(w, http.StatusOK, map[string]bool{"paused": false})
+}
+
+// handleCleanup evicts warm containers idle longer than ?maxIdle= (a
+// duration, default 0 for all idle containers) without waiting for the
+// cleanup loop. Minimum warm floors are kept
+func (s *Server) handleCleanup(w http.ResponseWriter, r *http.Request) {
+	var maxIdle time.Duration
+	if raw := r.URL.Query().Get("maxIdle"); raw != "" {
+		parsed, err := time.ParseDuration(raw)
+		if err != nil || parsed < 0 {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid maxIdle %q", raw))
+			return
+		}
+		maxIdle = parsed
+	}
+
+	removed, err := s.pool.CleanupIdleContainers(maxIdle)
+	if err != nil {
+		s.logger.Error("Failed to clean up idle containers", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, "failed to clean up idle containers")
+		return
+	}
+
+	total := 0
+	for _, count := range removed {
+		total += count
+	}
+	s.logger.Info("Evicted idle containers on demand",
+		zap.Duration("maxIdle", maxIdle),
+		zap.Int("removed", total))
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"maxIdle": maxIdle.String(),
+		"removed": removed,
+		"total":   total,
+	})
+}
+
+// handleRemoveActionContainers force-removes all containers for an action
+func (s *Server) handleRemoveActionContainers(w http.ResponseWriter, r *http.Request) {
+	namespace := r.PathValue("namespace")
+	name := r.PathValue("name")
+
+	removed, marked, err := s.pool.RemoveContainersForAction(r.Context(), namespace, name)
+	if err != nil {
+		s.logger.Error("Failed to remove containers for action",
+			zap.Error(err),
+			zap.String("namespace", namespace),
+			zap.String("name", name))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"namespace":        namespace,
+		"name":             name,
+		"removed":          removed,
+		"markedForRemoval": marked,
+	})
+}
+
+// handleGetActivation returns a recently completed or stored activation
+func (s *Server) handleGetActivation(w http.ResponseWriter, r *http.Request) {
+	if s.activations == nil {
+		writeError(w, http.StatusNotFound, "activation not found")
+		return
+	}
+
+	activationID := r.PathValue("id")
+	activation, err := s.activations.GetActivation(r.Context(), activationID)
+	if errors.Is(err, messaging.ErrActivationNotFound) {
+		writeError(w, http.StatusNotFound, "activation not found")
+		return
+	}
+	if err != nil {
+		s.logger.Error("Failed to look up activation",
+			zap.Error(err),
+			zap.String("activation_id", activationID))
+		writeError(w, http.StatusInternalServerError, "failed to look up activation")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, activation)
+}
+
+// SetActivations enables activation lookup by ID
+func (s *Server) SetActivations(activations ActivationReader) {
+	s.activations = activations
+}
+
+// writeJSON writes a JSON response body
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		zap.L().Warn("Failed to write admin response", zap.Error(err))
+	}
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
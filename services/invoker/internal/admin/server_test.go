@@ -0,0 +1,82 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/container"
+	"go.uber.org/zap"
+)
+
+// fakeConsumer is a consumer that is always ready
+type fakeConsumer struct {
+	paused bool
+}
+
+func (c *fakeConsumer) IsReady() bool  { return true }
+func (c *fakeConsumer) IsPaused() bool { return c.paused }
+func (c *fakeConsumer) Pause()         { c.paused = true }
+func (c *fakeConsumer) Resume()        { c.paused = false }
+
+// newTestServer returns an admin server over an empty pool
+func newTestServer(t *testing.T, token string) *Server {
+	t.Helper()
+
+	pool := container.NewContainerPool(nil, container.PoolConfig{MaxPoolSize: 1, CleanupInterval: time.Hour}, zap.NewNop())
+	t.Cleanup(func() { pool.Shutdown(context.Background()) })
+	return NewServer(0, token, pool, &fakeConsumer{}, zap.NewNop())
+}
+
+// do sends a request to the server with token as its bearer token
+func do(s *Server, method, target, token string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.httpServer.Handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestCleanupRequiresToken(t *testing.T) {
+	if rec := do(newTestServer(t, ""), http.MethodPost, "/admin/cleanup", "anything"); rec.Code != http.StatusForbidden {
+		t.Errorf("cleanup without a configured token = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+
+	s := newTestServer(t, "secret")
+	for _, token := range []string{"", "wrong"} {
+		if rec := do(s, http.MethodPost, "/admin/cleanup", token); rec.Code != http.StatusUnauthorized {
+			t.Errorf("cleanup with token %q = %d, want %d", token, rec.Code, http.StatusUnauthorized)
+		}
+	}
+}
+
+func TestCleanupEvictsIdleContainers(t *testing.T) {
+	s := newTestServer(t, "secret")
+
+	for _, maxIdle := range []string{"soon", "-1m"} {
+		if rec := do(s, http.MethodPost, "/admin/cleanup?maxIdle="+maxIdle, "secret"); rec.Code != http.StatusBadRequest {
+			t.Errorf("cleanup with maxIdle %q = %d, want %d", maxIdle, rec.Code, http.StatusBadRequest)
+		}
+	}
+
+	rec := do(s, http.MethodPost, "/admin/cleanup?maxIdle=5m", "secret")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("cleanup = %d: %s", rec.Code, rec.Body)
+	}
+	var summary struct {
+		MaxIdle string         `json:"maxIdle"`
+		Removed map[string]int `json:"removed"`
+		Total   *int           `json:"total"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&summary); err != nil {
+		t.Fatalf("decoding summary: %v", err)
+	}
+	if summary.MaxIdle != "5m0s" || summary.Removed == nil || summary.Total == nil || *summary.Total != 0 {
+		t.Errorf("summary = %s, want nothing removed idle over 5m0s", rec.Body)
+	}
+}
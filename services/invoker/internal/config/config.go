@@ -1,19 +1,29 @@
 package config
 
 import (
+	"fmt"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
 )
 
+// runtimeImageEnvPrefix prefixes env vars overriding a runtime language's
+// image, e.g. RUNTIME_IMAGE_GO
+const runtimeImageEnvPrefix = "RUNTIME_IMAGE_"
+
 // Config holds the application configuration
 type Config struct {
-	Redis      RedisConfig
-	Docker     DockerConfig
-	Invoker    InvokerConfig
-	Pool       PoolConfig
-	MinIO      MinIOConfig
-	Resources  ResourceConfig
+	Redis       RedisConfig
+	Docker      DockerConfig
+	Invoker     InvokerConfig
+	Pool        PoolConfig
+	Activations ActivationsConfig
+	MinIO       MinIOConfig
+	Resources   ResourceConfig
+	Logging     LoggingConfig
+	Shutdown    ShutdownConfig
 }
 
 // RedisConfig holds Redis connection settings
@@ -25,25 +35,102 @@ type RedisConfig struct {
 
 // DockerConfig holds Docker daemon settings
 type DockerConfig struct {
-	Host        string
-	APIVersion  string
-	NetworkName string
+	Host            string
+	APIVersion      string
+	NetworkName     string
+	ContainerPrefix string            // names and labels the containers this invoker creates
+	ImageAllowlist  []string          // exact images or "/"-terminated prefixes
+	ImageDigests    map[string]string // runtime kind -> pinned sha256 digest
+	RuntimeImages   map[string]string // runtime language -> image, from RUNTIME_IMAGE_<LANGUAGE>
+
+	// ExecRuntimes registers runtime kinds without an HTTP server, driven
+	// over docker exec
+	ExecRuntimes map[string]ExecRuntimeConfig
+
+	// EntrypointAllowlist holds the entrypoint executables untrusted
+	// runtimes may override the image entrypoint with
+	EntrypointAllowlist []string
+
+	NetworkMode   string        // default network mode: "" (managed network), none, host or a network name
+	CreateTimeout time.Duration // bounds container creation
+	StartTimeout  time.Duration // bounds container start until running
+
+	MaxConcurrentCalls int  // bound on concurrent inspect/create/start/logs calls to the daemon
+	VerifyLimits       bool // warn when a started container's resource limits differ from those requested
+
+	// RestartPolicy ("no" or "on-failure") lets Docker restart crashed
+	// runtimes, at most RestartMaxRetries times
+	RestartPolicy     string
+	RestartMaxRetries int
+}
+
+// ExecRuntimeConfig describes a runtime kind driven over docker exec
+type ExecRuntimeConfig struct {
+	Image   string
+	Command []string // run per activation with the params as JSON on stdin
 }
 
 // InvokerConfig holds invoker-specific settings
 type InvokerConfig struct {
-	ID               string
-	Port             int
-	MaxConcurrent    int
-	ContainerTimeout int
+	ID                string
+	Port              int
+	MaxConcurrent     int
+	ContainerTimeout  int
+	InitTimeout       time.Duration // budget for /init; runs are bound by ContainerTimeout and the action deadline
+	ResultWrapKey     string        // key non-JSON output of exec runtimes is wrapped under
 	HeartbeatInterval time.Duration
+	HighWatermark     float64 // fraction of MaxConcurrent reported as overloaded
+	AdminToken        string  // bearer token for admin endpoints, empty disables them
+	DedupTTL          time.Duration
+	DeadlineGraceMs   int                 // clock skew tolerated before dropping a past-deadline message
+	LimitsAnnotation  string              // action parameter read for unset timeout/memory limits
+	MaxEnvVars        int                 // most env vars an action may set
+	MaxEnvBytes       int                 // total size of an action's env, KEY=value per var
+	CodeSigningKey    string              // PEM ed25519 public key file, empty disables signature checks
+	StartPosition     string              // "$" or "0": where a newly created consumer group starts reading
+	LeaveGroupOnStop  bool                // requeue pending messages and leave the consumer group on shutdown
+	Reservations      map[string]int      // namespace -> concurrent slots reserved out of MaxConcurrent
+	Redactions        map[string][]string // namespace ("*" for all) -> result key paths replaced with "***"
+	LogConcurrency    int                 // concurrent container log reads, 0 for unbounded
+	Events            bool                // publish lifecycle events to EventsChannel
+	EventsChannel     string              // Redis pub/sub channel for lifecycle events
+
+	// MemorySuggestions publishes per-action memory limit suggestions
+	MemorySuggestions        bool
+	MemorySuggestionInterval time.Duration
+	MemoryHeadroom           float64 // fraction added over p95 peak memory
 }
 
 // PoolConfig holds container pool settings
 type PoolConfig struct {
-	MaxSize     int
-	IdleTimeout time.Duration
-	Prewarm     map[string]int // runtime -> count
+	MaxSize                int
+	MaxTotalContainers     int
+	IdleTimeout            time.Duration
+	CleanupInterval        time.Duration
+	CleanupJitter          float64        // fraction each cleanup interval is randomly varied by
+	Prewarm                map[string]int // runtime -> count
+	MinWarm                map[string]int // runtime -> warm floor
+	PrewarmJitter          time.Duration
+	PrewarmParallelism     int // prewarm containers created concurrently at startup
+	KeepFailedContainers   bool
+	FailedRetention        time.Duration
+	RemoveFailedOnShutdown bool    // remove retained failed containers on shutdown
+	QuarantineRatio        float64 // recent failure ratio that removes a container
+	PredictiveWarming      bool
+	DemandWindow           time.Duration
+	DemandAlpha            float64
+	PinnedActions          map[string]int // namespace/action -> dedicated CPUs
+	ReservedCPUs           int            // CPUs kept for unpinned containers
+	ActionMetrics          []string       // namespace/action labeled individually in start metrics
+	ShareByCodeHash        bool           // reuse warm containers across actions, and namespaces, with identical code
+}
+
+// ActivationsConfig holds activation record settings
+type ActivationsConfig struct {
+	Retention          time.Duration
+	NamespaceRetention map[string]time.Duration // namespace -> retention
+	CompressThreshold  int                      // response bytes above which results are gzipped, 0 = never
+	RecentCapacity     int                      // completed activations kept in memory for lookup by ID
 }
 
 // MinIOConfig holds MinIO connection settings
@@ -56,10 +143,21 @@ type MinIOConfig struct {
 
 // ResourceConfig holds container resource limits
 type ResourceConfig struct {
-	MemoryMB int64
+	MemoryMB  int64
 	CPUShares int64
 }
 
+// LoggingConfig holds structured logger settings
+type LoggingConfig struct {
+	Level  string // debug, info, warn or error
+	Format string // json or console
+}
+
+// ShutdownConfig holds graceful shutdown settings
+type ShutdownConfig struct {
+	DrainTimeout time.Duration // deadline for draining the pool before containers are force-removed
+}
+
 // Load loads configuration from environment variables and config files
 func Load() (*Config, error) {
 	viper.SetEnvPrefix("INVOKER")
@@ -72,19 +170,68 @@ func Load() (*Config, error) {
 	viper.SetDefault("docker.host", "unix:///var/run/docker.sock")
 	viper.SetDefault("docker.apiversion", "1.41")
 	viper.SetDefault("docker.networkname", "openwhisk")
+	viper.SetDefault("docker.containerprefix", "whisk")
+	viper.SetDefault("docker.imageallowlist", []string{"ghcr.io/penguintechinc/"})
+	viper.SetDefault("docker.entrypointallowlist", []string{})
+	viper.SetDefault("docker.networkmode", "")
+	viper.SetDefault("docker.createtimeout", "30s")
+	viper.SetDefault("docker.starttimeout", "30s")
+	viper.SetDefault("docker.maxconcurrentcalls", 16)
+	viper.SetDefault("docker.verifylimits", false)
+	viper.SetDefault("docker.restartpolicy", "")
+	viper.SetDefault("docker.restartmaxretries", 3)
 	viper.SetDefault("invoker.id", "invoker0")
 	viper.SetDefault("invoker.port", 8085)
 	viper.SetDefault("invoker.maxconcurrent", 10)
 	viper.SetDefault("invoker.containertimeout", 300)
+	viper.SetDefault("invoker.inittimeout", "5m")
+	viper.SetDefault("invoker.resultwrapkey", "body")
 	viper.SetDefault("invoker.heartbeatinterval", "10s")
+	viper.SetDefault("invoker.highwatermark", 0.8)
+	viper.SetDefault("invoker.admintoken", "")
+	viper.SetDefault("invoker.dedupttl", "10m")
+	viper.SetDefault("invoker.deadlinegracems", 0)
+	viper.SetDefault("invoker.limitsannotation", "limits")
+	viper.SetDefault("invoker.maxenvvars", 1000)
+	viper.SetDefault("invoker.maxenvbytes", 256<<10)
+	viper.SetDefault("invoker.codesigningkey", "")
+	viper.SetDefault("invoker.startposition", "$")
+	viper.SetDefault("invoker.leavegrouponstop", false)
+	viper.SetDefault("invoker.logconcurrency", 4)
+	viper.SetDefault("invoker.events", false)
+	viper.SetDefault("invoker.eventschannel", "penguinwhisk:events")
+	viper.SetDefault("invoker.memorysuggestions", false)
+	viper.SetDefault("invoker.memorysuggestioninterval", "5m")
+	viper.SetDefault("invoker.memoryheadroom", 0.2)
 	viper.SetDefault("pool.maxsize", 100)
+	viper.SetDefault("pool.maxtotalcontainers", 0)
 	viper.SetDefault("pool.idletimeout", "10m")
+	viper.SetDefault("pool.cleanupinterval", "1m")
+	viper.SetDefault("pool.cleanupjitter", 0.1)
+	viper.SetDefault("pool.prewarmjitter", "0s")
+	viper.SetDefault("pool.prewarmparallelism", 4)
+	viper.SetDefault("pool.keepfailedcontainers", false)
+	viper.SetDefault("pool.failedretention", "30m")
+	viper.SetDefault("pool.removefailedonshutdown", false)
+	viper.SetDefault("pool.quarantineratio", 0.5)
+	viper.SetDefault("pool.predictivewarming", false)
+	viper.SetDefault("pool.demandwindow", "1m")
+	viper.SetDefault("pool.demandalpha", 0.3)
+	viper.SetDefault("pool.reservedcpus", 1)
+	viper.SetDefault("pool.actionmetrics", []string{})
+	viper.SetDefault("pool.sharebycodehash", false)
+	viper.SetDefault("activations.retention", "0s")
+	viper.SetDefault("activations.compressthreshold", 64*1024)
+	viper.SetDefault("activations.recentcapacity", 1000)
 	viper.SetDefault("minio.endpoint", "minio:9000")
 	viper.SetDefault("minio.accesskey", "minioadmin")
 	viper.SetDefault("minio.secretkey", "minioadmin")
 	viper.SetDefault("minio.usessl", false)
 	viper.SetDefault("resources.memorymb", 256)
 	viper.SetDefault("resources.cpushares", 1024)
+	viper.SetDefault("logging.level", "info")
+	viper.SetDefault("logging.format", "json")
+	viper.SetDefault("shutdown.draintimeout", "30s")
 
 	// Parse prewarm configuration
 	prewarmMap := make(map[string]int)
@@ -97,6 +244,75 @@ func Load() (*Config, error) {
 		}
 	}
 
+	// Parse minimum warm floor configuration
+	minWarmMap := make(map[string]int)
+	if viper.IsSet("pool.minwarm") {
+		minWarmConfig := viper.GetStringMap("pool.minwarm")
+		for runtime, count := range minWarmConfig {
+			if c, ok := count.(int); ok {
+				minWarmMap[runtime] = c
+			}
+		}
+	}
+
+	// Parse per-action CPU pinning
+	pinnedMap := make(map[string]int)
+	if viper.IsSet("pool.pinnedactions") {
+		pinnedConfig := viper.GetStringMap("pool.pinnedactions")
+		for action, count := range pinnedConfig {
+			if c, ok := count.(int); ok {
+				pinnedMap[action] = c
+			}
+		}
+	}
+
+	// Parse runtime image overrides from RUNTIME_IMAGE_<LANGUAGE> env vars
+	runtimeImages := make(map[string]string)
+	for _, env := range os.Environ() {
+		name, image, _ := strings.Cut(env, "=")
+		language, ok := strings.CutPrefix(name, runtimeImageEnvPrefix)
+		if ok && language != "" && image != "" {
+			runtimeImages[strings.ToLower(language)] = image
+		}
+	}
+
+	// Parse exec-transport runtimes
+	execRuntimes := make(map[string]ExecRuntimeConfig)
+	if viper.IsSet("docker.execruntimes") {
+		if err := viper.UnmarshalKey("docker.execruntimes", &execRuntimes); err != nil {
+			return nil, fmt.Errorf("invalid docker.execruntimes: %w", err)
+		}
+		for kind, rt := range execRuntimes {
+			if rt.Image == "" || len(rt.Command) == 0 {
+				return nil, fmt.Errorf("exec runtime %s needs an image and a command", kind)
+			}
+		}
+	}
+
+	// Parse per-namespace capacity reservations
+	reservationMap := make(map[string]int)
+	if viper.IsSet("invoker.reservations") {
+		reservationConfig := viper.GetStringMap("invoker.reservations")
+		for namespace, slots := range reservationConfig {
+			if s, ok := slots.(int); ok {
+				reservationMap[namespace] = s
+			}
+		}
+	}
+
+	// Parse per-namespace activation retention
+	retentionMap := make(map[string]time.Duration)
+	if viper.IsSet("activations.namespaceretention") {
+		retentionConfig := viper.GetStringMapString("activations.namespaceretention")
+		for namespace, value := range retentionConfig {
+			retention, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid retention for namespace %s: %w", namespace, err)
+			}
+			retentionMap[namespace] = retention
+		}
+	}
+
 	cfg := &Config{
 		Redis: RedisConfig{
 			Host: viper.GetString("redis.host"),
@@ -104,21 +320,77 @@ func Load() (*Config, error) {
 			URL:  viper.GetString("redis.url"),
 		},
 		Docker: DockerConfig{
-			Host:        viper.GetString("docker.host"),
-			APIVersion:  viper.GetString("docker.apiversion"),
-			NetworkName: viper.GetString("docker.networkname"),
+			Host:                viper.GetString("docker.host"),
+			APIVersion:          viper.GetString("docker.apiversion"),
+			NetworkName:         viper.GetString("docker.networkname"),
+			ContainerPrefix:     viper.GetString("docker.containerprefix"),
+			ImageAllowlist:      viper.GetStringSlice("docker.imageallowlist"),
+			ImageDigests:        viper.GetStringMapString("docker.imagedigests"),
+			RuntimeImages:       runtimeImages,
+			ExecRuntimes:        execRuntimes,
+			EntrypointAllowlist: viper.GetStringSlice("docker.entrypointallowlist"),
+			NetworkMode:         viper.GetString("docker.networkmode"),
+			CreateTimeout:       viper.GetDuration("docker.createtimeout"),
+			StartTimeout:        viper.GetDuration("docker.starttimeout"),
+			MaxConcurrentCalls:  viper.GetInt("docker.maxconcurrentcalls"),
+			VerifyLimits:        viper.GetBool("docker.verifylimits"),
+			RestartPolicy:       viper.GetString("docker.restartpolicy"),
+			RestartMaxRetries:   viper.GetInt("docker.restartmaxretries"),
 		},
 		Invoker: InvokerConfig{
-			ID:                viper.GetString("invoker.id"),
-			Port:              viper.GetInt("invoker.port"),
-			MaxConcurrent:     viper.GetInt("invoker.maxconcurrent"),
-			ContainerTimeout:  viper.GetInt("invoker.containertimeout"),
-			HeartbeatInterval: viper.GetDuration("invoker.heartbeatinterval"),
+			ID:                       viper.GetString("invoker.id"),
+			Port:                     viper.GetInt("invoker.port"),
+			MaxConcurrent:            viper.GetInt("invoker.maxconcurrent"),
+			ContainerTimeout:         viper.GetInt("invoker.containertimeout"),
+			InitTimeout:              viper.GetDuration("invoker.inittimeout"),
+			ResultWrapKey:            viper.GetString("invoker.resultwrapkey"),
+			HeartbeatInterval:        viper.GetDuration("invoker.heartbeatinterval"),
+			HighWatermark:            viper.GetFloat64("invoker.highwatermark"),
+			AdminToken:               viper.GetString("invoker.admintoken"),
+			DedupTTL:                 viper.GetDuration("invoker.dedupttl"),
+			DeadlineGraceMs:          viper.GetInt("invoker.deadlinegracems"),
+			LimitsAnnotation:         viper.GetString("invoker.limitsannotation"),
+			MaxEnvVars:               viper.GetInt("invoker.maxenvvars"),
+			MaxEnvBytes:              viper.GetInt("invoker.maxenvbytes"),
+			CodeSigningKey:           viper.GetString("invoker.codesigningkey"),
+			StartPosition:            viper.GetString("invoker.startposition"),
+			LeaveGroupOnStop:         viper.GetBool("invoker.leavegrouponstop"),
+			Reservations:             reservationMap,
+			Redactions:               viper.GetStringMapStringSlice("invoker.redactions"),
+			LogConcurrency:           viper.GetInt("invoker.logconcurrency"),
+			Events:                   viper.GetBool("invoker.events"),
+			EventsChannel:            viper.GetString("invoker.eventschannel"),
+			MemorySuggestions:        viper.GetBool("invoker.memorysuggestions"),
+			MemorySuggestionInterval: viper.GetDuration("invoker.memorysuggestioninterval"),
+			MemoryHeadroom:           viper.GetFloat64("invoker.memoryheadroom"),
 		},
 		Pool: PoolConfig{
-			MaxSize:     viper.GetInt("pool.maxsize"),
-			IdleTimeout: viper.GetDuration("pool.idletimeout"),
-			Prewarm:     prewarmMap,
+			MaxSize:                viper.GetInt("pool.maxsize"),
+			MaxTotalContainers:     viper.GetInt("pool.maxtotalcontainers"),
+			IdleTimeout:            viper.GetDuration("pool.idletimeout"),
+			CleanupInterval:        viper.GetDuration("pool.cleanupinterval"),
+			Prewarm:                prewarmMap,
+			MinWarm:                minWarmMap,
+			CleanupJitter:          viper.GetFloat64("pool.cleanupjitter"),
+			PrewarmJitter:          viper.GetDuration("pool.prewarmjitter"),
+			PrewarmParallelism:     viper.GetInt("pool.prewarmparallelism"),
+			KeepFailedContainers:   viper.GetBool("pool.keepfailedcontainers"),
+			FailedRetention:        viper.GetDuration("pool.failedretention"),
+			RemoveFailedOnShutdown: viper.GetBool("pool.removefailedonshutdown"),
+			QuarantineRatio:        viper.GetFloat64("pool.quarantineratio"),
+			PredictiveWarming:      viper.GetBool("pool.predictivewarming"),
+			DemandWindow:           viper.GetDuration("pool.demandwindow"),
+			DemandAlpha:            viper.GetFloat64("pool.demandalpha"),
+			PinnedActions:          pinnedMap,
+			ReservedCPUs:           viper.GetInt("pool.reservedcpus"),
+			ActionMetrics:          viper.GetStringSlice("pool.actionmetrics"),
+			ShareByCodeHash:        viper.GetBool("pool.sharebycodehash"),
+		},
+		Activations: ActivationsConfig{
+			Retention:          viper.GetDuration("activations.retention"),
+			CompressThreshold:  viper.GetInt("activations.compressthreshold"),
+			RecentCapacity:     viper.GetInt("activations.recentcapacity"),
+			NamespaceRetention: retentionMap,
 		},
 		MinIO: MinIOConfig{
 			Endpoint:  viper.GetString("minio.endpoint"),
@@ -130,6 +402,13 @@ func Load() (*Config, error) {
 			MemoryMB:  viper.GetInt64("resources.memorymb"),
 			CPUShares: viper.GetInt64("resources.cpushares"),
 		},
+		Logging: LoggingConfig{
+			Level:  viper.GetString("logging.level"),
+			Format: viper.GetString("logging.format"),
+		},
+		Shutdown: ShutdownConfig{
+			DrainTimeout: viper.GetDuration("shutdown.draintimeout"),
+		},
 	}
 
 	return cfg, nil
@@ -0,0 +1,36 @@
+package container
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// otherActions labels starts of actions outside the metrics allowlist
+const otherActions = "other"
+
+// actionStarts counts containers handed to each action by whether they were
+// cold-started or reused warm, for tuning prewarm per action. rate() over
+// the series gives the windowed view
+var actionStarts = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "penguinwhisk",
+		Subsystem: "pool",
+		Name:      "action_starts_total",
+		Help:      "Containers handed to actions, by cold start or warm reuse.",
+	},
+	[]string{"action", "start"},
+)
+
+// recordActionStart counts a cold or warm start for an action. Only
+// allowlisted actions get their own label, bounding series cardinality
+func (p *ContainerPool) recordActionStart(action string, cold bool) {
+	label := otherActions
+	if p.actionMetrics[action] {
+		label = action
+	}
+	start := "warm"
+	if cold {
+		start = "cold"
+	}
+	actionStarts.WithLabelValues(label, start).Inc()
+}
@@ -0,0 +1,45 @@
+package container
+
+import "strings"
+
+// ImageAllowlist restricts which images invocations may request
+// Entries ending in "/" match any image under that registry or repository
+// prefix; all other entries must match the image reference exactly
+type ImageAllowlist struct {
+	exact    map[string]struct{}
+	prefixes []string
+}
+
+// NewImageAllowlist creates an allowlist from exact names and "/"-terminated prefixes
+func NewImageAllowlist(entries []string) *ImageAllowlist {
+	allowlist := &ImageAllowlist{
+		exact: make(map[string]struct{}),
+	}
+
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if strings.HasSuffix(entry, "/") {
+			allowlist.prefixes = append(allowlist.prefixes, entry)
+		} else {
+			allowlist.exact[entry] = struct{}{}
+		}
+	}
+
+	return allowlist
+}
+
+// Allows reports whether the image may be pulled and run
+func (a *ImageAllowlist) Allows(image string) bool {
+	if _, ok := a.exact[image]; ok {
+		return true
+	}
+	for _, prefix := range a.prefixes {
+		if strings.HasPrefix(image, prefix) {
+			return true
+		}
+	}
+	return false
+}
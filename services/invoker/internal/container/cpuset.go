@@ -0,0 +1,73 @@
+package container
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// CPUSetAllocator hands pinned actions dedicated, non-overlapping CPU ranges.
+// Ranges are carved from the highest CPUs down so the low CPUs stay shared by
+// unpinned containers, and an action keeps its range once assigned
+type CPUSetAllocator struct {
+	mu       sync.Mutex
+	pinned   map[string]int    // action -> CPUs requested
+	assigned map[string]string // action -> cpuset
+	next     int               // highest CPU not yet assigned
+	reserved int               // CPUs never handed out to pinned actions
+	all      string            // every host CPU, used to unpin a container
+}
+
+// NewCPUSetAllocator creates an allocator over totalCPUs host CPUs (all
+// detected CPUs when zero), keeping reserved CPUs (at least one) for unpinned
+// containers
+func NewCPUSetAllocator(totalCPUs, reserved int, pinned map[string]int) *CPUSetAllocator {
+	if totalCPUs <= 0 {
+		totalCPUs = runtime.NumCPU()
+	}
+	if reserved < 1 {
+		reserved = 1
+	}
+	return &CPUSetAllocator{
+		pinned:   pinned,
+		assigned: make(map[string]string),
+		next:     totalCPUs - 1,
+		reserved: reserved,
+		all:      fmt.Sprintf("0-%d", totalCPUs-1),
+	}
+}
+
+// All returns the cpuset covering every host CPU
+func (a *CPUSetAllocator) All() string {
+	return a.all
+}
+
+// Assign returns the cpuset for a pinned action, allocating it on first use.
+// Unpinned actions get an empty cpuset
+func (a *CPUSetAllocator) Assign(action string) (string, error) {
+	cpus := a.pinned[action]
+	if cpus <= 0 {
+		return "", nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if cpuset, ok := a.assigned[action]; ok {
+		return cpuset, nil
+	}
+
+	first := a.next - cpus + 1
+	if first < a.reserved {
+		return "", fmt.Errorf("no CPUs left to pin action %s to %d CPUs", action, cpus)
+	}
+
+	cpuset := fmt.Sprintf("%d-%d", first, a.next)
+	if cpus == 1 {
+		cpuset = fmt.Sprintf("%d", first)
+	}
+	a.assigned[action] = cpuset
+	a.next = first - 1
+
+	return cpuset, nil
+}
@@ -0,0 +1,61 @@
+package container
+
+import (
+	"math"
+	"sync"
+)
+
+// DefaultDemandAlpha weights the latest window in the demand EWMA
+const DefaultDemandAlpha = 0.3
+
+// DemandTracker records container requests per runtime and smooths them into
+// an exponentially weighted moving average of arrivals per window
+type DemandTracker struct {
+	mu     sync.Mutex
+	alpha  float64
+	counts map[string]int     // runtime -> arrivals in the current window
+	rates  map[string]float64 // runtime -> smoothed arrivals per window
+}
+
+// NewDemandTracker creates a demand tracker; alpha outside (0, 1] falls back
+// to DefaultDemandAlpha
+func NewDemandTracker(alpha float64) *DemandTracker {
+	if alpha <= 0 || alpha > 1 {
+		alpha = DefaultDemandAlpha
+	}
+	return &DemandTracker{
+		alpha:  alpha,
+		counts: make(map[string]int),
+		rates:  make(map[string]float64),
+	}
+}
+
+// Record counts one container request for a runtime
+func (d *DemandTracker) Record(runtime string) {
+	d.mu.Lock()
+	d.counts[runtime]++
+	d.mu.Unlock()
+}
+
+// Tick closes the current window, folding its arrivals into the moving
+// average, and returns the smoothed prewarm target per runtime
+func (d *DemandTracker) Tick() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for runtime := range d.counts {
+		if _, ok := d.rates[runtime]; !ok {
+			d.rates[runtime] = 0
+		}
+	}
+
+	targets := make(map[string]int, len(d.rates))
+	for runtime, rate := range d.rates {
+		rate = d.alpha*float64(d.counts[runtime]) + (1-d.alpha)*rate
+		d.rates[runtime] = rate
+		targets[runtime] = int(math.Ceil(rate - 0.05)) // ignore a decayed tail
+	}
+	d.counts = make(map[string]int)
+
+	return targets
+}
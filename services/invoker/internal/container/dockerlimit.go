@@ -0,0 +1,116 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// DefaultMaxDockerCalls bounds concurrent Docker API calls when none is
+// configured
+const DefaultMaxDockerCalls = 16
+
+// LimitedClient wraps a Docker client so the calls that load the daemon
+// most (inspect, create, start and logs) share one concurrency bound. Other
+// calls pass straight through to the embedded client
+type LimitedClient struct {
+	*client.Client
+	slots chan struct{}
+}
+
+// NewLimitedClient wraps cli, allowing at most maxConcurrent limited calls
+// at once (DefaultMaxDockerCalls when zero or less)
+func NewLimitedClient(cli *client.Client, maxConcurrent int) *LimitedClient {
+	if maxConcurrent <= 0 {
+		maxConcurrent = DefaultMaxDockerCalls
+	}
+	return &LimitedClient{
+		Client: cli,
+		slots:  make(chan struct{}, maxConcurrent),
+	}
+}
+
+// Share wraps another Docker client so its calls count against the same
+// bound, letting components with their own clients share one limit
+func (c *LimitedClient) Share(cli *client.Client) *LimitedClient {
+	return &LimitedClient{
+		Client: cli,
+		slots:  c.slots,
+	}
+}
+
+// acquire waits for a call slot, returning a func that frees it
+func (c *LimitedClient) acquire(ctx context.Context) (func(), error) {
+	select {
+	case c.slots <- struct{}{}:
+		var once sync.Once
+		return func() { once.Do(func() { <-c.slots }) }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting for Docker API slot: %w", ctx.Err())
+	}
+}
+
+// ContainerInspect inspects a container within the call bound
+func (c *LimitedClient) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return types.ContainerJSON{}, err
+	}
+	defer release()
+	return c.Client.ContainerInspect(ctx, containerID)
+}
+
+// ContainerCreate creates a container within the call bound
+func (c *LimitedClient) ContainerCreate(ctx context.Context, config *container.Config, hostConfig *container.HostConfig, networkingConfig *network.NetworkingConfig, platform *ocispec.Platform, containerName string) (container.CreateResponse, error) {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return container.CreateResponse{}, err
+	}
+	defer release()
+	return c.Client.ContainerCreate(ctx, config, hostConfig, networkingConfig, platform, containerName)
+}
+
+// ContainerStart starts a container within the call bound
+func (c *LimitedClient) ContainerStart(ctx context.Context, containerID string, options container.StartOptions) error {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	return c.Client.ContainerStart(ctx, containerID, options)
+}
+
+// ContainerLogs reads container logs within the call bound. Reading the
+// stream is most of the cost, so the slot is held until the reader is
+// closed, except for followed streams which may stay open indefinitely
+func (c *LimitedClient) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	release, err := c.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	logs, err := c.Client.ContainerLogs(ctx, containerID, options)
+	if err != nil || options.Follow {
+		release()
+		return logs, err
+	}
+	return &releasingReader{ReadCloser: logs, release: release}, nil
+}
+
+// releasingReader frees a call slot when the wrapped reader is closed
+type releasingReader struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releasingReader) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
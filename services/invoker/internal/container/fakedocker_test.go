@@ -0,0 +1,389 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"go.uber.org/zap"
+)
+
+// testNetwork is the managed network fake containers are attached to
+const testNetwork = "test-net"
+
+// fakeDocker serves the slice of the Docker API the pool uses, keeping track
+// of the containers it created and removed
+type fakeDocker struct {
+	mu       sync.Mutex
+	next     int
+	running  map[string]bool // created and not yet removed
+	started  map[string]bool
+	renamed  map[string]string
+	memory   map[string]int64  // memory limit each container was created with
+	networks map[string]string // network mode each container was created with
+	restarts map[string]int    // restart count Docker reports
+	cpusets  map[string]string // cpuset each container was last updated to
+	configs  map[string]createdConfig
+	killed   map[string]bool
+	removed  []string
+	pulls    []string // images pulled, as repo:tag or repo@digest
+
+	// inFlight and peak count concurrent creates ("create") and calls on
+	// containers (by action, e.g. "json")
+	inFlight map[string]int
+	peak     map[string]int
+	// callDelay slows down every create and inspect so concurrent calls
+	// overlap
+	callDelay time.Duration
+
+	// createGate, when set, holds every create until it is closed;
+	// stopGate, inspectGate and updateGate do the same for stops, inspects
+	// and updates
+	createGate  chan struct{}
+	stopGate    chan struct{}
+	inspectGate chan struct{}
+	updateGate  chan struct{}
+	// startDelay slows down every start; failStart makes starts fail
+	startDelay time.Duration
+	failStart  bool
+	// failCreates makes that many of the next creates fail
+	failCreates int
+	// neverRunning keeps started containers from reporting they run
+	neverRunning bool
+	// failStop makes graceful stops fail, as for a container ignoring SIGTERM
+	failStop bool
+	// missingImages makes every image absent locally so creates pull it;
+	// pullGate, when set, holds every pull stream open until it is closed
+	missingImages bool
+	pullGate      chan struct{}
+	// networkCreated is whether the managed network exists. Creating it
+	// always reports a conflict, as when another invoker wins the race
+	networkCreated bool
+	// unenforcedMemory reports containers without their memory limit, as a
+	// daemon lacking cgroup memory support does
+	unenforcedMemory bool
+}
+
+// createdConfig is the part of a container's create request tests check
+type createdConfig struct {
+	Image      string
+	Entrypoint []string
+	Cmd        []string
+}
+
+// hostMemory is the cgroup memory limit reported for unlimited containers
+const hostMemory = 64 << 30
+
+// newTestPool returns a pool over a fake Docker daemon whose containers
+// default to 256MB. The pool is shut down when the test ends, unless the
+// test did so itself
+func newTestPool(t *testing.T, config PoolConfig) (*ContainerPool, *fakeDocker) {
+	t.Helper()
+
+	fake := &fakeDocker{
+		running:  make(map[string]bool),
+		started:  make(map[string]bool),
+		renamed:  make(map[string]string),
+		memory:   make(map[string]int64),
+		networks: make(map[string]string),
+		restarts: make(map[string]int),
+		cpusets:  make(map[string]string),
+		configs:  make(map[string]createdConfig),
+		killed:   make(map[string]bool),
+		inFlight: make(map[string]int),
+		peak:     make(map[string]int),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	cli, err := client.NewClientWithOpts(
+		client.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")),
+		client.WithVersion("1.41"),
+		client.WithHTTPClient(server.Client()),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager := &ContainerManager{
+		dockerClient:    NewLimitedClient(cli, 0),
+		networkName:     testNetwork,
+		containerPrefix: "test",
+		resourceLimits:  ResourceLimits{MemoryMB: 256},
+		createTimeout:   5 * time.Second,
+		startTimeout:    5 * time.Second,
+		logger:          zap.NewNop(),
+	}
+
+	if config.MaxPoolSize == 0 {
+		config.MaxPoolSize = 10
+	}
+	if config.CleanupInterval == 0 {
+		config.CleanupInterval = time.Hour
+	}
+	pool := NewContainerPool(manager, config, zap.NewNop())
+	t.Cleanup(func() {
+		select {
+		case <-pool.stopCleanup:
+			// The test already shut the pool down
+		default:
+			pool.Shutdown(context.Background())
+		}
+	})
+	return pool, fake
+}
+
+// live returns the number of containers created and not yet removed
+func (f *fakeDocker) live() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.running)
+}
+
+// wasKilled reports whether a container was killed
+func (f *fakeDocker) wasKilled(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.killed[id]
+}
+
+// peakCalls returns the most calls of a kind that ran at once
+func (f *fakeDocker) peakCalls(kind string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.peak[kind]
+}
+
+// enter counts a call of a kind as in flight, delaying creates and inspects
+// by callDelay, and returns a func ending it
+func (f *fakeDocker) enter(kind string) func() {
+	f.mu.Lock()
+	f.inFlight[kind]++
+	f.peak[kind] = max(f.peak[kind], f.inFlight[kind])
+	delay := f.callDelay
+	f.mu.Unlock()
+
+	if kind == "create" || kind == "json" {
+		time.Sleep(delay)
+	}
+	return func() {
+		f.mu.Lock()
+		f.inFlight[kind]--
+		f.mu.Unlock()
+	}
+}
+
+// wasRemoved reports whether a container was removed
+func (f *fakeDocker) wasRemoved(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, removed := range f.removed {
+		if removed == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Drop the /v1.41 version prefix
+	path := r.URL.Path
+	if rest, ok := strings.CutPrefix(path, "/v"); ok {
+		if i := strings.Index(rest, "/"); i >= 0 {
+			path = rest[i:]
+		}
+	}
+
+	switch {
+	case path == "/networks" && r.Method == http.MethodGet:
+		f.mu.Lock()
+		networks := []map[string]interface{}{}
+		if f.networkCreated {
+			networks = append(networks, map[string]interface{}{"Name": testNetwork, "Id": testNetwork})
+		}
+		f.mu.Unlock()
+		writeJSON(w, http.StatusOK, networks)
+
+	case path == "/networks/create" && r.Method == http.MethodPost:
+		f.mu.Lock()
+		f.networkCreated = true
+		f.mu.Unlock()
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"message": fmt.Sprintf("network with name %s already exists", testNetwork),
+		})
+
+	case path == "/images/create" && r.Method == http.MethodPost:
+		f.servePull(w, r)
+
+	case strings.HasPrefix(path, "/images/") && r.Method == http.MethodGet:
+		f.mu.Lock()
+		missing := f.missingImages
+		f.mu.Unlock()
+		if missing {
+			writeJSON(w, http.StatusNotFound, map[string]interface{}{"message": "no such image"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Architecture": runtime.GOARCH})
+
+	case path == "/containers/create" && r.Method == http.MethodPost:
+		if f.createGate != nil {
+			<-f.createGate
+		}
+		defer f.enter("create")()
+		var body struct {
+			createdConfig
+			HostConfig struct {
+				Memory      int64
+				NetworkMode string
+			}
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		if f.failCreates > 0 {
+			f.failCreates--
+			f.mu.Unlock()
+			http.Error(w, "create failed", http.StatusInternalServerError)
+			return
+		}
+		f.next++
+		id := fmt.Sprintf("%064d", f.next)
+		f.running[id] = true
+		f.memory[id] = body.HostConfig.Memory
+		f.networks[id] = body.HostConfig.NetworkMode
+		f.configs[id] = body.createdConfig
+		f.mu.Unlock()
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"Id": id})
+
+	case strings.HasPrefix(path, "/containers/"):
+		id, action, _ := strings.Cut(strings.TrimPrefix(path, "/containers/"), "/")
+		f.mu.Lock()
+		gate := map[string]chan struct{}{"stop": f.stopGate, "json": f.inspectGate, "update": f.updateGate}[action]
+		f.mu.Unlock()
+		if gate != nil {
+			<-gate
+		}
+		defer f.enter(action)()
+		f.serveContainer(w, r, id, action)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveContainer handles calls on one container
+func (f *fakeDocker) serveContainer(w http.ResponseWriter, r *http.Request, id, action string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case action == "json":
+		// Containers without networking aren't attached to any network
+		networks := map[string]interface{}{
+			testNetwork: map[string]interface{}{"IPAddress": "10.0.0.1"},
+		}
+		if f.networks[id] == NetworkModeNone {
+			networks = map[string]interface{}{}
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"Id":              id,
+			"State":           map[string]interface{}{"Running": f.running[id] && f.started[id] && !f.neverRunning},
+			"RestartCount":    f.restarts[id],
+			"HostConfig":      map[string]interface{}{"Memory": f.appliedMemory(id)},
+			"NetworkSettings": map[string]interface{}{"Networks": networks},
+		})
+	case action == "stats":
+		limit := f.appliedMemory(id)
+		if limit == 0 {
+			limit = hostMemory
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"memory_stats": map[string]interface{}{"limit": limit},
+		})
+	case action == "start":
+		if f.failStart {
+			http.Error(w, "start failed", http.StatusInternalServerError)
+			return
+		}
+		time.Sleep(f.startDelay)
+		f.started[id] = true
+		w.WriteHeader(http.StatusNoContent)
+	case action == "update":
+		var body struct{ CpusetCpus string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.cpusets[id] = body.CpusetCpus
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Warnings": nil})
+	case action == "stop" && f.failStop:
+		http.Error(w, "container did not stop", http.StatusInternalServerError)
+	case action == "kill":
+		f.killed[id] = true
+		w.WriteHeader(http.StatusNoContent)
+	case action == "rename":
+		f.renamed[id] = r.URL.Query().Get("name")
+		w.WriteHeader(http.StatusNoContent)
+	case action == "" && r.Method == http.MethodDelete:
+		delete(f.running, id)
+		f.removed = append(f.removed, id)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		// stop just succeeds
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// servePull records an image pull and streams its progress, holding the
+// stream open while pullGate is set
+func (f *fakeDocker) servePull(w http.ResponseWriter, r *http.Request) {
+	image, tag := r.URL.Query().Get("fromImage"), r.URL.Query().Get("tag")
+	separator := ":"
+	if strings.HasPrefix(tag, "sha256:") {
+		separator = "@"
+	}
+
+	f.mu.Lock()
+	f.pulls = append(f.pulls, image+separator+tag)
+	gate := f.pullGate
+	f.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"status": "Pulling from " + image})
+	w.(http.Flusher).Flush()
+
+	if gate != nil {
+		select {
+		case <-gate:
+		case <-r.Context().Done():
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "Download complete"})
+}
+
+// appliedMemory returns the memory limit a container reports
+// Must be called with f.mu held
+func (f *fakeDocker) appliedMemory(id string) int64 {
+	if f.unenforcedMemory {
+		return 0
+	}
+	return f.memory[id]
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -2,8 +2,12 @@ package container
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"regexp"
+	"runtime"
 	"strings"
 	"time"
 
@@ -13,10 +17,10 @@ import (
 	"github.com/docker/docker/api/types/image"
 	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
 	"github.com/docker/go-connections/nat"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/config"
 	"go.uber.org/zap"
-
-	"openwhisk-invoker/internal/config"
 )
 
 // ContainerState represents the state of a container
@@ -42,8 +46,34 @@ type ContainerSpec struct {
 	Memory      int64 // bytes
 	Timeout     time.Duration
 	Environment map[string]string
+	Entrypoint  []string // overrides the image entrypoint when set
+	Cmd         []string // overrides the image command when set
+	Trusted     bool     // trusted runtimes skip the entrypoint allowlist
+	CpusetCpus  string   // CPUs the container is pinned to, e.g. "4-5"
+	NetworkMode string   // "" for the managed network, "none", "host" or an existing network
+
+	// RestartPolicy is "no" or "on-failure", "" for the manager default.
+	// RestartMaxRetries caps on-failure restarts
+	RestartPolicy     string
+	RestartMaxRetries int
 }
 
+const (
+	// NetworkModeNone creates containers without networking; they can only
+	// be driven through the exec transport
+	NetworkModeNone = "none"
+	// NetworkModeHost shares the host network and is limited to trusted runtimes
+	NetworkModeHost = "host"
+
+	// RestartPolicyNo leaves crashed containers stopped
+	RestartPolicyNo = "no"
+	// RestartPolicyOnFailure has Docker restart containers that exit non-zero
+	RestartPolicyOnFailure = "on-failure"
+)
+
+// networkNamePattern matches Docker network names
+var networkNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
 // Container represents a managed container instance
 type Container struct {
 	ID        string
@@ -51,24 +81,53 @@ type Container struct {
 	State     ContainerState
 	Runtime   string
 	CreatedAt time.Time
+	ImageArch string // architecture the image was built for
+	Emulated  bool   // image architecture differs from the host's
+	MemoryMB  int64  // memory limit the container was created with
+	Restarts  int    // Docker restarts seen so far under a restart policy
+	Timings   ColdStartTimings
 }
 
+// ColdStartTimings breaks down where a cold start's latency went, in
+// milliseconds. Init is measured by the executor
+type ColdStartTimings struct {
+	PullMs   int64 `json:"pullMs"`
+	CreateMs int64 `json:"createMs"`
+	StartMs  int64 `json:"startMs"`
+	InitMs   int64 `json:"initMs"`
+}
+
+// imageDigestPattern matches the digest of an image pinned as repo@sha256:<hex>
+var imageDigestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
 // ContainerManager manages Docker container lifecycle
 type ContainerManager struct {
-	dockerClient    *client.Client
+	dockerClient    *LimitedClient
 	networkName     string
 	containerPrefix string
 	resourceLimits  ResourceLimits
+	entrypoints     map[string]bool // entrypoint executables allowed for untrusted runtimes
+	createTimeout   time.Duration   // bounds the Docker create call, excluding the image pull
+	startTimeout    time.Duration   // bounds start until the container is running
+	networkMode     string          // default network mode, "" for the managed network
+	restartPolicy   string          // default restart policy, "" for none
+	restartRetries  int             // default cap on on-failure restarts
+	verifyLimits    bool            // check applied resource limits after start
 	logger          *zap.Logger
 }
 
-// NewContainerManager creates a new container manager instance
-func NewContainerManager(cfg *config.Config) (*ContainerManager, error) {
-	logger, err := zap.NewProduction()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create logger: %w", err)
-	}
+const (
+	// DefaultCreateTimeout bounds container creation when none is configured
+	DefaultCreateTimeout = 30 * time.Second
+	// DefaultStartTimeout bounds container start when none is configured
+	DefaultStartTimeout = 30 * time.Second
+	// stopKillMargin is how long past the stop grace period StopOrKill waits
+	// on the Docker API before escalating to a kill
+	stopKillMargin = 5 * time.Second
+)
 
+// NewContainerManager creates a new container manager instance
+func NewContainerManager(cfg *config.Config, logger *zap.Logger) (*ContainerManager, error) {
 	// Create Docker client
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
@@ -87,15 +146,34 @@ func NewContainerManager(cfg *config.Config) (*ContainerManager, error) {
 	}
 
 	manager := &ContainerManager{
-		dockerClient:    cli,
-		networkName:     cfg.Docker.Network,
+		dockerClient:    NewLimitedClient(cli, cfg.Docker.MaxConcurrentCalls),
+		networkName:     cfg.Docker.NetworkName,
 		containerPrefix: cfg.Docker.ContainerPrefix,
 		resourceLimits: ResourceLimits{
-			MemoryMB:    int64(cfg.Docker.MemoryLimitMB),
-			CPUShares:   int64(cfg.Docker.CPUShares),
-			TimeoutSecs: cfg.Docker.TimeoutSeconds,
+			MemoryMB:    cfg.Resources.MemoryMB,
+			CPUShares:   cfg.Resources.CPUShares,
+			TimeoutSecs: cfg.Invoker.ContainerTimeout,
 		},
-		logger: logger,
+		entrypoints:    make(map[string]bool, len(cfg.Docker.EntrypointAllowlist)),
+		createTimeout:  cfg.Docker.CreateTimeout,
+		startTimeout:   cfg.Docker.StartTimeout,
+		networkMode:    cfg.Docker.NetworkMode,
+		restartPolicy:  cfg.Docker.RestartPolicy,
+		restartRetries: cfg.Docker.RestartMaxRetries,
+		verifyLimits:   cfg.Docker.VerifyLimits,
+		logger:         logger,
+	}
+	if manager.createTimeout <= 0 {
+		manager.createTimeout = DefaultCreateTimeout
+	}
+	if manager.startTimeout <= 0 {
+		manager.startTimeout = DefaultStartTimeout
+	}
+	if err := validateRestartPolicy(manager.restartPolicy, manager.restartRetries); err != nil {
+		return nil, fmt.Errorf("invalid docker restart policy: %w", err)
+	}
+	for _, entrypoint := range cfg.Docker.EntrypointAllowlist {
+		manager.entrypoints[entrypoint] = true
 	}
 
 	// Ensure network exists
@@ -136,7 +214,23 @@ func (m *ContainerManager) ensureNetwork(ctx context.Context) error {
 		},
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create network: %w", err)
+		// Another invoker starting alongside us may have won the race
+		if !errdefs.IsConflict(err) && !strings.Contains(err.Error(), "already exists") {
+			return fmt.Errorf("failed to create network: %w", err)
+		}
+
+		networks, listErr := m.dockerClient.NetworkList(ctx, types.NetworkListOptions{
+			Filters: filters.NewArgs(filters.Arg("name", m.networkName)),
+		})
+		if listErr != nil {
+			return fmt.Errorf("failed to list networks after create conflict: %w", listErr)
+		}
+		if len(networks) == 0 {
+			return fmt.Errorf("failed to create network: %w", err)
+		}
+
+		m.logger.Debug("network created concurrently by another invoker", zap.String("network", m.networkName))
+		return nil
 	}
 
 	m.logger.Info("created Docker network", zap.String("network", m.networkName))
@@ -147,10 +241,37 @@ func (m *ContainerManager) ensureNetwork(ctx context.Context) error {
 func (m *ContainerManager) CreateContainer(ctx context.Context, spec ContainerSpec) (*Container, error) {
 	m.logger.Debug("creating container", zap.String("image", spec.Image))
 
+	// Images pinned by digest must carry a well-formed sha256 digest
+	if _, digest, pinned := strings.Cut(spec.Image, "@"); pinned && !imageDigestPattern.MatchString(digest) {
+		return nil, fmt.Errorf("invalid image digest in %q", spec.Image)
+	}
+
+	if err := m.validateEntrypoint(spec); err != nil {
+		return nil, err
+	}
+
+	networkMode := spec.NetworkMode
+	if networkMode == "" {
+		networkMode = m.networkMode
+	}
+	if err := validateNetworkMode(networkMode, spec.Trusted); err != nil {
+		return nil, err
+	}
+
+	restartPolicy, restartRetries := spec.RestartPolicy, spec.RestartMaxRetries
+	if restartPolicy == "" {
+		restartPolicy, restartRetries = m.restartPolicy, m.restartRetries
+	}
+	if err := validateRestartPolicy(restartPolicy, restartRetries); err != nil {
+		return nil, err
+	}
+
 	// Pull image if not exists
+	pullStart := time.Now()
 	if err := m.pullImageIfNeeded(ctx, spec.Image); err != nil {
 		return nil, fmt.Errorf("failed to pull image: %w", err)
 	}
+	pullMs := time.Since(pullStart).Milliseconds()
 
 	// Build environment variables
 	env := make([]string, 0, len(spec.Environment))
@@ -172,6 +293,12 @@ func (m *ContainerManager) CreateContainer(ctx context.Context, spec ContainerSp
 		},
 		StopTimeout: func() *int { t := int(spec.Timeout.Seconds()); return &t }(),
 	}
+	if len(spec.Entrypoint) > 0 {
+		containerConfig.Entrypoint = spec.Entrypoint
+	}
+	if len(spec.Cmd) > 0 {
+		containerConfig.Cmd = spec.Cmd
+	}
 
 	// Host configuration with resource limits
 	memoryBytes := spec.Memory
@@ -181,14 +308,21 @@ func (m *ContainerManager) CreateContainer(ctx context.Context, spec ContainerSp
 
 	hostConfig := &container.HostConfig{
 		Resources: container.Resources{
-			Memory:    memoryBytes,
-			CPUShares: m.resourceLimits.CPUShares,
+			Memory:     memoryBytes,
+			CPUShares:  m.resourceLimits.CPUShares,
+			CpusetCpus: spec.CpusetCpus,
 		},
 		NetworkMode: container.NetworkMode(m.networkName),
 		AutoRemove:  false, // We manage removal explicitly
 	}
+	if restartPolicy != "" {
+		hostConfig.RestartPolicy = container.RestartPolicy{
+			Name:              container.RestartPolicyMode(restartPolicy),
+			MaximumRetryCount: restartRetries,
+		}
+	}
 
-	// Network configuration
+	// Network configuration; none and host get no endpoint
 	networkConfig := &network.NetworkingConfig{
 		EndpointsConfig: map[string]*network.EndpointSettings{
 			m.networkName: {
@@ -196,13 +330,31 @@ func (m *ContainerManager) CreateContainer(ctx context.Context, spec ContainerSp
 			},
 		},
 	}
+	switch networkMode {
+	case "":
+	case NetworkModeNone, NetworkModeHost:
+		hostConfig.NetworkMode = container.NetworkMode(networkMode)
+		networkConfig = &network.NetworkingConfig{}
+	default:
+		hostConfig.NetworkMode = container.NetworkMode(networkMode)
+		networkConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				networkMode: {
+					NetworkID: networkMode,
+				},
+			},
+		}
+	}
 
 	// Generate container name
 	containerName := fmt.Sprintf("%s-%d", m.containerPrefix, time.Now().UnixNano())
 
 	// Create container
+	createStart := time.Now()
+	createCtx, cancel := context.WithTimeout(ctx, m.createTimeout)
+	defer cancel()
 	resp, err := m.dockerClient.ContainerCreate(
-		ctx,
+		createCtx,
 		containerConfig,
 		hostConfig,
 		networkConfig,
@@ -215,21 +367,99 @@ func (m *ContainerManager) CreateContainer(ctx context.Context, spec ContainerSp
 			zap.Error(err))
 		return nil, fmt.Errorf("failed to create container: %w", err)
 	}
+	createMs := time.Since(createStart).Milliseconds()
 
 	m.logger.Info("container created",
 		zap.String("id", resp.ID[:12]),
 		zap.String("name", containerName),
 		zap.String("image", spec.Image))
 
+	imageArch, emulated := m.checkImageArch(ctx, spec.Image)
+
 	return &Container{
 		ID:        resp.ID,
 		IP:        "", // Will be populated after start
 		State:     ContainerStateCreated,
 		Runtime:   spec.Image,
 		CreatedAt: time.Now(),
+		ImageArch: imageArch,
+		Emulated:  emulated,
+		MemoryMB:  memoryBytes / (1024 * 1024),
+		Timings: ColdStartTimings{
+			PullMs:   pullMs,
+			CreateMs: createMs,
+		},
 	}, nil
 }
 
+// validateEntrypoint rejects entrypoint overrides on untrusted runtimes unless
+// the executable is allowlisted
+func (m *ContainerManager) validateEntrypoint(spec ContainerSpec) error {
+	if spec.Trusted || len(spec.Entrypoint) == 0 {
+		return nil
+	}
+	if !m.entrypoints[spec.Entrypoint[0]] {
+		return fmt.Errorf("entrypoint %q is not allowed", spec.Entrypoint[0])
+	}
+	return nil
+}
+
+// validateNetworkMode checks a container network mode: host networking is
+// reserved for trusted runtimes and custom networks must be valid names
+func validateNetworkMode(mode string, trusted bool) error {
+	switch mode {
+	case "", NetworkModeNone:
+		return nil
+	case NetworkModeHost:
+		if !trusted {
+			return fmt.Errorf("host networking is only allowed for trusted runtimes")
+		}
+		return nil
+	}
+	if !networkNamePattern.MatchString(mode) {
+		return fmt.Errorf("invalid network %q", mode)
+	}
+	return nil
+}
+
+// validateRestartPolicy checks a container restart policy. Policies that
+// restart stopped containers would fight the pool's own removal, so only
+// on-failure restarts are allowed, and they must be capped
+func validateRestartPolicy(policy string, maxRetries int) error {
+	switch policy {
+	case "", RestartPolicyNo:
+		return nil
+	case RestartPolicyOnFailure:
+		if maxRetries <= 0 {
+			return fmt.Errorf("on-failure restart policy needs a positive retry cap")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported restart policy %q", policy)
+}
+
+// checkImageArch reports the image's architecture and whether it differs
+// from the host's, meaning the container runs under emulation
+func (m *ContainerManager) checkImageArch(ctx context.Context, imageName string) (string, bool) {
+	inspect, _, err := m.dockerClient.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		m.logger.Debug("failed to inspect image architecture",
+			zap.String("image", imageName),
+			zap.Error(err))
+		return "", false
+	}
+
+	if inspect.Architecture == "" || inspect.Architecture == runtime.GOARCH {
+		return inspect.Architecture, false
+	}
+
+	m.logger.Warn("image architecture differs from host, container will run emulated",
+		zap.String("image", imageName),
+		zap.String("imageArch", inspect.Architecture),
+		zap.String("hostArch", runtime.GOARCH))
+	return inspect.Architecture, true
+}
+
 // pullImageIfNeeded pulls the Docker image if it doesn't exist locally
 func (m *ContainerManager) pullImageIfNeeded(ctx context.Context, imageName string) error {
 	// Check if image exists locally
@@ -248,8 +478,22 @@ func (m *ContainerManager) pullImageIfNeeded(ctx context.Context, imageName stri
 	}
 	defer reader.Close()
 
-	// Wait for pull to complete
-	_, err = io.Copy(io.Discard, reader)
+	// Wait for pull to complete; closing the stream on cancellation unblocks
+	// the copy and aborts the pull
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, reader)
+		done <- err
+	}()
+
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		reader.Close()
+		<-done
+		m.logger.Warn("image pull canceled", zap.String("image", imageName))
+		return fmt.Errorf("image pull canceled: %w", ctx.Err())
+	}
 	if err != nil {
 		return fmt.Errorf("failed to read pull response: %w", err)
 	}
@@ -262,6 +506,9 @@ func (m *ContainerManager) pullImageIfNeeded(ctx context.Context, imageName stri
 func (m *ContainerManager) StartContainer(ctx context.Context, containerID string) error {
 	m.logger.Debug("starting container", zap.String("id", containerID[:12]))
 
+	ctx, cancel := context.WithTimeout(ctx, m.startTimeout)
+	defer cancel()
+
 	// Start container
 	if err := m.dockerClient.ContainerStart(ctx, containerID, container.StartOptions{}); err != nil {
 		m.logger.Error("failed to start container",
@@ -271,29 +518,101 @@ func (m *ContainerManager) StartContainer(ctx context.Context, containerID strin
 	}
 
 	// Wait for container to be running
-	deadline := time.Now().Add(30 * time.Second)
-	for time.Now().Before(deadline) {
+	for {
 		inspect, err := m.dockerClient.ContainerInspect(ctx, containerID)
 		if err != nil {
 			return fmt.Errorf("failed to inspect container: %w", err)
 		}
 
 		if inspect.State.Running {
+			// Containers without networking have no endpoint on the network
+			var ip string
+			if endpoint, ok := inspect.NetworkSettings.Networks[m.networkName]; ok {
+				ip = endpoint.IPAddress
+			}
 			m.logger.Info("container started",
 				zap.String("id", containerID[:12]),
-				zap.String("ip", inspect.NetworkSettings.Networks[m.networkName].IPAddress))
+				zap.String("ip", ip))
 			return nil
 		}
 
 		select {
 		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return fmt.Errorf("container failed to start within %s: %w", m.startTimeout, ctx.Err())
+			}
 			return ctx.Err()
 		case <-time.After(100 * time.Millisecond):
 			// Continue waiting
 		}
 	}
+}
+
+// LimitMismatchError reports resource limits Docker did not apply as requested
+type LimitMismatchError struct {
+	ContainerID string
+	Mismatches  []string
+}
 
-	return fmt.Errorf("container failed to start within timeout")
+func (e *LimitMismatchError) Error() string {
+	return fmt.Sprintf("container %s limits not applied as requested: %s",
+		e.ContainerID, strings.Join(e.Mismatches, "; "))
+}
+
+// VerifyLimits checks that a started container got the resource limits spec
+// asked for. Docker can silently drop limits on hosts missing a cgroup
+// controller, so both the stored HostConfig and the memory limit the cgroup
+// reports are compared. Returns a *LimitMismatchError on mismatch
+func (m *ContainerManager) VerifyLimits(ctx context.Context, containerID string, spec ContainerSpec) error {
+	memoryBytes := spec.Memory
+	if memoryBytes == 0 {
+		memoryBytes = m.resourceLimits.MemoryMB * 1024 * 1024
+	}
+
+	inspect, err := m.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect container: %w", err)
+	}
+
+	var mismatches []string
+	applied := inspect.HostConfig.Resources
+	if applied.Memory != memoryBytes {
+		mismatches = append(mismatches, fmt.Sprintf("memory %d bytes, requested %d", applied.Memory, memoryBytes))
+	}
+	if applied.CPUShares != m.resourceLimits.CPUShares {
+		mismatches = append(mismatches, fmt.Sprintf("cpu shares %d, requested %d", applied.CPUShares, m.resourceLimits.CPUShares))
+	}
+	if spec.CpusetCpus != "" && applied.CpusetCpus != spec.CpusetCpus {
+		mismatches = append(mismatches, fmt.Sprintf("cpuset %q, requested %q", applied.CpusetCpus, spec.CpusetCpus))
+	}
+
+	// An unenforced memory limit shows up in the cgroup as the host's memory
+	if memoryBytes > 0 {
+		stats, err := m.dockerClient.ContainerStatsOneShot(ctx, containerID)
+		if err != nil {
+			return fmt.Errorf("failed to get container stats: %w", err)
+		}
+		defer stats.Body.Close()
+
+		var parsed types.StatsJSON
+		if err := json.NewDecoder(stats.Body).Decode(&parsed); err != nil {
+			return fmt.Errorf("failed to decode container stats: %w", err)
+		}
+		if parsed.MemoryStats.Limit > uint64(memoryBytes) {
+			mismatches = append(mismatches, fmt.Sprintf("cgroup memory limit %d bytes, requested %d", parsed.MemoryStats.Limit, memoryBytes))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return &LimitMismatchError{ContainerID: containerID, Mismatches: mismatches}
+	}
+	return nil
+}
+
+// DockerClient returns the manager's concurrency-limited Docker client, whose
+// Share lets other components draw on the same call bound
+func (m *ContainerManager) DockerClient() *LimitedClient {
+	return m.dockerClient
 }
 
 // StopContainer stops a running container with a grace period
@@ -318,6 +637,37 @@ func (m *ContainerManager) StopContainer(ctx context.Context, containerID string
 	return nil
 }
 
+// StopOrKill stops a container gracefully and, if the stop fails or hangs
+// past the grace period, kills it with SIGKILL. The container is then
+// force-removed so one ignoring SIGTERM is never leaked
+func (m *ContainerManager) StopOrKill(ctx context.Context, containerID string, timeout time.Duration) error {
+	stopCtx, cancel := context.WithTimeout(ctx, timeout+stopKillMargin)
+	err := m.StopContainer(stopCtx, containerID, timeout)
+	cancel()
+
+	if err != nil {
+		m.logger.Warn("graceful stop failed, killing container",
+			zap.String("id", containerID[:12]),
+			zap.Error(err))
+		// Force removal below still kills it if the daemon can
+		m.KillContainer(ctx, containerID)
+	}
+
+	return m.RemoveContainer(ctx, containerID, true)
+}
+
+// KillContainer sends SIGKILL to a container, stopping whatever runs in it
+// immediately without removing it
+func (m *ContainerManager) KillContainer(ctx context.Context, containerID string) error {
+	if err := m.dockerClient.ContainerKill(ctx, containerID, "SIGKILL"); err != nil {
+		m.logger.Error("failed to kill container",
+			zap.String("id", containerID[:12]),
+			zap.Error(err))
+		return fmt.Errorf("failed to kill container: %w", err)
+	}
+	return nil
+}
+
 // RemoveContainer removes a container
 func (m *ContainerManager) RemoveContainer(ctx context.Context, containerID string, force bool) error {
 	m.logger.Debug("removing container",
@@ -340,6 +690,71 @@ func (m *ContainerManager) RemoveContainer(ctx context.Context, containerID stri
 	return nil
 }
 
+// MarkContainerFailed renames a container so it is recognizable as failed and
+// can be inspected before it is reaped
+func (m *ContainerManager) MarkContainerFailed(ctx context.Context, containerID string) error {
+	failedName := fmt.Sprintf("%s-failed-%s", m.containerPrefix, containerID[:12])
+
+	if err := m.dockerClient.ContainerRename(ctx, containerID, failedName); err != nil {
+		m.logger.Error("failed to rename failed container",
+			zap.String("id", containerID[:12]),
+			zap.Error(err))
+		return fmt.Errorf("failed to rename container: %w", err)
+	}
+
+	m.logger.Warn("container retained for inspection",
+		zap.String("id", containerID[:12]),
+		zap.String("name", failedName))
+	return nil
+}
+
+// PinCPUs restricts a running container to the given cpuset
+func (m *ContainerManager) PinCPUs(ctx context.Context, containerID string, cpuset string) error {
+	_, err := m.dockerClient.ContainerUpdate(ctx, containerID, container.UpdateConfig{
+		Resources: container.Resources{
+			CpusetCpus: cpuset,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to pin container to cpuset %s: %w", cpuset, err)
+	}
+
+	m.logger.Debug("container pinned",
+		zap.String("id", containerID[:12]),
+		zap.String("cpuset", cpuset))
+	return nil
+}
+
+// MemoryUsage returns a container's peak memory usage in bytes, falling back
+// to current usage where the cgroup doesn't track a peak (cgroup v2)
+func (m *ContainerManager) MemoryUsage(ctx context.Context, containerID string) (uint64, error) {
+	stats, err := m.dockerClient.ContainerStatsOneShot(ctx, containerID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get container stats: %w", err)
+	}
+	defer stats.Body.Close()
+
+	var parsed types.StatsJSON
+	if err := json.NewDecoder(stats.Body).Decode(&parsed); err != nil {
+		return 0, fmt.Errorf("failed to decode container stats: %w", err)
+	}
+
+	if parsed.MemoryStats.MaxUsage > 0 {
+		return parsed.MemoryStats.MaxUsage, nil
+	}
+	return parsed.MemoryStats.Usage, nil
+}
+
+// RestartState reports whether a container is running and how many times
+// Docker has restarted it under its restart policy
+func (m *ContainerManager) RestartState(ctx context.Context, containerID string) (bool, int, error) {
+	inspect, err := m.dockerClient.ContainerInspect(ctx, containerID)
+	if err != nil {
+		return false, 0, fmt.Errorf("failed to inspect container: %w", err)
+	}
+	return inspect.State.Running, inspect.RestartCount, nil
+}
+
 // GetContainerIP retrieves the IP address of a container on the managed network
 func (m *ContainerManager) GetContainerIP(ctx context.Context, containerID string) (string, error) {
 	inspect, err := m.dockerClient.ContainerInspect(ctx, containerID)
@@ -450,6 +865,32 @@ func (m *ContainerManager) ListContainers(ctx context.Context, filterMap map[str
 	return result, nil
 }
 
+// capacityErrorMarkers are substrings of Docker errors caused by the host
+// running out of resources rather than by the action or image
+var capacityErrorMarkers = []string{
+	"no space left on device",
+	"cannot allocate memory",
+	"out of memory",
+	"too many open files",
+	"resource temporarily unavailable",
+}
+
+// IsCapacityError reports whether a container operation failed because the
+// host is out of memory, disk or other resources
+func IsCapacityError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, marker := range capacityErrorMarkers {
+		if strings.Contains(msg, marker) {
+			return true
+		}
+	}
+	return false
+}
+
 // Close closes the Docker client connection
 func (m *ContainerManager) Close() error {
 	if m.dockerClient != nil {
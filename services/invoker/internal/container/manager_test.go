@@ -0,0 +1,181 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDigest is a well-formed image digest
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestPinnedImagePulledAndCreatedByDigest(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+	fake.missingImages = true
+	pinned := "ghcr.io/penguintechinc/go@" + testDigest
+
+	container, err := pool.manager.CreateContainer(context.Background(), ContainerSpec{Image: pinned})
+	if err != nil {
+		t.Fatalf("CreateContainer() = %v", err)
+	}
+
+	if !reflect.DeepEqual(fake.pulls, []string{pinned}) {
+		t.Errorf("pulled %v, want only %s", fake.pulls, pinned)
+	}
+	if image := fake.configs[container.ID].Image; image != pinned {
+		t.Errorf("container created from %q, want %q", image, pinned)
+	}
+}
+
+func TestMalformedDigestRejected(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+
+	_, err := pool.manager.CreateContainer(context.Background(), ContainerSpec{Image: "ghcr.io/penguintechinc/go@sha256:abc"})
+	if err == nil || !strings.Contains(err.Error(), "invalid image digest") {
+		t.Fatalf("CreateContainer() = %v, want an invalid digest error", err)
+	}
+	if fake.live() != 0 || len(fake.pulls) != 0 {
+		t.Error("malformed digest reached Docker")
+	}
+}
+
+func TestEntrypointAndCmdOverride(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+	pool.manager.entrypoints = map[string]bool{"/bin/wrapper": true}
+	ctx := context.Background()
+
+	container, err := pool.manager.CreateContainer(ctx, ContainerSpec{
+		Image:      "go:1.23",
+		Entrypoint: []string{"/bin/wrapper", "--"},
+		Cmd:        []string{"/action/exec", "-v"},
+	})
+	if err != nil {
+		t.Fatalf("CreateContainer() = %v", err)
+	}
+	config := fake.configs[container.ID]
+	if !reflect.DeepEqual(config.Entrypoint, []string{"/bin/wrapper", "--"}) || !reflect.DeepEqual(config.Cmd, []string{"/action/exec", "-v"}) {
+		t.Errorf("container created with entrypoint %q and cmd %q", config.Entrypoint, config.Cmd)
+	}
+
+	// Untrusted runtimes are held to the allowlist, trusted ones are not
+	spec := ContainerSpec{Image: "go:1.23", Entrypoint: []string{"/bin/sh", "-c"}}
+	if _, err := pool.manager.CreateContainer(ctx, spec); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Errorf("CreateContainer(untrusted) = %v, want the entrypoint rejected", err)
+	}
+	spec.Trusted = true
+	if _, err := pool.manager.CreateContainer(ctx, spec); err != nil {
+		t.Errorf("CreateContainer(trusted) = %v", err)
+	}
+}
+
+func TestEnsureNetworkToleratesConcurrentCreate(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+
+	// The network is missing when listed, then another invoker creates it
+	// first and ours gets a conflict
+	if err := pool.manager.ensureNetwork(context.Background()); err != nil {
+		t.Fatalf("ensureNetwork() = %v, want the concurrently created network accepted", err)
+	}
+	if !fake.networkCreated {
+		t.Fatal("network was never created")
+	}
+
+	// Already there on the next start
+	if err := pool.manager.ensureNetwork(context.Background()); err != nil {
+		t.Fatalf("ensureNetwork() = %v with the network present", err)
+	}
+}
+
+func TestImagePullCanceledWithContext(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+	fake.missingImages = true
+	fake.pullGate = make(chan struct{})
+	t.Cleanup(func() { close(fake.pullGate) })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := pool.manager.pullImageIfNeeded(ctx, "go:1.23")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("pullImageIfNeeded() = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("canceled pull returned after %v", elapsed)
+	}
+}
+
+func TestStartRespectsConfiguredTimeout(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+	fake.neverRunning = true
+	pool.manager.startTimeout = 300 * time.Millisecond
+
+	container, err := pool.manager.CreateContainer(context.Background(), ContainerSpec{Image: "go:1.23"})
+	if err != nil {
+		t.Fatalf("CreateContainer() = %v", err)
+	}
+
+	start := time.Now()
+	err = pool.manager.StartContainer(context.Background(), container.ID)
+	elapsed := time.Since(start)
+	if !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "within 300ms") {
+		t.Fatalf("StartContainer() = %v, want the start timeout", err)
+	}
+	if elapsed < 300*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("StartContainer() gave up after %v, want about 300ms", elapsed)
+	}
+}
+
+func TestStopOrKillForceKillsHungContainer(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+	fake.failStop = true
+
+	container, err := pool.manager.CreateContainer(context.Background(), ContainerSpec{Image: "go:1.23"})
+	if err != nil {
+		t.Fatalf("CreateContainer() = %v", err)
+	}
+
+	if err := pool.manager.StopOrKill(context.Background(), container.ID, time.Second); err != nil {
+		t.Fatalf("StopOrKill() = %v", err)
+	}
+	if !fake.wasKilled(container.ID) {
+		t.Error("container ignoring stop was not killed")
+	}
+	if !fake.wasRemoved(container.ID) {
+		t.Error("container ignoring stop was not removed")
+	}
+}
+
+func TestLimitedClientBoundsConcurrentCalls(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+	fake.callDelay = 20 * time.Millisecond
+
+	container, err := pool.manager.CreateContainer(context.Background(), ContainerSpec{Image: "go:1.23"})
+	if err != nil {
+		t.Fatalf("CreateContainer() = %v", err)
+	}
+
+	// Two components with their own clients share one bound of 2
+	limited := NewLimitedClient(pool.manager.dockerClient.Client, 2)
+	clients := []*LimitedClient{limited, limited.Share(pool.manager.dockerClient.Client)}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(cli *LimitedClient) {
+			defer wg.Done()
+			if _, err := cli.ContainerInspect(context.Background(), container.ID); err != nil {
+				t.Errorf("ContainerInspect() = %v", err)
+			}
+		}(clients[i%2])
+	}
+	wg.Wait()
+
+	if peak := fake.peakCalls("json"); peak != 2 {
+		t.Errorf("%d inspects ran at once, want the bound of 2", peak)
+	}
+}
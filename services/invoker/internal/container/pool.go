@@ -2,9 +2,13 @@ package container
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"math/rand"
 	"sync"
 	"time"
+
+	"go.uber.org/zap"
 )
 
 // PoolState represents the state of a pooled container
@@ -14,6 +18,7 @@ const (
 	PoolStateWarm   PoolState = "warm"
 	PoolStateBusy   PoolState = "busy"
 	PoolStatePaused PoolState = "paused"
+	PoolStateFailed PoolState = "failed"
 )
 
 // PooledContainer wraps a container with pooling metadata
@@ -22,15 +27,128 @@ type PooledContainer struct {
 	Runtime           string
 	State             PoolState
 	LastUsed          time.Time
-	InitializedAction string // empty if just prewarmed
+	InitializedAction string // action key, empty if just prewarmed
+	CodeHash          string // hash of the code the action was initialized with
+	NeedsInit         bool   // checked out for an action it isn't initialized with
+	RemoveOnReturn    bool   // remove instead of pooling when returned
+	Outcomes          OutcomeRing
+	CPUSet            string // cpuset of the pinned action it runs, if any
+	NetworkMode       string // network mode it was created with, "" for the manager default
+}
+
+// outcomeWindow is how many recent invocations a container's health covers
+const outcomeWindow = 8
+
+// ErrContainerLimit is returned when creating a container would exceed
+// MaxTotalContainers
+var ErrContainerLimit = errors.New("container limit reached")
+
+// DefaultPrewarmParallelism is how many prewarm containers are created at
+// once when no parallelism is configured
+const DefaultPrewarmParallelism = 4
+
+// containerStopTimeout is the grace period a container gets to exit on
+// SIGTERM before it is killed on removal
+const containerStopTimeout = 2 * time.Second
+
+// forceRemoveTimeout bounds force-removing a container once the shutdown
+// drain deadline has passed
+const forceRemoveTimeout = 10 * time.Second
+
+// minQuarantineSamples is how many outcomes are needed before quarantining
+const minQuarantineSamples = 4
+
+// OutcomeRing records the success of a container's most recent invocations
+type OutcomeRing struct {
+	failed [outcomeWindow]bool
+	next   int
+	count  int
+}
+
+// Record adds an invocation outcome, overwriting the oldest when full
+func (r *OutcomeRing) Record(success bool) {
+	r.failed[r.next] = !success
+	r.next = (r.next + 1) % outcomeWindow
+	if r.count < outcomeWindow {
+		r.count++
+	}
+}
+
+// FailureRatio returns the fraction of recorded outcomes that failed
+func (r *OutcomeRing) FailureRatio() float64 {
+	if r.count == 0 {
+		return 0
+	}
+	failures := 0
+	for i := 0; i < r.count; i++ {
+		if r.failed[i] {
+			failures++
+		}
+	}
+	return float64(failures) / float64(r.count)
+}
+
+// ActionKey builds the InitializedAction key for an action
+func ActionKey(namespace, name string) string {
+	return namespace + "/" + name
 }
 
 // PoolConfig defines configuration for the container pool
 type PoolConfig struct {
-	MaxPoolSize       int
-	PrewarmConfig     map[string]int // runtime -> prewarm count
-	IdleTimeout       time.Duration
-	CleanupInterval   time.Duration
+	MaxPoolSize        int
+	MaxTotalContainers int            // warm + busy cap, 0 = unlimited
+	PrewarmConfig      map[string]int // runtime -> prewarm count
+	MinWarm            map[string]int // runtime -> floor of uninitialized warm containers kept by cleanup
+	IdleTimeout        time.Duration
+	CleanupInterval    time.Duration
+	CleanupJitter      float64       // fraction each cleanup interval is randomly varied by, 0-1
+	PrewarmJitter      time.Duration // minimum spacing between prewarm creations
+	PrewarmParallelism int           // containers created concurrently by PrewarmContainers
+
+	// KeepFailedContainers retains containers returned without reuse for
+	// post-mortem inspection instead of removing them immediately
+	KeepFailedContainers bool
+	FailedRetention      time.Duration
+	// RemoveFailedOnShutdown removes retained failed containers on shutdown
+	// too; by default they outlive the invoker so they can still be inspected
+	RemoveFailedOnShutdown bool
+
+	// QuarantineFailureRatio removes a container on return once this share
+	// of its recent invocations failed, 0 = never
+	QuarantineFailureRatio float64
+
+	// PredictiveWarming raises prewarm targets above PrewarmConfig to follow
+	// an EWMA of recent demand, re-evaluated every DemandWindow
+	PredictiveWarming bool
+	DemandWindow      time.Duration
+	DemandAlpha       float64
+
+	// PinnedActions gives actions dedicated CPUs (action -> CPU count),
+	// carved from HostCPUs while keeping ReservedCPUs for everything else
+	PinnedActions map[string]int
+	HostCPUs      int
+	ReservedCPUs  int
+
+	// ActionMetrics lists the actions (namespace/name) whose cold and warm
+	// starts are labeled individually; the rest are counted as "other"
+	ActionMetrics []string
+
+	// ShareByCodeHash lets a warm container initialized with identical code
+	// serve another action, even in another namespace. The container is
+	// re-initialized so it doesn't keep the first action's env and bound
+	// params, but the runtime's build cache spares the compile. Meant for
+	// trusted single-tenant clusters
+	ShareByCodeHash bool
+
+	// RuntimeImages maps runtime kinds to the image their containers are
+	// created from; pool keys not listed (blackbox actions) are images
+	RuntimeImages map[string]string
+}
+
+// ColdStartObserver is notified whenever the pool creates a container for a
+// request, with the time spent creating and starting it
+type ColdStartObserver interface {
+	ContainerColdStart(containerID, runtime string, durationMs int64)
 }
 
 // PoolStats provides statistics about the pool
@@ -41,85 +159,187 @@ type PoolStats struct {
 	TotalContainers   int
 }
 
+// poolCounters holds the figures GetPoolStats reports, updated as containers
+// are added, checked out and removed so reading stats never walks the pool
+type poolCounters struct {
+	mu      sync.Mutex
+	warm    map[string]int // runtime -> warm containers
+	prewarm map[string]int // runtime -> warm containers not yet initialized
+	busy    int
+}
+
+// PooledContainerInfo is a point-in-time snapshot of a pooled container
+type PooledContainerInfo struct {
+	ContainerID       string    `json:"containerId"`
+	Runtime           string    `json:"runtime"`
+	State             PoolState `json:"state"`
+	LastUsed          time.Time `json:"lastUsed"`
+	InitializedAction string    `json:"initializedAction,omitempty"`
+}
+
 // ContainerPool manages a pool of warm containers for fast invocations
 type ContainerPool struct {
-	manager         *ContainerManager
-	warmContainers  map[string][]*PooledContainer // runtime -> containers
-	busyContainers  map[string]*PooledContainer   // containerID -> container
-	prewarmConfig   map[string]int                // runtime -> count
-	mu              sync.RWMutex
-	maxPoolSize     int
-	idleTimeout     time.Duration
-	cleanupInterval time.Duration
-	stopCleanup     chan struct{}
-	cleanupWg       sync.WaitGroup
+	manager            *ContainerManager
+	warmContainers     map[string][]*PooledContainer // runtime -> containers
+	busyContainers     map[string]*PooledContainer   // containerID -> container
+	failedContainers   map[string]*PooledContainer   // containerID -> container
+	prewarmConfig      map[string]int                // runtime -> count
+	minWarm            map[string]int                // runtime -> uninitialized warm floor
+	mu                 sync.RWMutex
+	maxPoolSize        int
+	maxTotalContainers int
+	creating           int // containers being created outside the lock, counted against maxTotalContainers
+	removing           int // containers detached from the pool and being removed outside the lock, counted likewise
+	capacityReleased   chan struct{}
+	idleTimeout        time.Duration
+	cleanupInterval    time.Duration
+	cleanupJitter      float64
+	prewarmJitter      time.Duration
+	sleep              func(context.Context, time.Duration) error // waits out prewarm jitter; replaced in tests
+	prewarmParallelism int
+	keepFailed         bool
+	failedRetention    time.Duration
+	removeFailed       bool // remove retained failed containers on shutdown
+	quarantineRatio    float64
+	basePrewarm        map[string]int // runtime -> configured prewarm count
+	demand             *DemandTracker // nil unless predictive warming is on
+	demandWindow       time.Duration
+	cpusets            *CPUSetAllocator // nil unless actions are pinned
+	counters           poolCounters
+	coldStarts         ColdStartObserver // nil unless events are enabled
+	actionMetrics      map[string]bool   // actions with their own start metrics
+	shareByCodeHash    bool              // reuse warm containers across actions with identical code
+	runtimeImages      map[string]string // runtime -> image ref
+	stopCleanup        chan struct{}
+	cleanupWg          sync.WaitGroup
+	logger             *zap.Logger
 }
 
 // NewContainerPool creates a new container pool
-func NewContainerPool(manager *ContainerManager, config PoolConfig) *ContainerPool {
+func NewContainerPool(manager *ContainerManager, config PoolConfig, logger *zap.Logger) *ContainerPool {
 	pool := &ContainerPool{
-		manager:         manager,
-		warmContainers:  make(map[string][]*PooledContainer),
-		busyContainers:  make(map[string]*PooledContainer),
-		prewarmConfig:   config.PrewarmConfig,
-		maxPoolSize:     config.MaxPoolSize,
-		idleTimeout:     config.IdleTimeout,
-		cleanupInterval: config.CleanupInterval,
-		stopCleanup:     make(chan struct{}),
+		manager:        manager,
+		warmContainers: make(map[string][]*PooledContainer),
+		counters: poolCounters{
+			warm:    make(map[string]int),
+			prewarm: make(map[string]int),
+		},
+		busyContainers:     make(map[string]*PooledContainer),
+		failedContainers:   make(map[string]*PooledContainer),
+		prewarmConfig:      config.PrewarmConfig,
+		minWarm:            config.MinWarm,
+		maxPoolSize:        config.MaxPoolSize,
+		maxTotalContainers: config.MaxTotalContainers,
+		capacityReleased:   make(chan struct{}),
+		idleTimeout:        config.IdleTimeout,
+		cleanupInterval:    config.CleanupInterval,
+		cleanupJitter:      config.CleanupJitter,
+		prewarmJitter:      config.PrewarmJitter,
+		sleep:              sleepContext,
+		prewarmParallelism: config.PrewarmParallelism,
+		keepFailed:         config.KeepFailedContainers,
+		failedRetention:    config.FailedRetention,
+		removeFailed:       config.RemoveFailedOnShutdown,
+		quarantineRatio:    config.QuarantineFailureRatio,
+		stopCleanup:        make(chan struct{}),
+		actionMetrics:      make(map[string]bool, len(config.ActionMetrics)),
+		shareByCodeHash:    config.ShareByCodeHash,
+		runtimeImages:      config.RuntimeImages,
+		logger:             logger,
+	}
+	for _, action := range config.ActionMetrics {
+		pool.actionMetrics[action] = true
+	}
+
+	if pool.prewarmConfig == nil {
+		// ScalePool records its targets here
+		pool.prewarmConfig = make(map[string]int)
+	}
+	if pool.prewarmParallelism <= 0 {
+		pool.prewarmParallelism = DefaultPrewarmParallelism
+	}
+
+	if len(config.PinnedActions) > 0 {
+		pool.cpusets = NewCPUSetAllocator(config.HostCPUs, config.ReservedCPUs, config.PinnedActions)
 	}
 
 	// Start cleanup goroutine
 	pool.cleanupWg.Add(1)
 	go pool.cleanupLoop()
 
+	if config.PredictiveWarming && config.DemandWindow > 0 {
+		pool.basePrewarm = make(map[string]int, len(pool.prewarmConfig))
+		for runtime, count := range pool.prewarmConfig {
+			pool.basePrewarm[runtime] = count
+		}
+		pool.demand = NewDemandTracker(config.DemandAlpha)
+		pool.demandWindow = config.DemandWindow
+
+		pool.cleanupWg.Add(1)
+		go pool.demandLoop()
+	}
+
 	return pool
 }
 
 // GetContainer gets a container from the pool or creates a new one
 // Selection priority:
-// 1. Warm container initialized with same action (stem cell reuse)
+// 1. Warm container initialized with same action and code (stem cell reuse)
 // 2. Warm container with matching runtime (needs /init)
 // 3. Create new container (cold start)
-func (p *ContainerPool) GetContainer(ctx context.Context, runtime string, action string) (*PooledContainer, error) {
+// When the total container cap is reached, a cold start waits for a
+// container to be returned until the context deadline expires. Cold starts
+// also return their pull, create and start timings. Warm containers are
+// reused across memory tiers as long as they have at least memoryMB, but
+// only for the network mode they were created with.
+// Cold containers are created outside the lock so other checkouts aren't
+// held up behind Docker
+func (p *ContainerPool) GetContainer(ctx context.Context, runtime string, action string, codeHash string, memoryMB int64, networkMode string) (*PooledContainer, *ColdStartTimings, error) {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	// First: check for warm container initialized with same action
-	if containers, exists := p.warmContainers[runtime]; exists {
-		for i, pc := range containers {
-			if pc.InitializedAction == action && pc.State == PoolStateWarm {
-				// Remove from warm pool
-				p.warmContainers[runtime] = append(containers[:i], containers[i+1:]...)
 
-				// Mark as busy
-				pc.State = PoolStateBusy
-				pc.LastUsed = time.Now()
-				p.busyContainers[pc.Container.ID] = pc
+	if p.demand != nil {
+		p.demand.Record(runtime)
+	}
 
-				return pc, nil
-			}
+	for {
+		if pc := p.takeWarmContainer(runtime, action, codeHash, memoryMB, networkMode); pc != nil {
+			p.recordActionStart(action, false)
+			p.mu.Unlock()
+			p.pinContainer(ctx, pc, action)
+			return pc, nil, nil
 		}
-	}
 
-	// Second: check for warm container with matching runtime
-	if containers, exists := p.warmContainers[runtime]; exists && len(containers) > 0 {
-		// Take the most recently used container
-		pc := containers[len(containers)-1]
-		p.warmContainers[runtime] = containers[:len(containers)-1]
+		if p.reserveSlot() {
+			break
+		}
 
-		// Mark as busy
-		pc.State = PoolStateBusy
-		pc.LastUsed = time.Now()
-		pc.InitializedAction = action
-		p.busyContainers[pc.Container.ID] = pc
+		// Idle containers of other runtimes give up their slot before we block
+		if oldest := p.detachOldestContainer(); oldest != nil {
+			p.mu.Unlock()
+			if err := p.removeDetached(oldest); err != nil {
+				p.logger.Error("failed to remove evicted container",
+					zap.String("id", oldest.Container.ID),
+					zap.Error(err))
+			}
+			p.mu.Lock()
+			continue
+		}
 
-		return pc, nil
+		if err := p.waitForCapacity(ctx); err != nil {
+			p.mu.Unlock()
+			return nil, nil, fmt.Errorf("container limit of %d reached: %w", p.maxTotalContainers, err)
+		}
 	}
+	p.mu.Unlock()
 
 	// Third: create new container (cold start)
-	container, err := p.manager.CreateContainer(ctx, runtime)
+	container, err := p.startContainer(ctx, runtime, memoryMB, networkMode)
+
+	p.mu.Lock()
+	p.releaseSlot()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create container: %w", err)
+		p.mu.Unlock()
+		return nil, nil, err
 	}
 
 	pc := &PooledContainer{
@@ -128,46 +348,351 @@ func (p *ContainerPool) GetContainer(ctx context.Context, runtime string, action
 		State:             PoolStateBusy,
 		LastUsed:          time.Now(),
 		InitializedAction: action,
+		CodeHash:          codeHash,
+		NeedsInit:         true,
+		NetworkMode:       networkMode,
 	}
 
 	p.busyContainers[container.ID] = pc
+	p.countBusy(1)
+	p.recordActionStart(action, true)
+
+	timings := container.Timings
+	if p.coldStarts != nil {
+		// Notify outside the request path; observers may do network I/O
+		go p.coldStarts.ContainerColdStart(container.ID, runtime, timings.PullMs+timings.CreateMs+timings.StartMs)
+	}
+	p.mu.Unlock()
 
-	return pc, nil
+	p.pinContainer(ctx, pc, action)
+	return pc, &timings, nil
 }
 
-// ReturnContainer returns a container to the pool or removes it
-func (p *ContainerPool) ReturnContainer(containerID string, reuse bool) error {
+// containerSpec returns the spec for a runtime's containers with memoryMB of
+// memory and networkMode, or the manager defaults when zero
+func (p *ContainerPool) containerSpec(runtime string, memoryMB int64, networkMode string) ContainerSpec {
+	image, ok := p.runtimeImages[runtime]
+	if !ok {
+		image = runtime
+	}
+	return ContainerSpec{
+		Image:       image,
+		Memory:      memoryMB * 1024 * 1024,
+		NetworkMode: networkMode,
+	}
+}
+
+// startContainer creates a container for runtime with memoryMB of memory
+// and networkMode (the manager defaults when zero) and starts it, so every
+// container the pool hands out is running and has its IP. Containers
+// without networking have no IP and are driven through the exec transport.
+// A container that fails to start is removed again
+func (p *ContainerPool) startContainer(ctx context.Context, runtime string, memoryMB int64, networkMode string) (*Container, error) {
+	spec := p.containerSpec(runtime, memoryMB, networkMode)
+	container, err := p.manager.CreateContainer(ctx, spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create container: %w", err)
+	}
+
+	startBegin := time.Now()
+	if err := p.manager.StartContainer(ctx, container.ID); err != nil {
+		p.removeUnstarted(container.ID)
+		return nil, fmt.Errorf("failed to start container: %w", err)
+	}
+	container.Timings.StartMs = time.Since(startBegin).Milliseconds()
+	container.State = ContainerStateRunning
+
+	if networkMode != NetworkModeNone {
+		ip, err := p.manager.GetContainerIP(ctx, container.ID)
+		if err != nil {
+			p.removeUnstarted(container.ID)
+			return nil, fmt.Errorf("failed to get container IP: %w", err)
+		}
+		container.IP = ip
+	}
+
+	// Mismatched limits are reported but the container is still used
+	if p.manager.verifyLimits {
+		if err := p.manager.VerifyLimits(ctx, container.ID, spec); err != nil {
+			p.logger.Warn("container limits differ from requested",
+				zap.String("id", container.ID),
+				zap.Error(err))
+		}
+	}
+
+	return container, nil
+}
+
+// removeUnstarted force-removes a container that never became usable
+func (p *ContainerPool) removeUnstarted(containerID string) {
+	removeCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := p.manager.RemoveContainer(removeCtx, containerID, true); err != nil {
+		p.logger.Error("failed to remove unstarted container",
+			zap.String("id", containerID),
+			zap.Error(err))
+	}
+}
+
+// SetColdStartObserver registers an observer notified of cold starts
+func (p *ContainerPool) SetColdStartObserver(observer ColdStartObserver) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.coldStarts = observer
+}
+
+// pinContainer moves a checked-out container onto its action's cpuset, or
+// back onto all CPUs when a pinned container is reused by an unpinned action.
+// Pinning failures are logged and the container runs unpinned. The
+// container is checked out, so only its caller touches it
+// Must be called without lock held
+func (p *ContainerPool) pinContainer(ctx context.Context, pc *PooledContainer, action string) {
+	if p.cpusets == nil {
+		return
+	}
+
+	cpuset, err := p.cpusets.Assign(action)
+	if err != nil {
+		p.logger.Warn("running action unpinned",
+			zap.String("action", action),
+			zap.Error(err))
+	}
+	if cpuset == pc.CPUSet {
+		return
+	}
+
+	target := cpuset
+	if target == "" {
+		target = p.cpusets.All()
+	}
+	if err := p.manager.PinCPUs(ctx, pc.Container.ID, target); err != nil {
+		p.logger.Error("failed to pin container",
+			zap.String("id", pc.Container.ID),
+			zap.String("action", action),
+			zap.Error(err))
+		return
+	}
+	pc.CPUSet = cpuset
+}
+
+// takeWarmContainer checks out a warm container for the runtime, preferring
+// one already initialized with the action, then (when sharing by code hash)
+// one initialized with identical code for another action, which needs a
+// cheap re-init. A container
+// initialized with different code for the same action is re-initialized
+// rather than reused.
+// Containers created with networkMode and at least memoryMB qualify, and the
+// smallest sufficient one is taken so larger containers stay free for
+// actions that need them.
+// Returns nil if none is available
+// Must be called with lock held
+func (p *ContainerPool) takeWarmContainer(runtime string, action string, codeHash string, memoryMB int64, networkMode string) *PooledContainer {
+	containers := p.warmContainers[runtime]
+
+	// First: check for warm container initialized with same action and code
+	best := -1
+	for i, pc := range containers {
+		if pc.InitializedAction == action && pc.CodeHash == codeHash && pc.State == PoolStateWarm &&
+			betterFit(pc, containers, best, memoryMB, networkMode) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		pc := p.checkOutWarm(runtime, best)
+		pc.NeedsInit = false
+		return pc
+	}
+
+	// Failing that, check for warm container initialized with the same code by
+	// another action. It is re-initialized for this action so env and bound
+	// params never carry over, and the runtime reuses the compiled binary
+	if p.shareByCodeHash && codeHash != "" {
+		for i, pc := range containers {
+			if pc.CodeHash == codeHash && pc.State == PoolStateWarm &&
+				betterFit(pc, containers, best, memoryMB, networkMode) {
+				best = i
+			}
+		}
+		if best >= 0 {
+			pc := p.checkOutWarm(runtime, best)
+			pc.InitializedAction = action
+			pc.NeedsInit = true
+			return pc
+		}
+	}
+
+	// Second: check for warm container with matching runtime, taking the
+	// most recently used among equally sized ones
+	for i := len(containers) - 1; i >= 0; i-- {
+		if betterFit(containers[i], containers, best, memoryMB, networkMode) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		pc := p.checkOutWarm(runtime, best)
+		pc.InitializedAction = action
+		pc.CodeHash = codeHash
+		pc.NeedsInit = true
+		return pc
+	}
+
+	return nil
+}
+
+// betterFit reports whether pc has networkMode and room for memoryMB and is
+// smaller than the current best candidate, if any
+func betterFit(pc *PooledContainer, containers []*PooledContainer, best int, memoryMB int64, networkMode string) bool {
+	if pc.NetworkMode != networkMode || pc.Container.MemoryMB < memoryMB {
+		return false
+	}
+	return best < 0 || pc.Container.MemoryMB < containers[best].Container.MemoryMB
+}
+
+// checkOutWarm moves the runtime's i-th warm container to the busy set
+// Must be called with lock held
+func (p *ContainerPool) checkOutWarm(runtime string, i int) *PooledContainer {
+	containers := p.warmContainers[runtime]
+	pc := containers[i]
+	p.warmContainers[runtime] = append(containers[:i], containers[i+1:]...)
+	p.countWarm(pc, -1)
+
+	pc.State = PoolStateBusy
+	pc.LastUsed = time.Now()
+	p.busyContainers[pc.Container.ID] = pc
+	p.countBusy(1)
+
+	return pc
+}
+
+// totalContainers returns the number of warm and busy containers, including
+// those still being created or removed
+// Must be called with lock held
+func (p *ContainerPool) totalContainers() int {
+	total := len(p.busyContainers) + p.creating + p.removing
+	for _, containers := range p.warmContainers {
+		total += len(containers)
+	}
+	return total
+}
+
+// reserveSlot claims room for a container about to be created outside the
+// lock, so concurrent creations can't overshoot MaxTotalContainers. Returns
+// false when the pool is at the cap
+// Must be called with lock held
+func (p *ContainerPool) reserveSlot() bool {
+	if p.maxTotalContainers > 0 && p.totalContainers() >= p.maxTotalContainers {
+		return false
+	}
+	p.creating++
+	return true
+}
+
+// releaseSlot gives back a slot claimed by reserveSlot once its container is
+// added to the pool or failed to be created, and wakes up waiters to recheck
+// Must be called with lock held
+func (p *ContainerPool) releaseSlot() {
+	p.creating--
+	p.signalCapacity()
+}
+
+// addWarm puts a container in its runtime's warm pool
+// Must be called with lock held
+func (p *ContainerPool) addWarm(pc *PooledContainer) {
+	p.warmContainers[pc.Runtime] = append(p.warmContainers[pc.Runtime], pc)
+	p.countWarm(pc, 1)
+}
+
+// countWarm adjusts the stats counters for a container entering (delta 1) or
+// leaving (delta -1) the warm pool
+// Must be called with lock held
+func (p *ContainerPool) countWarm(pc *PooledContainer, delta int) {
+	p.counters.mu.Lock()
+	defer p.counters.mu.Unlock()
+
+	p.counters.warm[pc.Runtime] += delta
+	if pc.InitializedAction == "" {
+		p.counters.prewarm[pc.Runtime] += delta
+	}
+}
+
+// countBusy adjusts the busy container counter
+// Must be called with lock held
+func (p *ContainerPool) countBusy(delta int) {
+	p.counters.mu.Lock()
+	p.counters.busy += delta
+	p.counters.mu.Unlock()
+}
+
+// waitForCapacity releases the lock until a container is returned or removed,
+// or the context is done
+// Must be called with lock held
+func (p *ContainerPool) waitForCapacity(ctx context.Context) error {
+	released := p.capacityReleased
+
+	p.mu.Unlock()
+	defer p.mu.Lock()
+
+	select {
+	case <-released:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// signalCapacity wakes up all GetContainer calls waiting for capacity
+// Must be called with lock held
+func (p *ContainerPool) signalCapacity() {
+	close(p.capacityReleased)
+	p.capacityReleased = make(chan struct{})
+}
+
+// ReturnContainer returns a container to the pool or removes it. Containers
+// are stopped outside the lock so other checkouts aren't held up behind Docker
+func (p *ContainerPool) ReturnContainer(containerID string, reuse bool) error {
+	p.mu.Lock()
 
 	pc, exists := p.busyContainers[containerID]
 	if !exists {
+		p.mu.Unlock()
 		return fmt.Errorf("container %s not found in busy pool", containerID)
 	}
 
 	// Remove from busy pool
 	delete(p.busyContainers, containerID)
+	p.countBusy(-1)
+
+	// Quarantine flaky containers instead of handing them out again
+	if reuse && !pc.RemoveOnReturn && p.shouldQuarantine(pc) {
+		p.logger.Warn("quarantining container",
+			zap.String("id", containerID),
+			zap.Float64("failureRatio", pc.Outcomes.FailureRatio()))
+		reuse = false
+	}
+
+	if pc.RemoveOnReturn || !reuse {
+		p.removing++
+		p.mu.Unlock()
 
-	if !reuse {
-		// Remove container
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		return p.manager.RemoveContainer(ctx, containerID)
+		if !pc.RemoveOnReturn && p.keepFailed {
+			return p.retainFailedContainer(pc)
+		}
+		return p.removeDetached(pc)
 	}
 
-	// Check pool size limit
+	// Make room in a full pool by evicting the least recently used container
 	totalWarm := 0
 	for _, containers := range p.warmContainers {
 		totalWarm += len(containers)
 	}
 
+	var evicted *PooledContainer
 	if totalWarm >= p.maxPoolSize {
-		// Pool is full, remove oldest container
-		if err := p.removeOldestContainer(); err != nil {
-			// If removal fails, just remove this container
-			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-			defer cancel()
-			return p.manager.RemoveContainer(ctx, containerID)
+		if evicted = p.detachOldestContainer(); evicted == nil {
+			// Nothing to evict, so this container doesn't fit
+			p.removing++
+			p.mu.Unlock()
+			return p.removeDetached(pc)
 		}
 	}
 
@@ -175,86 +700,265 @@ func (p *ContainerPool) ReturnContainer(containerID string, reuse bool) error {
 	pc.State = PoolStateWarm
 	pc.LastUsed = time.Now()
 
-	if p.warmContainers[pc.Runtime] == nil {
-		p.warmContainers[pc.Runtime] = make([]*PooledContainer, 0)
+	p.addWarm(pc)
+	p.signalCapacity()
+	p.mu.Unlock()
+
+	if evicted != nil {
+		if err := p.removeDetached(evicted); err != nil {
+			p.logger.Error("failed to remove evicted container",
+				zap.String("id", evicted.Container.ID),
+				zap.Error(err))
+		}
 	}
-	p.warmContainers[pc.Runtime] = append(p.warmContainers[pc.Runtime], pc)
 
 	return nil
 }
 
-// PrewarmContainers creates prewarm containers according to configuration
-func (p *ContainerPool) PrewarmContainers(ctx context.Context) error {
+// KillContainer stops a checked-out container's processes at once, for
+// runaway actions whose container is about to be removed anyway
+func (p *ContainerPool) KillContainer(ctx context.Context, containerID string) error {
+	return p.manager.KillContainer(ctx, containerID)
+}
+
+// RecordOutcome records whether an invocation on a busy container succeeded
+func (p *ContainerPool) RecordOutcome(containerID string, success bool) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	for runtime, count := range p.prewarmConfig {
-		// Check how many containers already exist for this runtime
-		existing := 0
-		if containers, exists := p.warmContainers[runtime]; exists {
-			for _, pc := range containers {
-				if pc.InitializedAction == "" {
-					existing++
-				}
-			}
-		}
+	if pc, ok := p.busyContainers[containerID]; ok {
+		pc.Outcomes.Record(success)
+	}
+}
 
-		// Create additional containers if needed
-		needed := count - existing
-		for i := 0; i < needed; i++ {
-			container, err := p.manager.CreateContainer(ctx, runtime)
-			if err != nil {
-				return fmt.Errorf("failed to prewarm container for runtime %s: %w", runtime, err)
+// shouldQuarantine reports whether a container's recent failure ratio is
+// over the quarantine threshold
+// Must be called with lock held
+func (p *ContainerPool) shouldQuarantine(pc *PooledContainer) bool {
+	if p.quarantineRatio <= 0 || pc.Outcomes.count < minQuarantineSamples {
+		return false
+	}
+	return pc.Outcomes.FailureRatio() >= p.quarantineRatio
+}
+
+// RemoveContainersForAction removes warm containers initialized with the
+// action and marks busy ones for removal when they are returned
+// Returns the number of containers removed and marked
+func (p *ContainerPool) RemoveContainersForAction(ctx context.Context, namespace, name string) (int, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := ActionKey(namespace, name)
+	removed := 0
+	var firstErr error
+
+	for runtime, containers := range p.warmContainers {
+		remaining := make([]*PooledContainer, 0, len(containers))
+
+		for _, pc := range containers {
+			if pc.InitializedAction != key {
+				remaining = append(remaining, pc)
+				continue
 			}
 
-			pc := &PooledContainer{
-				Container:         container,
-				Runtime:           runtime,
-				State:             PoolStateWarm,
-				LastUsed:          time.Now(),
-				InitializedAction: "",
+			if err := p.manager.StopOrKill(ctx, pc.Container.ID, containerStopTimeout); err != nil && firstErr == nil {
+				firstErr = fmt.Errorf("failed to remove container %s: %w", pc.Container.ID, err)
 			}
+			p.countWarm(pc, -1)
+			removed++
+		}
+
+		p.warmContainers[runtime] = remaining
+	}
 
-			if p.warmContainers[runtime] == nil {
-				p.warmContainers[runtime] = make([]*PooledContainer, 0)
+	marked := 0
+	for _, pc := range p.busyContainers {
+		if pc.InitializedAction == key {
+			pc.RemoveOnReturn = true
+			marked++
+		}
+	}
+
+	if removed > 0 {
+		p.signalCapacity()
+	}
+
+	return removed, marked, firstErr
+}
+
+// PrewarmContainers creates prewarm containers according to configuration,
+// up to PrewarmParallelism at a time. Runtimes are prewarmed in random order
+// and, when PrewarmJitter is set, each worker spaces out its creations so
+// invokers booting together don't hit the registry and Docker daemon in
+// lockstep. A failed creation doesn't stop the others; failures are
+// returned together once the rest are done
+func (p *ContainerPool) PrewarmContainers(ctx context.Context) error {
+	p.mu.Lock()
+	runtimes := make([]string, 0, len(p.prewarmConfig))
+	for runtime := range p.prewarmConfig {
+		runtimes = append(runtimes, runtime)
+	}
+	rand.Shuffle(len(runtimes), func(i, j int) {
+		runtimes[i], runtimes[j] = runtimes[j], runtimes[i]
+	})
+
+	// One job per container still missing from each runtime's target
+	var jobs []string
+	for _, runtime := range runtimes {
+		existing := 0
+		for _, pc := range p.warmContainers[runtime] {
+			if pc.InitializedAction == "" {
+				existing++
 			}
-			p.warmContainers[runtime] = append(p.warmContainers[runtime], pc)
 		}
+		for i := existing; i < p.prewarmConfig[runtime]; i++ {
+			jobs = append(jobs, runtime)
+		}
+	}
+	p.mu.Unlock()
+
+	if len(jobs) == 0 {
+		return nil
 	}
 
+	workers := p.prewarmParallelism
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	queue := make(chan string, len(jobs))
+	for _, runtime := range jobs {
+		queue <- runtime
+	}
+	close(queue)
+
+	var (
+		mu       sync.Mutex
+		created  int
+		failures []error
+		wg       sync.WaitGroup
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			first := true
+			for runtime := range queue {
+				if !first {
+					if err := p.waitPrewarmJitter(ctx, rand.Float64()); err != nil {
+						return
+					}
+				}
+				first = false
+
+				err := p.prewarmOne(ctx, runtime)
+
+				mu.Lock()
+				if err != nil {
+					failures = append(failures, fmt.Errorf("runtime %s: %w", runtime, err))
+					p.logger.Error("failed to prewarm container",
+						zap.String("runtime", runtime),
+						zap.Error(err))
+				} else {
+					created++
+					p.logger.Info("prewarmed container",
+						zap.String("runtime", runtime),
+						zap.Int("created", created),
+						zap.Int("total", len(jobs)))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if err := ctx.Err(); err != nil {
+		failures = append(failures, fmt.Errorf("prewarm interrupted: %w", err))
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("prewarmed %d of %d containers: %w", created, len(jobs), errors.Join(failures...))
+	}
 	return nil
 }
 
-// ScalePool increases or decreases prewarm containers for a runtime
-func (p *ContainerPool) ScalePool(ctx context.Context, runtime string, delta int) error {
+// prewarmOne creates a container for runtime and adds it to the warm pool,
+// unless the pool is at MaxTotalContainers. The lock is only taken to claim
+// a slot and add the container, so creations run in parallel
+func (p *ContainerPool) prewarmOne(ctx context.Context, runtime string) error {
+	p.mu.Lock()
+	if !p.reserveSlot() {
+		p.mu.Unlock()
+		return ErrContainerLimit
+	}
+	p.mu.Unlock()
+
+	container, err := p.startContainer(ctx, runtime, 0, "")
+
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.releaseSlot()
+	if err != nil {
+		return err
+	}
 
+	p.addWarm(&PooledContainer{
+		Container:         container,
+		Runtime:           runtime,
+		State:             PoolStateWarm,
+		LastUsed:          time.Now(),
+		InitializedAction: "",
+	})
+	return nil
+}
+
+// waitPrewarmJitter sleeps for prewarmDelay of the configured jitter
+func (p *ContainerPool) waitPrewarmJitter(ctx context.Context, r float64) error {
+	if p.prewarmJitter <= 0 {
+		return nil
+	}
+	return p.sleep(ctx, prewarmDelay(p.prewarmJitter, r))
+}
+
+// prewarmDelay spreads the delay between prewarm creations uniformly over
+// [jitter, 2*jitter) using r in [0, 1)
+func prewarmDelay(jitter time.Duration, r float64) time.Duration {
+	return jitter + time.Duration(float64(jitter)*r)
+}
+
+// sleepContext sleeps for d or until ctx is done
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ScalePool increases or decreases prewarm containers for a runtime. New
+// containers are created outside the lock and within MaxTotalContainers
+func (p *ContainerPool) ScalePool(ctx context.Context, runtime string, delta int) error {
 	if delta > 0 {
 		// Add containers
 		for i := 0; i < delta; i++ {
-			container, err := p.manager.CreateContainer(ctx, runtime)
-			if err != nil {
+			if err := p.prewarmOne(ctx, runtime); err != nil {
 				return fmt.Errorf("failed to scale up pool: %w", err)
 			}
-
-			pc := &PooledContainer{
-				Container:         container,
-				Runtime:           runtime,
-				State:             PoolStateWarm,
-				LastUsed:          time.Now(),
-				InitializedAction: "",
-			}
-
-			if p.warmContainers[runtime] == nil {
-				p.warmContainers[runtime] = make([]*PooledContainer, 0)
-			}
-			p.warmContainers[runtime] = append(p.warmContainers[runtime], pc)
 		}
 
 		// Update prewarm config
+		p.mu.Lock()
 		p.prewarmConfig[runtime] = p.prewarmConfig[runtime] + delta
-	} else if delta < 0 {
+		p.mu.Unlock()
+		return nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if delta < 0 {
 		// Remove containers
 		toRemove := -delta
 		containers := p.warmContainers[runtime]
@@ -263,7 +967,7 @@ func (p *ContainerPool) ScalePool(ctx context.Context, runtime string, delta int
 			pc := containers[i]
 			if pc.State == PoolStateWarm && pc.InitializedAction == "" {
 				removeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
-				if err := p.manager.RemoveContainer(removeCtx, pc.Container.ID); err != nil {
+				if err := p.manager.StopOrKill(removeCtx, pc.Container.ID, containerStopTimeout); err != nil {
 					cancel()
 					return fmt.Errorf("failed to remove container: %w", err)
 				}
@@ -276,6 +980,7 @@ func (p *ContainerPool) ScalePool(ctx context.Context, runtime string, delta int
 		removed := 0
 		for _, pc := range containers {
 			if removed < toRemove && pc.State == PoolStateWarm && pc.InitializedAction == "" {
+				p.countWarm(pc, -1)
 				removed++
 			} else {
 				remaining = append(remaining, pc)
@@ -294,8 +999,9 @@ func (p *ContainerPool) ScalePool(ctx context.Context, runtime string, delta int
 	return nil
 }
 
-// CleanupIdleContainers removes containers idle longer than maxIdle
-func (p *ContainerPool) CleanupIdleContainers(maxIdle time.Duration) error {
+// CleanupIdleContainers removes containers idle longer than maxIdle, keeping
+// each runtime's minimum warm floor. Returns how many were removed per runtime
+func (p *ContainerPool) CleanupIdleContainers(maxIdle time.Duration) (map[string]int, error) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
@@ -303,16 +1009,31 @@ func (p *ContainerPool) CleanupIdleContainers(maxIdle time.Duration) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
+	removed := 0
+	removedByRuntime := make(map[string]int)
 	for runtime, containers := range p.warmContainers {
 		remaining := make([]*PooledContainer, 0)
 
+		// Never evict uninitialized containers below the runtime's minimum
+		// warm floor
+		spare := p.spareUninitialized(runtime)
+
 		for _, pc := range containers {
-			if pc.State == PoolStateWarm && now.Sub(pc.LastUsed) > maxIdle {
+			if (pc.InitializedAction != "" || spare > 0) && pc.State == PoolStateWarm && now.Sub(pc.LastUsed) > maxIdle {
+				if pc.InitializedAction == "" {
+					spare--
+				}
+
 				// Remove idle container
-				if err := p.manager.RemoveContainer(ctx, pc.Container.ID); err != nil {
+				if err := p.manager.StopOrKill(ctx, pc.Container.ID, containerStopTimeout); err != nil {
 					// Log error but continue cleanup
-					fmt.Printf("Failed to remove idle container %s: %v\n", pc.Container.ID, err)
+					p.logger.Error("failed to remove idle container",
+						zap.String("id", pc.Container.ID),
+						zap.Error(err))
 				}
+				p.countWarm(pc, -1)
+				removed++
+				removedByRuntime[runtime]++
 			} else {
 				remaining = append(remaining, pc)
 			}
@@ -321,49 +1042,268 @@ func (p *ContainerPool) CleanupIdleContainers(maxIdle time.Duration) error {
 		p.warmContainers[runtime] = remaining
 	}
 
-	return nil
+	if removed > 0 {
+		p.signalCapacity()
+	}
+
+	p.reapFailedContainers(ctx, now)
+
+	return removedByRuntime, nil
 }
 
-// GetPoolStats returns statistics about the pool
-func (p *ContainerPool) GetPoolStats() PoolStats {
+// restartCheck is the Docker state of a warm container found by
+// recheckRestartedContainers
+type restartCheck struct {
+	pc       *PooledContainer
+	known    int  // restarts the pool knew of when the check started
+	checked  bool // false if Docker couldn't be asked
+	running  bool
+	restarts int
+	ip       string // refreshed IP of a restarted container, if known
+}
+
+// recheckRestartedContainers catches warm containers Docker restarted after a
+// crash. A restarted runtime has lost its initialized action and may have a
+// new IP, so the IP is refreshed and the container re-initialized on its next
+// checkout. Containers no longer running have used up their restarts and are
+// removed. Docker is queried outside the lock; containers checked out in the
+// meantime are left to the next pass
+func (p *ContainerPool) recheckRestartedContainers(ctx context.Context) {
 	p.mu.RLock()
-	defer p.mu.RUnlock()
+	var checks []restartCheck
+	for _, containers := range p.warmContainers {
+		for _, pc := range containers {
+			checks = append(checks, restartCheck{pc: pc, known: pc.Container.Restarts})
+		}
+	}
+	p.mu.RUnlock()
+
+	for i := range checks {
+		check := &checks[i]
+		id := check.pc.Container.ID
+		running, restarts, err := p.manager.RestartState(ctx, id)
+		if err != nil {
+			p.logger.Error("failed to check warm container",
+				zap.String("id", id),
+				zap.Error(err))
+			continue
+		}
+		check.checked, check.running, check.restarts = true, running, restarts
 
-	stats := PoolStats{
-		WarmContainers:    make(map[string]int),
-		BusyContainers:    len(p.busyContainers),
-		PrewarmContainers: make(map[string]int),
-		TotalContainers:   len(p.busyContainers),
+		if running && restarts > check.known {
+			if ip, err := p.manager.GetContainerIP(ctx, id); err == nil {
+				check.ip = ip
+			}
+		}
 	}
 
-	for runtime, containers := range p.warmContainers {
-		warmCount := 0
-		prewarmCount := 0
+	p.mu.Lock()
+	var stopped []*PooledContainer
+	for _, check := range checks {
+		pc := check.pc
+		i := indexOf(p.warmContainers[pc.Runtime], pc)
+		if !check.checked || i < 0 {
+			continue
+		}
 
-		for _, pc := range containers {
-			warmCount++
-			if pc.InitializedAction == "" {
-				prewarmCount++
+		if !check.running {
+			p.logger.Warn("removing warm container that stopped after restarts",
+				zap.String("id", pc.Container.ID),
+				zap.Int("restarts", check.restarts))
+			containers := p.warmContainers[pc.Runtime]
+			p.warmContainers[pc.Runtime] = append(containers[:i], containers[i+1:]...)
+			p.countWarm(pc, -1)
+			p.removing++
+			stopped = append(stopped, pc)
+			continue
+		}
+
+		if check.restarts > pc.Container.Restarts {
+			p.logger.Info("warm container was restarted, re-initializing on next use",
+				zap.String("id", pc.Container.ID))
+			pc.Container.Restarts = check.restarts
+			if check.ip != "" {
+				pc.Container.IP = check.ip
 			}
+			p.countWarm(pc, -1)
+			pc.InitializedAction = ""
+			pc.CodeHash = ""
+			p.countWarm(pc, 1)
 		}
+	}
+	p.mu.Unlock()
+
+	for _, pc := range stopped {
+		if err := p.removeDetached(pc); err != nil {
+			p.logger.Error("failed to remove stopped container",
+				zap.String("id", pc.Container.ID),
+				zap.Error(err))
+		}
+	}
+}
+
+// spareUninitialized returns how many uninitialized warm containers a
+// runtime has above its minimum warm floor, negative when below it
+// Must be called with lock held
+func (p *ContainerPool) spareUninitialized(runtime string) int {
+	uninitialized := 0
+	for _, pc := range p.warmContainers[runtime] {
+		if pc.InitializedAction == "" {
+			uninitialized++
+		}
+	}
+	return uninitialized - p.minWarm[runtime]
+}
+
+// indexOf returns the position of pc in containers, or -1
+func indexOf(containers []*PooledContainer, pc *PooledContainer) int {
+	for i, candidate := range containers {
+		if candidate == pc {
+			return i
+		}
+	}
+	return -1
+}
+
+// EnsureMinWarm creates warm containers for any runtime below its minimum
+// warm floor, so the pool heals back after failures or removals. Only
+// uninitialized containers count toward the floor, since those initialized
+// for an action need a re-init to serve another. Containers are started
+// outside the lock and never beyond MaxTotalContainers
+func (p *ContainerPool) EnsureMinWarm(ctx context.Context) error {
+	p.mu.RLock()
+	missing := make(map[string]int, len(p.minWarm))
+	for runtime := range p.minWarm {
+		if needed := -p.spareUninitialized(runtime); needed > 0 {
+			missing[runtime] = needed
+		}
+	}
+	p.mu.RUnlock()
 
+	for runtime, needed := range missing {
+		for i := 0; i < needed; i++ {
+			if err := p.prewarmOne(ctx, runtime); err != nil {
+				return fmt.Errorf("failed to restore min warm container for runtime %s: %w", runtime, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// retainFailedContainer labels a failed container detached from the pool
+// and keeps it around until the failed retention period elapses
+// Must be called without lock held
+func (p *ContainerPool) retainFailedContainer(pc *PooledContainer) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if err := p.manager.MarkContainerFailed(ctx, pc.Container.ID); err != nil {
+		// Fall back to removal so the container doesn't leak untracked
+		p.logger.Error("failed to mark container as failed",
+			zap.String("id", pc.Container.ID),
+			zap.Error(err))
+		return p.removeDetached(pc)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.removing--
+	pc.State = PoolStateFailed
+	pc.LastUsed = time.Now()
+	p.failedContainers[pc.Container.ID] = pc
+	p.signalCapacity()
+
+	return nil
+}
+
+// reapFailedContainers removes retained failed containers past their retention
+// Must be called with lock held
+func (p *ContainerPool) reapFailedContainers(ctx context.Context, now time.Time) {
+	for id, pc := range p.failedContainers {
+		if now.Sub(pc.LastUsed) <= p.failedRetention {
+			continue
+		}
+
+		if err := p.manager.StopOrKill(ctx, id, containerStopTimeout); err != nil {
+			// Log error and retry on the next cleanup pass
+			p.logger.Error("failed to remove failed container",
+				zap.String("id", id),
+				zap.Error(err))
+			continue
+		}
+		delete(p.failedContainers, id)
+	}
+}
+
+// GetPoolStats returns statistics about the pool from the incrementally
+// maintained counters, without taking the pool lock
+func (p *ContainerPool) GetPoolStats() PoolStats {
+	p.counters.mu.Lock()
+	defer p.counters.mu.Unlock()
+
+	stats := PoolStats{
+		WarmContainers:    make(map[string]int, len(p.counters.warm)),
+		BusyContainers:    p.counters.busy,
+		PrewarmContainers: make(map[string]int, len(p.counters.prewarm)),
+		TotalContainers:   p.counters.busy,
+	}
+
+	for runtime, warmCount := range p.counters.warm {
 		stats.WarmContainers[runtime] = warmCount
-		stats.PrewarmContainers[runtime] = prewarmCount
+		stats.PrewarmContainers[runtime] = p.counters.prewarm[runtime]
 		stats.TotalContainers += warmCount
 	}
 
 	return stats
 }
 
-// removeOldestContainer removes the oldest container from the pool
+// ListByRuntime returns snapshots of the warm and busy containers for a runtime
+func (p *ContainerPool) ListByRuntime(runtime string) []PooledContainerInfo {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	infos := make([]PooledContainerInfo, 0, len(p.warmContainers[runtime]))
+	for _, pc := range p.warmContainers[runtime] {
+		infos = append(infos, pc.info())
+	}
+	for _, pc := range p.busyContainers {
+		if pc.Runtime == runtime {
+			infos = append(infos, pc.info())
+		}
+	}
+
+	return infos
+}
+
+// info copies the container's externally visible fields
+func (pc *PooledContainer) info() PooledContainerInfo {
+	return PooledContainerInfo{
+		ContainerID:       pc.Container.ID,
+		Runtime:           pc.Runtime,
+		State:             pc.State,
+		LastUsed:          pc.LastUsed,
+		InitializedAction: pc.InitializedAction,
+	}
+}
+
+// detachOldestContainer takes the least recently used warm container out of
+// the pool for removeDetached, returning nil if there is none. Uninitialized
+// containers are kept when their runtime is at its minimum warm floor. The
+// container stays counted against MaxTotalContainers until it is removed
 // Must be called with lock held
-func (p *ContainerPool) removeOldestContainer() error {
+func (p *ContainerPool) detachOldestContainer() *PooledContainer {
 	var oldestPC *PooledContainer
 	var oldestRuntime string
 	var oldestIndex int
 
 	for runtime, containers := range p.warmContainers {
+		atFloor := p.spareUninitialized(runtime) <= 0
 		for i, pc := range containers {
+			if pc.InitializedAction == "" && atFloor {
+				continue
+			}
 			if oldestPC == nil || pc.LastUsed.Before(oldestPC.LastUsed) {
 				oldestPC = pc
 				oldestRuntime = runtime
@@ -373,38 +1313,131 @@ func (p *ContainerPool) removeOldestContainer() error {
 	}
 
 	if oldestPC == nil {
-		return fmt.Errorf("no containers to remove")
+		return nil
 	}
 
-	// Remove from pool
 	containers := p.warmContainers[oldestRuntime]
 	p.warmContainers[oldestRuntime] = append(containers[:oldestIndex], containers[oldestIndex+1:]...)
+	p.countWarm(oldestPC, -1)
+	p.removing++
+
+	return oldestPC
+}
 
-	// Remove container
+// removeDetached stops and removes a container detached from the pool, then
+// frees its slot
+// Must be called without lock held
+func (p *ContainerPool) removeDetached(pc *PooledContainer) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
-	return p.manager.RemoveContainer(ctx, oldestPC.Container.ID)
+	err := p.manager.StopOrKill(ctx, pc.Container.ID, containerStopTimeout)
+
+	p.mu.Lock()
+	p.removing--
+	p.signalCapacity()
+	p.mu.Unlock()
+
+	return err
 }
 
 // cleanupLoop periodically cleans up idle containers
 func (p *ContainerPool) cleanupLoop() {
 	defer p.cleanupWg.Done()
 
-	ticker := time.NewTicker(p.cleanupInterval)
+	// Jitter each interval so invokers started together don't hit the Docker
+	// API in lockstep
+	timer := time.NewTimer(jitteredInterval(p.cleanupInterval, p.cleanupJitter, rand.Float64()))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			timer.Reset(jitteredInterval(p.cleanupInterval, p.cleanupJitter, rand.Float64()))
+			if _, err := p.CleanupIdleContainers(p.idleTimeout); err != nil {
+				p.logger.Error("cleanup failed", zap.Error(err))
+			}
+			if p.manager.restartPolicy == RestartPolicyOnFailure {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+				p.recheckRestartedContainers(ctx)
+				cancel()
+			}
+			if len(p.minWarm) > 0 {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+				if err := p.EnsureMinWarm(ctx); err != nil {
+					p.logger.Error("failed to restore min warm containers", zap.Error(err))
+				}
+				cancel()
+			}
+		case <-p.stopCleanup:
+			return
+		}
+	}
+}
+
+// jitteredInterval spreads base uniformly over [base*(1-jitter),
+// base*(1+jitter)] using r in [0, 1). Jitter is clamped to 0-1
+func jitteredInterval(base time.Duration, jitter, r float64) time.Duration {
+	if jitter <= 0 {
+		return base
+	}
+	if jitter > 1 {
+		jitter = 1
+	}
+	spread := float64(base) * jitter
+	interval := time.Duration(float64(base) - spread + 2*spread*r)
+	if interval <= 0 {
+		// A zero interval would busy-loop the timer
+		return time.Millisecond
+	}
+	return interval
+}
+
+// demandLoop periodically moves prewarm targets toward recent demand
+func (p *ContainerPool) demandLoop() {
+	defer p.cleanupWg.Done()
+
+	ticker := time.NewTicker(p.demandWindow)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			if err := p.CleanupIdleContainers(p.idleTimeout); err != nil {
-				fmt.Printf("Cleanup error: %v\n", err)
-			}
+			p.adjustPrewarmTargets(p.demand.Tick())
 		case <-p.stopCleanup:
 			return
 		}
 	}
 }
 
+// adjustPrewarmTargets scales each runtime toward its demand target, never
+// below the configured prewarm count or above the pool size
+func (p *ContainerPool) adjustPrewarmTargets(targets map[string]int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	for runtime, target := range targets {
+		p.mu.RLock()
+		base := p.basePrewarm[runtime]
+		current := p.prewarmConfig[runtime]
+		p.mu.RUnlock()
+
+		if target < base {
+			target = base
+		}
+		if p.maxPoolSize > 0 && target > p.maxPoolSize {
+			target = p.maxPoolSize
+		}
+
+		if delta := target - current; delta != 0 {
+			if err := p.ScalePool(ctx, runtime, delta); err != nil {
+				p.logger.Error("failed to scale pool",
+					zap.String("runtime", runtime),
+					zap.Error(err))
+			}
+		}
+	}
+}
+
 // Shutdown stops the pool and removes all containers
 func (p *ContainerPool) Shutdown(ctx context.Context) error {
 	// Stop cleanup goroutine
@@ -417,20 +1450,56 @@ func (p *ContainerPool) Shutdown(ctx context.Context) error {
 	// Remove all warm containers
 	for runtime, containers := range p.warmContainers {
 		for _, pc := range containers {
-			if err := p.manager.RemoveContainer(ctx, pc.Container.ID); err != nil {
-				fmt.Printf("Failed to remove container %s during shutdown: %v\n", pc.Container.ID, err)
-			}
+			p.shutdownRemove(ctx, pc.Container.ID)
+			p.countWarm(pc, -1)
 		}
 		delete(p.warmContainers, runtime)
 	}
 
 	// Remove all busy containers
 	for id, pc := range p.busyContainers {
-		if err := p.manager.RemoveContainer(ctx, pc.Container.ID); err != nil {
-			fmt.Printf("Failed to remove container %s during shutdown: %v\n", pc.Container.ID, err)
-		}
+		p.shutdownRemove(ctx, pc.Container.ID)
 		delete(p.busyContainers, id)
+		p.countBusy(-1)
+	}
+
+	// Retained failed containers are left for inspection unless configured
+	// otherwise
+	for id := range p.failedContainers {
+		if p.removeFailed {
+			p.shutdownRemove(ctx, id)
+		} else {
+			p.logger.Info("leaving failed container for inspection", zap.String("id", id))
+		}
+		delete(p.failedContainers, id)
 	}
 
 	return nil
 }
+
+// shutdownRemove stops and removes a container during shutdown. Once ctx's
+// deadline has passed, containers are force-removed without waiting on a
+// graceful stop so a hung container can't hold up shutdown
+// Must be called with lock held
+func (p *ContainerPool) shutdownRemove(ctx context.Context, containerID string) {
+	if ctx.Err() == nil {
+		err := p.manager.StopOrKill(ctx, containerID, containerStopTimeout)
+		if err == nil {
+			return
+		}
+		if ctx.Err() == nil {
+			p.logger.Error("failed to remove container during shutdown",
+				zap.String("id", containerID),
+				zap.Error(err))
+			return
+		}
+	}
+
+	forceCtx, cancel := context.WithTimeout(context.Background(), forceRemoveTimeout)
+	defer cancel()
+	if err := p.manager.RemoveContainer(forceCtx, containerID, true); err != nil {
+		p.logger.Error("failed to force-remove container during shutdown",
+			zap.String("id", containerID),
+			zap.Error(err))
+	}
+}
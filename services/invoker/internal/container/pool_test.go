@@ -0,0 +1,1076 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// coldContainer checks out a new container for action, failing the test if
+// one can't be had
+func coldContainer(t *testing.T, pool *ContainerPool, runtime, action string) *PooledContainer {
+	t.Helper()
+	pc, _, err := pool.GetContainer(context.Background(), runtime, action, "hash-"+action, 0, "")
+	if err != nil {
+		t.Fatalf("GetContainer(%s) = %v", action, err)
+	}
+	return pc
+}
+
+func TestFailedContainerRetainedThenReaped(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{
+		KeepFailedContainers: true,
+		FailedRetention:      time.Minute,
+	})
+
+	pc := coldContainer(t, pool, "go:1.23", "ns/failing")
+	id := pc.Container.ID
+	if err := pool.ReturnContainer(id, false); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+	if fake.wasRemoved(id) || fake.renamed[id] == "" {
+		t.Fatalf("failed container was not retained and renamed")
+	}
+
+	// Nothing is reaped within the retention period
+	pool.mu.Lock()
+	pool.reapFailedContainers(context.Background(), time.Now())
+	pool.mu.Unlock()
+	if fake.wasRemoved(id) {
+		t.Fatal("failed container reaped before its retention elapsed")
+	}
+
+	pool.mu.Lock()
+	pool.reapFailedContainers(context.Background(), time.Now().Add(2*time.Minute))
+	pool.mu.Unlock()
+	if !fake.wasRemoved(id) {
+		t.Fatal("failed container not reaped after its retention elapsed")
+	}
+}
+
+func TestShutdownLeavesFailedContainers(t *testing.T) {
+	for _, removeFailed := range []bool{false, true} {
+		pool, fake := newTestPool(t, PoolConfig{
+			KeepFailedContainers:   true,
+			FailedRetention:        time.Hour,
+			RemoveFailedOnShutdown: removeFailed,
+		})
+
+		id := coldContainer(t, pool, "go:1.23", "ns/failing").Container.ID
+		if err := pool.ReturnContainer(id, false); err != nil {
+			t.Fatalf("ReturnContainer() = %v", err)
+		}
+		if err := pool.Shutdown(context.Background()); err != nil {
+			t.Fatalf("Shutdown() = %v", err)
+		}
+
+		if fake.wasRemoved(id) != removeFailed {
+			t.Fatalf("RemoveFailedOnShutdown=%v: failed container removed = %v", removeFailed, fake.wasRemoved(id))
+		}
+	}
+}
+
+func TestContainerCapBlocksUntilReturn(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{MaxTotalContainers: 2})
+
+	first := coldContainer(t, pool, "go:1.23", "ns/a")
+	coldContainer(t, pool, "go:1.23", "ns/b")
+
+	// At the cap, a get waits for capacity until its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, _, err := pool.GetContainer(ctx, "go:1.23", "ns/c", "hash-c", 0, ""); err == nil {
+		t.Fatal("GetContainer succeeded past the container cap")
+	}
+
+	// A blocked get goes through once a container is returned
+	got := make(chan error, 1)
+	go func() {
+		_, _, err := pool.GetContainer(context.Background(), "go:1.23", "ns/c", "hash-c", 0, "")
+		got <- err
+	}()
+	select {
+	case err := <-got:
+		t.Fatalf("GetContainer returned %v before a container was returned", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err := pool.ReturnContainer(first.Container.ID, false); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+	select {
+	case err := <-got:
+		if err != nil {
+			t.Fatalf("GetContainer() = %v after a return", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetContainer still blocked after a container was returned")
+	}
+	if live := fake.live(); live > 2 {
+		t.Fatalf("%d containers live, over the cap of 2", live)
+	}
+}
+
+func TestContainerCapCountsInFlightCreates(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{MaxTotalContainers: 2})
+	fake.createGate = make(chan struct{})
+
+	// Hold two creations in flight, then check every create path sees them
+	done := make(chan error, 2)
+	for _, action := range []string{"ns/a", "ns/b"} {
+		go func(action string) {
+			_, _, err := pool.GetContainer(context.Background(), "go:1.23", action, "hash-"+action, 0, "")
+			done <- err
+		}(action)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		pool.mu.RLock()
+		creating := pool.creating
+		pool.mu.RUnlock()
+		if creating == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d creations in flight, want 2", creating)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err := pool.prewarmOne(context.Background(), "go:1.23"); !errors.Is(err, ErrContainerLimit) {
+		t.Fatalf("prewarmOne() = %v, want ErrContainerLimit", err)
+	}
+	if err := pool.ScalePool(context.Background(), "go:1.23", 1); !errors.Is(err, ErrContainerLimit) {
+		t.Fatalf("ScalePool() = %v, want ErrContainerLimit", err)
+	}
+
+	close(fake.createGate)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("GetContainer() = %v", err)
+		}
+	}
+	if live := fake.live(); live != 2 {
+		t.Fatalf("%d containers live, want 2", live)
+	}
+}
+
+func TestCleanupKeepsMinWarmFloor(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{MinWarm: map[string]int{"go:1.23": 2}})
+	if err := pool.ScalePool(context.Background(), "go:1.23", 5); err != nil {
+		t.Fatalf("ScalePool() = %v", err)
+	}
+
+	// Every container is idle; only those above the floor go
+	removed, err := pool.CleanupIdleContainers(-time.Second)
+	if err != nil || removed["go:1.23"] != 3 {
+		t.Fatalf("CleanupIdleContainers() = %v, %v, want 3 removed", removed, err)
+	}
+	if warm := pool.GetPoolStats().WarmContainers["go:1.23"]; warm != 2 {
+		t.Fatalf("%d warm containers survived, want the floor of 2", warm)
+	}
+	if live := fake.live(); live != 2 {
+		t.Fatalf("%d containers live, want 2", live)
+	}
+}
+
+func TestEnsureMinWarmStaysWithinCap(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{
+		MaxTotalContainers: 3,
+		MinWarm:            map[string]int{"go:1.23": 2, "python:3.12": 2},
+	})
+
+	err := pool.EnsureMinWarm(context.Background())
+	if !errors.Is(err, ErrContainerLimit) {
+		t.Fatalf("EnsureMinWarm() = %v, want ErrContainerLimit once the cap is hit", err)
+	}
+	if live := fake.live(); live != 3 {
+		t.Fatalf("%d containers live, want the cap of 3", live)
+	}
+	for id := range fake.running {
+		if !fake.started[id] {
+			t.Fatalf("min warm container %s was never started", id)
+		}
+	}
+}
+
+func TestRemoveContainersForActionOnlyTouchesThatAction(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+
+	keepWarm := coldContainer(t, pool, "go:1.23", ActionKey("ns", "keep"))
+	dropWarm := coldContainer(t, pool, "go:1.23", ActionKey("ns", "drop"))
+	dropBusy := coldContainer(t, pool, "go:1.23", ActionKey("ns", "drop"))
+	for _, pc := range []*PooledContainer{keepWarm, dropWarm} {
+		if err := pool.ReturnContainer(pc.Container.ID, true); err != nil {
+			t.Fatalf("ReturnContainer() = %v", err)
+		}
+	}
+
+	removed, marked, err := pool.RemoveContainersForAction(context.Background(), "ns", "drop")
+	if err != nil || removed != 1 || marked != 1 {
+		t.Fatalf("RemoveContainersForAction() = %d, %d, %v, want 1 removed and 1 marked", removed, marked, err)
+	}
+	if !fake.wasRemoved(dropWarm.Container.ID) || fake.wasRemoved(keepWarm.Container.ID) {
+		t.Fatal("removed the wrong warm containers")
+	}
+
+	// The busy container goes when returned, even if marked reusable
+	if err := pool.ReturnContainer(dropBusy.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+	if !fake.wasRemoved(dropBusy.Container.ID) {
+		t.Fatal("busy container kept after its action was removed")
+	}
+
+	pc, timings, err := pool.GetContainer(context.Background(), "go:1.23", ActionKey("ns", "keep"), "hash-"+ActionKey("ns", "keep"), 0, "")
+	if err != nil || timings != nil || pc.Container.ID != keepWarm.Container.ID {
+		t.Fatalf("the other action lost its warm container")
+	}
+}
+
+func TestFlakyContainerQuarantinedOnReturn(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{QuarantineFailureRatio: 0.5})
+
+	// Too few outcomes to judge: the container goes back to the pool
+	pc := coldContainer(t, pool, "go:1.23", "ns/flaky")
+	pool.RecordOutcome(pc.Container.ID, false)
+	if err := pool.ReturnContainer(pc.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+	if fake.wasRemoved(pc.Container.ID) {
+		t.Fatal("container quarantined before enough outcomes were recorded")
+	}
+
+	pc, _, err := pool.GetContainer(context.Background(), "go:1.23", "ns/flaky", "hash-ns/flaky", 0, "")
+	if err != nil {
+		t.Fatalf("GetContainer() = %v", err)
+	}
+	for i := 0; i < minQuarantineSamples; i++ {
+		pool.RecordOutcome(pc.Container.ID, i%2 == 0)
+	}
+	if err := pool.ReturnContainer(pc.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+	if !fake.wasRemoved(pc.Container.ID) {
+		t.Fatal("container over the failure threshold was handed back to the pool")
+	}
+	if warm := pool.GetPoolStats().WarmContainers["go:1.23"]; warm != 0 {
+		t.Fatalf("%d warm containers, want the quarantined one gone", warm)
+	}
+}
+
+func TestHealthyContainerNotQuarantined(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{QuarantineFailureRatio: 0.5})
+
+	pc := coldContainer(t, pool, "go:1.23", "ns/healthy")
+	for i := 0; i < 2*minQuarantineSamples; i++ {
+		pool.RecordOutcome(pc.Container.ID, i%4 != 0)
+	}
+	if err := pool.ReturnContainer(pc.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+	if fake.wasRemoved(pc.Container.ID) {
+		t.Fatal("container under the failure threshold was quarantined")
+	}
+}
+
+// recountStats walks the pool to compute the stats GetPoolStats should report
+func recountStats(pool *ContainerPool) PoolStats {
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+
+	stats := PoolStats{
+		WarmContainers:    make(map[string]int),
+		BusyContainers:    len(pool.busyContainers),
+		PrewarmContainers: make(map[string]int),
+		TotalContainers:   len(pool.busyContainers),
+	}
+	for runtime, containers := range pool.warmContainers {
+		for _, pc := range containers {
+			stats.WarmContainers[runtime]++
+			if pc.InitializedAction == "" {
+				stats.PrewarmContainers[runtime]++
+			}
+			stats.TotalContainers++
+		}
+	}
+	return stats
+}
+
+// sameStats compares stats, treating a missing runtime as zero
+func sameStats(a, b PoolStats) bool {
+	return a.BusyContainers == b.BusyContainers &&
+		a.TotalContainers == b.TotalContainers &&
+		sameCounts(a.WarmContainers, b.WarmContainers) &&
+		sameCounts(a.PrewarmContainers, b.PrewarmContainers)
+}
+
+func sameCounts(a, b map[string]int) bool {
+	for runtime, n := range a {
+		if b[runtime] != n {
+			return false
+		}
+	}
+	for runtime, n := range b {
+		if a[runtime] != n {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPoolStatsMatchRecount(t *testing.T) {
+	pool, _ := newTestPool(t, PoolConfig{QuarantineFailureRatio: 0.5})
+	ctx := context.Background()
+
+	check := func(step string) {
+		t.Helper()
+		if got, want := pool.GetPoolStats(), recountStats(pool); !sameStats(got, want) {
+			t.Fatalf("after %s: GetPoolStats() = %+v, recount = %+v", step, got, want)
+		}
+	}
+
+	if err := pool.ScalePool(ctx, "go:1.23", 3); err != nil {
+		t.Fatalf("ScalePool() = %v", err)
+	}
+	if err := pool.ScalePool(ctx, "python:3.12", 2); err != nil {
+		t.Fatalf("ScalePool() = %v", err)
+	}
+	check("prewarming")
+
+	a := coldContainer(t, pool, "go:1.23", "ns/a")
+	b := coldContainer(t, pool, "go:1.23", "ns/b")
+	c := coldContainer(t, pool, "python:3.12", "ns/c")
+	check("checking out prewarmed containers")
+
+	if err := pool.ReturnContainer(a.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+	if err := pool.ReturnContainer(b.Container.ID, false); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+	check("returning containers")
+
+	for i := 0; i < minQuarantineSamples; i++ {
+		pool.RecordOutcome(c.Container.ID, false)
+	}
+	if err := pool.ReturnContainer(c.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+	check("quarantining a container")
+
+	if _, _, err := pool.RemoveContainersForAction(ctx, "ns", "a"); err != nil {
+		t.Fatalf("RemoveContainersForAction() = %v", err)
+	}
+	check("removing an action's containers")
+
+	if err := pool.ScalePool(ctx, "go:1.23", -1); err != nil {
+		t.Fatalf("ScalePool() = %v", err)
+	}
+	check("scaling down")
+
+	if _, err := pool.CleanupIdleContainers(-time.Second); err != nil {
+		t.Fatalf("CleanupIdleContainers() = %v", err)
+	}
+	check("cleaning up idle containers")
+}
+
+// coldStartRecorder records the cold starts a pool reports
+type coldStartRecorder chan int64
+
+func (r coldStartRecorder) ContainerColdStart(containerID, runtime string, durationMs int64) {
+	r <- durationMs
+}
+
+func TestColdStartStartsContainerAndReportsBreakdown(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+	fake.startDelay = 20 * time.Millisecond
+	observed := make(coldStartRecorder, 1)
+	pool.SetColdStartObserver(observed)
+
+	pc, timings, err := pool.GetContainer(context.Background(), "go:1.23", "ns/a", "hash-a", 0, "")
+	if err != nil {
+		t.Fatalf("GetContainer() = %v", err)
+	}
+	if !fake.started[pc.Container.ID] || pc.Container.IP == "" {
+		t.Fatal("cold container handed out without being started")
+	}
+	if timings == nil || timings.StartMs < 20 {
+		t.Fatalf("timings = %+v, want the start to take at least 20ms", timings)
+	}
+
+	select {
+	case total := <-observed:
+		if sum := timings.PullMs + timings.CreateMs + timings.StartMs; total != sum {
+			t.Fatalf("observer saw %dms, want pull+create+start = %dms", total, sum)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("cold start never reported")
+	}
+}
+
+func TestFailedStartRemovesContainer(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{MaxTotalContainers: 1})
+	fake.failStart = true
+
+	if _, _, err := pool.GetContainer(context.Background(), "go:1.23", "ns/a", "hash-a", 0, ""); err == nil {
+		t.Fatal("GetContainer succeeded with a container that failed to start")
+	}
+	if live := fake.live(); live != 0 {
+		t.Fatalf("%d containers live, want the unstarted one removed", live)
+	}
+
+	// The failed creation gave its slot back
+	fake.mu.Lock()
+	fake.failStart = false
+	fake.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, _, err := pool.GetContainer(ctx, "go:1.23", "ns/a", "hash-a", 0, ""); err != nil {
+		t.Fatalf("GetContainer() = %v after a failed start", err)
+	}
+}
+
+func TestWarmContainersReusedAcrossSmallerMemoryTiers(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+	ctx := context.Background()
+
+	pc, _, err := pool.GetContainer(ctx, "go:1.23", "ns/a", "hash-a", 256, "")
+	if err != nil {
+		t.Fatalf("GetContainer() = %v", err)
+	}
+	if mem := fake.memory[pc.Container.ID]; mem != 256*1024*1024 {
+		t.Fatalf("container created with %d bytes, want 256MB", mem)
+	}
+	if err := pool.ReturnContainer(pc.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+
+	// A smaller request fits in the warm container
+	small, timings, err := pool.GetContainer(ctx, "go:1.23", "ns/a", "hash-a", 128, "")
+	if err != nil || timings != nil || small.Container.ID != pc.Container.ID {
+		t.Fatalf("128MB request didn't reuse the warm 256MB container")
+	}
+	if err := pool.ReturnContainer(small.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+
+	// A larger one gets a container of its own size
+	large, timings, err := pool.GetContainer(ctx, "go:1.23", "ns/a", "hash-a", 512, "")
+	if err != nil {
+		t.Fatalf("GetContainer() = %v", err)
+	}
+	if timings == nil || large.Container.ID == pc.Container.ID {
+		t.Fatal("512MB request reused a 256MB container")
+	}
+	if mem := fake.memory[large.Container.ID]; mem != 512*1024*1024 {
+		t.Fatalf("container created with %d bytes, want 512MB", mem)
+	}
+}
+
+func TestNetworkModeAppliedAndKeptApartInPool(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+	ctx := context.Background()
+
+	isolated, _, err := pool.GetContainer(ctx, "go:1.23", "ns/a", "hash-a", 0, NetworkModeNone)
+	if err != nil {
+		t.Fatalf("GetContainer(none) = %v", err)
+	}
+	if mode := fake.networks[isolated.Container.ID]; mode != NetworkModeNone {
+		t.Fatalf("container created with network mode %q, want %q", mode, NetworkModeNone)
+	}
+	if isolated.Container.IP != "" {
+		t.Errorf("container without networking has IP %q", isolated.Container.IP)
+	}
+	if err := pool.ReturnContainer(isolated.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+
+	// The same action on the default network needs a container of its own
+	pc, timings, err := pool.GetContainer(ctx, "go:1.23", "ns/a", "hash-a", 0, "")
+	if err != nil {
+		t.Fatalf("GetContainer() = %v", err)
+	}
+	if timings == nil || pc.Container.ID == isolated.Container.ID {
+		t.Fatal("networked request reused a container without networking")
+	}
+	if err := pool.ReturnContainer(pc.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+
+	// while another isolated invocation reuses the warm one
+	again, timings, err := pool.GetContainer(ctx, "go:1.23", "ns/a", "hash-a", 0, NetworkModeNone)
+	if err != nil || timings != nil || again.Container.ID != isolated.Container.ID {
+		t.Fatalf("GetContainer(none) = %v, %v, want the warm isolated container", timings, err)
+	}
+}
+
+func TestShareByCodeHashAcrossNamespaces(t *testing.T) {
+	for _, share := range []bool{false, true} {
+		pool, _ := newTestPool(t, PoolConfig{ShareByCodeHash: share})
+		ctx := context.Background()
+
+		// Return the container with the shared code first, so without
+		// sharing the more recently used one is picked
+		same, _, err := pool.GetContainer(ctx, "go:1.23", ActionKey("ns1", "hello"), "hash-hello", 0, "")
+		if err != nil {
+			t.Fatalf("GetContainer() = %v", err)
+		}
+		other := coldContainer(t, pool, "go:1.23", ActionKey("ns1", "other"))
+		for _, pc := range []*PooledContainer{same, other} {
+			if err := pool.ReturnContainer(pc.Container.ID, true); err != nil {
+				t.Fatalf("ReturnContainer() = %v", err)
+			}
+		}
+
+		pc, timings, err := pool.GetContainer(ctx, "go:1.23", ActionKey("ns2", "hello"), "hash-hello", 0, "")
+		if err != nil || timings != nil {
+			t.Fatalf("GetContainer() = %v, %v, want a warm container", timings, err)
+		}
+		if got := pc.Container.ID == same.Container.ID; got != share {
+			t.Fatalf("ShareByCodeHash=%v: got the container with the same code = %v", share, got)
+		}
+		if !pc.NeedsInit || pc.InitializedAction != ActionKey("ns2", "hello") {
+			t.Fatalf("ShareByCodeHash=%v: container for another action handed out without re-init", share)
+		}
+	}
+}
+
+func TestStartedContainersVerifiedAgainstCreatedSpec(t *testing.T) {
+	for _, unenforced := range []bool{false, true} {
+		pool, fake := newTestPool(t, PoolConfig{})
+		pool.manager.verifyLimits = true
+		fake.unenforcedMemory = unenforced
+		core, logs := observer.New(zap.WarnLevel)
+		pool.logger = zap.New(core)
+
+		pc, _, err := pool.GetContainer(context.Background(), "go:1.23", "ns/a", "hash", 512, "")
+		if err != nil {
+			t.Fatalf("GetContainer() = %v", err)
+		}
+
+		warnings := logs.FilterMessage("container limits differ from requested").All()
+		if !unenforced {
+			if len(warnings) != 0 {
+				t.Errorf("limits reported as mismatched for a 512MB container: %v", warnings)
+			}
+			continue
+		}
+		if len(warnings) != 1 {
+			t.Fatalf("got %d limit warnings for an unenforced limit, want 1", len(warnings))
+		}
+		fields := warnings[0].ContextMap()
+		if fields["id"] != pc.Container.ID || !strings.Contains(fields["error"].(string), "requested 536870912") {
+			t.Errorf("limit warning fields = %v", fields)
+		}
+	}
+}
+
+func TestEvictionStopsContainerOutsideLock(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{MaxPoolSize: 1})
+
+	oldest := coldContainer(t, pool, "go:1.23", "ns/a")
+	newest := coldContainer(t, pool, "go:1.23", "ns/b")
+	if err := pool.ReturnContainer(oldest.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer(oldest) = %v", err)
+	}
+
+	// Returning the second container to the full pool evicts the first,
+	// whose stop hangs until the gate opens
+	fake.mu.Lock()
+	fake.stopGate = make(chan struct{})
+	fake.mu.Unlock()
+	returned := make(chan error, 1)
+	go func() {
+		returned <- pool.ReturnContainer(newest.Container.ID, true)
+	}()
+
+	listed := make(chan []PooledContainerInfo, 1)
+	go func() {
+		// Wait for the eviction to be underway before taking the lock
+		for {
+			pool.mu.RLock()
+			removing := pool.removing
+			pool.mu.RUnlock()
+			if removing > 0 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		listed <- pool.ListByRuntime("go:1.23")
+	}()
+
+	select {
+	case infos := <-listed:
+		if len(infos) != 1 || infos[0].ContainerID != newest.Container.ID {
+			t.Errorf("pool lists %v during eviction, want only the returned container", infos)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool lock held while the evicted container was stopped")
+	}
+
+	close(fake.stopGate)
+	if err := <-returned; err != nil {
+		t.Fatalf("ReturnContainer(newest) = %v", err)
+	}
+	if !fake.wasRemoved(oldest.Container.ID) {
+		t.Error("evicted container was not removed")
+	}
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	if pool.removing != 0 {
+		t.Errorf("%d containers still counted as removing", pool.removing)
+	}
+}
+
+func TestRecheckRestartedContainers(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+
+	var ids []string
+	for _, action := range []string{"ns/healthy", "ns/restarted", "ns/stopped"} {
+		pc := coldContainer(t, pool, "go:1.23", action)
+		ids = append(ids, pc.Container.ID)
+	}
+	for _, id := range ids {
+		if err := pool.ReturnContainer(id, true); err != nil {
+			t.Fatalf("ReturnContainer(%s) = %v", id, err)
+		}
+	}
+	healthy, restarted, stopped := ids[0], ids[1], ids[2]
+
+	fake.mu.Lock()
+	fake.restarts[restarted] = 1
+	fake.started[stopped] = false
+	fake.inspectGate = make(chan struct{})
+	fake.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		pool.recheckRestartedContainers(context.Background())
+		close(done)
+	}()
+
+	// Inspects hang on the gate, which must not keep the pool locked
+	time.Sleep(50 * time.Millisecond)
+	listed := make(chan int, 1)
+	go func() { listed <- len(pool.ListByRuntime("go:1.23")) }()
+	select {
+	case n := <-listed:
+		if n != 3 {
+			t.Errorf("pool lists %d containers during the recheck, want 3", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool lock held while warm containers were inspected")
+	}
+	close(fake.inspectGate)
+	<-done
+
+	if !fake.wasRemoved(stopped) {
+		t.Error("stopped container was not removed")
+	}
+	infos := make(map[string]PooledContainerInfo)
+	for _, info := range pool.ListByRuntime("go:1.23") {
+		infos[info.ContainerID] = info
+	}
+	if _, ok := infos[stopped]; ok || len(infos) != 2 {
+		t.Fatalf("warm containers after recheck = %v, want the healthy and restarted ones", infos)
+	}
+	if infos[healthy].InitializedAction != "ns/healthy" {
+		t.Errorf("healthy container initialized with %q, want ns/healthy", infos[healthy].InitializedAction)
+	}
+	if infos[restarted].InitializedAction != "" {
+		t.Errorf("restarted container still initialized with %q", infos[restarted].InitializedAction)
+	}
+	if stats := pool.GetPoolStats(); stats.WarmContainers["go:1.23"] != 2 || stats.PrewarmContainers["go:1.23"] != 1 {
+		t.Errorf("stats after recheck = %+v, want 2 warm with 1 uninitialized", stats)
+	}
+}
+
+func TestPinningDoesNotHoldPoolLock(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{
+		PinnedActions: map[string]int{"ns/pinned": 1},
+		HostCPUs:      4,
+	})
+
+	fake.mu.Lock()
+	fake.updateGate = make(chan struct{})
+	fake.mu.Unlock()
+
+	got := make(chan *PooledContainer, 1)
+	go func() {
+		pc, _, err := pool.GetContainer(context.Background(), "go:1.23", "ns/pinned", "hash", 0, "")
+		if err != nil {
+			t.Errorf("GetContainer() = %v", err)
+		}
+		got <- pc
+	}()
+
+	// Wait for the container to be checked out and its pin to hang
+	deadline := time.Now().Add(5 * time.Second)
+	for pool.GetPoolStats().BusyContainers == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("container never checked out")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	listed := make(chan int, 1)
+	go func() { listed <- len(pool.ListByRuntime("go:1.23")) }()
+	select {
+	case n := <-listed:
+		if n != 1 {
+			t.Errorf("pool lists %d containers while pinning, want 1", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pool lock held while the container was pinned")
+	}
+
+	close(fake.updateGate)
+	pc := <-got
+	if pc == nil {
+		return
+	}
+	if pc.CPUSet != "3" {
+		t.Errorf("container cpuset = %q, want 3", pc.CPUSet)
+	}
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if fake.cpusets[pc.Container.ID] != "3" {
+		t.Errorf("container updated to cpuset %q, want 3", fake.cpusets[pc.Container.ID])
+	}
+}
+
+func TestEnsureMinWarmCountsOnlyUninitialized(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{MinWarm: map[string]int{"go:1.23": 2}})
+
+	// Containers initialized for an action don't satisfy the floor
+	a := coldContainer(t, pool, "go:1.23", "ns/a")
+	b := coldContainer(t, pool, "go:1.23", "ns/b")
+	for _, pc := range []*PooledContainer{a, b} {
+		if err := pool.ReturnContainer(pc.Container.ID, true); err != nil {
+			t.Fatalf("ReturnContainer() = %v", err)
+		}
+	}
+
+	if err := pool.EnsureMinWarm(context.Background()); err != nil {
+		t.Fatalf("EnsureMinWarm() = %v", err)
+	}
+	stats := pool.GetPoolStats()
+	if stats.WarmContainers["go:1.23"] != 4 || stats.PrewarmContainers["go:1.23"] != 2 {
+		t.Fatalf("stats = %+v, want 4 warm of which 2 uninitialized", stats)
+	}
+
+	// A second pass finds the floor met
+	if err := pool.EnsureMinWarm(context.Background()); err != nil {
+		t.Fatalf("EnsureMinWarm() = %v", err)
+	}
+	if live := fake.live(); live != 4 {
+		t.Fatalf("%d containers live after a second pass, want 4", live)
+	}
+}
+
+func TestEvictionKeepsMinWarmFloor(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{
+		MaxTotalContainers: 2,
+		MinWarm:            map[string]int{"go:1.23": 1},
+	})
+
+	// The floor container is the oldest, so it would be evicted first
+	if err := pool.ScalePool(context.Background(), "go:1.23", 1); err != nil {
+		t.Fatalf("ScalePool() = %v", err)
+	}
+	floor := pool.ListByRuntime("go:1.23")[0].ContainerID
+	used := coldContainer(t, pool, "python:3.12", "ns/py")
+	if err := pool.ReturnContainer(used.Container.ID, true); err != nil {
+		t.Fatalf("ReturnContainer() = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, _, err := pool.GetContainer(ctx, "nodejs:20", "ns/js", "hash", 0, ""); err != nil {
+		t.Fatalf("GetContainer() = %v", err)
+	}
+
+	if fake.wasRemoved(floor) {
+		t.Error("container keeping the min warm floor was evicted")
+	}
+	if !fake.wasRemoved(used.Container.ID) {
+		t.Error("initialized container above the floor was not evicted")
+	}
+}
+
+// returnAll returns checked-out containers to the pool for reuse
+func returnAll(t *testing.T, pool *ContainerPool, containers ...*PooledContainer) {
+	t.Helper()
+	for _, pc := range containers {
+		if err := pool.ReturnContainer(pc.Container.ID, true); err != nil {
+			t.Fatalf("ReturnContainer(%s) = %v", pc.Container.ID, err)
+		}
+	}
+}
+
+func TestDemandTrackerRisesThenDecays(t *testing.T) {
+	d := NewDemandTracker(0.5)
+
+	var targets []int
+	for _, arrivals := range []int{4, 4, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0} {
+		for i := 0; i < arrivals; i++ {
+			d.Record("go:1.23")
+		}
+		targets = append(targets, d.Tick()["go:1.23"])
+	}
+
+	if !(targets[0] < targets[1] && targets[1] < targets[2]) {
+		t.Errorf("targets %v don't rise with sustained demand", targets)
+	}
+	for i := 3; i < len(targets); i++ {
+		if targets[i] > targets[i-1] {
+			t.Errorf("targets %v rise without demand", targets)
+			break
+		}
+	}
+	if last := targets[len(targets)-1]; last != 0 {
+		t.Errorf("target %d after demand stopped, want it decayed to 0", last)
+	}
+}
+
+func TestPredictiveWarmingScalesWithinBaseAndPoolSize(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{
+		MaxPoolSize:       4,
+		PrewarmConfig:     map[string]int{"go:1.23": 1},
+		PredictiveWarming: true,
+		DemandWindow:      time.Hour,
+	})
+	if err := pool.PrewarmContainers(context.Background()); err != nil {
+		t.Fatalf("PrewarmContainers() = %v", err)
+	}
+
+	pool.adjustPrewarmTargets(map[string]int{"go:1.23": 3})
+	if warm := pool.GetPoolStats().PrewarmContainers["go:1.23"]; warm != 3 {
+		t.Fatalf("%d prewarmed for a demand target of 3", warm)
+	}
+
+	pool.adjustPrewarmTargets(map[string]int{"go:1.23": 10})
+	if warm := pool.GetPoolStats().PrewarmContainers["go:1.23"]; warm != 4 {
+		t.Fatalf("%d prewarmed for a target over the pool size of 4", warm)
+	}
+
+	// Demand gone, the configured count stays
+	pool.adjustPrewarmTargets(map[string]int{"go:1.23": 0})
+	if warm := pool.GetPoolStats().PrewarmContainers["go:1.23"]; warm != 1 {
+		t.Fatalf("%d prewarmed once demand decayed, want the configured 1", warm)
+	}
+	if live := fake.live(); live != 1 {
+		t.Errorf("%d containers live, want the scaled down ones removed", live)
+	}
+}
+
+func TestChangedCodeForcesReinit(t *testing.T) {
+	pool, _ := newTestPool(t, PoolConfig{})
+	ctx := context.Background()
+
+	first := coldContainer(t, pool, "go:1.23", "ns/a")
+	returnAll(t, pool, first)
+
+	// Same action and version, but the code was redeployed
+	pc, timings, err := pool.GetContainer(ctx, "go:1.23", "ns/a", "hash-redeployed", 0, "")
+	if err != nil || timings != nil || pc.Container.ID != first.Container.ID {
+		t.Fatalf("GetContainer() = %v, %v, want the warm container", timings, err)
+	}
+	if !pc.NeedsInit || pc.CodeHash != "hash-redeployed" {
+		t.Fatalf("container with stale code reused without re-init (hash %s)", pc.CodeHash)
+	}
+	returnAll(t, pool, pc)
+
+	// Unchanged code is reused as is
+	pc, _, err = pool.GetContainer(ctx, "go:1.23", "ns/a", "hash-redeployed", 0, "")
+	if err != nil || pc.NeedsInit {
+		t.Fatalf("GetContainer() = %v, needs init %v, want the initialized container", err, pc.NeedsInit)
+	}
+}
+
+func TestActionSwitchForcesReinit(t *testing.T) {
+	pool, _ := newTestPool(t, PoolConfig{})
+
+	first := coldContainer(t, pool, "go:1.23", "ns/a")
+	returnAll(t, pool, first)
+
+	pc, timings, err := pool.GetContainer(context.Background(), "go:1.23", "ns/b", "hash-ns/b", 0, "")
+	if err != nil || timings != nil || pc.Container.ID != first.Container.ID {
+		t.Fatalf("GetContainer() = %v, %v, want the warm container", timings, err)
+	}
+	if !pc.NeedsInit || pc.InitializedAction != "ns/b" {
+		t.Fatalf("container for ns/a handed to ns/b without re-init")
+	}
+}
+
+func TestListByRuntime(t *testing.T) {
+	pool, _ := newTestPool(t, PoolConfig{})
+
+	warm := coldContainer(t, pool, "go:1.23", "ns/warm")
+	busy := coldContainer(t, pool, "go:1.23", "ns/busy")
+	coldContainer(t, pool, "python:3.12", "ns/py")
+	returnAll(t, pool, warm)
+
+	infos := pool.ListByRuntime("go:1.23")
+	got := make(map[string]PooledContainerInfo, len(infos))
+	for _, info := range infos {
+		got[info.ContainerID] = info
+	}
+	want := map[string]PooledContainerInfo{
+		warm.Container.ID: {ContainerID: warm.Container.ID, Runtime: "go:1.23", State: PoolStateWarm, InitializedAction: "ns/warm"},
+		busy.Container.ID: {ContainerID: busy.Container.ID, Runtime: "go:1.23", State: PoolStateBusy, InitializedAction: "ns/busy"},
+	}
+	for id, info := range got {
+		if info.LastUsed.IsZero() {
+			t.Errorf("%s listed without its last use", id)
+		}
+		info.LastUsed = time.Time{}
+		got[id] = info
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListByRuntime() = %+v, want %+v", got, want)
+	}
+
+	// Listings are copies
+	infos[0].State = PoolStateFailed
+	if pool.ListByRuntime("go:1.23")[0].State == PoolStateFailed {
+		t.Error("listing shares state with the pool")
+	}
+}
+
+func TestCleanupIntervalJitter(t *testing.T) {
+	base := time.Minute
+	seen := make(map[time.Duration]bool)
+	for _, r := range []float64{0, 0.25, 0.5, 0.75, 0.999} {
+		interval := jitteredInterval(base, 0.2, r)
+		if interval < 48*time.Second || interval > 72*time.Second {
+			t.Errorf("jitteredInterval(r=%v) = %v, want within 20%% of %v", r, interval, base)
+		}
+		seen[interval] = true
+	}
+	if len(seen) != 5 {
+		t.Errorf("successive intervals %v don't vary", seen)
+	}
+
+	if interval := jitteredInterval(base, 0, 0.9); interval != base {
+		t.Errorf("interval without jitter = %v, want %v", interval, base)
+	}
+	// Jitter is clamped, and never yields a zero interval
+	if interval := jitteredInterval(base, 5, 0); interval != time.Millisecond {
+		t.Errorf("interval with clamped jitter = %v, want 1ms", interval)
+	}
+}
+
+func TestShutdownForceRemovesAfterDrainDeadline(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+	returnAll(t, pool, coldContainer(t, pool, "go:1.23", "ns/a"), coldContainer(t, pool, "go:1.23", "ns/b"))
+
+	// Both containers hang on stop
+	fake.mu.Lock()
+	fake.stopGate = make(chan struct{})
+	fake.mu.Unlock()
+	t.Cleanup(func() { close(fake.stopGate) })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := pool.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Shutdown() took %v with a 300ms drain deadline", elapsed)
+	}
+	if live := fake.live(); live != 0 {
+		t.Errorf("%d containers left after shutdown, want them force-removed", live)
+	}
+}
+
+func TestActionStartMetrics(t *testing.T) {
+	pool, _ := newTestPool(t, PoolConfig{ActionMetrics: []string{"ns/tracked"}})
+	ctx := context.Background()
+
+	counts := func() [4]float64 {
+		return [4]float64{
+			testutil.ToFloat64(actionStarts.WithLabelValues("ns/tracked", "cold")),
+			testutil.ToFloat64(actionStarts.WithLabelValues("ns/tracked", "warm")),
+			testutil.ToFloat64(actionStarts.WithLabelValues(otherActions, "cold")),
+			testutil.ToFloat64(actionStarts.WithLabelValues(otherActions, "warm")),
+		}
+	}
+	before := counts()
+
+	tracked := coldContainer(t, pool, "go:1.23", "ns/tracked")
+	returnAll(t, pool, tracked)
+	if _, _, err := pool.GetContainer(ctx, "go:1.23", "ns/tracked", "hash-ns/tracked", 0, ""); err != nil {
+		t.Fatalf("GetContainer() = %v", err)
+	}
+	// Actions off the allowlist share one label
+	coldContainer(t, pool, "go:1.23", "ns/untracked")
+
+	after := counts()
+	var delta [4]float64
+	for i := range after {
+		delta[i] = after[i] - before[i]
+	}
+	if delta != [4]float64{1, 1, 1, 0} {
+		t.Errorf("starts counted as tracked cold/warm, other cold/warm = %v, want [1 1 1 0]", delta)
+	}
+}
+
+func TestPrewarmBoundedAndToleratesFailures(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{
+		PrewarmConfig:      map[string]int{"go:1.23": 6},
+		PrewarmParallelism: 2,
+	})
+	fake.callDelay = 20 * time.Millisecond
+	fake.failCreates = 1
+
+	err := pool.PrewarmContainers(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "prewarmed 5 of 6") {
+		t.Fatalf("PrewarmContainers() = %v, want the one failure reported", err)
+	}
+	if warm := pool.GetPoolStats().PrewarmContainers["go:1.23"]; warm != 5 {
+		t.Errorf("%d containers prewarmed, want the other 5", warm)
+	}
+	if peak := fake.peakCalls("create"); peak != 2 {
+		t.Errorf("%d creates ran at once, want the parallelism of 2", peak)
+	}
+
+	// A second pass fills in the failed one
+	if err := pool.PrewarmContainers(context.Background()); err != nil {
+		t.Fatalf("PrewarmContainers() = %v", err)
+	}
+	if warm := pool.GetPoolStats().PrewarmContainers["go:1.23"]; warm != 6 {
+		t.Errorf("%d containers prewarmed, want 6", warm)
+	}
+}
+
+func TestCleanupIdleContainersReportsRemovalsPerRuntime(t *testing.T) {
+	pool, fake := newTestPool(t, PoolConfig{})
+
+	goA := coldContainer(t, pool, "go:1.23", "ns/a")
+	goB := coldContainer(t, pool, "go:1.23", "ns/b")
+	py := coldContainer(t, pool, "python:3.12", "ns/py")
+	busy := coldContainer(t, pool, "go:1.23", "ns/busy")
+	returnAll(t, pool, goA, goB, py)
+
+	removed, err := pool.CleanupIdleContainers(0)
+	if err != nil {
+		t.Fatalf("CleanupIdleContainers() = %v", err)
+	}
+	if want := map[string]int{"go:1.23": 2, "python:3.12": 1}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("CleanupIdleContainers() = %v, want %v", removed, want)
+	}
+	if live := fake.live(); live != 1 || fake.wasRemoved(busy.Container.ID) {
+		t.Errorf("%d containers live, want only the busy one kept", live)
+	}
+}
@@ -0,0 +1,58 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPrewarmDelayStaysWithinJitter(t *testing.T) {
+	jitter := 200 * time.Millisecond
+	for _, r := range []float64{0, 0.25, 0.5, 0.999} {
+		delay := prewarmDelay(jitter, r)
+		if delay < jitter || delay >= 2*jitter {
+			t.Fatalf("prewarmDelay(%v, %v) = %v, want within [%v, %v)", jitter, r, delay, jitter, 2*jitter)
+		}
+	}
+}
+
+func TestWaitPrewarmJitterUsesSleeper(t *testing.T) {
+	var slept []time.Duration
+	p := &ContainerPool{
+		prewarmJitter: time.Second,
+		sleep: func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			return nil
+		},
+	}
+
+	if err := p.waitPrewarmJitter(context.Background(), 0.5); err != nil {
+		t.Fatalf("waitPrewarmJitter() = %v", err)
+	}
+	if len(slept) != 1 || slept[0] != 1500*time.Millisecond {
+		t.Fatalf("slept %v, want [1.5s]", slept)
+	}
+}
+
+func TestWaitPrewarmJitterDisabled(t *testing.T) {
+	p := &ContainerPool{
+		sleep: func(ctx context.Context, d time.Duration) error {
+			t.Fatalf("slept %v with jitter disabled", d)
+			return nil
+		},
+	}
+
+	if err := p.waitPrewarmJitter(context.Background(), 0.5); err != nil {
+		t.Fatalf("waitPrewarmJitter() = %v", err)
+	}
+}
+
+func TestSleepContextStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := sleepContext(ctx, time.Hour); !errors.Is(err, context.Canceled) {
+		t.Fatalf("sleepContext() = %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,95 @@
+package executor
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/messaging"
+)
+
+// maxCachedResults bounds the cache; expired entries are swept past it
+const maxCachedResults = 10000
+
+// cachedResult is a memoized successful action result
+type cachedResult struct {
+	result  map[string]interface{}
+	expires time.Time
+}
+
+// resultCache memoizes results of cacheable actions by action, version, code
+// and params
+type resultCache struct {
+	mu      sync.Mutex
+	entries map[string]cachedResult
+}
+
+func newResultCache() *resultCache {
+	return &resultCache{
+		entries: make(map[string]cachedResult),
+	}
+}
+
+// cacheKey hashes the action identity, the hash of its code and the params
+// it runs with: its bound params overridden by the invocation's, as the
+// runtime merges them. Map keys marshal in sorted order so equal params
+// produce equal keys
+func cacheKey(action messaging.ActionSpec, params map[string]interface{}, codeHash string) (string, error) {
+	merged := make(map[string]interface{}, len(action.Parameters)+len(params))
+	for key, value := range action.Parameters {
+		merged[key] = value
+	}
+	for key, value := range params {
+		merged[key] = value
+	}
+	paramsJSON, err := json.Marshal(merged)
+	if err != nil {
+		return "", fmt.Errorf("marshal params: %w", err)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(codeHash))
+	h.Write([]byte{0})
+	h.Write(paramsJSON)
+	return fmt.Sprintf("%s/%s@%s:%x", action.Namespace, action.Name, action.Version, h.Sum(nil)), nil
+}
+
+// get returns the cached result for key if it hasn't expired
+func (c *resultCache) get(key string, now time.Time) (map[string]interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(entry.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return entry.result, true
+}
+
+// put stores a result for ttl
+func (c *resultCache) put(key string, result map[string]interface{}, ttl time.Duration, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.entries) >= maxCachedResults {
+		for k, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCachedResults {
+			return
+		}
+	}
+
+	c.entries[key] = cachedResult{
+		result:  result,
+		expires: now.Add(ttl),
+	}
+}
@@ -0,0 +1,74 @@
+package executor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/container"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/messaging"
+)
+
+func TestCacheableActionServedFromCache(t *testing.T) {
+	e, _, rt := newTestExecutor(t, container.PoolConfig{})
+	invocation := func(id string) *messaging.InvocationMessage {
+		msg := testInvocation(id)
+		msg.Action.Cacheable = true
+		msg.Action.CacheTTL = 60
+		msg.Action.Parameters = map[string]any{"greeting": "hello"}
+		return msg
+	}
+	cached := func(result *messaging.ActivationResult) bool {
+		for _, annotation := range result.Annotations {
+			if annotation.Key == "cached" {
+				return annotation.Value == true
+			}
+		}
+		return false
+	}
+
+	// A miss runs the action, the same invocation again is a hit
+	for i, id := range []string{"act-miss", "act-hit"} {
+		result, err := e.HandleInvocation(context.Background(), invocation(id))
+		if err != nil || !result.Response.Success || result.Response.Result["n"] != 1.0 {
+			t.Fatalf("HandleInvocation(%s) = %+v, %v", id, result, err)
+		}
+		if got := cached(result); got != (i == 1) {
+			t.Fatalf("%s: cached = %v", id, got)
+		}
+	}
+	if runs := rt.runCount(); runs != 1 {
+		t.Fatalf("%d runs for two identical invocations, want 1", runs)
+	}
+
+	// Changing the bound params or redeploying the code misses
+	changes := map[string]func(*messaging.InvocationMessage){
+		"bound params": func(msg *messaging.InvocationMessage) { msg.Action.Parameters["greeting"] = "hi" },
+		"code":         func(msg *messaging.InvocationMessage) { msg.Action.Exec.Code = "package main // v2" },
+	}
+	for name, change := range changes {
+		before := rt.runCount()
+		msg := invocation("act-" + name)
+		change(msg)
+		result, err := e.HandleInvocation(context.Background(), msg)
+		if err != nil || cached(result) || rt.runCount() != before+1 {
+			t.Errorf("changed %s served from cache: %+v, %v", name, result, err)
+		}
+	}
+}
+
+func TestResultCacheExpiry(t *testing.T) {
+	c := newResultCache()
+	now := time.Now()
+	c.put("key", map[string]interface{}{"ok": true}, time.Minute, now)
+
+	if result, ok := c.get("key", now.Add(30*time.Second)); !ok || result["ok"] != true {
+		t.Fatalf("get() within the TTL = %v, %v", result, ok)
+	}
+	if _, ok := c.get("key", now.Add(2*time.Minute)); ok {
+		t.Fatal("result served after its TTL")
+	}
+	if _, ok := c.get("key", now); ok {
+		t.Fatal("expired result kept after it was found expired")
+	}
+}
@@ -0,0 +1,27 @@
+package executor
+
+import "fmt"
+
+const (
+	// DefaultMaxEnvVars is the most env vars an action may set
+	DefaultMaxEnvVars = 1000
+	// DefaultMaxEnvBytes caps an action env's total size, well under the
+	// kernel's exec argument limit
+	DefaultMaxEnvBytes = 256 << 10
+)
+
+// validateEnv checks an action env against the count and size limits. Size
+// counts each var as it is passed to exec, KEY=value plus a terminator
+func validateEnv(env map[string]string, maxVars, maxBytes int) error {
+	if len(env) > maxVars {
+		return fmt.Errorf("action env has %d variables, exceeding the limit of %d", len(env), maxVars)
+	}
+	size := 0
+	for key, value := range env {
+		size += len(key) + len(value) + 2
+	}
+	if size > maxBytes {
+		return fmt.Errorf("action env is %d bytes, exceeding the limit of %d", size, maxBytes)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package executor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  map[string]string
+		want string
+	}{
+		{name: "within limits", env: map[string]string{"A": "1", "B": "2"}},
+		{name: "too many", env: map[string]string{"A": "1", "B": "2", "C": "3"}, want: "3 variables"},
+		// "BIG=" plus 20 bytes and a terminator
+		{name: "too big", env: map[string]string{"BIG": strings.Repeat("x", 20)}, want: "25 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEnv(tt.env, 2, 16)
+			if tt.want == "" {
+				if err != nil {
+					t.Fatalf("validateEnv() = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("validateEnv() = %v, want an error mentioning %q", err, tt.want)
+			}
+		})
+	}
+}
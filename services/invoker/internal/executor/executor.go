@@ -1,44 +1,109 @@
 package executor
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/penguintechinc/penguinwhisk/invoker/internal/container"
-	"github.com/penguintechinc/penguinwhisk/invoker/internal/logs"
 	"github.com/penguintechinc/penguinwhisk/invoker/internal/messaging"
-	"github.com/penguintechinc/penguinwhisk/invoker/internal/proxy"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/runtime"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/sizing"
+	"go.uber.org/zap"
 )
 
+// Activation status codes for invocations rejected before running
+const (
+	statusDeveloperError = 2
+	statusInternalError  = 3
+)
+
+// killTimeout bounds killing a container whose action timed out
+const killTimeout = 5 * time.Second
+
+// closePartialTimeout bounds closing a partial stream, which happens even
+// when the invocation's own deadline has passed
+const closePartialTimeout = 5 * time.Second
+
+// resultAnnotationsKey is the reserved result key actions return custom
+// activation annotations under
+const resultAnnotationsKey = "__ow_annotations"
+
+// DefaultLimitsAnnotation is the action parameter limits are read from when
+// the limits block leaves them unset
+const DefaultLimitsAnnotation = "limits"
+
+// runtimeClient is the part of the runtime proxy the executor drives
+// actions through
+type runtimeClient interface {
+	Init(ctx context.Context, containerIP string, initPayload *runtime.InitPayload) (*runtime.InitResult, error)
+	Run(ctx context.Context, containerIP string, runPayload *runtime.RunPayload) (*runtime.RunResult, error)
+	RunStream(ctx context.Context, containerIP string, runPayload *runtime.RunPayload, onChunk func(map[string]interface{}) error) (*runtime.RunResult, error)
+	ExecRun(ctx context.Context, containerID string, command []string, runPayload *runtime.RunPayload) (*runtime.RunResult, error)
+}
+
 // Executor handles invocation messages and executes actions in containers
 type Executor struct {
 	pool       *container.ContainerPool
-	proxy      *proxy.RuntimeProxy
-	logs       *logs.LogCollector
+	proxy      runtimeClient
+	logs       *runtime.LogCollector
 	publisher  *messaging.Publisher
+	registry   *runtime.Registry
+	allowlist  *container.ImageAllowlist
+	verifier   *CodeVerifier // nil unless code signing is enforced
 	codeClient *http.Client
+	cache      *resultCache
+	advisor    *sizing.Advisor
+	logger     *zap.Logger
+
+	// limitsAnnotation is the action parameter holding timeout/memory for
+	// tools that annotate limits instead of setting them
+	limitsAnnotation string
+
+	// redactions maps a namespace ("*" for all) to result key paths masked
+	// before results are published
+	redactions map[string][]string
+
+	// maxEnvVars and maxEnvBytes bound an action's env so it can't exceed
+	// exec limits in the runtime
+	maxEnvVars  int
+	maxEnvBytes int
+
+	actionSlotsMu sync.Mutex
+	actionSlots   map[string]*actionSlots // action key -> concurrency semaphore
 }
 
 // NewExecutor creates a new executor instance
 func NewExecutor(
 	pool *container.ContainerPool,
-	proxy *proxy.RuntimeProxy,
-	logs *logs.LogCollector,
+	proxy *runtime.RuntimeProxy,
+	logs *runtime.LogCollector,
 	publisher *messaging.Publisher,
+	registry *runtime.Registry,
+	logger *zap.Logger,
 ) *Executor {
 	return &Executor{
 		pool:      pool,
 		proxy:     proxy,
 		logs:      logs,
 		publisher: publisher,
+		registry:  registry,
 		codeClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		cache:            newResultCache(),
+		logger:           logger,
+		limitsAnnotation: DefaultLimitsAnnotation,
+		maxEnvVars:       DefaultMaxEnvVars,
+		maxEnvBytes:      DefaultMaxEnvBytes,
+		actionSlots:      make(map[string]*actionSlots),
 	}
 }
 
@@ -46,59 +111,310 @@ func NewExecutor(
 func (e *Executor) HandleInvocation(ctx context.Context, msg *messaging.InvocationMessage) (*messaging.ActivationResult, error) {
 	startTime := time.Now()
 
-	// Get container from pool (warm or cold)
-	cont, isColdStart, err := e.pool.Get(ctx, msg.Runtime)
+	// Time spent queued for capacity can use up the deadline after the
+	// consumer checked it; don't spend a container on an invocation that is
+	// already late
+	if deadline, ok := ctx.Deadline(); ok && !startTime.Before(deadline) {
+		return e.errorResult(msg, startTime, statusDeveloperError, "invocation deadline exceeded before execution"), nil
+	}
+
+	// Blackbox actions bring their own image and code, so they skip the
+	// registry, code fetch and /init
+	blackbox := msg.Action.Exec.Kind == runtime.KindBlackbox
+	var spec runtime.RuntimeSpec
+	if blackbox {
+		if msg.Action.Exec.Image == "" {
+			return e.errorResult(msg, startTime, statusDeveloperError, "blackbox action has no image"), nil
+		}
+		spec = runtime.BlackboxSpec(msg.Action.Exec.Image)
+	} else {
+		var ok bool
+		if spec, ok = e.registry.Lookup(msg.Action.Exec.Kind); !ok {
+			return nil, fmt.Errorf("unknown runtime kind: %s", msg.Action.Exec.Kind)
+		}
+	}
+	// Containers without a network have no IP to reach /init and /run on
+	if msg.Action.Exec.Network == container.NetworkModeNone && spec.Transport == runtime.TransportHTTP {
+		if len(spec.ExecCommand) == 0 {
+			return e.errorResult(msg, startTime, statusDeveloperError, fmt.Sprintf("runtime %s cannot run without a network", spec.Kind)), nil
+		}
+		spec.Transport = runtime.TransportExec
+	}
+
+	applyAnnotatedLimits(&msg.Action.Limits, msg.Action.Parameters, e.limitsAnnotation)
+	applyRuntimeDefaults(&msg.Action.Limits, spec)
+	if timeout := msg.Action.Limits.Timeout; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(timeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	// Reject images that aren't allowlisted before anything gets pulled
+	if image := msg.Action.Exec.Image; image != "" && e.allowlist != nil && !e.allowlist.Allows(image) {
+		return e.errorResult(msg, startTime, statusDeveloperError, fmt.Sprintf("image %q is not allowed on this invoker", image)), nil
+	}
+
+	// Queue behind the action's own concurrency limit until the deadline
+	release, err := e.acquireActionSlot(ctx, msg)
+	if err != nil {
+		return e.errorResult(msg, startTime, statusDeveloperError, "action concurrency limit reached, invocation throttled"), nil
+	}
+	defer release()
+
+	// Reject an env the runtime couldn't exec the action with
+	if err := validateEnv(msg.Action.Exec.Env, e.maxEnvVars, e.maxEnvBytes); err != nil {
+		return e.errorResult(msg, startTime, statusDeveloperError, err.Error()), nil
+	}
+
+	// Fetch action code from MinIO unless it came inline; its hash keeps
+	// warm containers that were initialized with older code from being
+	// reused. Blackbox containers are pooled by image instead
+	poolKey := msg.Action.Exec.Kind
+	var code []byte
+	var codeHash string
+	if blackbox {
+		poolKey = spec.Image
+		codeHash = spec.Image
+	} else {
+		code = []byte(msg.Action.Exec.Code)
+		if codeURL := msg.Action.Exec.CodeURL; codeURL != "" {
+			code, err = e.fetchCode(ctx, codeURL)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch code: %w", err)
+			}
+		}
+		// Refuse code that isn't signed by the trusted key
+		if e.verifier != nil {
+			if err := e.verifier.Verify(code, msg.Action.Exec.CodeSignature); err != nil {
+				return e.errorResult(msg, startTime, statusDeveloperError, fmt.Sprintf("code signature rejected: %v", err)), nil
+			}
+		}
+		codeHash = fmt.Sprintf("%x", sha256.Sum256(code))
+	}
+
+	// Serve memoized results of cacheable actions without running a container
+	var resultKey string
+	if msg.Action.Cacheable && msg.Action.CacheTTL > 0 {
+		if key, err := cacheKey(msg.Action, msg.Params, codeHash); err == nil {
+			resultKey = key
+			if cached, ok := e.cache.get(key, startTime); ok {
+				return e.cachedResult(ctx, msg, startTime, cached)
+			}
+		}
+	}
+
+	// Get container from pool (warm or cold), preferring one already
+	// initialized with this action and code
+	actionKey := container.ActionKey(msg.Action.Namespace, msg.Action.Name)
+	pooled, coldTimings, err := e.pool.GetContainer(ctx, poolKey, actionKey, codeHash, int64(msg.Action.Limits.Memory), msg.Action.Exec.Network)
 	if err != nil {
+		if container.IsCapacityError(err) {
+			return nil, &messaging.RetryableError{Err: fmt.Errorf("host at capacity: %w", err)}
+		}
 		return nil, fmt.Errorf("failed to get container: %w", err)
 	}
+	cont := pooled.Container
+	isColdStart := coldTimings != nil
 
 	// Ensure container is returned to pool or removed
 	var returnToPool = true
 	defer func() {
-		if returnToPool {
-			e.pool.Return(cont)
-		} else {
-			e.pool.Remove(cont.ID)
+		if err := e.pool.ReturnContainer(cont.ID, returnToPool); err != nil {
+			e.logger.Warn("Failed to return container",
+				zap.Error(err),
+				zap.String("activation_id", msg.ActivationID))
 		}
 	}()
 
-	// Fetch action code from MinIO
-	code, err := e.fetchCode(ctx, msg.CodeURL)
-	if err != nil {
-		returnToPool = false
-		return nil, fmt.Errorf("failed to fetch code: %w", err)
+	// Tag runtime requests so the runtime's logs can be tied to the activation
+	transactionID := msg.Context.TransactionID
+	if transactionID == "" {
+		transactionID = msg.ActivationID
 	}
 
-	// If cold start, initialize the container
-	if isColdStart {
-		initReq := &proxy.InitRequest{
-			Code:   code,
-			Binary: msg.Binary,
-			Main:   msg.Main,
+	// Initialize cold containers and warm ones last initialized with another
+	// action or code; exec runtimes have no /init and blackbox images are
+	// self-contained
+	var annotations []messaging.Annotation
+	coldStart := cont.Timings
+	needsInit := isColdStart || pooled.NeedsInit
+	if needsInit && spec.Transport == runtime.TransportHTTP && !blackbox {
+		initReq := &runtime.InitPayload{
+			Name:       actionKey,
+			Code:       string(code),
+			Binary:     msg.Action.Exec.Binary,
+			Main:       msg.Action.Exec.Main,
+			InitParams: msg.Action.Parameters,
+			BuildFlags: msg.Action.Exec.BuildFlags,
+			Env:        msg.Action.Exec.Env,
+
+			TransactionID: transactionID,
+			TraceParent:   msg.Context.TraceParent,
 		}
-		if err := e.proxy.Init(ctx, cont, initReq); err != nil {
+		// Let the runtime budget its compile against the activation deadline
+		if deadline, ok := ctx.Deadline(); ok {
+			initReq.Deadline = deadline.UnixMilli()
+		}
+		initStart := time.Now()
+		initResult, err := e.proxy.Init(ctx, cont.IP, initReq)
+		if err != nil {
 			returnToPool = false
 			return nil, fmt.Errorf("failed to initialize container: %w", err)
 		}
+		coldStart.InitMs = time.Since(initStart).Milliseconds()
+
+		// Surface compile stats on the activation that paid for the init
+		annotations = append(annotations,
+			messaging.Annotation{Key: "compileMs", Value: initResult.CompileMs},
+			messaging.Annotation{Key: "binaryBytes", Value: initResult.BinaryBytes},
+		)
+	}
+
+	// Break down where the cold start's latency went
+	if isColdStart {
+		annotations = append(annotations, messaging.Annotation{Key: "coldStart", Value: coldStart})
+	}
+
+	// Record which runtime image ran the activation to correlate behavior
+	// changes with image rollouts
+	runtimeImage := cont.Runtime
+	if runtimeImage == "" {
+		runtimeImage = spec.ImageRef()
+	}
+	annotations = append(annotations,
+		messaging.Annotation{Key: "kind", Value: spec.Kind},
+		messaging.Annotation{Key: "runtimeImage", Value: runtimeImage},
+	)
+
+	// Flag activations slowed down by running a foreign-arch image
+	if cont.Emulated {
+		annotations = append(annotations,
+			messaging.Annotation{Key: "emulated", Value: true},
+			messaging.Annotation{Key: "imageArch", Value: cont.ImageArch},
+		)
 	}
 
 	// Run the action
-	runReq := &proxy.RunRequest{
-		Value: msg.Parameters,
+	runStart := time.Now()
+	runReq := &runtime.RunPayload{
+		Value:         msg.Params,
+		Namespace:     msg.Action.Namespace,
+		ActionName:    msg.Action.Name,
+		ActivationID:  msg.ActivationID,
+		TransactionID: transactionID,
+		Deadline:      msg.Deadline,
+		TraceParent:   msg.Context.TraceParent,
+		RawInput:      msg.RawInput,
+		RawBody:       msg.RawBody,
+		StdinFormat:   msg.Action.Exec.StdinFormat,
+		StdinProtocol: msg.Action.Exec.StdinProtocol,
+		Activation: runtime.ActivationContext{
+			ID:         msg.ActivationID,
+			Namespace:  msg.Action.Namespace,
+			ActionName: msg.Action.Name,
+			APIHost:    msg.Context.APIHost,
+			APIKey:     msg.Context.APIKey,
+			Deadline:   msg.Deadline,
+		},
+	}
+	var runResp *runtime.RunResult
+	switch {
+	case spec.Transport == runtime.TransportExec:
+		runResp, err = e.proxy.ExecRun(ctx, cont.ID, spec.ExecCommand, runReq)
+	case msg.Stream:
+		runResp, err = e.proxy.RunStream(ctx, cont.IP, runReq, func(chunk map[string]interface{}) error {
+			return e.publisher.PublishPartial(ctx, msg.ActivationID, chunk)
+		})
+		e.closePartial(ctx, msg.ActivationID, runResp, err)
+	default:
+		runResp, err = e.proxy.Run(ctx, cont.IP, runReq)
 	}
-	runResp, err := e.proxy.Run(ctx, cont, runReq)
 	if err != nil {
-		returnToPool = false
+		// Runtime failures count against the container's health. A runtime
+		// that answered with an error stays pooled so the pool can
+		// quarantine it once such failures pile up; timed-out and
+		// unreachable containers are removed right away
+		e.pool.RecordOutcome(cont.ID, false)
+		var timeoutErr *runtime.TimeoutError
+		var execErr *runtime.ExecutionError
+		switch {
+		case errors.As(err, &timeoutErr):
+			returnToPool = false
+			// A timed-out action may still be running; kill it now rather
+			// than letting it burn CPU until the container is removed. Exec
+			// runs kill their own container
+			if spec.Transport != runtime.TransportExec {
+				killCtx, cancel := context.WithTimeout(context.Background(), killTimeout)
+				if killErr := e.pool.KillContainer(killCtx, cont.ID); killErr != nil {
+					e.logger.Warn("Failed to kill timed-out container",
+						zap.Error(killErr),
+						zap.String("activation_id", msg.ActivationID))
+				}
+				cancel()
+			}
+		case errors.As(err, &execErr):
+			// Returned for reuse, subject to quarantine
+		default:
+			returnToPool = false
+		}
 		return nil, fmt.Errorf("failed to run action: %w", err)
 	}
 
-	// Collect logs from container
-	containerLogs, err := e.logs.Collect(ctx, cont.ID)
+	// The runtime answered, so the container is healthy; application and
+	// developer errors returned by the action say nothing about it
+	e.pool.RecordOutcome(cont.ID, true)
+	if runResp.ExitCode != 0 {
+		annotations = append(annotations, messaging.Annotation{Key: "exitCode", Value: runResp.ExitCode})
+	}
+	if e.advisor != nil {
+		e.advisor.Sample(ctx, actionKey, cont.ID)
+	}
+
+	// Pull out custom annotations the action returned before the result is
+	// cached or published
+	customAnnotations := takeResultAnnotations(runResp.Result)
+
+	if resultKey != "" && runResp.StatusCode == 0 {
+		e.cache.put(resultKey, runResp.Result, time.Duration(msg.Action.CacheTTL)*time.Second, time.Now())
+	}
+
+	// Trim the result down to the requested projection
+	if msg.ResultProjection != "" && runResp.Result != nil {
+		projected, err := projectResult(runResp.Result, msg.ResultProjection)
+		if err != nil {
+			runResp.Result = nil
+			runResp.StatusCode = statusDeveloperError
+			runResp.Error = err.Error()
+		} else {
+			runResp.Result = projected
+		}
+	}
+
+	// Mask configured secrets before the result leaves the invoker
+	if paths := e.redactionPaths(msg.Action.Namespace); len(paths) > 0 && runResp.Result != nil {
+		runResp.Result = redactResult(runResp.Result, paths)
+	}
+
+	// Collect logs from container, bounded so a burst of activations doesn't
+	// serialize behind the Docker API
+	var containerLogs []string
+	collected, err := e.logs.CollectWithSemaphore(ctx, cont.ID, runStart)
 	if err != nil {
 		// Log collection failure shouldn't fail the activation
 		containerLogs = []string{fmt.Sprintf("Failed to collect logs: %v", err)}
+	} else {
+		containerLogs = e.logs.FormatLogs(collected.Lines)
+		// A missing activation marker means the runtime died mid-activation
+		if !collected.Complete {
+			annotations = append(annotations, messaging.Annotation{Key: "logsComplete", Value: false})
+		}
+	}
+	if limitKB := msg.Action.Limits.Logs; limitKB > 0 {
+		containerLogs = truncateLogs(containerLogs, limitKB*1024)
 	}
 
+	annotations = mergeAnnotations(annotations, customAnnotations)
+
 	// Calculate duration
 	endTime := time.Now()
 	duration := endTime.Sub(startTime).Milliseconds()
@@ -106,27 +422,320 @@ func (e *Executor) HandleInvocation(ctx context.Context, msg *messaging.Invocati
 	// Build activation result
 	result := &messaging.ActivationResult{
 		ActivationID: msg.ActivationID,
+		Namespace:    msg.Action.Namespace,
+		Name:         msg.Action.Name,
+		Version:      msg.Action.Version,
 		Response: messaging.Response{
 			StatusCode: runResp.StatusCode,
+			Success:    runResp.StatusCode == 0,
 			Result:     runResp.Result,
+			Error:      runResp.Error,
+			ExitCode:   runResp.ExitCode,
 		},
-		Logs:      containerLogs,
-		Start:     startTime.UnixMilli(),
-		End:       endTime.UnixMilli(),
-		Duration:  duration,
-		Namespace: msg.Namespace,
-		Action:    msg.Action,
+		Logs:        containerLogs,
+		Start:       startTime.UnixMilli(),
+		End:         endTime.UnixMilli(),
+		Duration:    duration,
+		Annotations: annotations,
+	}
+
+	return result, nil
+}
+
+// closePartial ends a streamed run's partial stream with its final response,
+// or with the error that cut the run short
+func (e *Executor) closePartial(ctx context.Context, activationID string, runResp *runtime.RunResult, runErr error) {
+	var response messaging.Response
+	var timeoutErr *runtime.TimeoutError
+	switch {
+	case errors.As(runErr, &timeoutErr):
+		response = messaging.Response{StatusCode: statusDeveloperError, Error: runErr.Error()}
+	case runErr != nil:
+		response = messaging.Response{StatusCode: statusInternalError, Error: runErr.Error()}
+	default:
+		response = messaging.Response{
+			StatusCode: runResp.StatusCode,
+			Success:    runResp.StatusCode == 0,
+			Result:     runResp.Result,
+			Error:      runResp.Error,
+		}
 	}
 
-	// Publish result
-	if err := e.publisher.PublishResult(ctx, result); err != nil {
-		return result, fmt.Errorf("failed to publish result: %w", err)
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), closePartialTimeout)
+	defer cancel()
+	if err := e.publisher.ClosePartial(ctx, activationID, response); err != nil {
+		e.logger.Warn("Failed to close partial stream",
+			zap.Error(err),
+			zap.String("activation_id", activationID))
+	}
+}
+
+// cachedResult builds an activation from a memoized result
+func (e *Executor) cachedResult(ctx context.Context, msg *messaging.InvocationMessage, startTime time.Time, cached map[string]interface{}) (*messaging.ActivationResult, error) {
+	response := messaging.Response{
+		Success: true,
+		Result:  cached,
+	}
+	if msg.ResultProjection != "" {
+		projected, err := projectResult(cached, msg.ResultProjection)
+		if err != nil {
+			response = messaging.Response{
+				StatusCode: statusDeveloperError,
+				Error:      err.Error(),
+			}
+		} else {
+			response.Result = projected
+		}
+	}
+	if paths := e.redactionPaths(msg.Action.Namespace); len(paths) > 0 && response.Result != nil {
+		response.Result = redactResult(response.Result, paths)
+	}
+
+	endTime := time.Now()
+	result := &messaging.ActivationResult{
+		ActivationID: msg.ActivationID,
+		Namespace:    msg.Action.Namespace,
+		Name:         msg.Action.Name,
+		Version:      msg.Action.Version,
+		Response:     response,
+		Start:        startTime.UnixMilli(),
+		End:          endTime.UnixMilli(),
+		Duration:     endTime.Sub(startTime).Milliseconds(),
+		Annotations: []messaging.Annotation{
+			{Key: "cached", Value: true},
+		},
 	}
 
 	return result, nil
 }
 
-// fetchCode retrieves action code from MinIO using a presigned URL
+// takeResultAnnotations removes the reserved __ow_annotations object from an
+// action result and returns its entries, sorted by key. Only string, number
+// and boolean values are kept
+func takeResultAnnotations(result map[string]interface{}) []messaging.Annotation {
+	raw, ok := result[resultAnnotationsKey]
+	if !ok {
+		return nil
+	}
+	delete(result, resultAnnotationsKey)
+
+	custom, ok := raw.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	annotations := make([]messaging.Annotation, 0, len(custom))
+	for key, value := range custom {
+		switch value.(type) {
+		case string, float64, bool:
+			annotations = append(annotations, messaging.Annotation{Key: key, Value: value})
+		}
+	}
+	sort.Slice(annotations, func(i, j int) bool {
+		return annotations[i].Key < annotations[j].Key
+	})
+	return annotations
+}
+
+// mergeAnnotations appends custom annotations whose keys the invoker hasn't
+// already set, so actions can't overwrite the invoker's own
+func mergeAnnotations(annotations, custom []messaging.Annotation) []messaging.Annotation {
+	set := make(map[string]bool, len(annotations))
+	for _, annotation := range annotations {
+		set[annotation.Key] = true
+	}
+	for _, annotation := range custom {
+		if !set[annotation.Key] {
+			annotations = append(annotations, annotation)
+		}
+	}
+	return annotations
+}
+
+// truncateLogs keeps log lines up to maxBytes, noting how many were dropped
+func truncateLogs(lines []string, maxBytes int) []string {
+	size := 0
+	for i, line := range lines {
+		size += len(line)
+		if size > maxBytes {
+			return append(lines[:i:i], fmt.Sprintf("Logs truncated: %d of %d lines dropped after %d bytes", len(lines)-i, len(lines), maxBytes))
+		}
+	}
+	return lines
+}
+
+// errorResult builds a failed activation for an invocation rejected before it ran
+func (e *Executor) errorResult(msg *messaging.InvocationMessage, startTime time.Time, statusCode int, errMsg string) *messaging.ActivationResult {
+	endTime := time.Now()
+	return &messaging.ActivationResult{
+		ActivationID: msg.ActivationID,
+		Namespace:    msg.Action.Namespace,
+		Name:         msg.Action.Name,
+		Version:      msg.Action.Version,
+		Response: messaging.Response{
+			StatusCode: statusCode,
+			Success:    false,
+			Error:      errMsg,
+		},
+		Start:    startTime.UnixMilli(),
+		End:      endTime.UnixMilli(),
+		Duration: endTime.Sub(startTime).Milliseconds(),
+	}
+}
+
+// applyAnnotatedLimits fills unset timeout and memory limits from an
+// annotation such as {"limits": {"timeout": 30000, "memory": 512}}
+func applyAnnotatedLimits(limits *messaging.LimitsSpec, params map[string]any, key string) {
+	if key == "" {
+		return
+	}
+	annotation, ok := params[key].(map[string]any)
+	if !ok {
+		return
+	}
+
+	if limits.Timeout == 0 {
+		limits.Timeout = annotatedInt(annotation["timeout"])
+	}
+	if limits.Memory == 0 {
+		limits.Memory = annotatedInt(annotation["memory"])
+	}
+}
+
+// annotatedInt reads a positive integer annotation value, which JSON decodes
+// as a number or deployment tools may send as a string
+func annotatedInt(value any) int {
+	var n int
+	switch v := value.(type) {
+	case float64:
+		n = int(v)
+	case int:
+		n = v
+	case string:
+		n, _ = strconv.Atoi(v)
+	}
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
+// applyRuntimeDefaults fills unset limits from the runtime's defaults
+func applyRuntimeDefaults(limits *messaging.LimitsSpec, spec runtime.RuntimeSpec) {
+	if limits.Memory == 0 {
+		limits.Memory = spec.DefaultMemoryMB
+	}
+	if limits.Timeout == 0 {
+		limits.Timeout = spec.DefaultTimeoutMs
+	}
+	if limits.Concurrency == 0 {
+		limits.Concurrency = spec.DefaultConcurrency
+	}
+}
+
+// actionSlots counts an action's running invocations against its declared
+// concurrency limit. The limit can change while invocations hold slots, so
+// it is a counter checked against the latest limit rather than a fixed-size
+// channel. Guarded by actionSlotsMu
+type actionSlots struct {
+	active  int
+	limit   int
+	changed chan struct{} // closed and replaced when a slot frees up or the limit changes
+}
+
+// signal wakes up invocations waiting for a slot to recheck
+// Must be called with actionSlotsMu held
+func (s *actionSlots) signal() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// acquireActionSlot blocks until the action is below its declared concurrency
+// limit or the context is done. Semaphores are created lazily per action and
+// follow the most recently declared limit, including for invocations already
+// waiting
+func (e *Executor) acquireActionSlot(ctx context.Context, msg *messaging.InvocationMessage) (func(), error) {
+	limit := msg.Action.Limits.Concurrency
+	if limit <= 0 {
+		return func() {}, nil
+	}
+
+	key := container.ActionKey(msg.Action.Namespace, msg.Action.Name)
+
+	e.actionSlotsMu.Lock()
+	slots, ok := e.actionSlots[key]
+	if !ok {
+		slots = &actionSlots{changed: make(chan struct{})}
+		e.actionSlots[key] = slots
+	}
+	if slots.limit != limit {
+		slots.limit = limit
+		slots.signal()
+	}
+
+	for slots.active >= slots.limit {
+		changed := slots.changed
+		e.actionSlotsMu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		e.actionSlotsMu.Lock()
+	}
+	slots.active++
+	e.actionSlotsMu.Unlock()
+
+	return func() {
+		e.actionSlotsMu.Lock()
+		slots.active--
+		slots.signal()
+		e.actionSlotsMu.Unlock()
+	}, nil
+}
+
+// SetImageAllowlist restricts the images invocations may request
+func (e *Executor) SetImageAllowlist(allowlist *container.ImageAllowlist) {
+	e.allowlist = allowlist
+}
+
+// SetCodeVerifier enforces that fetched action code is signed by the
+// verifier's trusted key
+func (e *Executor) SetCodeVerifier(verifier *CodeVerifier) {
+	e.verifier = verifier
+}
+
+// SetLimitsAnnotation sets the action parameter read for timeout and memory
+// when the limits block leaves them unset; empty disables the fallback
+func (e *Executor) SetLimitsAnnotation(key string) {
+	e.limitsAnnotation = key
+}
+
+// SetRedactions sets the result key paths redacted per namespace, with "*"
+// applying to every namespace
+func (e *Executor) SetRedactions(redactions map[string][]string) {
+	e.redactions = redactions
+}
+
+// SetEnvLimits sets the most env vars an action may have and their total
+// size in bytes; zero or less keeps the default
+func (e *Executor) SetEnvLimits(maxVars, maxBytes int) {
+	if maxVars > 0 {
+		e.maxEnvVars = maxVars
+	}
+	if maxBytes > 0 {
+		e.maxEnvBytes = maxBytes
+	}
+}
+
+// SetMemoryAdvisor enables sampling container memory after each activation
+// for memory limit suggestions
+func (e *Executor) SetMemoryAdvisor(advisor *sizing.Advisor) {
+	e.advisor = advisor
+}
+
+// fetchCode retrieves action code from MinIO using a presigned URL. Network
+// failures and 5xx responses mean the store is down rather than the action
+// being broken, so they are returned as retryable to re-queue the invocation
 func (e *Executor) fetchCode(ctx context.Context, codeURL string) ([]byte, error) {
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, codeURL, nil)
 	if err != nil {
@@ -135,10 +744,18 @@ func (e *Executor) fetchCode(ctx context.Context, codeURL string) ([]byte, error
 
 	resp, err := e.codeClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch code: %w", err)
+		// A request cut short by the invocation's own deadline says nothing
+		// about the store
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("failed to fetch code: %w", err)
+		}
+		return nil, &messaging.RetryableError{Err: fmt.Errorf("code store unavailable: %w", err)}
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return nil, &messaging.RetryableError{Err: fmt.Errorf("code store unavailable: status code %d", resp.StatusCode)}
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
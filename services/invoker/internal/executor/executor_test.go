@@ -0,0 +1,489 @@
+package executor
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"reflect"
+	goruntime "runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/container"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/messaging"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/runtime"
+)
+
+// annotation returns the value of an activation's annotation
+func annotation(result *messaging.ActivationResult, key string) (any, bool) {
+	for _, a := range result.Annotations {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return nil, false
+}
+
+func TestRuntimeFailuresQuarantineContainer(t *testing.T) {
+	e, fake, rt := newTestExecutor(t, container.PoolConfig{QuarantineFailureRatio: 0.5})
+	rt.run = func(ctx context.Context, payload *runtime.RunPayload) (*runtime.RunResult, error) {
+		return nil, &runtime.ExecutionError{Message: "run request returned non-200 status", StatusCode: 500}
+	}
+
+	// The failing container keeps being reused until enough failures are
+	// recorded to quarantine it
+	first := fmt.Sprintf("%064d", 1)
+	for i := 1; i <= 4; i++ {
+		if _, err := e.HandleInvocation(context.Background(), testInvocation(fmt.Sprintf("act-%d", i))); err == nil {
+			t.Fatalf("invocation %d succeeded on a failing runtime", i)
+		}
+		if created := fake.created(); created != 1 {
+			t.Fatalf("invocation %d created %d containers, want the failing one reused", i, created)
+		}
+		if removed := fake.wasRemoved(first); removed != (i == 4) {
+			t.Fatalf("after %d failures container removed = %v", i, removed)
+		}
+	}
+
+	// The next invocation gets a fresh container
+	rt.run = nil
+	result, err := e.HandleInvocation(context.Background(), testInvocation("act-5"))
+	if err != nil || !result.Response.Success {
+		t.Fatalf("HandleInvocation() = %+v, %v", result, err)
+	}
+	if created := fake.created(); created != 2 {
+		t.Errorf("%d containers created, want a fresh one after quarantine", created)
+	}
+}
+
+func TestActionErrorsDoNotQuarantineContainer(t *testing.T) {
+	e, fake, rt := newTestExecutor(t, container.PoolConfig{QuarantineFailureRatio: 0.5})
+	rt.run = func(ctx context.Context, payload *runtime.RunPayload) (*runtime.RunResult, error) {
+		return &runtime.RunResult{Error: "bad input", StatusCode: statusDeveloperError}, nil
+	}
+
+	for i := 1; i <= 8; i++ {
+		result, err := e.HandleInvocation(context.Background(), testInvocation(fmt.Sprintf("act-%d", i)))
+		if err != nil || result.Response.Success {
+			t.Fatalf("HandleInvocation() = %+v, %v, want a developer error", result, err)
+		}
+	}
+	if created := fake.created(); created != 1 || fake.wasRemoved(fmt.Sprintf("%064d", 1)) {
+		t.Errorf("healthy container running a failing action was quarantined")
+	}
+}
+
+func TestRunPayloadCarriesStdinOptionsAndActivation(t *testing.T) {
+	e, _, rt := newTestExecutor(t, container.PoolConfig{})
+
+	msg := testInvocation("act-1")
+	msg.RawInput = true
+	msg.RawBody = []byte("raw bytes")
+	msg.Action.Exec.StdinFormat = "json-line"
+	msg.Action.Exec.StdinProtocol = "actionloop"
+	msg.Context.APIHost = "https://whisk.example"
+	msg.Context.APIKey = "key"
+	if _, err := e.HandleInvocation(context.Background(), msg); err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+
+	payload := rt.runs[0]
+	if !payload.RawInput || string(payload.RawBody) != "raw bytes" {
+		t.Errorf("raw input = %v, %q", payload.RawInput, payload.RawBody)
+	}
+	if payload.StdinFormat != "json-line" || payload.StdinProtocol != "actionloop" {
+		t.Errorf("stdin format, protocol = %q, %q", payload.StdinFormat, payload.StdinProtocol)
+	}
+	want := runtime.ActivationContext{
+		ID:         "act-1",
+		Namespace:  "ns",
+		ActionName: "echo",
+		APIHost:    "https://whisk.example",
+		APIKey:     "key",
+		Deadline:   msg.Deadline,
+	}
+	if !reflect.DeepEqual(payload.Activation, want) {
+		t.Errorf("activation = %+v, want %+v", payload.Activation, want)
+	}
+}
+
+func TestImageAllowlist(t *testing.T) {
+	tests := []struct {
+		name    string
+		image   string
+		allowed bool
+	}{
+		{name: "allowed", image: "ghcr.io/penguintechinc/echo:1", allowed: true},
+		{name: "disallowed", image: "docker.io/someone/miner:latest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, fake, _ := newTestExecutor(t, container.PoolConfig{})
+			e.SetImageAllowlist(container.NewImageAllowlist([]string{"ghcr.io/penguintechinc/"}))
+
+			msg := testInvocation("act-1")
+			msg.Action.Exec = messaging.ExecSpec{Kind: runtime.KindBlackbox, Image: tt.image}
+			result, err := e.HandleInvocation(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("HandleInvocation: %v", err)
+			}
+
+			if result.Response.Success != tt.allowed {
+				t.Errorf("success = %v, want %v (%s)", result.Response.Success, tt.allowed, result.Response.Error)
+			}
+			if !tt.allowed && !strings.Contains(result.Response.Error, "is not allowed") {
+				t.Errorf("error = %q, want an allowlist rejection", result.Response.Error)
+			}
+			if created := fake.created(); created != 0 != tt.allowed {
+				t.Errorf("%d containers created for image %s", created, tt.image)
+			}
+		})
+	}
+}
+
+func TestActionConcurrencyLimitSerializesInvocations(t *testing.T) {
+	e, _, rt := newTestExecutor(t, container.PoolConfig{})
+	var mu sync.Mutex
+	running, most := 0, 0
+	rt.run = func(ctx context.Context, payload *runtime.RunPayload) (*runtime.RunResult, error) {
+		mu.Lock()
+		running++
+		most = max(most, running)
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return &runtime.RunResult{Result: map[string]interface{}{}}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 2; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			msg := testInvocation(fmt.Sprintf("act-%d", i))
+			msg.Action.Limits.Concurrency = 1
+			if result, err := e.HandleInvocation(context.Background(), msg); err != nil || !result.Response.Success {
+				t.Errorf("HandleInvocation(act-%d) = %+v, %v", i, result, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if most != 1 {
+		t.Errorf("%d invocations ran at once, want them serialized", most)
+	}
+}
+
+func TestZeroLimitsGetRuntimeDefaults(t *testing.T) {
+	e, fake, rt := newTestExecutor(t, container.PoolConfig{})
+	spec, _ := e.registry.Lookup(runtimeKind(t))
+
+	msg := testInvocation("act-1")
+	start := time.Now()
+	if _, err := e.HandleInvocation(context.Background(), msg); err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+
+	if msg.Action.Limits.Memory != spec.DefaultMemoryMB || msg.Action.Limits.Timeout != spec.DefaultTimeoutMs {
+		t.Errorf("limits = %+v, want the runtime's %d MB and %d ms", msg.Action.Limits, spec.DefaultMemoryMB, spec.DefaultTimeoutMs)
+	}
+	if _, memory := fake.createdWith(fmt.Sprintf("%064d", 1)); memory != int64(spec.DefaultMemoryMB) {
+		t.Errorf("container created with %d MB, want %d", memory, spec.DefaultMemoryMB)
+	}
+	// The init is budgeted against the runtime's default timeout
+	budget := time.UnixMilli(rt.inits[0].Deadline).Sub(start)
+	if want := time.Duration(spec.DefaultTimeoutMs) * time.Millisecond; budget > want || budget < want-5*time.Second {
+		t.Errorf("init budget = %v, want about %v", budget, want)
+	}
+}
+
+// runtimeKind returns the runtime kind testInvocation uses
+func runtimeKind(t *testing.T) string {
+	t.Helper()
+	return testInvocation("").Action.Exec.Kind
+}
+
+func TestAnnotatedLimitsHonored(t *testing.T) {
+	e, fake, rt := newTestExecutor(t, container.PoolConfig{})
+	var budget time.Duration
+	rt.run = func(ctx context.Context, payload *runtime.RunPayload) (*runtime.RunResult, error) {
+		deadline, _ := ctx.Deadline()
+		budget = time.Until(deadline)
+		return &runtime.RunResult{Result: map[string]interface{}{}}, nil
+	}
+
+	msg := testInvocation("act-1")
+	msg.Action.Parameters = map[string]any{DefaultLimitsAnnotation: map[string]any{"timeout": 2000.0, "memory": "512"}}
+	if _, err := e.HandleInvocation(context.Background(), msg); err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+
+	if budget <= 0 || budget > 2*time.Second {
+		t.Errorf("run budget = %v, want the annotated 2s timeout", budget)
+	}
+	if _, memory := fake.createdWith(fmt.Sprintf("%064d", 1)); memory != 512 {
+		t.Errorf("container created with %d MB, want the annotated 512", memory)
+	}
+}
+
+func TestEmulatedImageAnnotated(t *testing.T) {
+	e, fake, _ := newTestExecutor(t, container.PoolConfig{})
+	fake.arch = "amd64"
+	if goruntime.GOARCH == "amd64" {
+		fake.arch = "arm64"
+	}
+
+	result, err := e.HandleInvocation(context.Background(), testInvocation("act-1"))
+	if err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+	if emulated, _ := annotation(result, "emulated"); emulated != true {
+		t.Errorf("emulated annotation = %v, want true", emulated)
+	}
+	if arch, _ := annotation(result, "imageArch"); arch != fake.arch {
+		t.Errorf("imageArch annotation = %v, want %s", arch, fake.arch)
+	}
+}
+
+func TestNativeImageNotAnnotatedEmulated(t *testing.T) {
+	e, _, _ := newTestExecutor(t, container.PoolConfig{})
+
+	result, err := e.HandleInvocation(context.Background(), testInvocation("act-1"))
+	if err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+	if emulated, ok := annotation(result, "emulated"); ok {
+		t.Errorf("emulated annotation = %v on a native image", emulated)
+	}
+}
+
+func TestResultProjection(t *testing.T) {
+	e, _, rt := newTestExecutor(t, container.PoolConfig{})
+	rt.run = func(ctx context.Context, payload *runtime.RunPayload) (*runtime.RunResult, error) {
+		return &runtime.RunResult{Result: map[string]interface{}{
+			"data": map[string]interface{}{
+				"items": []interface{}{
+					map[string]interface{}{"id": 1.0},
+					map[string]interface{}{"id": 2.0, "name": "second"},
+				},
+			},
+			"debug": "large",
+		}}, nil
+	}
+
+	msg := testInvocation("act-1")
+	msg.ResultProjection = "$.data.items[1]"
+	result, err := e.HandleInvocation(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+	want := map[string]interface{}{"id": 2.0, "name": "second"}
+	if !reflect.DeepEqual(result.Response.Result, want) {
+		t.Errorf("result = %v, want %v", result.Response.Result, want)
+	}
+}
+
+func TestContainerLogsReturnedWithinLimit(t *testing.T) {
+	e, fake, _ := newTestExecutor(t, container.PoolConfig{})
+	fake.logs = []string{"first", "second"}
+
+	result, err := e.HandleInvocation(context.Background(), testInvocation("act-1"))
+	if err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+	if len(result.Logs) != 2 || !strings.HasSuffix(result.Logs[0], "stdout: first") || !strings.HasSuffix(result.Logs[1], "stdout: second") {
+		t.Fatalf("logs = %q, want both lines", result.Logs)
+	}
+
+	// Logs past the action's limit are dropped
+	fake.logs = []string{strings.Repeat("x", 800), strings.Repeat("y", 800)}
+	msg := testInvocation("act-2")
+	msg.Action.Limits.Logs = 1
+	result, err = e.HandleInvocation(context.Background(), msg)
+	if err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+	if len(result.Logs) != 2 || !strings.Contains(result.Logs[0], "xxx") || !strings.HasPrefix(result.Logs[1], "Logs truncated: 1 of 2 lines dropped") {
+		t.Errorf("logs = %q, want the second line truncated", result.Logs)
+	}
+}
+
+func TestMissingLogMarkerAnnotated(t *testing.T) {
+	for _, noMarker := range []bool{false, true} {
+		e, fake, _ := newTestExecutor(t, container.PoolConfig{})
+		fake.logs = []string{"ran"}
+		fake.noMarker = noMarker
+
+		result, err := e.HandleInvocation(context.Background(), testInvocation("act-1"))
+		if err != nil {
+			t.Fatalf("HandleInvocation: %v", err)
+		}
+		complete, ok := annotation(result, "logsComplete")
+		if ok != noMarker || (ok && complete != false) {
+			t.Errorf("without marker %v: logsComplete annotation = %v, %v", noMarker, complete, ok)
+		}
+	}
+}
+
+func TestRuntimeImageAnnotated(t *testing.T) {
+	e, _, _ := newTestExecutor(t, container.PoolConfig{})
+	spec, _ := e.registry.Lookup(runtimeKind(t))
+
+	result, err := e.HandleInvocation(context.Background(), testInvocation("act-1"))
+	if err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+	if kind, _ := annotation(result, "kind"); kind != spec.Kind {
+		t.Errorf("kind annotation = %v, want %s", kind, spec.Kind)
+	}
+	if image, _ := annotation(result, "runtimeImage"); image != spec.ImageRef() {
+		t.Errorf("runtimeImage annotation = %v, want %s", image, spec.ImageRef())
+	}
+}
+
+func TestCodeSignatureEnforced(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := testInvocation("").Action.Exec.Code
+	tests := []struct {
+		name      string
+		signature string
+		wantError string
+	}{
+		{name: "valid", signature: base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte(code)))},
+		{name: "invalid", signature: base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte("other code"))), wantError: "does not match the trusted key"},
+		{name: "missing", wantError: ErrCodeUnsigned.Error()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, fake, _ := newTestExecutor(t, container.PoolConfig{})
+			e.SetCodeVerifier(&CodeVerifier{key: public})
+
+			msg := testInvocation("act-1")
+			msg.Action.Exec.CodeSignature = tt.signature
+			result, err := e.HandleInvocation(context.Background(), msg)
+			if err != nil {
+				t.Fatalf("HandleInvocation: %v", err)
+			}
+
+			if tt.wantError == "" {
+				if !result.Response.Success {
+					t.Errorf("signed code rejected: %s", result.Response.Error)
+				}
+				return
+			}
+			if result.Response.Success || !strings.Contains(result.Response.Error, tt.wantError) {
+				t.Errorf("error = %q, want a rejection mentioning %q", result.Response.Error, tt.wantError)
+			}
+			if created := fake.created(); created != 0 {
+				t.Errorf("%d containers created for rejected code", created)
+			}
+		})
+	}
+}
+
+func TestBlackboxActionSkipsInit(t *testing.T) {
+	e, fake, rt := newTestExecutor(t, container.PoolConfig{})
+
+	msg := testInvocation("act-1")
+	msg.Action.Exec = messaging.ExecSpec{Kind: runtime.KindBlackbox, Image: "ghcr.io/penguintechinc/echo:1"}
+	result, err := e.HandleInvocation(context.Background(), msg)
+	if err != nil || !result.Response.Success {
+		t.Fatalf("HandleInvocation() = %+v, %v", result, err)
+	}
+
+	if inits, runs := rt.initCount(), rt.runCount(); inits != 0 || runs != 1 {
+		t.Errorf("%d inits and %d runs, want the blackbox run without an init", inits, runs)
+	}
+	if image, _ := fake.createdWith(fmt.Sprintf("%064d", 1)); image != "ghcr.io/penguintechinc/echo:1" {
+		t.Errorf("container created from %q, want the action's image", image)
+	}
+}
+
+func TestExpiredInvocationSkipsPool(t *testing.T) {
+	e, fake, rt := newTestExecutor(t, container.PoolConfig{})
+
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	result, err := e.HandleInvocation(ctx, testInvocation("act-1"))
+	if err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+
+	if result.Response.Success || !strings.Contains(result.Response.Error, "deadline exceeded") {
+		t.Errorf("response = %+v, want a deadline exceeded activation", result.Response)
+	}
+	if created, runs := fake.created(), rt.runCount(); created != 0 || runs != 0 {
+		t.Errorf("%d containers created and %d runs for an expired invocation", created, runs)
+	}
+}
+
+func TestResultRedactedBeforePublishing(t *testing.T) {
+	e, _, rt := newTestExecutor(t, container.PoolConfig{})
+	e.SetRedactions(map[string][]string{"ns": {"credentials.token"}, "other": {"user"}})
+	produced := map[string]interface{}{
+		"credentials": map[string]interface{}{"token": "s3cret"},
+		"user":        "alice",
+	}
+	rt.run = func(ctx context.Context, payload *runtime.RunPayload) (*runtime.RunResult, error) {
+		return &runtime.RunResult{Result: produced}, nil
+	}
+
+	result, err := e.HandleInvocation(context.Background(), testInvocation("act-1"))
+	if err != nil {
+		t.Fatalf("HandleInvocation: %v", err)
+	}
+	want := map[string]interface{}{
+		"credentials": map[string]interface{}{"token": redactedValue},
+		"user":        "alice",
+	}
+	if !reflect.DeepEqual(result.Response.Result, want) {
+		t.Errorf("result = %v, want %v", result.Response.Result, want)
+	}
+	if token := produced["credentials"].(map[string]interface{})["token"]; token != "s3cret" {
+		t.Errorf("action's own result redacted to %v", token)
+	}
+}
+
+func TestTimedOutContainerKilled(t *testing.T) {
+	e, fake, rt := newTestExecutor(t, container.PoolConfig{})
+	rt.run = func(ctx context.Context, payload *runtime.RunPayload) (*runtime.RunResult, error) {
+		return nil, &runtime.TimeoutError{Message: "run request timed out", Timeout: time.Second}
+	}
+
+	if _, err := e.HandleInvocation(context.Background(), testInvocation("act-1")); err == nil {
+		t.Fatal("timed-out invocation succeeded")
+	}
+	id := fmt.Sprintf("%064d", 1)
+	if !fake.wasKilled(id) {
+		t.Error("timed-out container not killed")
+	}
+	if !fake.wasRemoved(id) {
+		t.Error("timed-out container not removed")
+	}
+}
+
+func TestActionSwitchReinitializesContainer(t *testing.T) {
+	e, fake, rt := newTestExecutor(t, container.PoolConfig{MaxPoolSize: 1})
+
+	for i, name := range []string{"a", "a", "b"} {
+		msg := testInvocation(fmt.Sprintf("act-%d", i))
+		msg.Action.Name = name
+		if result, err := e.HandleInvocation(context.Background(), msg); err != nil || !result.Response.Success {
+			t.Fatalf("HandleInvocation(%s) = %+v, %v", name, result, err)
+		}
+	}
+
+	// The warm container is reused throughout, initialized again only when
+	// another action takes it over
+	if created, inits := fake.created(), rt.initCount(); created != 1 || inits != 2 {
+		t.Errorf("%d containers created and %d inits, want one container initialized for a then b", created, inits)
+	}
+}
@@ -0,0 +1,295 @@
+package executor
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	goruntime "runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/config"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/container"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/messaging"
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/runtime"
+	"github.com/penguintechinc/penguinwhisk/invoker/pkg/types"
+	"go.uber.org/zap"
+)
+
+// testNetwork is the managed network fake containers are attached to
+const testNetwork = "test-net"
+
+// fakeDocker serves the slice of the Docker API the pool and log collector
+// use, keeping track of the containers it created, killed and removed
+type fakeDocker struct {
+	mu      sync.Mutex
+	next    int
+	images  map[string]string // container -> image it was created from
+	memory  map[string]int64  // container -> memory limit in bytes
+	killed  map[string]bool
+	removed map[string]bool
+
+	// arch is the architecture images report, the host's when empty
+	arch string
+
+	// logs are the lines every container reports, each ending the activation
+	// unless noMarker is set
+	logs     []string
+	noMarker bool
+}
+
+// fakeRuntime stands in for the runtime proxy, recording the requests it
+// gets and answering runs with run, or by echoing the params when nil
+type fakeRuntime struct {
+	mu    sync.Mutex
+	inits []*runtime.InitPayload
+	runs  []*runtime.RunPayload
+	run   func(ctx context.Context, payload *runtime.RunPayload) (*runtime.RunResult, error)
+}
+
+// newTestExecutor returns an executor whose pool runs over a fake Docker
+// daemon and whose actions run in a fake runtime. The pool is shut down
+// when the test ends
+func newTestExecutor(t *testing.T, poolConfig container.PoolConfig) (*Executor, *fakeDocker, *fakeRuntime) {
+	t.Helper()
+
+	fake := &fakeDocker{
+		images:  make(map[string]string),
+		memory:  make(map[string]int64),
+		killed:  make(map[string]bool),
+		removed: make(map[string]bool),
+	}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	t.Setenv("DOCKER_HOST", "tcp://"+strings.TrimPrefix(server.URL, "http://"))
+
+	cfg := &config.Config{}
+	cfg.Docker.NetworkName = testNetwork
+	cfg.Docker.ContainerPrefix = "test"
+	cfg.Resources.MemoryMB = 256
+	manager, err := container.NewContainerManager(cfg, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewContainerManager: %v", err)
+	}
+
+	registry := runtime.DefaultRegistry()
+	if poolConfig.MaxPoolSize == 0 {
+		poolConfig.MaxPoolSize = 10
+	}
+	if poolConfig.CleanupInterval == 0 {
+		poolConfig.CleanupInterval = time.Hour
+	}
+	poolConfig.RuntimeImages = registry.Images()
+	pool := container.NewContainerPool(manager, poolConfig, zap.NewNop())
+	t.Cleanup(func() { pool.Shutdown(context.Background()) })
+
+	rt := &fakeRuntime{}
+	e := NewExecutor(pool, nil, runtime.NewLogCollector(manager.DockerClient()), nil, registry, zap.NewNop())
+	e.proxy = rt
+	return e, fake, rt
+}
+
+// testInvocation returns an invocation of ns/echo, a Go action with inline
+// code, due in a minute
+func testInvocation(activationID string) *messaging.InvocationMessage {
+	return &messaging.InvocationMessage{
+		ActivationID: activationID,
+		Action: messaging.ActionSpec{
+			Namespace: "ns",
+			Name:      "echo",
+			Exec: messaging.ExecSpec{
+				Kind: types.RuntimeKindGo,
+				Code: "package main",
+			},
+		},
+		Params:   map[string]any{"n": 1.0},
+		Deadline: time.Now().Add(time.Minute).UnixMilli(),
+	}
+}
+
+// created returns the number of containers created so far
+func (f *fakeDocker) created() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.next
+}
+
+// createdWith returns the image and memory limit in MB a container was
+// created with
+func (f *fakeDocker) createdWith(id string) (string, int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.images[id], f.memory[id] / (1024 * 1024)
+}
+
+// wasKilled reports whether a container was killed
+func (f *fakeDocker) wasKilled(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.killed[id]
+}
+
+// wasRemoved reports whether a container was removed
+func (f *fakeDocker) wasRemoved(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.removed[id]
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Drop the /v1.xx version prefix
+	path := r.URL.Path
+	if rest, ok := strings.CutPrefix(path, "/v"); ok {
+		if i := strings.Index(rest, "/"); i >= 0 {
+			path = rest[i:]
+		}
+	}
+
+	switch {
+	case path == "/_ping":
+		w.Header().Set("API-Version", "1.41")
+		w.Write([]byte("OK"))
+
+	case path == "/networks" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, []map[string]interface{}{{"Name": testNetwork, "Id": testNetwork}})
+
+	case strings.HasPrefix(path, "/images/") && r.Method == http.MethodGet:
+		f.mu.Lock()
+		arch := f.arch
+		f.mu.Unlock()
+		if arch == "" {
+			arch = goruntime.GOARCH
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"Architecture": arch})
+
+	case path == "/containers/create" && r.Method == http.MethodPost:
+		var body struct {
+			Image      string
+			HostConfig struct{ Memory int64 }
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		f.next++
+		id := fmt.Sprintf("%064d", f.next)
+		f.images[id] = body.Image
+		f.memory[id] = body.HostConfig.Memory
+		f.mu.Unlock()
+		writeJSON(w, http.StatusCreated, map[string]interface{}{"Id": id})
+
+	case strings.HasPrefix(path, "/containers/"):
+		id, action, _ := strings.Cut(strings.TrimPrefix(path, "/containers/"), "/")
+		f.serveContainer(w, r, id, action)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// serveContainer handles calls on one container
+func (f *fakeDocker) serveContainer(w http.ResponseWriter, r *http.Request, id, action string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case action == "json":
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"Id":         id,
+			"State":      map[string]interface{}{"Running": !f.removed[id]},
+			"HostConfig": map[string]interface{}{"LogConfig": map[string]interface{}{"Type": "json-file"}},
+			"NetworkSettings": map[string]interface{}{
+				"Networks": map[string]interface{}{
+					testNetwork: map[string]interface{}{"IPAddress": "10.0.0.1"},
+				},
+			},
+		})
+	case action == "logs":
+		lines := f.logs
+		if !f.noMarker {
+			lines = append(lines[:len(lines):len(lines)], runtime.LogMarker)
+		}
+		for _, line := range lines {
+			writeLogFrame(w, time.Now().UTC().Format(time.RFC3339Nano)+" "+line+"\n")
+		}
+	case action == "kill":
+		f.killed[id] = true
+		w.WriteHeader(http.StatusNoContent)
+	case action == "" && r.Method == http.MethodDelete:
+		f.removed[id] = true
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		// start and stop just succeed
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// writeLogFrame writes a line in Docker's multiplexed stdout log format
+func writeLogFrame(w http.ResponseWriter, line string) {
+	header := make([]byte, 8)
+	header[0] = 1
+	binary.BigEndian.PutUint32(header[4:], uint32(len(line)))
+	w.Write(header)
+	w.Write([]byte(line))
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// Init records the init request and reports a successful compile
+func (rt *fakeRuntime) Init(ctx context.Context, containerIP string, payload *runtime.InitPayload) (*runtime.InitResult, error) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	rt.inits = append(rt.inits, payload)
+	return &runtime.InitResult{OK: true, CompileMs: 10, BinaryBytes: 1024}, nil
+}
+
+// Run records the run request and answers it
+func (rt *fakeRuntime) Run(ctx context.Context, containerIP string, payload *runtime.RunPayload) (*runtime.RunResult, error) {
+	rt.mu.Lock()
+	rt.runs = append(rt.runs, payload)
+	run := rt.run
+	rt.mu.Unlock()
+
+	if run != nil {
+		return run(ctx, payload)
+	}
+	result := make(map[string]interface{}, len(payload.Value))
+	for key, value := range payload.Value {
+		result[key] = value
+	}
+	return &runtime.RunResult{Result: result}, nil
+}
+
+// RunStream answers like Run without streaming any partial results
+func (rt *fakeRuntime) RunStream(ctx context.Context, containerIP string, payload *runtime.RunPayload, onChunk func(map[string]interface{}) error) (*runtime.RunResult, error) {
+	return rt.Run(ctx, containerIP, payload)
+}
+
+// ExecRun answers like Run
+func (rt *fakeRuntime) ExecRun(ctx context.Context, containerID string, command []string, payload *runtime.RunPayload) (*runtime.RunResult, error) {
+	return rt.Run(ctx, containerID, payload)
+}
+
+// initCount returns the number of init requests made so far
+func (rt *fakeRuntime) initCount() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return len(rt.inits)
+}
+
+// runCount returns the number of run requests made so far
+func (rt *fakeRuntime) runCount() int {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	return len(rt.runs)
+}
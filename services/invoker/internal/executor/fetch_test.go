@@ -0,0 +1,77 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/penguintechinc/penguinwhisk/invoker/internal/messaging"
+)
+
+func TestFetchCodeStoreOutageIsRetryable(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		retryable bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "store error", status: http.StatusInternalServerError, retryable: true},
+		{name: "store unavailable", status: http.StatusServiceUnavailable, retryable: true},
+		{name: "missing code", status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				w.Write([]byte("package main"))
+			}))
+			defer server.Close()
+
+			e := &Executor{codeClient: server.Client()}
+			code, err := e.fetchCode(context.Background(), server.URL)
+
+			var retryable *messaging.RetryableError
+			if got := errors.As(err, &retryable); got != tt.retryable {
+				t.Fatalf("retryable = %v, want %v (err: %v)", got, tt.retryable, err)
+			}
+			if tt.status == http.StatusOK && (err != nil || string(code) != "package main") {
+				t.Fatalf("fetchCode() = %q, %v", code, err)
+			}
+		})
+	}
+}
+
+func TestFetchCodeUnreachableStoreIsRetryable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	e := &Executor{codeClient: http.DefaultClient}
+	_, err := e.fetchCode(context.Background(), url)
+
+	var retryable *messaging.RetryableError
+	if !errors.As(err, &retryable) {
+		t.Fatalf("expected a retryable error for an unreachable store, got %v", err)
+	}
+}
+
+func TestFetchCodeCanceledInvocationIsNotRetryable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	e := &Executor{codeClient: server.Client()}
+	_, err := e.fetchCode(ctx, server.URL)
+
+	var retryable *messaging.RetryableError
+	if err == nil || errors.As(err, &retryable) {
+		t.Fatalf("expected a non-retryable error once the invocation is done, got %v", err)
+	}
+}
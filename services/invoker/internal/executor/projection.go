@@ -0,0 +1,74 @@
+package executor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// projectResult applies a JSONPath-style projection such as "$.user.emails[0]"
+// to an action result. Object projections become the result; scalars and
+// arrays are wrapped under "value"
+func projectResult(result map[string]interface{}, expr string) (map[string]interface{}, error) {
+	path := strings.TrimPrefix(strings.TrimPrefix(expr, "$"), ".")
+	if path == "" {
+		return result, nil
+	}
+
+	var current interface{} = result
+	for _, segment := range strings.Split(path, ".") {
+		name, indexes, err := parseSegment(segment)
+		if err != nil {
+			return nil, fmt.Errorf("invalid result projection %q: %w", expr, err)
+		}
+
+		if name != "" {
+			obj, ok := current.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("result projection %q: %q is not an object", expr, name)
+			}
+			if current, ok = obj[name]; !ok {
+				return nil, fmt.Errorf("result projection %q: field %q not found", expr, name)
+			}
+		}
+
+		for _, index := range indexes {
+			arr, ok := current.([]interface{})
+			if !ok || index >= len(arr) {
+				return nil, fmt.Errorf("result projection %q: index %d out of range", expr, index)
+			}
+			current = arr[index]
+		}
+	}
+
+	if obj, ok := current.(map[string]interface{}); ok {
+		return obj, nil
+	}
+	return map[string]interface{}{"value": current}, nil
+}
+
+// parseSegment splits a path segment like "items[2][0]" into its field name
+// and array indexes
+func parseSegment(segment string) (string, []int, error) {
+	name, rest, _ := strings.Cut(segment, "[")
+	if rest == "" {
+		if name == "" {
+			return "", nil, fmt.Errorf("empty path segment")
+		}
+		return name, nil, nil
+	}
+
+	var indexes []int
+	for _, part := range strings.Split("["+rest, "[")[1:] {
+		raw, ok := strings.CutSuffix(part, "]")
+		if !ok {
+			return "", nil, fmt.Errorf("unterminated index in %q", segment)
+		}
+		index, err := strconv.Atoi(raw)
+		if err != nil || index < 0 {
+			return "", nil, fmt.Errorf("invalid index %q in %q", raw, segment)
+		}
+		indexes = append(indexes, index)
+	}
+	return name, indexes, nil
+}
@@ -0,0 +1,65 @@
+package executor
+
+import "strings"
+
+// redactedValue replaces redacted result fields
+const redactedValue = "***"
+
+// allNamespaces keys redaction paths applied to every namespace
+const allNamespaces = "*"
+
+// redactionPaths returns the result key paths redacted for a namespace
+func (e *Executor) redactionPaths(namespace string) []string {
+	if len(e.redactions) == 0 {
+		return nil
+	}
+	paths := append([]string{}, e.redactions[allNamespaces]...)
+	return append(paths, e.redactions[namespace]...)
+}
+
+// redactResult replaces the fields at dotted key paths such as
+// "credentials.token" with "***". Paths crossing an array apply to each
+// element, and missing paths are ignored. The result is copied along
+// redacted paths, so the action's own value (which may be cached) is left
+// untouched
+func redactResult(result map[string]interface{}, paths []string) map[string]interface{} {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if redacted, ok := redactPath(result, strings.Split(path, ".")).(map[string]interface{}); ok {
+			result = redacted
+		}
+	}
+	return result
+}
+
+// redactPath returns a copy of value with the field at keys redacted, or
+// value itself when the path doesn't exist
+func redactPath(value interface{}, keys []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		field, ok := v[keys[0]]
+		if !ok {
+			return value
+		}
+		copied := make(map[string]interface{}, len(v))
+		for k, fv := range v {
+			copied[k] = fv
+		}
+		if len(keys) == 1 {
+			copied[keys[0]] = redactedValue
+		} else {
+			copied[keys[0]] = redactPath(field, keys[1:])
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, elem := range v {
+			copied[i] = redactPath(elem, keys)
+		}
+		return copied
+	default:
+		return value
+	}
+}
@@ -0,0 +1,59 @@
+package executor
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrCodeUnsigned is returned when signing is enforced but the action code
+// carries no signature
+var ErrCodeUnsigned = errors.New("action code is not signed")
+
+// CodeVerifier checks detached ed25519 signatures over action code
+type CodeVerifier struct {
+	key ed25519.PublicKey
+}
+
+// LoadCodeVerifier reads a PEM-encoded ed25519 public key from path
+func LoadCodeVerifier(path string) (*CodeVerifier, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read code signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("code signing key %s is not PEM encoded", path)
+	}
+	parsed, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("parse code signing key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("code signing key %s is not an ed25519 key", path)
+	}
+
+	return &CodeVerifier{key: key}, nil
+}
+
+// Verify checks a base64-encoded signature over the code bytes
+func (v *CodeVerifier) Verify(code []byte, signature string) error {
+	if signature == "" {
+		return ErrCodeUnsigned
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("malformed code signature: %w", err)
+	}
+	if !ed25519.Verify(v.key, code, sig) {
+		return errors.New("code signature does not match the trusted key")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// FormatJSON emits one JSON object per log line
+	FormatJSON = "json"
+	// FormatConsole emits human-readable log lines
+	FormatConsole = "console"
+)
+
+// New creates the structured logger shared by all invoker components
+func New(level, format string) (*zap.Logger, error) {
+	cfg, err := config(level, format)
+	if err != nil {
+		return nil, err
+	}
+
+	logger, err := cfg.Build()
+	if err != nil {
+		return nil, fmt.Errorf("build logger: %w", err)
+	}
+
+	return logger, nil
+}
+
+// config returns the zap configuration for a level and format, writing to
+// stderr
+func config(level, format string) (zap.Config, error) {
+	var lvl zapcore.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return zap.Config{}, fmt.Errorf("invalid log level %q: %w", level, err)
+	}
+
+	cfg := zap.NewProductionConfig()
+	cfg.Level = zap.NewAtomicLevelAt(lvl)
+	cfg.EncoderConfig.TimeKey = "timestamp"
+	cfg.EncoderConfig.EncodeTime = zapcore.ISO8601TimeEncoder
+
+	switch format {
+	case "", FormatJSON:
+		cfg.Encoding = FormatJSON
+	case FormatConsole:
+		cfg.Encoding = FormatConsole
+	default:
+		return zap.Config{}, fmt.Errorf("invalid log format %q", format)
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestJSONOutputAtConfiguredLevel(t *testing.T) {
+	cfg, err := config("warn", FormatJSON)
+	if err != nil {
+		t.Fatalf("config: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "log")
+	cfg.OutputPaths = []string{path}
+	logger, err := cfg.Build()
+	if err != nil {
+		t.Fatalf("build logger: %v", err)
+	}
+
+	logger.Info("dropped below the level")
+	logger.Warn("kept", zap.String("id", "c1"))
+	logger.Sync()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open log: %v", err)
+	}
+	defer f.Close()
+
+	var entries []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("log line %q is not JSON: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("got %d log lines, want only the warning: %v", len(entries), entries)
+	}
+	entry := entries[0]
+	if entry["level"] != "warn" || entry["msg"] != "kept" || entry["id"] != "c1" {
+		t.Errorf("log entry = %v", entry)
+	}
+	if _, ok := entry["timestamp"]; !ok {
+		t.Errorf("log entry %v has no timestamp", entry)
+	}
+}
+
+func TestInvalidConfig(t *testing.T) {
+	if _, err := New("loud", FormatJSON); err == nil {
+		t.Error("New accepted an invalid level")
+	}
+	if _, err := New("info", "xml"); err == nil {
+		t.Error("New accepted an invalid format")
+	}
+}
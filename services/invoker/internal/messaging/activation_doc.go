@@ -0,0 +1,87 @@
+package messaging
+
+// OpenWhisk activation status strings, indexed by response status code
+var activationStatuses = map[int]string{
+	0: "success",
+	1: "application error",
+	2: "action developer error",
+	3: "whisk internal error",
+}
+
+// OpenWhiskActivation is the canonical OpenWhisk activation document
+type OpenWhiskActivation struct {
+	Namespace    string            `json:"namespace"`
+	Name         string            `json:"name"`
+	Version      string            `json:"version"`
+	Subject      string            `json:"subject"`
+	ActivationID string            `json:"activationId"`
+	Start        int64             `json:"start"`
+	End          int64             `json:"end"`
+	Duration     int64             `json:"duration"`
+	StatusCode   int               `json:"statusCode"`
+	Response     OpenWhiskResponse `json:"response"`
+	Logs         []string          `json:"logs"`
+	Annotations  []Annotation      `json:"annotations"`
+	Publish      bool              `json:"publish"`
+}
+
+// OpenWhiskResponse is the response section of an OpenWhisk activation
+type OpenWhiskResponse struct {
+	Status     string         `json:"status"`
+	StatusCode int            `json:"statusCode"`
+	Success    bool           `json:"success"`
+	Result     map[string]any `json:"result"`
+}
+
+// ActivationDoc converts the result into the activation document shape
+// standard OpenWhisk tooling expects. Status codes outside the OpenWhisk
+// range are reported as whisk internal errors, and errors are surfaced as
+// {"error": ...} in the result as OpenWhisk does
+func (r *ActivationResult) ActivationDoc() *OpenWhiskActivation {
+	statusCode := r.Response.StatusCode
+	if r.Response.Success {
+		statusCode = 0
+	}
+	status, ok := activationStatuses[statusCode]
+	if !ok {
+		statusCode = 3
+		status = activationStatuses[statusCode]
+	}
+
+	result := r.Response.Result
+	if r.Response.Error != "" {
+		result = map[string]any{"error": r.Response.Error}
+	}
+	if result == nil {
+		result = map[string]any{}
+	}
+
+	logs := r.Logs
+	if logs == nil {
+		logs = []string{}
+	}
+	annotations := r.Annotations
+	if annotations == nil {
+		annotations = []Annotation{}
+	}
+
+	return &OpenWhiskActivation{
+		Namespace:    r.Namespace,
+		Name:         r.Name,
+		Version:      r.Version,
+		Subject:      r.Namespace,
+		ActivationID: r.ActivationID,
+		Start:        r.Start,
+		End:          r.End,
+		Duration:     r.Duration,
+		StatusCode:   statusCode,
+		Response: OpenWhiskResponse{
+			Status:     status,
+			StatusCode: statusCode,
+			Success:    statusCode == 0,
+			Result:     result,
+		},
+		Logs:        logs,
+		Annotations: annotations,
+	}
+}
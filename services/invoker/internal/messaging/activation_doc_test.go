@@ -0,0 +1,73 @@
+package messaging
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestActivationDocMatchesOpenWhisk(t *testing.T) {
+	tests := []struct {
+		golden string
+		result *ActivationResult
+	}{
+		{
+			golden: "activation_doc.golden.json",
+			result: &ActivationResult{
+				ActivationID: "44794bd6aab74415b4e42a308d880e5b",
+				Namespace:    "guest",
+				Name:         "hello",
+				Version:      "0.0.2",
+				Response: Response{
+					Success: true,
+					Result:  map[string]any{"payload": "hello stranger"},
+				},
+				Start:    1700000000000,
+				End:      1700000000042,
+				Duration: 42,
+				Annotations: []Annotation{
+					{Key: "path", Value: "guest/hello"},
+					{Key: "waitTime", Value: 5},
+					{Key: "kind", Value: "go:1.23"},
+					{Key: "timeout", Value: false},
+					{Key: "limits", Value: map[string]any{"concurrency": 1, "logs": 10, "memory": 256, "timeout": 60000}},
+				},
+				Logs: []string{"2023-11-14T22:13:20.012Z stdout: hello"},
+			},
+		},
+		{
+			golden: "activation_doc_error.golden.json",
+			result: &ActivationResult{
+				ActivationID: "1f2a3b4c5d6e4f708192a3b4c5d6e7f8",
+				Namespace:    "guest",
+				Name:         "hello",
+				Version:      "0.0.2",
+				Response: Response{
+					StatusCode: 2,
+					Error:      "The action did not return a dictionary.",
+				},
+				Start:    1700000000000,
+				End:      1700000000007,
+				Duration: 7,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.golden, func(t *testing.T) {
+			want, err := os.ReadFile(filepath.Join("testdata", tt.golden))
+			if err != nil {
+				t.Fatalf("read golden file: %v", err)
+			}
+
+			got, err := json.MarshalIndent(tt.result.ActivationDoc(), "", "  ")
+			if err != nil {
+				t.Fatalf("marshal activation document: %v", err)
+			}
+			if !bytes.Equal(append(got, '\n'), want) {
+				t.Errorf("activation document differs from %s:\n%s", tt.golden, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package messaging
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// CapacityLimiter bounds concurrent invocations while guaranteeing reserved
+// slots to namespaces with a reservation. A reserved namespace uses its own
+// slots first and then competes for the shared best-effort slots; other
+// namespaces only ever get shared slots. Freed slots are handed to waiting
+// invocations round-robin by namespace, so a burst from one namespace
+// queues behind the others instead of taking every slot as it frees up
+type CapacityLimiter struct {
+	mu         sync.Mutex
+	shared     int                      // best-effort slots: total minus all reservations
+	sharedUsed int                      // best-effort slots in use
+	reserved   map[string]int           // namespace -> reserved slots
+	inUse      map[string]int           // namespace -> reserved slots in use
+	waiting    map[string][]*slotWaiter // namespace -> waiting invocations, oldest first
+	turns      []string                 // namespaces with waiters, next to be served first
+}
+
+// slotWaiter is an invocation waiting for a slot
+type slotWaiter struct {
+	granted chan struct{} // closed once a slot is handed over
+	free    func()        // bookkeeping to free the handed over slot
+}
+
+// NewCapacityLimiter creates a limiter over total slots with the given
+// per-namespace reservations, which may not exceed total
+func NewCapacityLimiter(total int, reservations map[string]int) (*CapacityLimiter, error) {
+	reservedTotal := 0
+	for namespace, slots := range reservations {
+		if slots < 0 {
+			return nil, fmt.Errorf("negative reservation for namespace %s", namespace)
+		}
+		reservedTotal += slots
+	}
+	if reservedTotal > total {
+		return nil, fmt.Errorf("reservations of %d slots exceed capacity of %d", reservedTotal, total)
+	}
+
+	return &CapacityLimiter{
+		shared:   total - reservedTotal,
+		reserved: reservations,
+		inUse:    make(map[string]int),
+		waiting:  make(map[string][]*slotWaiter),
+	}, nil
+}
+
+// Acquire blocks until a slot is available to the namespace or ctx is done,
+// returning a func that frees the slot
+func (l *CapacityLimiter) Acquire(ctx context.Context, namespace string) (func(), error) {
+	l.mu.Lock()
+	if free := l.take(namespace); free != nil {
+		l.mu.Unlock()
+		return l.releaseFunc(free), nil
+	}
+
+	w := &slotWaiter{granted: make(chan struct{})}
+	if len(l.waiting[namespace]) == 0 {
+		l.turns = append(l.turns, namespace)
+	}
+	l.waiting[namespace] = append(l.waiting[namespace], w)
+	l.mu.Unlock()
+
+	select {
+	case <-w.granted:
+		return l.releaseFunc(w.free), nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		select {
+		case <-w.granted:
+			// A slot was handed over as we gave up; pass it on
+			w.free()
+			l.serve()
+		default:
+			l.dropWaiter(namespace, w)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// take claims a free slot for the namespace, returning the bookkeeping to
+// free it, or nil when none is available
+// Must be called with lock held
+func (l *CapacityLimiter) take(namespace string) func() {
+	if l.inUse[namespace] < l.reserved[namespace] {
+		l.inUse[namespace]++
+		return func() { l.inUse[namespace]-- }
+	}
+	if l.sharedUsed < l.shared {
+		l.sharedUsed++
+		return func() { l.sharedUsed-- }
+	}
+	return nil
+}
+
+// serve hands free slots to waiting invocations, one namespace at a time.
+// A namespace that is served goes to the back of the line
+// Must be called with lock held
+func (l *CapacityLimiter) serve() {
+	for i := 0; i < len(l.turns); {
+		namespace := l.turns[i]
+		free := l.take(namespace)
+		if free == nil {
+			i++
+			continue
+		}
+
+		queue := l.waiting[namespace]
+		w := queue[0]
+		l.turns = append(l.turns[:i], l.turns[i+1:]...)
+		if len(queue) > 1 {
+			l.waiting[namespace] = queue[1:]
+			l.turns = append(l.turns, namespace)
+		} else {
+			delete(l.waiting, namespace)
+		}
+
+		w.free = free
+		close(w.granted)
+	}
+}
+
+// dropWaiter removes an invocation that gave up waiting
+// Must be called with lock held
+func (l *CapacityLimiter) dropWaiter(namespace string, w *slotWaiter) {
+	queue := l.waiting[namespace]
+	for i, queued := range queue {
+		if queued == w {
+			queue = append(queue[:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) > 0 {
+		l.waiting[namespace] = queue
+		return
+	}
+
+	delete(l.waiting, namespace)
+	for i, turn := range l.turns {
+		if turn == namespace {
+			l.turns = append(l.turns[:i], l.turns[i+1:]...)
+			break
+		}
+	}
+}
+
+// releaseFunc wraps a slot's bookkeeping so releasing it passes the slot to
+// the next waiter, and releasing twice is harmless
+func (l *CapacityLimiter) releaseFunc(free func()) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			free()
+			l.serve()
+		})
+	}
+}
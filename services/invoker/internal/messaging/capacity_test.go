@@ -0,0 +1,135 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// queueWaiter starts an Acquire for namespace and waits until it is queued.
+// The namespace is sent on order once the slot is granted, and the slot is
+// released when release is closed
+func queueWaiter(t *testing.T, l *CapacityLimiter, namespace string, order chan<- string, release <-chan struct{}) {
+	t.Helper()
+
+	l.mu.Lock()
+	queued := len(l.waiting[namespace])
+	l.mu.Unlock()
+
+	go func() {
+		free, err := l.Acquire(context.Background(), namespace)
+		if err != nil {
+			order <- "error: " + err.Error()
+			return
+		}
+		order <- namespace
+		<-release
+		free()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		l.mu.Lock()
+		n := len(l.waiting[namespace])
+		l.mu.Unlock()
+		if n > queued {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Acquire(%s) never queued", namespace)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCapacityLimiterServesNamespacesInTurn(t *testing.T) {
+	l, err := NewCapacityLimiter(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, err := l.Acquire(context.Background(), "busy")
+	if err != nil {
+		t.Fatalf("Acquire() = %v", err)
+	}
+
+	// A burst from one namespace queues ahead of a single invocation from
+	// another, which still gets the second slot to free up
+	order := make(chan string, 6)
+	release := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		queueWaiter(t, l, "busy", order, release)
+	}
+	queueWaiter(t, l, "quiet", order, release)
+
+	close(release)
+	held()
+
+	var got []string
+	for i := 0; i < 5; i++ {
+		select {
+		case namespace := <-order:
+			got = append(got, namespace)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("slots granted %v, then stalled", got)
+		}
+	}
+	want := []string{"busy", "quiet", "busy", "busy", "busy"}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("slots granted %v, want %v", got, want)
+		}
+	}
+}
+
+func TestCapacityLimiterReservedSlots(t *testing.T) {
+	l, err := NewCapacityLimiter(2, map[string]int{"gold": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The single shared slot is taken; gold still has its reservation
+	if _, err := l.Acquire(context.Background(), "other"); err != nil {
+		t.Fatalf("Acquire(other) = %v", err)
+	}
+	if _, err := l.Acquire(context.Background(), "gold"); err != nil {
+		t.Fatalf("Acquire(gold) = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "other"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire() past capacity = %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestCapacityLimiterCanceledWaiterLeavesQueue(t *testing.T) {
+	l, err := NewCapacityLimiter(1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	held, err := l.Acquire(context.Background(), "a")
+	if err != nil {
+		t.Fatalf("Acquire() = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.Acquire(ctx, "b"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Acquire() = %v, want context.DeadlineExceeded", err)
+	}
+
+	// The freed slot isn't handed to the waiter that gave up
+	held()
+	free, err := l.Acquire(context.Background(), "c")
+	if err != nil {
+		t.Fatalf("Acquire() = %v after the slot was freed", err)
+	}
+	free()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.waiting) != 0 || len(l.turns) != 0 || l.sharedUsed != 0 {
+		t.Fatalf("limiter left with waiters %v, turns %v, %d slots used", l.waiting, l.turns, l.sharedUsed)
+	}
+}
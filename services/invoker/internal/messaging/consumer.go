@@ -3,12 +3,14 @@ package messaging
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
-	"github.com/rs/zerolog/log"
+	"go.uber.org/zap"
 )
 
 const (
@@ -22,8 +24,45 @@ const (
 	BlockTimeout = 2000 * time.Millisecond
 	// MaxRetries for message processing
 	MaxRetries = 3
+	// DefaultUnreadyBackoff is how long the consumer stops reading after a
+	// retryable infrastructure failure
+	DefaultUnreadyBackoff = 30 * time.Second
+	// DefaultDedupTTL is how long an activation ID is remembered so a
+	// redelivered invocation is not run twice
+	DefaultDedupTTL = 10 * time.Minute
+	// DefaultStartPosition creates a missing consumer group at the end of
+	// the stream so a new deployment doesn't replay old invocations
+	DefaultStartPosition = "$"
+
+	// publishTimeout bounds result publishing, which outlives consumer
+	// cancellation so in-flight results still reach Redis on shutdown
+	publishTimeout = 10 * time.Second
+
+	// leaveGroupTimeout bounds requeueing pending messages and leaving the
+	// consumer group on stop
+	leaveGroupTimeout = 10 * time.Second
+	// leaveGroupBatch is how many pending entries are fetched at a time
+	leaveGroupBatch = 100
+
+	dedupKeyPrefix  = "penguinwhisk:dedup:"
+	dedupInProgress = "in_progress"
 )
 
+// RetryableError marks an invocation failure caused by invoker-side
+// infrastructure. The message is requeued on the stream instead of producing
+// a failed activation, so this or another invoker runs it again
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return fmt.Sprintf("retryable: %v", e.Err)
+}
+
+func (e *RetryableError) Unwrap() error {
+	return e.Err
+}
+
 // InvocationHandler processes invocation requests
 type InvocationHandler interface {
 	HandleInvocation(ctx context.Context, msg *InvocationMessage) (*ActivationResult, error)
@@ -37,23 +76,41 @@ type Consumer struct {
 	groupName    string
 	consumerName string
 	handler      InvocationHandler
+	publisher    *Publisher
+	logger       *zap.Logger
 
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
 	mu     sync.Mutex
 	active int
+
+	unreadyBackoff time.Duration
+	unreadyUntil   time.Time
+	paused         bool
+
+	dedupTTL      time.Duration
+	deadlineGrace time.Duration
+	startPosition string // stream ID a newly created consumer group reads from
+	capacity      *CapacityLimiter
+	events        *EventEmitter // nil unless lifecycle events are enabled
+	leaveGroup    bool          // delete this consumer from the group on stop
 }
 
 // InvocationMessage represents an invocation request
 type InvocationMessage struct {
-	ActivationID    string            `json:"activation_id"`
-	Action          ActionSpec        `json:"action"`
-	Params          map[string]any    `json:"params"`
-	Blocking        bool              `json:"blocking"`
-	ResponseChannel string            `json:"response_channel,omitempty"`
-	Deadline        int64             `json:"deadline"`
-	Context         InvocationContext `json:"context"`
+	ActivationID     string            `json:"activation_id"`
+	Action           ActionSpec        `json:"action"`
+	Params           map[string]any    `json:"params"`
+	Blocking         bool              `json:"blocking"`
+	ResponseChannel  string            `json:"response_channel,omitempty"`
+	Deadline         int64             `json:"deadline"`
+	Context          InvocationContext `json:"context"`
+	Stream           bool              `json:"stream,omitempty"`            // forward partial results while running
+	ResultProjection string            `json:"result_projection,omitempty"` // JSONPath applied to the result, e.g. $.data.items[0]
+	IncludeLogs      bool              `json:"include_logs,omitempty"`      // return logs inline on the blocking response
+	RawInput         bool              `json:"raw_input,omitempty"`         // pass RawBody to the action instead of the params
+	RawBody          []byte            `json:"raw_body,omitempty"`          // base64 in JSON
 }
 
 // ActionSpec describes the action to invoke
@@ -64,48 +121,63 @@ type ActionSpec struct {
 	Exec       ExecSpec       `json:"exec"`
 	Limits     LimitsSpec     `json:"limits"`
 	Parameters map[string]any `json:"parameters,omitempty"`
+	Cacheable  bool           `json:"cacheable,omitempty"` // results depend only on params, safe to memoize
+	CacheTTL   int            `json:"cache_ttl,omitempty"` // seconds a memoized result stays valid
 }
 
 // ExecSpec describes action execution metadata
 type ExecSpec struct {
-	Kind       string `json:"kind"`
-	Code       string `json:"code,omitempty"`
-	Image      string `json:"image,omitempty"`
-	Main       string `json:"main,omitempty"`
-	Binary     bool   `json:"binary,omitempty"`
-	Entrypoint string `json:"entrypoint,omitempty"`
+	Kind       string            `json:"kind"`
+	Code       string            `json:"code,omitempty"`
+	Image      string            `json:"image,omitempty"`
+	Main       string            `json:"main,omitempty"`
+	Binary     bool              `json:"binary,omitempty"`
+	Entrypoint string            `json:"entrypoint,omitempty"`
+	BuildFlags []string          `json:"build_flags,omitempty"` // e.g. -trimpath, -ldflags=-s -w, -tags=...
+	Env        map[string]string `json:"env,omitempty"`         // environment variables set for the action
+
+	CodeURL       string `json:"code_url,omitempty"`       // presigned URL the code is fetched from, when not inline
+	CodeSignature string `json:"code_signature,omitempty"` // base64 ed25519 signature over the code
+	Network       string `json:"network,omitempty"`        // container network mode, e.g. none
+	StdinFormat   string `json:"stdin_format,omitempty"`   // how params are framed on stdin: json, json-line or length-prefixed
+	StdinProtocol string `json:"stdin_protocol,omitempty"` // env, or actionloop to send activation metadata on stdin too
 }
 
 // LimitsSpec defines resource limits
 type LimitsSpec struct {
-	Timeout     int `json:"timeout"`      // milliseconds
-	Memory      int `json:"memory"`       // megabytes
-	Concurrency int `json:"concurrency"`  // max concurrent activations
-	Logs        int `json:"logs"`         // kilobytes
+	Timeout     int `json:"timeout"`     // milliseconds
+	Memory      int `json:"memory"`      // megabytes
+	Concurrency int `json:"concurrency"` // max concurrent activations
+	Logs        int `json:"logs"`        // kilobytes
 }
 
 // InvocationContext provides invocation metadata
 type InvocationContext struct {
-	Namespace   string `json:"namespace"`
-	ActionName  string `json:"action_name"`
+	Namespace    string `json:"namespace"`
+	ActionName   string `json:"action_name"`
 	ActivationID string `json:"activation_id"`
-	APIHost     string `json:"api_host"`
-	APIKey      string `json:"api_key,omitempty"`
-	Deadline    int64  `json:"deadline"`
+	APIHost      string `json:"api_host"`
+	APIKey       string `json:"api_key,omitempty"`
+	Deadline     int64  `json:"deadline"`
+
+	// TransactionID and TraceParent correlate runtime logs with the
+	// controller's request; the activation ID stands in when unset
+	TransactionID string `json:"transaction_id,omitempty"`
+	TraceParent   string `json:"traceparent,omitempty"`
 }
 
 // ActivationResult represents the result of an invocation
 type ActivationResult struct {
-	ActivationID string         `json:"activation_id"`
-	Namespace    string         `json:"namespace"`
-	Name         string         `json:"name"`
-	Version      string         `json:"version"`
-	Response     Response       `json:"response"`
-	Start        int64          `json:"start"`
-	End          int64          `json:"end"`
-	Duration     int64          `json:"duration"`
-	Annotations  []Annotation   `json:"annotations,omitempty"`
-	Logs         []string       `json:"logs,omitempty"`
+	ActivationID string       `json:"activation_id"`
+	Namespace    string       `json:"namespace"`
+	Name         string       `json:"name"`
+	Version      string       `json:"version"`
+	Response     Response     `json:"response"`
+	Start        int64        `json:"start"`
+	End          int64        `json:"end"`
+	Duration     int64        `json:"duration"`
+	Annotations  []Annotation `json:"annotations,omitempty"`
+	Logs         []string     `json:"logs,omitempty"`
 }
 
 // Response contains activation result
@@ -114,6 +186,7 @@ type Response struct {
 	Success    bool           `json:"success"`
 	Result     map[string]any `json:"result,omitempty"`
 	Error      string         `json:"error,omitempty"`
+	ExitCode   int            `json:"exitCode,omitempty"` // exit code of a failed action process
 }
 
 // Annotation represents activation metadata
@@ -122,8 +195,19 @@ type Annotation struct {
 	Value any    `json:"value"`
 }
 
+// setAnnotation sets an annotation, replacing any existing one with the key
+func (r *ActivationResult) setAnnotation(key string, value any) {
+	for i := range r.Annotations {
+		if r.Annotations[i].Key == key {
+			r.Annotations[i].Value = value
+			return
+		}
+	}
+	r.Annotations = append(r.Annotations, Annotation{Key: key, Value: value})
+}
+
 // NewConsumer creates a new Redis Streams consumer
-func NewConsumer(redisURL, invokerID string, handler InvocationHandler) (*Consumer, error) {
+func NewConsumer(redisURL, invokerID string, handler InvocationHandler, logger *zap.Logger) (*Consumer, error) {
 	opts, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, fmt.Errorf("parse redis URL: %w", err)
@@ -145,54 +229,77 @@ func NewConsumer(redisURL, invokerID string, handler InvocationHandler) (*Consum
 		groupName:    GroupName,
 		consumerName: fmt.Sprintf("invoker-%s", invokerID),
 		handler:      handler,
-	}
+		publisher:    NewPublisher(client),
+		logger:       logger,
 
-	if err := c.ensureConsumerGroup(ctx); err != nil {
-		return nil, fmt.Errorf("ensure consumer group: %w", err)
+		unreadyBackoff: DefaultUnreadyBackoff,
+		dedupTTL:       DefaultDedupTTL,
+		startPosition:  DefaultStartPosition,
 	}
 
-	log.Info().
-		Str("invoker_id", invokerID).
-		Str("stream", StreamName).
-		Str("group", GroupName).
-		Str("consumer", c.consumerName).
-		Msg("Consumer initialized")
+	c.logger.Info("Consumer initialized",
+		zap.String("invoker_id", invokerID),
+		zap.String("stream", StreamName),
+		zap.String("group", GroupName),
+		zap.String("consumer", c.consumerName))
 
 	return c, nil
 }
 
 // ensureConsumerGroup creates the consumer group if it doesn't exist
 func (c *Consumer) ensureConsumerGroup(ctx context.Context) error {
-	err := c.redisClient.XGroupCreateMkStream(ctx, c.streamName, c.groupName, "0").Err()
-	if err != nil && err.Error() != "BUSYGROUP Consumer Group name already exists" {
+	err := c.redisClient.XGroupCreateMkStream(ctx, c.streamName, c.groupName, c.startPosition).Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
 		return fmt.Errorf("create consumer group: %w", err)
 	}
 
-	log.Debug().
-		Str("stream", c.streamName).
-		Str("group", c.groupName).
-		Msg("Consumer group ready")
+	c.logger.Debug("Consumer group ready",
+		zap.String("stream", c.streamName),
+		zap.String("group", c.groupName),
+		zap.String("start_position", c.startPosition))
 
 	return nil
 }
 
+// isNoGroupError reports whether Redis rejected a read because the stream or
+// consumer group no longer exists
+func isNoGroupError(err error) bool {
+	return err != nil && strings.HasPrefix(err.Error(), "NOGROUP")
+}
+
 // Start begins consuming messages from the stream
 func (c *Consumer) Start(ctx context.Context) error {
 	c.ctx, c.cancel = context.WithCancel(ctx)
 
-	log.Info().
-		Str("consumer", c.consumerName).
-		Msg("Starting consumer")
+	c.logger.Info("Starting consumer",
+		zap.String("consumer", c.consumerName))
+
+	if err := c.ensureConsumerGroup(c.ctx); err != nil {
+		return fmt.Errorf("ensure consumer group: %w", err)
+	}
+
+	// Pick up where a previous run under this consumer name left off
+	if err := c.recoverPending(); err != nil {
+		c.logger.Error("Failed to recover pending messages",
+			zap.Error(err))
+	}
 
 	for {
 		select {
 		case <-c.ctx.Done():
-			log.Info().Msg("Consumer shutdown requested")
+			c.logger.Info("Consumer shutdown requested")
 			c.wg.Wait()
 			return c.ctx.Err()
 		default:
+			// Stop pulling new work while the invoker can't run it
+			if !c.IsReady() {
+				time.Sleep(time.Second)
+				continue
+			}
+
 			if err := c.readMessages(); err != nil {
-				log.Error().Err(err).Msg("Error reading messages")
+				c.logger.Error("Error reading messages",
+					zap.Error(err))
 				time.Sleep(time.Second)
 			}
 		}
@@ -213,66 +320,211 @@ func (c *Consumer) readMessages() error {
 		if err == redis.Nil {
 			return nil
 		}
+		// The stream or group was deleted out from under us; recreate it
+		if isNoGroupError(err) {
+			c.logger.Warn("Consumer group missing, recreating",
+				zap.String("stream", c.streamName),
+				zap.String("group", c.groupName))
+			if err := c.ensureConsumerGroup(c.ctx); err != nil {
+				return fmt.Errorf("recreate consumer group: %w", err)
+			}
+			return nil
+		}
 		return fmt.Errorf("xreadgroup: %w", err)
 	}
 
 	for _, stream := range streams {
-		for _, message := range stream.Messages {
-			c.wg.Add(1)
-			c.incrementActive()
-
-			go func(msg redis.XMessage) {
-				defer c.wg.Done()
-				defer c.decrementActive()
-				c.processMessage(c.ctx, msg)
-			}(message)
+		c.dispatch(stream.Messages)
+	}
+
+	return nil
+}
+
+// dispatch processes a batch of messages concurrently
+func (c *Consumer) dispatch(messages []redis.XMessage) {
+	for _, message := range c.fairOrder(messages) {
+		c.wg.Add(1)
+		c.incrementActive()
+
+		go func(msg redis.XMessage) {
+			defer c.wg.Done()
+			defer c.decrementActive()
+			c.processMessage(c.ctx, msg)
+		}(message)
+	}
+}
+
+// recoverPending reprocesses messages still pending for this consumer from
+// a previous run, which crashed or was killed before acking them. The
+// consumer name is derived from the invoker ID, so a restarted invoker reads
+// its own pending entries back by reading its history from ID 0 before it
+// moves on to new messages
+func (c *Consumer) recoverPending() error {
+	lastID := "0"
+	recovered := 0
+	for {
+		streams, err := c.redisClient.XReadGroup(c.ctx, &redis.XReadGroupArgs{
+			Group:    c.groupName,
+			Consumer: c.consumerName,
+			Streams:  []string{c.streamName, lastID},
+			Count:    10,
+			Block:    -1, // history reads never block
+		}).Result()
+		if err != nil && err != redis.Nil {
+			return fmt.Errorf("xreadgroup pending: %w", err)
 		}
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			break
+		}
+
+		messages := streams[0].Messages
+		lastID = messages[len(messages)-1].ID
+		live := make([]redis.XMessage, 0, len(messages))
+		for _, msg := range messages {
+			// Entries trimmed from the stream come back without values
+			if len(msg.Values) == 0 {
+				c.ackMessage(c.ctx, msg.ID)
+				continue
+			}
+			c.releaseStaleClaim(c.ctx, msg)
+			live = append(live, msg)
+		}
+		c.dispatch(live)
+		recovered += len(live)
 	}
 
+	if recovered > 0 {
+		c.logger.Info("Recovered pending messages from previous run",
+			zap.String("consumer", c.consumerName),
+			zap.Int("count", recovered))
+	}
 	return nil
 }
 
+// releaseStaleClaim drops the in-progress dedup claim of a message being
+// recovered. The claim was taken by this consumer's previous run, which died
+// before finishing, and would otherwise hold the message until it expires.
+// Completed results are kept so the message is answered from them
+func (c *Consumer) releaseStaleClaim(ctx context.Context, msg redis.XMessage) {
+	if c.dedupTTL <= 0 {
+		return
+	}
+	invMsg, err := c.parseInvocationMessage(msg.Values)
+	if err != nil {
+		return
+	}
+	key := dedupKeyPrefix + invMsg.ActivationID
+	if stored, err := c.redisClient.Get(ctx, key).Result(); err == nil && stored == dedupInProgress {
+		c.releaseActivation(ctx, invMsg.ActivationID)
+	}
+}
+
+// fairOrder interleaves a batch round-robin by namespace so a burst from one
+// namespace isn't started ahead of other tenants. Which invocation gets a
+// concurrency slot is decided by the capacity limiter, which hands out freed
+// slots round-robin by namespace as well
+func (c *Consumer) fairOrder(messages []redis.XMessage) []redis.XMessage {
+	namespaces := make([]string, 0)
+	byNamespace := make(map[string][]redis.XMessage)
+
+	for _, msg := range messages {
+		// Unparseable messages share one bucket and are rejected on processing
+		namespace := ""
+		if invMsg, err := c.parseInvocationMessage(msg.Values); err == nil {
+			namespace = invMsg.Action.Namespace
+		}
+
+		if _, seen := byNamespace[namespace]; !seen {
+			namespaces = append(namespaces, namespace)
+		}
+		byNamespace[namespace] = append(byNamespace[namespace], msg)
+	}
+
+	ordered := make([]redis.XMessage, 0, len(messages))
+	for len(ordered) < len(messages) {
+		for _, namespace := range namespaces {
+			if queue := byNamespace[namespace]; len(queue) > 0 {
+				ordered = append(ordered, queue[0])
+				byNamespace[namespace] = queue[1:]
+			}
+		}
+	}
+
+	return ordered
+}
+
 // processMessage processes a single message
 func (c *Consumer) processMessage(ctx context.Context, msg redis.XMessage) {
-	log.Debug().
-		Str("message_id", msg.ID).
-		Interface("values", msg.Values).
-		Msg("Processing message")
+	c.logger.Debug("Processing message",
+		zap.String("message_id", msg.ID),
+		zap.Any("values", msg.Values))
 
 	// Parse invocation message
 	invMsg, err := c.parseInvocationMessage(msg.Values)
 	if err != nil {
-		log.Error().
-			Err(err).
-			Str("message_id", msg.ID).
-			Msg("Failed to parse invocation message")
+		c.logger.Error("Failed to parse invocation message",
+			zap.Error(err),
+			zap.String("message_id", msg.ID))
 		c.ackMessage(ctx, msg.ID)
 		return
 	}
 
-	// Check deadline
-	if time.Now().UnixMilli() > invMsg.Deadline {
-		log.Warn().
-			Str("activation_id", invMsg.ActivationID).
-			Int64("deadline", invMsg.Deadline).
-			Msg("Invocation already past deadline")
+	// Skip activations this or another invoker has already claimed
+	if !c.claimActivation(ctx, msg.ID, invMsg.ActivationID) {
+		return
+	}
+
+	// Check deadline, allowing for clock skew with the controller
+	if time.Now().UnixMilli() > invMsg.Deadline+c.deadlineGrace.Milliseconds() {
+		c.logger.Warn("Invocation already past deadline",
+			zap.String("activation_id", invMsg.ActivationID),
+			zap.Int64("deadline", invMsg.Deadline))
 		c.ackMessage(ctx, msg.ID)
 		c.publishErrorResult(ctx, invMsg, "Invocation deadline exceeded")
 		return
 	}
 
 	// Create invocation context with timeout
-	deadline := time.UnixMilli(invMsg.Deadline)
+	deadline := time.UnixMilli(invMsg.Deadline).Add(c.deadlineGrace)
 	invCtx, cancel := context.WithDeadline(ctx, deadline)
 	defer cancel()
 
+	// Wait for a concurrency slot, which reserved namespaces always have
+	if c.capacity != nil {
+		release, err := c.capacity.Acquire(invCtx, invMsg.Action.Namespace)
+		if err != nil {
+			c.logger.Warn("No capacity before invocation deadline",
+				zap.String("activation_id", invMsg.ActivationID),
+				zap.String("namespace", invMsg.Action.Namespace))
+			c.ackMessage(ctx, msg.ID)
+			c.publishErrorResult(ctx, invMsg, "Invocation deadline exceeded waiting for capacity")
+			return
+		}
+		defer release()
+	}
+
+	if c.events != nil {
+		c.events.InvocationStarted(ctx, invMsg)
+	}
+
 	// Handle invocation
 	result, err := c.handler.HandleInvocation(invCtx, invMsg)
+
+	var retryable *RetryableError
+	if errors.As(err, &retryable) {
+		c.logger.Warn("Invoker unable to run invocation, requeueing message",
+			zap.Error(err),
+			zap.String("activation_id", invMsg.ActivationID),
+			zap.String("message_id", msg.ID),
+			zap.Duration("backoff", c.unreadyBackoff))
+		c.retryLater(ctx, msg.ID, invMsg.ActivationID)
+		return
+	}
+
 	if err != nil {
-		log.Error().
-			Err(err).
-			Str("activation_id", invMsg.ActivationID).
-			Msg("Invocation failed")
+		c.logger.Error("Invocation failed",
+			zap.Error(err),
+			zap.String("activation_id", invMsg.ActivationID))
 
 		result = &ActivationResult{
 			ActivationID: invMsg.ActivationID,
@@ -289,22 +541,128 @@ func (c *Consumer) processMessage(ctx context.Context, msg redis.XMessage) {
 		}
 	}
 
+	// Publish even if the consumer is stopping so the result isn't dropped
+	ctx, cancelPublish := context.WithTimeout(context.WithoutCancel(ctx), publishTimeout)
+	defer cancelPublish()
+
 	// Publish result to activations stream
 	if err := c.publishResult(ctx, result); err != nil {
-		log.Error().
-			Err(err).
-			Str("activation_id", invMsg.ActivationID).
-			Msg("Failed to publish result")
+		c.logger.Error("Failed to publish result",
+			zap.Error(err),
+			zap.String("activation_id", invMsg.ActivationID))
+	}
+	c.recordActivationResult(ctx, result)
+
+	// Answer the waiting caller of a blocking invocation
+	if invMsg.Blocking && invMsg.ResponseChannel != "" {
+		if err := c.publishResponse(ctx, invMsg, result); err != nil {
+			c.logger.Error("Failed to publish blocking response",
+				zap.Error(err),
+				zap.String("activation_id", invMsg.ActivationID),
+				zap.String("channel", invMsg.ResponseChannel))
+		}
 	}
 
 	// Acknowledge message
 	c.ackMessage(ctx, msg.ID)
 
-	log.Info().
-		Str("activation_id", invMsg.ActivationID).
-		Bool("success", result.Response.Success).
-		Int64("duration_ms", result.Duration).
-		Msg("Invocation completed")
+	if c.events != nil {
+		c.events.InvocationCompleted(ctx, invMsg, result)
+	}
+
+	c.logger.Info("Invocation completed",
+		zap.String("activation_id", invMsg.ActivationID),
+		zap.Bool("success", result.Response.Success),
+		zap.Int64("duration_ms", result.Duration))
+}
+
+// retryLater hands an invocation this invoker couldn't run back to the
+// group: the message is re-added to the stream for any invoker to read, and
+// this one backs off reading. If the requeue fails the message stays
+// pending and is recovered on the next start
+func (c *Consumer) retryLater(ctx context.Context, messageID, activationID string) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), publishTimeout)
+	defer cancel()
+
+	c.releaseActivation(ctx, activationID)
+	if err := c.requeueMessage(ctx, messageID); err != nil {
+		c.logger.Error("Failed to requeue invocation, leaving message pending",
+			zap.Error(err),
+			zap.String("activation_id", activationID),
+			zap.String("message_id", messageID))
+	}
+	c.markUnready()
+}
+
+// claimActivation records the activation ID as seen, returning false if it
+// was already claimed. A duplicate whose result is known gets that result
+// republished and is acked; one still in progress is left pending
+func (c *Consumer) claimActivation(ctx context.Context, messageID, activationID string) bool {
+	if c.dedupTTL <= 0 {
+		return true
+	}
+
+	key := dedupKeyPrefix + activationID
+	claimed, err := c.redisClient.SetNX(ctx, key, dedupInProgress, c.dedupTTL).Result()
+	if err != nil {
+		c.logger.Warn("Failed to record activation for deduplication",
+			zap.Error(err),
+			zap.String("activation_id", activationID))
+		return true
+	}
+	if claimed {
+		return true
+	}
+
+	stored, err := c.redisClient.Get(ctx, key).Result()
+	if err != nil || stored == dedupInProgress {
+		c.logger.Warn("Duplicate invocation still in progress, leaving message pending",
+			zap.String("activation_id", activationID),
+			zap.String("message_id", messageID))
+		return false
+	}
+
+	var result ActivationResult
+	if err := json.Unmarshal([]byte(stored), &result); err != nil {
+		c.logger.Error("Failed to decode deduplicated result",
+			zap.Error(err),
+			zap.String("activation_id", activationID))
+	} else if err := c.publishResult(ctx, &result); err != nil {
+		c.logger.Error("Failed to republish deduplicated result",
+			zap.Error(err),
+			zap.String("activation_id", activationID))
+	}
+
+	c.logger.Info("Skipped duplicate invocation",
+		zap.String("activation_id", activationID),
+		zap.String("message_id", messageID))
+	c.ackMessage(ctx, messageID)
+	return false
+}
+
+// recordActivationResult stores the result so duplicates can republish it
+func (c *Consumer) recordActivationResult(ctx context.Context, result *ActivationResult) {
+	if c.dedupTTL <= 0 {
+		return
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return
+	}
+	if err := c.redisClient.Set(ctx, dedupKeyPrefix+result.ActivationID, data, c.dedupTTL).Err(); err != nil {
+		c.logger.Warn("Failed to record activation result for deduplication",
+			zap.Error(err),
+			zap.String("activation_id", result.ActivationID))
+	}
+}
+
+// releaseActivation forgets an activation so a redelivery can run it
+func (c *Consumer) releaseActivation(ctx context.Context, activationID string) {
+	if c.dedupTTL <= 0 {
+		return
+	}
+	c.redisClient.Del(ctx, dedupKeyPrefix+activationID)
 }
 
 // parseInvocationMessage parses message values into InvocationMessage
@@ -324,26 +682,18 @@ func (c *Consumer) parseInvocationMessage(values map[string]any) (*InvocationMes
 
 // publishResult publishes activation result to activations stream
 func (c *Consumer) publishResult(ctx context.Context, result *ActivationResult) error {
-	data, err := json.Marshal(result)
-	if err != nil {
-		return fmt.Errorf("marshal result: %w", err)
-	}
-
-	err = c.redisClient.XAdd(ctx, &redis.XAddArgs{
-		Stream: ActivationsStream,
-		Values: map[string]any{
-			"activation_id": result.ActivationID,
-			"namespace":     result.Namespace,
-			"success":       result.Response.Success,
-			"data":          string(data),
-		},
-	}).Err()
+	return c.publisher.PublishActivation(ctx, result)
+}
 
-	if err != nil {
-		return fmt.Errorf("xadd to activations stream: %w", err)
+// publishResponse sends the result to a blocking invocation's response
+// channel, with logs only when the caller asked for them inline
+func (c *Consumer) publishResponse(ctx context.Context, msg *InvocationMessage, result *ActivationResult) error {
+	response := *result
+	if !msg.IncludeLogs {
+		response.Logs = nil
 	}
 
-	return nil
+	return c.publisher.PublishToChannel(ctx, msg.ResponseChannel, &response)
 }
 
 // publishErrorResult publishes an error result
@@ -363,10 +713,9 @@ func (c *Consumer) publishErrorResult(ctx context.Context, msg *InvocationMessag
 	}
 
 	if err := c.publishResult(ctx, result); err != nil {
-		log.Error().
-			Err(err).
-			Str("activation_id", msg.ActivationID).
-			Msg("Failed to publish error result")
+		c.logger.Error("Failed to publish error result",
+			zap.Error(err),
+			zap.String("activation_id", msg.ActivationID))
 	}
 }
 
@@ -374,16 +723,17 @@ func (c *Consumer) publishErrorResult(ctx context.Context, msg *InvocationMessag
 func (c *Consumer) ackMessage(ctx context.Context, messageID string) {
 	err := c.redisClient.XAck(ctx, c.streamName, c.groupName, messageID).Err()
 	if err != nil {
-		log.Error().
-			Err(err).
-			Str("message_id", messageID).
-			Msg("Failed to acknowledge message")
+		c.logger.Error("Failed to acknowledge message",
+			zap.Error(err),
+			zap.String("message_id", messageID))
 	}
 }
 
-// Stop gracefully stops the consumer
-func (c *Consumer) Stop() {
-	log.Info().Msg("Stopping consumer")
+// Stop gracefully stops the consumer. When leaving the group on stop is
+// enabled, it returns the IDs of messages still pending for this consumer
+// once in-flight invocations have drained
+func (c *Consumer) Stop() []string {
+	c.logger.Info("Stopping consumer")
 
 	if c.cancel != nil {
 		c.cancel()
@@ -391,13 +741,94 @@ func (c *Consumer) Stop() {
 
 	c.wg.Wait()
 
+	var pending []string
+	if c.leaveGroup && c.redisClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), leaveGroupTimeout)
+		pending = c.leaveConsumerGroup(ctx)
+		cancel()
+	}
+
 	if c.redisClient != nil {
 		if err := c.redisClient.Close(); err != nil {
-			log.Error().Err(err).Msg("Error closing redis client")
+			c.logger.Error("Error closing redis client",
+				zap.Error(err))
+		}
+	}
+
+	c.logger.Info("Consumer stopped")
+	return pending
+}
+
+// leaveConsumerGroup removes this consumer from the group with XGROUP
+// DELCONSUMER. Deleting a consumer drops its pending entries from the group,
+// so each is first re-added to the stream for other invokers and acked. If
+// requeueing fails the consumer stays in the group so its entries can still
+// be claimed. Returns the IDs of the messages that were pending
+func (c *Consumer) leaveConsumerGroup(ctx context.Context) []string {
+	var pending []string
+	for {
+		entries, err := c.redisClient.XPendingExt(ctx, &redis.XPendingExtArgs{
+			Stream:   c.streamName,
+			Group:    c.groupName,
+			Consumer: c.consumerName,
+			Start:    "-",
+			End:      "+",
+			Count:    leaveGroupBatch,
+		}).Result()
+		if err != nil && err != redis.Nil {
+			c.logger.Error("Failed to list pending messages, staying in consumer group",
+				zap.Error(err),
+				zap.String("consumer", c.consumerName))
+			return pending
 		}
+		if len(entries) == 0 {
+			break
+		}
+
+		for _, entry := range entries {
+			pending = append(pending, entry.ID)
+			if err := c.requeueMessage(ctx, entry.ID); err != nil {
+				c.logger.Error("Failed to requeue pending message, staying in consumer group",
+					zap.Error(err),
+					zap.String("message_id", entry.ID))
+				return pending
+			}
+		}
+	}
+
+	if err := c.redisClient.XGroupDelConsumer(ctx, c.streamName, c.groupName, c.consumerName).Err(); err != nil {
+		c.logger.Error("Failed to leave consumer group",
+			zap.Error(err),
+			zap.String("consumer", c.consumerName))
+		return pending
 	}
 
-	log.Info().Msg("Consumer stopped")
+	c.logger.Info("Left consumer group",
+		zap.String("consumer", c.consumerName),
+		zap.Int("requeued", len(pending)))
+	return pending
+}
+
+// requeueMessage re-adds a pending message to the stream as a new entry and
+// acks the original. Entries already trimmed from the stream are just acked
+func (c *Consumer) requeueMessage(ctx context.Context, messageID string) error {
+	messages, err := c.redisClient.XRangeN(ctx, c.streamName, messageID, messageID, 1).Result()
+	if err != nil {
+		return fmt.Errorf("read message: %w", err)
+	}
+	if len(messages) > 0 {
+		err := c.redisClient.XAdd(ctx, &redis.XAddArgs{
+			Stream: c.streamName,
+			Values: messages[0].Values,
+		}).Err()
+		if err != nil {
+			return fmt.Errorf("re-add message: %w", err)
+		}
+	}
+	if err := c.redisClient.XAck(ctx, c.streamName, c.groupName, messageID).Err(); err != nil {
+		return fmt.Errorf("ack message: %w", err)
+	}
+	return nil
 }
 
 // GetActiveInvocations returns the count of active invocations
@@ -407,6 +838,99 @@ func (c *Consumer) GetActiveInvocations() int {
 	return c.active
 }
 
+// IsReady reports whether the consumer is accepting new work
+func (c *Consumer) IsReady() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return !c.paused && time.Now().After(c.unreadyUntil)
+}
+
+// Pause stops reading new messages; in-flight invocations keep running
+func (c *Consumer) Pause() {
+	c.mu.Lock()
+	c.paused = true
+	c.mu.Unlock()
+
+	c.logger.Info("Consumer paused",
+		zap.String("consumer", c.consumerName))
+}
+
+// Resume restarts reading new messages after Pause
+func (c *Consumer) Resume() {
+	c.mu.Lock()
+	c.paused = false
+	c.mu.Unlock()
+
+	c.logger.Info("Consumer resumed",
+		zap.String("consumer", c.consumerName))
+}
+
+// IsPaused reports whether consumption was paused by an operator
+func (c *Consumer) IsPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.paused
+}
+
+// markUnready stops reading new messages for the unready backoff
+func (c *Consumer) markUnready() {
+	c.mu.Lock()
+	c.unreadyUntil = time.Now().Add(c.unreadyBackoff)
+	c.mu.Unlock()
+}
+
+// SetUnreadyBackoff configures how long reads pause after a retryable failure
+func (c *Consumer) SetUnreadyBackoff(backoff time.Duration) {
+	c.mu.Lock()
+	c.unreadyBackoff = backoff
+	c.mu.Unlock()
+}
+
+// SetDedupTTL configures how long activation IDs are remembered for
+// deduplication; zero disables it
+func (c *Consumer) SetDedupTTL(ttl time.Duration) {
+	c.dedupTTL = ttl
+}
+
+// SetDeadlineGrace configures how far past its deadline a message may be
+// received before it is dropped, to tolerate controller clock skew
+func (c *Consumer) SetDeadlineGrace(grace time.Duration) {
+	c.deadlineGrace = grace
+}
+
+// SetCapacityLimiter bounds concurrent invocations, honoring the limiter's
+// per-namespace reservations
+func (c *Consumer) SetCapacityLimiter(capacity *CapacityLimiter) {
+	c.capacity = capacity
+}
+
+// SetPublisher replaces the publisher results are written through, so they
+// get its retention, compression and recent activation lookup
+func (c *Consumer) SetPublisher(publisher *Publisher) {
+	c.publisher = publisher
+}
+
+// SetEventEmitter enables invocation.started and invocation.completed events
+func (c *Consumer) SetEventEmitter(events *EventEmitter) {
+	c.events = events
+}
+
+// SetLeaveGroupOnStop makes Stop requeue this consumer's pending messages
+// and delete it from the consumer group, for invokers that are scaled down
+// rather than restarted
+func (c *Consumer) SetLeaveGroupOnStop(leave bool) {
+	c.leaveGroup = leave
+}
+
+// SetStartPosition configures where a newly created consumer group starts
+// reading: "$" for only new invocations, "0" for the whole stream history.
+// It has no effect on a group that already exists
+func (c *Consumer) SetStartPosition(position string) {
+	if position != "" {
+		c.startPosition = position
+	}
+}
+
 // incrementActive increments active invocation counter
 func (c *Consumer) incrementActive() {
 	c.mu.Lock()
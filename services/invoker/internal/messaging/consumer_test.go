@@ -0,0 +1,498 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+// handlerFunc adapts a function to InvocationHandler
+type handlerFunc func(ctx context.Context, msg *InvocationMessage) (*ActivationResult, error)
+
+func (f handlerFunc) HandleInvocation(ctx context.Context, msg *InvocationMessage) (*ActivationResult, error) {
+	return f(ctx, msg)
+}
+
+// newTestRedis starts an in-memory Redis and returns a client for it
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+// newTestConsumer builds a consumer reading from client with deduplication
+// disabled, as NewConsumer would without dialing
+func newTestConsumer(t *testing.T, client *redis.Client, handler InvocationHandler) *Consumer {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	c := &Consumer{
+		redisClient:    client,
+		invokerID:      "test",
+		streamName:     StreamName,
+		groupName:      GroupName,
+		consumerName:   "invoker-test",
+		handler:        handler,
+		publisher:      NewPublisher(client),
+		logger:         zap.NewNop(),
+		ctx:            ctx,
+		cancel:         cancel,
+		unreadyBackoff: DefaultUnreadyBackoff,
+		startPosition:  "0",
+	}
+	if err := c.ensureConsumerGroup(ctx); err != nil {
+		t.Fatalf("ensureConsumerGroup: %v", err)
+	}
+	return c
+}
+
+// succeed is a handler returning a successful activation echoing the params
+func succeed(_ context.Context, msg *InvocationMessage) (*ActivationResult, error) {
+	return &ActivationResult{
+		ActivationID: msg.ActivationID,
+		Namespace:    msg.Action.Namespace,
+		Name:         msg.Action.Name,
+		Response: Response{
+			Success: true,
+			Result:  msg.Params,
+		},
+		Logs: []string{"stdout: ran"},
+	}, nil
+}
+
+// countingHandler runs invocations with succeed, counting them per
+// activation ID
+type countingHandler struct {
+	mu   sync.Mutex
+	runs map[string]int
+}
+
+func (h *countingHandler) HandleInvocation(ctx context.Context, msg *InvocationMessage) (*ActivationResult, error) {
+	h.mu.Lock()
+	if h.runs == nil {
+		h.runs = make(map[string]int)
+	}
+	h.runs[msg.ActivationID]++
+	h.mu.Unlock()
+	return succeed(ctx, msg)
+}
+
+// count returns how many times an activation ran
+func (h *countingHandler) count(activationID string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.runs[activationID]
+}
+
+// testInvocation returns an invocation of namespace/echo due in a minute
+func testInvocation(activationID, namespace string) *InvocationMessage {
+	return &InvocationMessage{
+		ActivationID: activationID,
+		Action: ActionSpec{
+			Namespace: namespace,
+			Name:      "echo",
+		},
+		Params:   map[string]any{"n": 1.0},
+		Deadline: time.Now().Add(time.Minute).UnixMilli(),
+	}
+}
+
+// enqueue adds an invocation to the invocations stream and reads it through
+// the consumer group, returning the message as the consumer receives it
+func enqueue(t *testing.T, c *Consumer, msg *InvocationMessage) redis.XMessage {
+	t.Helper()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("marshal invocation: %v", err)
+	}
+	ctx := context.Background()
+	if err := c.redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: c.streamName,
+		Values: map[string]any{"data": string(data)},
+	}).Err(); err != nil {
+		t.Fatalf("XAdd: %v", err)
+	}
+
+	streams, err := c.redisClient.XReadGroup(ctx, &redis.XReadGroupArgs{
+		Group:    c.groupName,
+		Consumer: c.consumerName,
+		Streams:  []string{c.streamName, ">"},
+		Count:    1,
+		Block:    -1,
+	}).Result()
+	if err != nil || len(streams) == 0 || len(streams[0].Messages) == 0 {
+		t.Fatalf("XReadGroup: %v", err)
+	}
+	return streams[0].Messages[0]
+}
+
+// publishedFields returns the fields of the activations stream entry for an
+// activation, failing the test if there isn't one
+func publishedFields(t *testing.T, client *redis.Client, activationID string) map[string]string {
+	t.Helper()
+
+	entries, err := client.XRange(context.Background(), ActivationsStream, "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRange: %v", err)
+	}
+	for _, entry := range entries {
+		if entry.Values["activation_id"] == activationID {
+			fields := make(map[string]string, len(entry.Values))
+			for key, value := range entry.Values {
+				fields[key] = value.(string)
+			}
+			return fields
+		}
+	}
+	t.Fatalf("no activation %s on %s", activationID, ActivationsStream)
+	return nil
+}
+
+func TestProcessMessagePublishesThroughPublisher(t *testing.T) {
+	mr, client := newTestRedis(t)
+	c := newTestConsumer(t, client, handlerFunc(succeed))
+
+	publisher := NewPublisher(client)
+	publisher.SetRetention(time.Hour, nil)
+	c.SetPublisher(publisher)
+
+	msg := testInvocation("act-1", "ns")
+	c.processMessage(context.Background(), enqueue(t, c, msg))
+
+	fields := publishedFields(t, client, "act-1")
+	if fields["expiresAt"] == "" {
+		t.Errorf("published fields %v have no expiresAt", fields)
+	}
+	if ttl := mr.TTL(activationKeyPrefix + "act-1"); ttl != time.Hour {
+		t.Errorf("activation record TTL = %v, want %v", ttl, time.Hour)
+	}
+
+	pending, err := client.XPending(context.Background(), StreamName, GroupName).Result()
+	if err != nil {
+		t.Fatalf("XPending: %v", err)
+	}
+	if pending.Count != 0 {
+		t.Errorf("%d messages still pending after processing", pending.Count)
+	}
+}
+
+// pendingCount returns the number of messages read but not acked by the group
+func pendingCount(t *testing.T, client *redis.Client) int64 {
+	t.Helper()
+
+	pending, err := client.XPending(context.Background(), StreamName, GroupName).Result()
+	if err != nil {
+		t.Fatalf("XPending: %v", err)
+	}
+	return pending.Count
+}
+
+// publishedCount returns the number of results published for an activation
+func publishedCount(t *testing.T, client *redis.Client, activationID string) int {
+	t.Helper()
+
+	entries, err := client.XRange(context.Background(), ActivationsStream, "-", "+").Result()
+	if err != nil {
+		t.Fatalf("XRange: %v", err)
+	}
+	count := 0
+	for _, entry := range entries {
+		if entry.Values["activation_id"] == activationID {
+			count++
+		}
+	}
+	return count
+}
+
+// waitFor polls cond until it holds, failing the test after a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDuplicateActivationRunsOnce(t *testing.T) {
+	_, client := newTestRedis(t)
+	handler := &countingHandler{}
+	c := newTestConsumer(t, client, handler)
+	c.SetDedupTTL(time.Minute)
+
+	msg := testInvocation("act-1", "ns")
+	c.processMessage(context.Background(), enqueue(t, c, msg))
+	c.processMessage(context.Background(), enqueue(t, c, msg))
+
+	if runs := handler.count("act-1"); runs != 1 {
+		t.Errorf("action ran %d times for one activation ID", runs)
+	}
+	// The duplicate is answered with the recorded result
+	if published := publishedCount(t, client, "act-1"); published != 2 {
+		t.Errorf("%d results published, want the original and its republished copy", published)
+	}
+	if pending := pendingCount(t, client); pending != 0 {
+		t.Errorf("%d messages still pending after the duplicate", pending)
+	}
+}
+
+func TestDuplicateActivationInProgressLeftPending(t *testing.T) {
+	_, client := newTestRedis(t)
+	handler := &countingHandler{}
+	c := newTestConsumer(t, client, handler)
+	c.SetDedupTTL(time.Minute)
+
+	// Another delivery of the activation is still running
+	if err := client.Set(context.Background(), dedupKeyPrefix+"act-1", dedupInProgress, time.Minute).Err(); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	c.processMessage(context.Background(), enqueue(t, c, testInvocation("act-1", "ns")))
+
+	if runs := handler.count("act-1"); runs != 0 {
+		t.Errorf("action ran %d times while a duplicate was in progress", runs)
+	}
+	if pending := pendingCount(t, client); pending != 1 {
+		t.Errorf("%d messages pending, want the duplicate left for redelivery", pending)
+	}
+}
+
+func TestPausedConsumerStopsReading(t *testing.T) {
+	_, client := newTestRedis(t)
+	handler := &countingHandler{}
+	c := newTestConsumer(t, client, handler)
+	c.Pause()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	if err := client.XAdd(context.Background(), &redis.XAddArgs{
+		Stream: StreamName,
+		Values: map[string]any{"data": mustMarshal(t, testInvocation("act-1", "ns"))},
+	}).Err(); err != nil {
+		t.Fatalf("XAdd: %v", err)
+	}
+
+	// Paused, the message is neither read nor run
+	time.Sleep(1500 * time.Millisecond)
+	if c.IsReady() || !c.IsPaused() {
+		t.Error("paused consumer reports ready")
+	}
+	if pending, runs := pendingCount(t, client), handler.count("act-1"); pending != 0 || runs != 0 {
+		t.Fatalf("paused consumer read %d messages and ran %d", pending, runs)
+	}
+
+	c.Resume()
+	waitFor(t, "the message to run after resuming", func() bool { return handler.count("act-1") == 1 })
+	if !c.IsReady() {
+		t.Error("resumed consumer not ready")
+	}
+}
+
+// mustMarshal returns an invocation as stream entry data
+func mustMarshal(t *testing.T, msg *InvocationMessage) string {
+	t.Helper()
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		t.Fatalf("marshal invocation: %v", err)
+	}
+	return string(data)
+}
+
+func TestDeletedConsumerGroupRecreated(t *testing.T) {
+	_, client := newTestRedis(t)
+	handler := &countingHandler{}
+	c := newTestConsumer(t, client, handler)
+
+	// An operator deletes the stream out from under the consumer
+	ctx := context.Background()
+	if err := client.Del(ctx, StreamName).Err(); err != nil {
+		t.Fatalf("Del: %v", err)
+	}
+	if err := c.readMessages(); err != nil {
+		t.Fatalf("readMessages after the group was deleted: %v", err)
+	}
+
+	if err := client.XAdd(ctx, &redis.XAddArgs{
+		Stream: StreamName,
+		Values: map[string]any{"data": mustMarshal(t, testInvocation("act-1", "ns"))},
+	}).Err(); err != nil {
+		t.Fatalf("XAdd: %v", err)
+	}
+	if err := c.readMessages(); err != nil {
+		t.Fatalf("readMessages after recreating the group: %v", err)
+	}
+	c.wg.Wait()
+
+	if runs := handler.count("act-1"); runs != 1 {
+		t.Errorf("action ran %d times after the group was recreated, want 1", runs)
+	}
+}
+
+func TestDeadlineGrace(t *testing.T) {
+	tests := []struct {
+		name  string
+		grace time.Duration
+		runs  int
+	}{
+		{name: "within grace", grace: time.Second, runs: 1},
+		{name: "without grace"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client := newTestRedis(t)
+			handler := &countingHandler{}
+			c := newTestConsumer(t, client, handler)
+			c.SetDeadlineGrace(tt.grace)
+
+			// The controller's clock runs slightly ahead of the invoker's
+			msg := testInvocation("act-1", "ns")
+			msg.Deadline = time.Now().Add(-50 * time.Millisecond).UnixMilli()
+			c.processMessage(context.Background(), enqueue(t, c, msg))
+
+			if runs := handler.count("act-1"); runs != tt.runs {
+				t.Errorf("action ran %d times, want %d", runs, tt.runs)
+			}
+			fields := publishedFields(t, client, "act-1")
+			var response Response
+			if err := json.Unmarshal([]byte(fields["response"]), &response); err != nil {
+				t.Fatalf("unmarshal response: %v", err)
+			}
+			if response.Success != (tt.runs == 1) {
+				t.Errorf("published response = %+v", response)
+			}
+		})
+	}
+}
+
+func TestConsumerGroupCreatedAtStartPosition(t *testing.T) {
+	tests := []struct {
+		position string
+		replayed bool
+	}{
+		{position: "$"},
+		{position: "0", replayed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.position, func(t *testing.T) {
+			_, client := newTestRedis(t)
+			ctx := context.Background()
+
+			// An invocation from before the group existed
+			if err := client.XAdd(ctx, &redis.XAddArgs{
+				Stream: StreamName,
+				Values: map[string]any{"data": mustMarshal(t, testInvocation("act-old", "ns"))},
+			}).Err(); err != nil {
+				t.Fatalf("XAdd: %v", err)
+			}
+
+			c := &Consumer{redisClient: client, streamName: StreamName, groupName: GroupName, logger: zap.NewNop()}
+			c.SetStartPosition(tt.position)
+			if err := c.ensureConsumerGroup(ctx); err != nil {
+				t.Fatalf("ensureConsumerGroup: %v", err)
+			}
+
+			streams, err := client.XReadGroup(ctx, &redis.XReadGroupArgs{
+				Group:    GroupName,
+				Consumer: "reader",
+				Streams:  []string{StreamName, ">"},
+				Block:    -1,
+			}).Result()
+			if err != nil && err != redis.Nil {
+				t.Fatalf("XReadGroup: %v", err)
+			}
+			if replayed := len(streams) > 0 && len(streams[0].Messages) > 0; replayed != tt.replayed {
+				t.Errorf("old invocation replayed = %v, want %v", replayed, tt.replayed)
+			}
+		})
+	}
+}
+
+func TestStopLeavesConsumerGroup(t *testing.T) {
+	mr, client := newTestRedis(t)
+	c := newTestConsumer(t, client, handlerFunc(succeed))
+	c.SetLeaveGroupOnStop(true)
+
+	// Read but never processed before the invoker is scaled down
+	msg := enqueue(t, c, testInvocation("act-1", "ns"))
+
+	pending := c.Stop()
+	if !reflect.DeepEqual(pending, []string{msg.ID}) {
+		t.Errorf("Stop() = %v, want the pending message %s", pending, msg.ID)
+	}
+
+	// Stop closed the consumer's client
+	inspect := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { inspect.Close() })
+	consumers, err := inspect.XInfoConsumers(context.Background(), StreamName, GroupName).Result()
+	if err != nil {
+		t.Fatalf("XInfoConsumers: %v", err)
+	}
+	if len(consumers) != 0 {
+		t.Errorf("consumers %v still in the group", consumers)
+	}
+	if pending := pendingCount(t, inspect); pending != 0 {
+		t.Errorf("%d messages left pending in the group", pending)
+	}
+
+	// The message was requeued for other invokers
+	entries, err := inspect.XRange(context.Background(), StreamName, "("+msg.ID, "+").Result()
+	if err != nil {
+		t.Fatalf("XRange: %v", err)
+	}
+	if len(entries) != 1 || entries[0].Values["data"] != msg.Values["data"] {
+		t.Errorf("stream entries after %s = %v, want the requeued message", msg.ID, entries)
+	}
+}
+
+func TestPendingMessagesRecoveredOnStart(t *testing.T) {
+	_, client := newTestRedis(t)
+	handler := &countingHandler{}
+	c := newTestConsumer(t, client, handler)
+	c.SetDedupTTL(time.Minute)
+
+	// A previous run under the same consumer name read both messages and was
+	// killed, one of them mid-invocation
+	enqueue(t, c, testInvocation("act-1", "ns"))
+	enqueue(t, c, testInvocation("act-2", "ns"))
+	if err := client.Set(context.Background(), dedupKeyPrefix+"act-2", dedupInProgress, time.Minute).Err(); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+
+	if err := c.recoverPending(); err != nil {
+		t.Fatalf("recoverPending: %v", err)
+	}
+	c.wg.Wait()
+
+	for _, id := range []string{"act-1", "act-2"} {
+		if runs := handler.count(id); runs != 1 {
+			t.Errorf("%s ran %d times, want it recovered once", id, runs)
+		}
+	}
+	if pending := pendingCount(t, client); pending != 0 {
+		t.Errorf("%d messages still pending after recovery", pending)
+	}
+}
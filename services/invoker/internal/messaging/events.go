@@ -0,0 +1,116 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultEventsChannel is the pub/sub channel lifecycle events go to
+	DefaultEventsChannel = "penguinwhisk:events"
+
+	EventInvocationStarted   = "invocation.started"
+	EventInvocationCompleted = "invocation.completed"
+	EventContainerColdStart  = "container.coldstart"
+
+	// eventPublishTimeout bounds publishes made outside an invocation's context
+	eventPublishTimeout = 2 * time.Second
+)
+
+// Event is a lightweight lifecycle event for live activity feeds. Only the
+// fields relevant to the event type are set
+type Event struct {
+	Type         string `json:"type"`
+	Timestamp    int64  `json:"timestamp"` // unix milliseconds
+	InvokerID    string `json:"invokerId"`
+	ActivationID string `json:"activationId,omitempty"`
+	Namespace    string `json:"namespace,omitempty"`
+	Action       string `json:"action,omitempty"`
+	ContainerID  string `json:"containerId,omitempty"`
+	Runtime      string `json:"runtime,omitempty"`
+	Success      *bool  `json:"success,omitempty"`
+	DurationMs   int64  `json:"durationMs,omitempty"`
+}
+
+// EventEmitter publishes lifecycle events to a Redis pub/sub channel,
+// separate from the activations stream. Events are best effort: failures are
+// logged and never affect the invocation
+type EventEmitter struct {
+	redisClient *redis.Client
+	channel     string
+	invokerID   string
+	logger      *zap.Logger
+}
+
+// NewEventEmitter creates an emitter publishing to channel, or to
+// DefaultEventsChannel when channel is empty
+func NewEventEmitter(redisClient *redis.Client, channel, invokerID string, logger *zap.Logger) *EventEmitter {
+	if channel == "" {
+		channel = DefaultEventsChannel
+	}
+	return &EventEmitter{
+		redisClient: redisClient,
+		channel:     channel,
+		invokerID:   invokerID,
+		logger:      logger,
+	}
+}
+
+// Emit stamps and publishes an event
+func (e *EventEmitter) Emit(ctx context.Context, event Event) {
+	event.Timestamp = time.Now().UnixMilli()
+	event.InvokerID = e.invokerID
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		e.logger.Warn("Failed to marshal event", zap.Error(err), zap.String("type", event.Type))
+		return
+	}
+	if err := e.redisClient.Publish(ctx, e.channel, data).Err(); err != nil {
+		e.logger.Warn("Failed to publish event",
+			zap.Error(err),
+			zap.String("type", event.Type),
+			zap.String("channel", e.channel))
+	}
+}
+
+// InvocationStarted emits an invocation.started event
+func (e *EventEmitter) InvocationStarted(ctx context.Context, msg *InvocationMessage) {
+	e.Emit(ctx, Event{
+		Type:         EventInvocationStarted,
+		ActivationID: msg.ActivationID,
+		Namespace:    msg.Action.Namespace,
+		Action:       msg.Action.Name,
+	})
+}
+
+// InvocationCompleted emits an invocation.completed event with the outcome
+func (e *EventEmitter) InvocationCompleted(ctx context.Context, msg *InvocationMessage, result *ActivationResult) {
+	success := result.Response.Success
+	e.Emit(ctx, Event{
+		Type:         EventInvocationCompleted,
+		ActivationID: msg.ActivationID,
+		Namespace:    msg.Action.Namespace,
+		Action:       msg.Action.Name,
+		Success:      &success,
+		DurationMs:   result.Duration,
+	})
+}
+
+// ContainerColdStart emits a container.coldstart event. It is called by the
+// container pool, which has no invocation context to publish under
+func (e *EventEmitter) ContainerColdStart(containerID, runtime string, durationMs int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), eventPublishTimeout)
+	defer cancel()
+
+	e.Emit(ctx, Event{
+		Type:        EventContainerColdStart,
+		ContainerID: containerID,
+		Runtime:     runtime,
+		DurationMs:  durationMs,
+	})
+}
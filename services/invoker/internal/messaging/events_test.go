@@ -0,0 +1,54 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestInvocationLifecycleEvents(t *testing.T) {
+	_, client := newTestRedis(t)
+	c := newTestConsumer(t, client, handlerFunc(succeed))
+	c.SetEventEmitter(NewEventEmitter(client, "", "invoker-0", zap.NewNop()))
+
+	sub := client.Subscribe(context.Background(), DefaultEventsChannel)
+	t.Cleanup(func() { sub.Close() })
+	if _, err := sub.Receive(context.Background()); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+
+	c.processMessage(context.Background(), enqueue(t, c, testInvocation("act-1", "ns")))
+
+	var events []Event
+	for len(events) < 2 {
+		select {
+		case message := <-sub.Channel():
+			var event Event
+			if err := json.Unmarshal([]byte(message.Payload), &event); err != nil {
+				t.Fatalf("unmarshal event %s: %v", message.Payload, err)
+			}
+			events = append(events, event)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got events %+v, want started and completed", events)
+		}
+	}
+
+	started, completed := events[0], events[1]
+	if started.Type != EventInvocationStarted || completed.Type != EventInvocationCompleted {
+		t.Fatalf("event types = %s, %s", started.Type, completed.Type)
+	}
+	for _, event := range events {
+		if event.ActivationID != "act-1" || event.Namespace != "ns" || event.Action != "echo" || event.InvokerID != "invoker-0" || event.Timestamp == 0 {
+			t.Errorf("%s event = %+v", event.Type, event)
+		}
+	}
+	if started.Success != nil {
+		t.Errorf("started event has an outcome")
+	}
+	if completed.Success == nil || !*completed.Success {
+		t.Errorf("completed event success = %v, want true", completed.Success)
+	}
+}
@@ -0,0 +1,143 @@
+package messaging
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// HeartbeatsStream is where invokers report their health
+	HeartbeatsStream = "penguinwhisk:heartbeats"
+	// DefaultHighWatermark is the fraction of MaxConcurrent above which an
+	// invoker reports itself overloaded
+	DefaultHighWatermark = 0.8
+
+	defaultMaxHeartbeatLen = 1000
+)
+
+// LoadReporter reports the number of in-flight invocations
+type LoadReporter interface {
+	GetActiveInvocations() int
+}
+
+// ReadinessReporter reports whether the invoker is accepting new work
+type ReadinessReporter interface {
+	IsReady() bool
+}
+
+// HeartbeatPublisher periodically publishes invoker health to Redis
+type HeartbeatPublisher struct {
+	redisClient   *redis.Client
+	invokerID     string
+	interval      time.Duration
+	load          LoadReporter
+	maxConcurrent int
+	highWatermark float64
+	logger        *zap.Logger
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewHeartbeatPublisher creates a new heartbeat publisher
+func NewHeartbeatPublisher(redisClient *redis.Client, invokerID string, interval time.Duration, logger *zap.Logger) *HeartbeatPublisher {
+	return &HeartbeatPublisher{
+		redisClient:   redisClient,
+		invokerID:     invokerID,
+		interval:      interval,
+		highWatermark: DefaultHighWatermark,
+		logger:        logger,
+	}
+}
+
+// SetLoadReporter configures the concurrency source used to compute the
+// overloaded backpressure signal
+func (h *HeartbeatPublisher) SetLoadReporter(load LoadReporter, maxConcurrent int, highWatermark float64) {
+	h.load = load
+	h.maxConcurrent = maxConcurrent
+	if highWatermark > 0 {
+		h.highWatermark = highWatermark
+	}
+}
+
+// Start begins publishing heartbeats in the background
+func (h *HeartbeatPublisher) Start(ctx context.Context) {
+	ctx, h.cancel = context.WithCancel(ctx)
+
+	h.wg.Add(1)
+	go func() {
+		defer h.wg.Done()
+
+		ticker := time.NewTicker(h.interval)
+		defer ticker.Stop()
+
+		for {
+			h.publish(ctx)
+
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops publishing heartbeats
+func (h *HeartbeatPublisher) Stop() {
+	if h.cancel != nil {
+		h.cancel()
+	}
+	h.wg.Wait()
+}
+
+// publish sends a single heartbeat to the heartbeats stream
+func (h *HeartbeatPublisher) publish(ctx context.Context) {
+	active := 0
+	if h.load != nil {
+		active = h.load.GetActiveInvocations()
+	}
+	overloaded := IsOverloaded(active, h.maxConcurrent, h.highWatermark)
+
+	status := "healthy"
+	if overloaded {
+		status = "overloaded"
+	}
+	if readiness, ok := h.load.(ReadinessReporter); ok && !readiness.IsReady() {
+		status = "unready"
+	}
+
+	err := h.redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: HeartbeatsStream,
+		MaxLen: defaultMaxHeartbeatLen,
+		Approx: true,
+		Values: map[string]any{
+			"invoker_id":         h.invokerID,
+			"timestamp":          strconv.FormatInt(time.Now().UnixMilli(), 10),
+			"capacity":           strconv.Itoa(h.maxConcurrent),
+			"active_invocations": strconv.Itoa(active),
+			"max_concurrent":     strconv.Itoa(h.maxConcurrent),
+			"overloaded":         strconv.FormatBool(overloaded),
+			"status":             status,
+		},
+	}).Err()
+	if err != nil && ctx.Err() == nil {
+		h.logger.Error("Failed to publish heartbeat",
+			zap.Error(err),
+			zap.String("invoker_id", h.invokerID))
+	}
+}
+
+// IsOverloaded reports whether active invocations exceed the high-watermark
+// fraction of maxConcurrent
+func IsOverloaded(active, maxConcurrent int, highWatermark float64) bool {
+	if maxConcurrent <= 0 {
+		return false
+	}
+	return float64(active) > float64(maxConcurrent)*highWatermark
+}
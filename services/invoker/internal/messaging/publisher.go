@@ -1,10 +1,16 @@
 package messaging
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/redis/go-redis/v9"
@@ -12,63 +18,76 @@ import (
 
 const (
 	// Default stream configuration
-	defaultActivationsStream = "penguinwhisk:activations"
-	defaultMaxStreamLen      = 10000
-	defaultChannelTTL        = 300 // 5 minutes
+	defaultMaxStreamLen = 10000
+	defaultChannelTTL   = 300 // 5 minutes
+	activationKeyPrefix = "penguinwhisk:activation:"
+	partialStreamPrefix = "penguinwhisk:partial:"
+
+	// ttlAnnotation carries the activation's retention in seconds
+	ttlAnnotation = "ttl"
+
+	// DefaultCompressThreshold is the serialized response size above which
+	// the response field is gzip-compressed
+	DefaultCompressThreshold = 64 * 1024
+	// ResponseEncodingGzip marks a response field holding base64-encoded gzip
+	ResponseEncodingGzip = "gzip"
 )
 
-// ActivationResponse represents the response portion of an activation
-type ActivationResponse struct {
-	Success bool                   `json:"success"`
-	Result  map[string]interface{} `json:"result"`
-}
-
-// ActivationResult represents the complete activation result
-type ActivationResult struct {
-	ActivationID  string                 `json:"activationId"`
-	Namespace     string                 `json:"namespace"`
-	ActionName    string                 `json:"name"`
-	ActionVersion string                 `json:"version"`
-	Subject       string                 `json:"subject"`
-	Start         int64                  `json:"start"`
-	End           int64                  `json:"end"`
-	Duration      int                    `json:"duration"`
-	StatusCode    int                    `json:"statusCode"` // 0=success, 1=app error, 2=dev error, 3=internal error
-	Response      ActivationResponse     `json:"response"`
-	Logs          []string               `json:"logs"`
-	Annotations   map[string]interface{} `json:"annotations"`
-	Cause         string                 `json:"cause,omitempty"` // for sequences
-}
+// ErrActivationNotFound is returned for activations neither recently
+// published nor in the activation store
+var ErrActivationNotFound = errors.New("activation not found")
 
 // Publisher handles publishing activation results to Redis
 type Publisher struct {
-	redisClient      *redis.Client
+	redisClient       *redis.Client
 	activationsStream string
-	maxStreamLen     int64
-	channelTTL       time.Duration
+	maxStreamLen      int64
+	channelTTL        time.Duration
+
+	defaultRetention   time.Duration
+	namespaceRetention map[string]time.Duration
+
+	compressThreshold int // bytes, 0 disables compression
+
+	recent *RecentActivations // nil unless recent activations are kept for lookup
+
+	inflight sync.WaitGroup // publishes not yet written to Redis
 }
 
 // NewPublisher creates a new activation result publisher
 func NewPublisher(redisClient *redis.Client) *Publisher {
 	return &Publisher{
-		redisClient:      redisClient,
-		activationsStream: defaultActivationsStream,
-		maxStreamLen:     defaultMaxStreamLen,
-		channelTTL:       time.Duration(defaultChannelTTL) * time.Second,
+		redisClient:       redisClient,
+		activationsStream: ActivationsStream,
+		maxStreamLen:      defaultMaxStreamLen,
+		channelTTL:        time.Duration(defaultChannelTTL) * time.Second,
+		compressThreshold: DefaultCompressThreshold,
 	}
 }
 
 // PublishActivation publishes an activation result to the main activations stream
 func (p *Publisher) PublishActivation(ctx context.Context, result *ActivationResult) error {
+	p.inflight.Add(1)
+	defer p.inflight.Done()
+
 	if result == nil {
 		return fmt.Errorf("activation result cannot be nil")
 	}
 
+	// Stamp the activation with its retention before serializing
+	retention := p.RetentionFor(result.Namespace)
+	if retention > 0 {
+		result.setAnnotation(ttlAnnotation, int64(retention.Seconds()))
+	}
+
 	// Convert result to Redis hash fields
 	fields, err := p.resultToFields(result)
 	if err != nil {
 		return fmt.Errorf("failed to convert result to fields: %w", err)
 	}
+	if retention > 0 {
+		fields["expiresAt"] = strconv.FormatInt(time.Now().Add(retention).UnixMilli(), 10)
+	}
 
 	// Publish to stream with approximate maxlen trimming
 	args := &redis.XAddArgs{
@@ -83,12 +102,61 @@ func (p *Publisher) PublishActivation(ctx context.Context, result *ActivationRes
 		return fmt.Errorf("failed to publish activation to stream: %w", err)
 	}
 
+	// Keep a per-activation record that expires after the namespace's retention
+	if retention > 0 {
+		key := activationKeyPrefix + result.ActivationID
+		_, err = p.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.HSet(ctx, key, fields)
+			pipe.Expire(ctx, key, retention)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to store activation record: %w", err)
+		}
+	}
+
+	if p.recent != nil {
+		p.recent.Add(result)
+	}
+
 	return nil
 }
 
+// GetActivation looks up an activation by ID, first among recently published
+// activations and then in the activation store, which only holds activations
+// of namespaces with a retention. Returns ErrActivationNotFound otherwise
+func (p *Publisher) GetActivation(ctx context.Context, activationID string) (*ActivationResult, error) {
+	if p.recent != nil {
+		if result, ok := p.recent.Get(activationID); ok {
+			return result, nil
+		}
+	}
+
+	fields, err := p.redisClient.HGetAll(ctx, activationKeyPrefix+activationID).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read activation record: %w", err)
+	}
+	if len(fields) == 0 {
+		return nil, ErrActivationNotFound
+	}
+	return fieldsToResult(fields)
+}
+
+// RetentionFor returns how long activations of a namespace are kept,
+// falling back to the default retention. Zero means no expiring record
+func (p *Publisher) RetentionFor(namespace string) time.Duration {
+	if retention, ok := p.namespaceRetention[namespace]; ok {
+		return retention
+	}
+	return p.defaultRetention
+}
+
 // PublishToChannel publishes an activation result to a specific response channel
 // Used for blocking invocations where the controller is waiting for a response
 func (p *Publisher) PublishToChannel(ctx context.Context, channel string, result *ActivationResult) error {
+	p.inflight.Add(1)
+	defer p.inflight.Done()
+
 	if result == nil {
 		return fmt.Errorf("activation result cannot be nil")
 	}
@@ -125,20 +193,87 @@ func (p *Publisher) PublishToChannel(ctx context.Context, channel string, result
 	return nil
 }
 
+// PartialStreamName returns the stream callers tail for an activation's
+// partial results
+func PartialStreamName(activationID string) string {
+	return partialStreamPrefix + activationID
+}
+
+// PublishPartial appends a partial result to the activation's partial stream
+func (p *Publisher) PublishPartial(ctx context.Context, activationID string, chunk map[string]interface{}) error {
+	return p.publishPartialEntry(ctx, activationID, "chunk", chunk, nil)
+}
+
+// ClosePartial appends the final response, closing the activation's partial
+// stream. Failed runs close it too, with their status code and error, so
+// callers tailing the stream aren't left waiting
+func (p *Publisher) ClosePartial(ctx context.Context, activationID string, response Response) error {
+	fields := map[string]interface{}{
+		"statusCode": response.StatusCode,
+	}
+	if response.Error != "" {
+		fields["error"] = response.Error
+	}
+	return p.publishPartialEntry(ctx, activationID, "result", response.Result, fields)
+}
+
+// publishPartialEntry writes one typed entry to a partial stream, with any
+// extra fields alongside the data
+func (p *Publisher) publishPartialEntry(ctx context.Context, activationID string, entryType string, data map[string]interface{}, fields map[string]interface{}) error {
+	p.inflight.Add(1)
+	defer p.inflight.Done()
+
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("failed to marshal partial result: %w", err)
+	}
+
+	values := map[string]interface{}{
+		"type": entryType,
+		"data": string(dataJSON),
+	}
+	for key, value := range fields {
+		values[key] = value
+	}
+
+	stream := PartialStreamName(activationID)
+	_, err = p.redisClient.XAdd(ctx, &redis.XAddArgs{
+		Stream: stream,
+		Values: values,
+	}).Result()
+	if err != nil {
+		return fmt.Errorf("failed to publish partial result: %w", err)
+	}
+
+	// Partial streams are only useful while the caller is tailing
+	if err := p.redisClient.Expire(ctx, stream, p.channelTTL).Err(); err != nil {
+		return fmt.Errorf("failed to set TTL on partial stream: %w", err)
+	}
+
+	return nil
+}
+
 // resultToFields converts ActivationResult to Redis stream fields
 func (p *Publisher) resultToFields(result *ActivationResult) (map[string]interface{}, error) {
 	fields := make(map[string]interface{})
 
 	// Basic fields
-	fields["activationId"] = result.ActivationID
+	fields["activation_id"] = result.ActivationID
 	fields["namespace"] = result.Namespace
-	fields["name"] = result.ActionName
-	fields["version"] = result.ActionVersion
-	fields["subject"] = result.Subject
+	fields["name"] = result.Name
+	fields["version"] = result.Version
+	fields["success"] = strconv.FormatBool(result.Response.Success)
+	fields["status_code"] = strconv.Itoa(result.Response.StatusCode)
 	fields["start"] = strconv.FormatInt(result.Start, 10)
 	fields["end"] = strconv.FormatInt(result.End, 10)
-	fields["duration"] = strconv.Itoa(result.Duration)
-	fields["statusCode"] = strconv.Itoa(result.StatusCode)
+	fields["duration"] = strconv.FormatInt(result.Duration, 10)
+
+	// Results that don't serialize as-is (NaN, infinities, non-string keys)
+	// are cleaned up rather than failing the activation
+	if sanitized, changed := sanitizeResult(result.Response.Result); changed {
+		result.Response.Result = sanitized
+		result.setAnnotation(sanitizedAnnotation, true)
+	}
 
 	// Serialize response
 	responseJSON, err := json.Marshal(result.Response)
@@ -146,6 +281,14 @@ func (p *Publisher) resultToFields(result *ActivationResult) (map[string]interfa
 		return nil, fmt.Errorf("failed to marshal response: %w", err)
 	}
 	fields["response"] = string(responseJSON)
+	if p.compressThreshold > 0 && len(responseJSON) > p.compressThreshold {
+		compressed, err := compressResponse(responseJSON)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress response: %w", err)
+		}
+		fields["response"] = compressed
+		fields["responseEncoding"] = ResponseEncodingGzip
+	}
 
 	// Serialize logs
 	logsJSON, err := json.Marshal(result.Logs)
@@ -161,12 +304,36 @@ func (p *Publisher) resultToFields(result *ActivationResult) (map[string]interfa
 	}
 	fields["annotations"] = string(annotationsJSON)
 
-	// Optional cause field for sequences
-	if result.Cause != "" {
-		fields["cause"] = result.Cause
+	return fields, nil
+}
+
+// fieldsToResult rebuilds an ActivationResult from its stored hash fields
+func fieldsToResult(fields map[string]string) (*ActivationResult, error) {
+	result := &ActivationResult{
+		ActivationID: fields["activation_id"],
+		Namespace:    fields["namespace"],
+		Name:         fields["name"],
+		Version:      fields["version"],
 	}
+	result.Start, _ = strconv.ParseInt(fields["start"], 10, 64)
+	result.End, _ = strconv.ParseInt(fields["end"], 10, 64)
+	result.Duration, _ = strconv.ParseInt(fields["duration"], 10, 64)
 
-	return fields, nil
+	responseJSON, err := DecodeResponse(fields["response"], fields["responseEncoding"])
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(responseJSON, &result.Response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+	if err := json.Unmarshal([]byte(fields["logs"]), &result.Logs); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal logs: %w", err)
+	}
+	if err := json.Unmarshal([]byte(fields["annotations"]), &result.Annotations); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal annotations: %w", err)
+	}
+
+	return result, nil
 }
 
 // SetMaxStreamLen configures the maximum stream length
@@ -174,11 +341,86 @@ func (p *Publisher) SetMaxStreamLen(maxLen int64) {
 	p.maxStreamLen = maxLen
 }
 
+// SetCompressThreshold configures the response size in bytes above which
+// responses are compressed; 0 disables compression
+func (p *Publisher) SetCompressThreshold(threshold int) {
+	p.compressThreshold = threshold
+}
+
 // SetChannelTTL configures the TTL for response channels
 func (p *Publisher) SetChannelTTL(ttl time.Duration) {
 	p.channelTTL = ttl
 }
 
+// SetRetention configures the default and per-namespace activation retention
+func (p *Publisher) SetRetention(defaultRetention time.Duration, namespaceRetention map[string]time.Duration) {
+	p.defaultRetention = defaultRetention
+	p.namespaceRetention = namespaceRetention
+}
+
+// SetRecentActivations keeps published activations in recent for lookup by
+// GetActivation
+func (p *Publisher) SetRecentActivations(recent *RecentActivations) {
+	p.recent = recent
+}
+
+// compressResponse gzips a serialized response and base64-encodes it so the
+// stream field stays valid text
+func compressResponse(data []byte) (string, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// DecodeResponse returns the serialized response from a stream entry's
+// response field, decompressing it according to its responseEncoding field
+func DecodeResponse(response, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(response), nil
+	case ResponseEncodingGzip:
+		compressed, err := base64.StdEncoding.DecodeString(response)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress response: %w", err)
+		}
+		defer zr.Close()
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress response: %w", err)
+		}
+		return data, nil
+	default:
+		return nil, fmt.Errorf("unknown response encoding %q", encoding)
+	}
+}
+
+// Flush waits for in-flight publishes to reach Redis, giving up when ctx is
+// done. Call it before closing the Redis client so late results aren't lost
+func (p *Publisher) Flush(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		p.inflight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("flush publisher: %w", ctx.Err())
+	}
+}
+
 // Close closes the publisher (currently a no-op, but included for future cleanup)
 func (p *Publisher) Close() error {
 	// No cleanup needed currently, but method exists for interface compatibility
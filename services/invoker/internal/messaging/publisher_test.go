@@ -0,0 +1,137 @@
+package messaging
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// annotationValue returns the value of an activation's annotation
+func annotationValue(result *ActivationResult, key string) (any, bool) {
+	for _, annotation := range result.Annotations {
+		if annotation.Key == key {
+			return annotation.Value, true
+		}
+	}
+	return nil, false
+}
+
+func TestRetentionAppliedPerNamespace(t *testing.T) {
+	mr, client := newTestRedis(t)
+	p := NewPublisher(client)
+	p.SetRetention(time.Hour, map[string]time.Duration{"audited": 30 * 24 * time.Hour})
+
+	ctx := context.Background()
+	results := map[string]*ActivationResult{
+		"ns":      {ActivationID: "act-default", Namespace: "ns"},
+		"audited": {ActivationID: "act-audited", Namespace: "audited"},
+	}
+	want := map[string]time.Duration{
+		"ns":      time.Hour,
+		"audited": 30 * 24 * time.Hour,
+	}
+	for namespace, result := range results {
+		if err := p.PublishActivation(ctx, result); err != nil {
+			t.Fatalf("PublishActivation(%s): %v", namespace, err)
+		}
+
+		if ttl := mr.TTL(activationKeyPrefix + result.ActivationID); ttl != want[namespace] {
+			t.Errorf("%s: record TTL = %v, want %v", namespace, ttl, want[namespace])
+		}
+		value, ok := annotationValue(result, ttlAnnotation)
+		if !ok || value != int64(want[namespace].Seconds()) {
+			t.Errorf("%s: ttl annotation = %v, want %d", namespace, value, int64(want[namespace].Seconds()))
+		}
+	}
+}
+
+func TestNoRetentionKeepsNoRecord(t *testing.T) {
+	mr, client := newTestRedis(t)
+	p := NewPublisher(client)
+
+	result := &ActivationResult{ActivationID: "act-1", Namespace: "ns"}
+	if err := p.PublishActivation(context.Background(), result); err != nil {
+		t.Fatalf("PublishActivation: %v", err)
+	}
+
+	if mr.Exists(activationKeyPrefix + "act-1") {
+		t.Error("activation record stored without a retention")
+	}
+	if _, ok := annotationValue(result, ttlAnnotation); ok {
+		t.Error("ttl annotation set without a retention")
+	}
+	if fields := publishedFields(t, client, "act-1"); fields["expiresAt"] != "" {
+		t.Errorf("expiresAt = %q without a retention", fields["expiresAt"])
+	}
+}
+
+func TestLargeResponseCompressedOnPublish(t *testing.T) {
+	_, client := newTestRedis(t)
+	large := strings.Repeat("x", 4096)
+	c := newTestConsumer(t, client, handlerFunc(func(ctx context.Context, msg *InvocationMessage) (*ActivationResult, error) {
+		result, _ := succeed(ctx, msg)
+		result.Response.Result = map[string]any{"payload": large}
+		return result, nil
+	}))
+
+	publisher := NewPublisher(client)
+	publisher.SetCompressThreshold(1024)
+	publisher.SetRetention(time.Hour, nil)
+	c.SetPublisher(publisher)
+
+	c.processMessage(context.Background(), enqueue(t, c, testInvocation("act-large", "ns")))
+
+	fields := publishedFields(t, client, "act-large")
+	if fields["responseEncoding"] != ResponseEncodingGzip {
+		t.Fatalf("responseEncoding = %q, want %q", fields["responseEncoding"], ResponseEncodingGzip)
+	}
+	if len(fields["response"]) >= len(large) {
+		t.Errorf("compressed response is %d bytes, not smaller than the %d byte result", len(fields["response"]), len(large))
+	}
+
+	data, err := DecodeResponse(fields["response"], fields["responseEncoding"])
+	if err != nil {
+		t.Fatalf("DecodeResponse: %v", err)
+	}
+	var response Response
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatalf("unmarshal decoded response: %v", err)
+	}
+	if !response.Success || response.Result["payload"] != large {
+		t.Errorf("decoded response = %+v, want the original result", response)
+	}
+
+	// Stored records decode the same way
+	stored, err := publisher.GetActivation(context.Background(), "act-large")
+	if err != nil {
+		t.Fatalf("GetActivation: %v", err)
+	}
+	if !reflect.DeepEqual(stored.Response, response) {
+		t.Errorf("stored response = %+v, want %+v", stored.Response, response)
+	}
+}
+
+func TestSmallResponseNotCompressed(t *testing.T) {
+	_, client := newTestRedis(t)
+	p := NewPublisher(client)
+	p.SetCompressThreshold(1024)
+
+	result := &ActivationResult{
+		ActivationID: "act-small",
+		Response:     Response{Success: true, Result: map[string]any{"ok": true}},
+	}
+	if err := p.PublishActivation(context.Background(), result); err != nil {
+		t.Fatalf("PublishActivation: %v", err)
+	}
+
+	fields := publishedFields(t, client, "act-small")
+	if fields["responseEncoding"] != "" {
+		t.Errorf("responseEncoding = %q for a small response", fields["responseEncoding"])
+	}
+	if fields["response"] != `{"statusCode":0,"success":true,"result":{"ok":true}}` {
+		t.Errorf("response = %s", fields["response"])
+	}
+}
@@ -0,0 +1,64 @@
+package messaging
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultRecentActivations is how many completed activations are kept in
+// memory for lookup when no capacity is configured
+const DefaultRecentActivations = 1000
+
+// RecentActivations is an LRU of recently published activations, so they can
+// be looked up without a persistent activation store
+type RecentActivations struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List               // front is most recent
+	entries  map[string]*list.Element // activation ID -> element holding *ActivationResult
+}
+
+// NewRecentActivations creates an LRU holding up to capacity activations
+// (DefaultRecentActivations when zero or less)
+func NewRecentActivations(capacity int) *RecentActivations {
+	if capacity <= 0 {
+		capacity = DefaultRecentActivations
+	}
+	return &RecentActivations{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element, capacity),
+	}
+}
+
+// Add records an activation, evicting the least recently used past capacity
+func (r *RecentActivations) Add(result *ActivationResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if elem, ok := r.entries[result.ActivationID]; ok {
+		elem.Value = result
+		r.order.MoveToFront(elem)
+		return
+	}
+
+	r.entries[result.ActivationID] = r.order.PushFront(result)
+	if r.order.Len() > r.capacity {
+		oldest := r.order.Back()
+		r.order.Remove(oldest)
+		delete(r.entries, oldest.Value.(*ActivationResult).ActivationID)
+	}
+}
+
+// Get returns a recent activation by ID
+func (r *RecentActivations) Get(activationID string) (*ActivationResult, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	elem, ok := r.entries[activationID]
+	if !ok {
+		return nil, false
+	}
+	r.order.MoveToFront(elem)
+	return elem.Value.(*ActivationResult), true
+}
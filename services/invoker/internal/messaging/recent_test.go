@@ -0,0 +1,64 @@
+package messaging
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRecentActivationsEvictsLeastRecentlyUsed(t *testing.T) {
+	r := NewRecentActivations(2)
+	r.Add(&ActivationResult{ActivationID: "a"})
+	r.Add(&ActivationResult{ActivationID: "b"})
+
+	// Looking a up makes b the least recently used
+	if _, ok := r.Get("a"); !ok {
+		t.Fatal("a missing before capacity was reached")
+	}
+	r.Add(&ActivationResult{ActivationID: "c"})
+
+	if _, ok := r.Get("b"); ok {
+		t.Error("b kept past capacity though it was least recently used")
+	}
+	for _, id := range []string{"a", "c"} {
+		if result, ok := r.Get(id); !ok || result.ActivationID != id {
+			t.Errorf("Get(%s) = %v, %v", id, result, ok)
+		}
+	}
+}
+
+func TestRecentActivationsReplacesSameID(t *testing.T) {
+	r := NewRecentActivations(2)
+	r.Add(&ActivationResult{ActivationID: "a", Duration: 1})
+	r.Add(&ActivationResult{ActivationID: "a", Duration: 2})
+	r.Add(&ActivationResult{ActivationID: "b"})
+
+	result, ok := r.Get("a")
+	if !ok || result.Duration != 2 {
+		t.Errorf("Get(a) = %+v, %v, want the latest result", result, ok)
+	}
+}
+
+func TestCompletedActivationFetchedByID(t *testing.T) {
+	_, client := newTestRedis(t)
+	c := newTestConsumer(t, client, handlerFunc(succeed))
+
+	// No retention, so only the recent activations can answer
+	publisher := NewPublisher(client)
+	publisher.SetRecentActivations(NewRecentActivations(10))
+	c.SetPublisher(publisher)
+
+	c.processMessage(context.Background(), enqueue(t, c, testInvocation("act-1", "ns")))
+
+	result, err := publisher.GetActivation(context.Background(), "act-1")
+	if err != nil {
+		t.Fatalf("GetActivation: %v", err)
+	}
+	if result.Namespace != "ns" || result.Name != "echo" || !result.Response.Success {
+		t.Errorf("GetActivation = %+v", result)
+	}
+
+	if _, err := publisher.GetActivation(context.Background(), "unknown"); !errors.Is(err, ErrActivationNotFound) {
+		t.Errorf("GetActivation(unknown) error = %v, want ErrActivationNotFound", err)
+	}
+}
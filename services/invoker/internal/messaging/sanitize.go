@@ -0,0 +1,107 @@
+package messaging
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+// sanitizedAnnotation flags activations whose result had to be altered to
+// serialize as JSON
+const sanitizedAnnotation = "resultSanitized"
+
+// sanitizeResult makes a result JSON-serializable: NaN and infinite floats
+// become null, maps with non-string keys get their keys stringified, and
+// strings have invalid UTF-8 replaced and control characters other than
+// tab, newline and carriage return dropped. Values needing no change are returned as is; changed containers are
+// copied. Reports whether anything was changed
+func sanitizeResult(result map[string]interface{}) (map[string]interface{}, bool) {
+	sanitized, changed := sanitizeValue(result)
+	if !changed {
+		return result, false
+	}
+	return sanitized.(map[string]interface{}), true
+}
+
+// sanitizeValue sanitizes one value of a result, recursing into maps and
+// slices
+func sanitizeValue(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case string:
+		if sanitized := sanitizeString(v); sanitized != v {
+			return sanitized, true
+		}
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, true
+		}
+	case float32:
+		if math.IsNaN(float64(v)) || math.IsInf(float64(v), 0) {
+			return nil, true
+		}
+	case map[string]interface{}:
+		var copied map[string]interface{}
+		for key, elem := range v {
+			sanitizedKey := sanitizeString(key)
+			sanitized, changed := sanitizeValue(elem)
+			if changed || sanitizedKey != key {
+				if copied == nil {
+					copied = make(map[string]interface{}, len(v))
+					for k, e := range v {
+						copied[k] = e
+					}
+				}
+				delete(copied, key)
+				copied[sanitizedKey] = sanitized
+			}
+		}
+		if copied != nil {
+			return copied, true
+		}
+	case map[interface{}]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			copied[sanitizeString(fmt.Sprint(key))], _ = sanitizeValue(elem)
+		}
+		return copied, true
+	case []interface{}:
+		var copied []interface{}
+		for i, elem := range v {
+			if sanitized, changed := sanitizeValue(elem); changed {
+				if copied == nil {
+					copied = append([]interface{}{}, v...)
+				}
+				copied[i] = sanitized
+			}
+		}
+		if copied != nil {
+			return copied, true
+		}
+	}
+	return value, false
+}
+
+// sanitizeString replaces invalid UTF-8 with U+FFFD and drops control
+// characters other than tab, newline and carriage return
+func sanitizeString(s string) string {
+	if utf8.ValidString(s) && strings.IndexFunc(s, isDroppedControl) < 0 {
+		return s
+	}
+
+	return strings.Map(func(r rune) rune {
+		if isDroppedControl(r) {
+			return -1
+		}
+		return r
+	}, strings.ToValidUTF8(s, string(utf8.RuneError)))
+}
+
+// isDroppedControl reports whether r is a control character removed from
+// result strings
+func isDroppedControl(r rune) bool {
+	if r == '\t' || r == '\n' || r == '\r' {
+		return false
+	}
+	return r < 0x20 || r == 0x7f
+}
@@ -0,0 +1,97 @@
+package messaging
+
+import (
+	"context"
+	"math"
+	"testing"
+)
+
+func TestSanitizeResult(t *testing.T) {
+	result := map[string]interface{}{
+		"ok":      "plain",
+		"inf":     math.Inf(1),
+		"invalid": "bad \xff byte",
+		"control": "bell\a and nul\x00 kept\ttab\nnewline",
+		"nested": []interface{}{
+			math.NaN(),
+			map[interface{}]interface{}{1: "one"},
+		},
+		"key\x01": true,
+	}
+
+	sanitized, changed := sanitizeResult(result)
+	if !changed {
+		t.Fatal("sanitizeResult reported no change")
+	}
+
+	want := map[string]interface{}{
+		"ok":      "plain",
+		"inf":     nil,
+		"invalid": "bad � byte",
+		"control": "bell and nul kept\ttab\nnewline",
+		"key":     true,
+	}
+	for key, value := range want {
+		if got, ok := sanitized[key]; !ok || got != value {
+			t.Errorf("sanitized[%q] = %#v, want %#v", key, got, value)
+		}
+	}
+	if _, ok := sanitized["key\x01"]; ok {
+		t.Error("key with a control character kept")
+	}
+
+	nested := sanitized["nested"].([]interface{})
+	if nested[0] != nil {
+		t.Errorf("NaN sanitized to %v, want nil", nested[0])
+	}
+	if m, ok := nested[1].(map[string]interface{}); !ok || m["1"] != "one" {
+		t.Errorf("non-string keyed map sanitized to %#v", nested[1])
+	}
+
+	// The caller's result is left untouched
+	if _, ok := result["inf"].(float64); !ok {
+		t.Error("sanitizeResult modified its input")
+	}
+}
+
+func TestSanitizeResultLeavesCleanResultAlone(t *testing.T) {
+	result := map[string]interface{}{
+		"text":   "héllo\tworld\r\n",
+		"number": 1.5,
+		"list":   []interface{}{"a", true},
+	}
+	if _, changed := sanitizeResult(result); changed {
+		t.Error("clean result reported as changed")
+	}
+}
+
+func TestSanitizedResultPublished(t *testing.T) {
+	_, client := newTestRedis(t)
+	c := newTestConsumer(t, client, handlerFunc(func(ctx context.Context, msg *InvocationMessage) (*ActivationResult, error) {
+		result, _ := succeed(ctx, msg)
+		result.Response.Result = map[string]any{
+			"ratio": math.Inf(-1),
+			"name":  "a\x00b\xfe",
+		}
+		return result, nil
+	}))
+
+	publisher := NewPublisher(client)
+	publisher.SetRecentActivations(NewRecentActivations(10))
+	c.SetPublisher(publisher)
+
+	c.processMessage(context.Background(), enqueue(t, c, testInvocation("act-1", "ns")))
+
+	fields := publishedFields(t, client, "act-1")
+	if want := `{"statusCode":0,"success":true,"result":{"name":"ab�","ratio":null}}`; fields["response"] != want {
+		t.Errorf("response = %s, want %s", fields["response"], want)
+	}
+
+	result, err := publisher.GetActivation(context.Background(), "act-1")
+	if err != nil {
+		t.Fatalf("GetActivation: %v", err)
+	}
+	if value, ok := annotationValue(result, sanitizedAnnotation); !ok || value != true {
+		t.Errorf("%s annotation = %v, want true", sanitizedAnnotation, value)
+	}
+}
@@ -0,0 +1,87 @@
+//go:build linux
+
+package runtime
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// journalEntry holds the journald fields written by Docker's journald driver
+type journalEntry struct {
+	Message           string `json:"MESSAGE"`
+	Priority          string `json:"PRIORITY"`
+	RealtimeTimestamp string `json:"__REALTIME_TIMESTAMP"` // microseconds since epoch
+}
+
+// readJournald reads a container's log lines from the host journal
+func readJournald(ctx context.Context, containerID string, since time.Time) (*CollectResult, error) {
+	if len(containerID) > 12 {
+		containerID = containerID[:12]
+	}
+
+	cmd := exec.CommandContext(ctx, "journalctl",
+		"CONTAINER_ID="+containerID,
+		"--since", since.Format("2006-01-02 15:04:05.000000"),
+		"--output", "json",
+		"--no-pager",
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("journalctl failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return parseJournal(bytes.NewReader(out))
+}
+
+// parseJournal parses journalctl JSON output into log lines, stopping at the
+// activation marker
+func parseJournal(out *bytes.Reader) (*CollectResult, error) {
+	result := &CollectResult{}
+
+	scanner := bufio.NewScanner(out)
+	scanner.Buffer(make([]byte, 64*1024), DefaultMaxLogSize)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue // Skip entries with binary messages
+		}
+
+		usec, err := strconv.ParseInt(entry.RealtimeTimestamp, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		// Docker logs stderr at priority 3 (err) and stdout at 6 (info)
+		stream := "stdout"
+		if entry.Priority == "3" {
+			stream = "stderr"
+		}
+
+		result.Lines = append(result.Lines, LogLine{
+			Timestamp: time.UnixMicro(usec).UTC(),
+			Stream:    stream,
+			Message:   entry.Message,
+		})
+
+		if strings.Contains(entry.Message, LogMarker) {
+			result.Complete = true
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read journal output: %w", err)
+	}
+
+	return result, nil
+}
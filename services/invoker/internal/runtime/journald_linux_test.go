@@ -0,0 +1,34 @@
+//go:build linux
+
+package runtime
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestParseJournalStopsAtMarker(t *testing.T) {
+	journal := `{"MESSAGE":"hello","PRIORITY":"6","__REALTIME_TIMESTAMP":"1700000000000000"}
+{"MESSAGE":[104,105],"PRIORITY":"6","__REALTIME_TIMESTAMP":"1700000000000001"}
+{"MESSAGE":"oops","PRIORITY":"3","__REALTIME_TIMESTAMP":"1700000000000002"}
+{"MESSAGE":"` + LogMarker + `","PRIORITY":"6","__REALTIME_TIMESTAMP":"1700000000000003"}
+{"MESSAGE":"next activation","PRIORITY":"6","__REALTIME_TIMESTAMP":"1700000000000004"}
+`
+	result, err := parseJournal(bytes.NewReader([]byte(journal)))
+	if err != nil {
+		t.Fatalf("parseJournal() = %v", err)
+	}
+
+	// The binary message is skipped and the next activation's line is left
+	if !result.Complete || len(result.Lines) != 3 {
+		t.Fatalf("parseJournal() = %+v, want 3 lines up to the marker", result)
+	}
+	hello, oops := result.Lines[0], result.Lines[1]
+	if hello.Message != "hello" || hello.Stream != "stdout" || !hello.Timestamp.Equal(time.UnixMicro(1700000000000000)) {
+		t.Errorf("first line = %+v", hello)
+	}
+	if oops.Message != "oops" || oops.Stream != "stderr" {
+		t.Errorf("second line = %+v, want stderr", oops)
+	}
+}
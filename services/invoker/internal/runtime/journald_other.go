@@ -0,0 +1,14 @@
+//go:build !linux
+
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// readJournald is unavailable off Linux
+func readJournald(ctx context.Context, containerID string, since time.Time) (*CollectResult, error) {
+	return nil, fmt.Errorf("journald log driver is only supported on linux")
+}
@@ -1,7 +1,6 @@
 package runtime
 
 import (
-	"bufio"
 	"context"
 	"encoding/binary"
 	"fmt"
@@ -9,8 +8,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
-	"github.com/docker/docker/client"
 )
 
 const (
@@ -27,22 +26,49 @@ type LogLine struct {
 	Message   string
 }
 
+// CollectResult holds the log lines collected for an activation
+type CollectResult struct {
+	Lines []LogLine
+	// Complete is false when the activation marker was never seen, meaning
+	// the runtime stopped before finishing the activation
+	Complete bool
+}
+
+// DockerLogReader is the part of the Docker API logs are collected through
+type DockerLogReader interface {
+	ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error)
+	ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error)
+}
+
 // LogCollector handles collection and framing of container logs
 type LogCollector struct {
-	manager   *ContainerManager
+	client    DockerLogReader
 	logMarker string
+
+	// journald reads logs for containers using the journald log driver,
+	// which the Docker logs API can't serve
+	journald func(ctx context.Context, containerID string, since time.Time) (*CollectResult, error)
+
+	// sem bounds concurrent reads through CollectWithSemaphore; nil means
+	// unbounded
+	sem chan struct{}
 }
 
-// NewLogCollector creates a new log collector
-func NewLogCollector(manager *ContainerManager) *LogCollector {
+// NewLogCollector creates a new log collector reading through client
+func NewLogCollector(client DockerLogReader) *LogCollector {
 	return &LogCollector{
-		manager:   manager,
+		client:    client,
 		logMarker: LogMarker,
+		journald:  readJournald,
 	}
 }
 
 // CollectLogs retrieves logs from a container since the specified timestamp
-func (lc *LogCollector) CollectLogs(ctx context.Context, containerID string, since time.Time) ([]LogLine, error) {
+func (lc *LogCollector) CollectLogs(ctx context.Context, containerID string, since time.Time) (*CollectResult, error) {
+	if lc.usesJournald(ctx, containerID) {
+		return lc.journald(ctx, containerID, since)
+	}
+
 	opts := container.LogsOptions{
 		ShowStdout: true,
 		ShowStderr: true,
@@ -51,7 +77,7 @@ func (lc *LogCollector) CollectLogs(ctx context.Context, containerID string, sin
 		Follow:     false,
 	}
 
-	logs, err := lc.manager.client.ContainerLogs(ctx, containerID, opts)
+	logs, err := lc.client.ContainerLogs(ctx, containerID, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get container logs: %w", err)
 	}
@@ -60,6 +86,40 @@ func (lc *LogCollector) CollectLogs(ctx context.Context, containerID string, sin
 	return lc.parseLogs(logs)
 }
 
+// SetConcurrency bounds how many CollectWithSemaphore reads run at once so
+// log collection for many activations doesn't pile onto the Docker API.
+// Zero or less removes the bound
+func (lc *LogCollector) SetConcurrency(n int) {
+	if n <= 0 {
+		lc.sem = nil
+		return
+	}
+	lc.sem = make(chan struct{}, n)
+}
+
+// CollectWithSemaphore collects logs like CollectLogs, first waiting for one
+// of the configured read slots
+func (lc *LogCollector) CollectWithSemaphore(ctx context.Context, containerID string, since time.Time) (*CollectResult, error) {
+	if lc.sem != nil {
+		select {
+		case lc.sem <- struct{}{}:
+			defer func() { <-lc.sem }()
+		case <-ctx.Done():
+			return nil, fmt.Errorf("waiting to collect logs: %w", ctx.Err())
+		}
+	}
+	return lc.CollectLogs(ctx, containerID, since)
+}
+
+// usesJournald reports whether the container logs to journald
+func (lc *LogCollector) usesJournald(ctx context.Context, containerID string) bool {
+	inspect, err := lc.client.ContainerInspect(ctx, containerID)
+	if err != nil || inspect.HostConfig == nil {
+		return false
+	}
+	return inspect.HostConfig.LogConfig.Type == "journald"
+}
+
 // StreamLogs streams logs from a container as they arrive
 func (lc *LogCollector) StreamLogs(ctx context.Context, containerID string, since time.Time) (<-chan LogLine, error) {
 	opts := container.LogsOptions{
@@ -70,7 +130,7 @@ func (lc *LogCollector) StreamLogs(ctx context.Context, containerID string, sinc
 		Follow:     true,
 	}
 
-	logs, err := lc.manager.client.ContainerLogs(ctx, containerID, opts)
+	logs, err := lc.client.ContainerLogs(ctx, containerID, opts)
 	if err != nil {
 		return nil, fmt.Errorf("failed to stream container logs: %w", err)
 	}
@@ -80,12 +140,12 @@ func (lc *LogCollector) StreamLogs(ctx context.Context, containerID string, sinc
 		defer close(ch)
 		defer logs.Close()
 
-		logLines, err := lc.parseLogs(logs)
+		collected, err := lc.parseLogs(logs)
 		if err != nil {
 			return
 		}
 
-		for _, line := range logLines {
+		for _, line := range collected.Lines {
 			select {
 			case ch <- line:
 				if strings.Contains(line.Message, lc.logMarker) {
@@ -100,9 +160,10 @@ func (lc *LogCollector) StreamLogs(ctx context.Context, containerID string, sinc
 	return ch, nil
 }
 
-// parseLogs parses Docker logs format into LogLine structs
-func (lc *LogCollector) parseLogs(reader io.Reader) ([]LogLine, error) {
-	var lines []LogLine
+// parseLogs parses Docker logs format into LogLine structs, noting whether
+// the activation marker was reached
+func (lc *LogCollector) parseLogs(reader io.Reader) (*CollectResult, error) {
+	result := &CollectResult{}
 	header := make([]byte, 8)
 
 	for {
@@ -136,15 +197,16 @@ func (lc *LogCollector) parseLogs(reader io.Reader) ([]LogLine, error) {
 			continue // Skip malformed lines
 		}
 
-		lines = append(lines, logLine)
+		result.Lines = append(result.Lines, logLine)
 
 		// Stop at marker
 		if strings.Contains(logLine.Message, lc.logMarker) {
+			result.Complete = true
 			break
 		}
 	}
 
-	return lines, nil
+	return result, nil
 }
 
 // parseLogLine parses a single log line with timestamp
@@ -220,6 +282,6 @@ func (lc *LogCollector) CollectAndFormatLogs(ctx context.Context, containerID st
 		return nil, err
 	}
 
-	formatted := lc.FormatLogs(logs)
+	formatted := lc.FormatLogs(logs.Lines)
 	return lc.TruncateLogs(formatted, maxSize), nil
 }
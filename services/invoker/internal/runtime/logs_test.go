@@ -0,0 +1,134 @@
+package runtime
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+// fakeLogReader serves container logs in Docker's multiplexed format for a
+// container using driver, counting concurrent log reads
+type fakeLogReader struct {
+	driver string
+	lines  []string
+	delay  time.Duration // how long each log read takes
+
+	mu     sync.Mutex
+	reads  int
+	active int
+	peak   int
+}
+
+func (f *fakeLogReader) ContainerInspect(ctx context.Context, containerID string) (types.ContainerJSON, error) {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			HostConfig: &container.HostConfig{LogConfig: container.LogConfig{Type: f.driver}},
+		},
+	}, nil
+}
+
+func (f *fakeLogReader) ContainerLogs(ctx context.Context, containerID string, options container.LogsOptions) (io.ReadCloser, error) {
+	f.mu.Lock()
+	f.reads++
+	f.active++
+	f.peak = max(f.peak, f.active)
+	f.mu.Unlock()
+
+	time.Sleep(f.delay)
+
+	f.mu.Lock()
+	f.active--
+	f.mu.Unlock()
+
+	var buf bytes.Buffer
+	for _, line := range f.lines {
+		frame := time.Now().UTC().Format(time.RFC3339Nano) + " " + line + "\n"
+		header := make([]byte, 8)
+		header[0] = 1
+		binary.BigEndian.PutUint32(header[4:], uint32(len(frame)))
+		buf.Write(header)
+		buf.WriteString(frame)
+	}
+	return io.NopCloser(&buf), nil
+}
+
+func TestJournaldDriverReadFromJournal(t *testing.T) {
+	tests := []struct {
+		driver      string
+		fromJournal bool
+	}{
+		{driver: "journald", fromJournal: true},
+		{driver: "json-file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.driver, func(t *testing.T) {
+			docker := &fakeLogReader{driver: tt.driver, lines: []string{"from docker", LogMarker}}
+			lc := NewLogCollector(docker)
+			var journalReads int
+			lc.journald = func(ctx context.Context, containerID string, since time.Time) (*CollectResult, error) {
+				journalReads++
+				return &CollectResult{
+					Lines:    []LogLine{{Timestamp: since, Stream: "stdout", Message: "from journal"}, {Message: LogMarker}},
+					Complete: true,
+				}, nil
+			}
+
+			result, err := lc.CollectLogs(context.Background(), "abc", time.Now())
+			if err != nil {
+				t.Fatalf("CollectLogs() = %v", err)
+			}
+
+			want := "from docker"
+			if tt.fromJournal {
+				want = "from journal"
+			}
+			if !result.Complete || len(result.Lines) != 2 || result.Lines[0].Message != want {
+				t.Errorf("CollectLogs() = %+v, want %q", result, want)
+			}
+			if fromJournal := journalReads == 1 && docker.reads == 0; fromJournal != tt.fromJournal {
+				t.Errorf("%d journal and %d Docker reads for the %s driver", journalReads, docker.reads, tt.driver)
+			}
+		})
+	}
+}
+
+func TestCollectWithSemaphoreBoundsConcurrentReads(t *testing.T) {
+	docker := &fakeLogReader{driver: "json-file", lines: []string{"ran", LogMarker}, delay: 20 * time.Millisecond}
+	lc := NewLogCollector(docker)
+	lc.SetConcurrency(3)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 12; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := lc.CollectWithSemaphore(context.Background(), "abc", time.Now()); err != nil {
+				t.Errorf("CollectWithSemaphore() = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if docker.reads != 12 || docker.peak != 3 {
+		t.Errorf("%d reads with at most %d at once, want 12 bounded to 3", docker.reads, docker.peak)
+	}
+}
+
+func TestCollectWithSemaphoreGivesUpWithContext(t *testing.T) {
+	lc := NewLogCollector(&fakeLogReader{driver: "json-file"})
+	lc.SetConcurrency(1)
+	lc.sem <- struct{}{} // another collection holds the only slot
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := lc.CollectWithSemaphore(ctx, "abc", time.Now()); err == nil {
+		t.Fatal("CollectWithSemaphore() succeeded without a free slot")
+	}
+}
@@ -1,6 +1,7 @@
 package runtime
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
@@ -8,25 +9,67 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"strings"
 	"time"
 
-	"github.com/sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"go.uber.org/zap"
+)
+
+const (
+	// TransactionHeader carries the activation's transaction ID to the
+	// runtime, which prefixes the action's logs with it
+	TransactionHeader = "X-OW-Transaction-Id"
+	// TraceParentHeader carries the W3C trace context to the runtime
+	TraceParentHeader = "traceparent"
 )
 
 // RuntimeProxy handles HTTP communication with action runtime containers
 type RuntimeProxy struct {
-	httpClient *http.Client
-	timeout    time.Duration
-	logger     *logrus.Logger
+	httpClient   *http.Client
+	dockerClient *client.Client // used by the exec transport
+	initTimeout  time.Duration  // budget for /init, which may compile
+	runTimeout   time.Duration  // cap on runs, which are further bound by the action deadline
+	wrapKey      string         // key non-JSON exec output is wrapped under
+	logger       *zap.Logger
 }
 
+const (
+	// DefaultInitTimeout bounds /init when no init timeout is configured
+	DefaultInitTimeout = 5 * time.Minute
+	// minRunTimeout is the budget a run gets when its deadline has already
+	// passed, so the runtime can still answer
+	minRunTimeout = 1 * time.Second
+	// execKillTimeout bounds killing a container whose exec run was cut short
+	execKillTimeout = 5 * time.Second
+	// DefaultResultWrapKey is the key non-JSON exec output is wrapped under,
+	// as the HTTP runtimes do by default
+	DefaultResultWrapKey = "body"
+)
+
 // InitPayload represents the initialization payload sent to runtime containers
 type InitPayload struct {
-	Name   string            `json:"name"`
-	Main   string            `json:"main"`
-	Code   string            `json:"code"`
-	Binary bool              `json:"binary"`
-	Env    map[string]string `json:"env"`
+	Name       string                 `json:"name"`
+	Main       string                 `json:"main"`
+	Code       string                 `json:"code"`
+	Binary     bool                   `json:"binary"`
+	Env        map[string]string      `json:"env"`
+	InitParams map[string]interface{} `json:"init_params,omitempty"` // bound params, overridden by run params
+	BuildFlags []string               `json:"build_flags,omitempty"` // allowlisted go build flags
+	Deadline   int64                  `json:"deadline,omitempty"`    // unix ms the activation must finish by, to budget compiles
+
+	// Sent as headers rather than in the payload
+	TransactionID string `json:"-"`
+	TraceParent   string `json:"-"`
+}
+
+// InitResult represents the compile stats reported by a runtime on init
+type InitResult struct {
+	OK          bool  `json:"ok"`
+	CompileMs   int64 `json:"compileMs"`
+	BinaryBytes int64 `json:"binaryBytes"`
 }
 
 // RunPayload represents the execution payload sent to runtime containers
@@ -37,13 +80,40 @@ type RunPayload struct {
 	ActivationID  string                 `json:"activation_id"`
 	TransactionID string                 `json:"transaction_id"`
 	Deadline      int64                  `json:"deadline"`
+	TraceParent   string                 `json:"-"` // sent as a header
+
+	RawInput      bool              `json:"raw_input,omitempty"`      // pass RawBody on stdin instead of the params
+	RawBody       []byte            `json:"raw_body,omitempty"`       // base64 in JSON
+	StdinFormat   string            `json:"stdin_format,omitempty"`   // json (default), json-line or length-prefixed
+	StdinProtocol string            `json:"stdin_protocol,omitempty"` // env (default) or actionloop
+	Activation    ActivationContext `json:"activation"`
+}
+
+// ActivationContext is the activation metadata the runtime sets as the
+// action's __OW_* env vars, and sends on stdin for the actionloop protocol
+type ActivationContext struct {
+	ID         string `json:"activationId"`
+	Namespace  string `json:"namespace"`
+	ActionName string `json:"action_name"`
+	APIHost    string `json:"api_host"`
+	APIKey     string `json:"api_key,omitempty"`
+	Deadline   int64  `json:"deadline"`
 }
 
 // RunResult represents the result of action execution
 type RunResult struct {
 	Result     map[string]interface{} `json:"result"`
 	Error      string                 `json:"error"`
-	StatusCode int                    `json:"statusCode"` // 0=success, 1=app error, 2=dev error
+	StatusCode int                    `json:"statusCode"`         // 0=success, 1=app error, 2=dev error
+	ExitCode   int                    `json:"exitCode,omitempty"` // non-zero exit code of a failed action process
+}
+
+// StreamChunk is one NDJSON line of a streamed run
+type StreamChunk struct {
+	Type     string                 `json:"type"` // "chunk", "result" or "error"
+	Data     map[string]interface{} `json:"data,omitempty"`
+	Error    string                 `json:"error,omitempty"`
+	ExitCode int                    `json:"exitCode,omitempty"`
 }
 
 // Error types for runtime operations
@@ -88,15 +158,13 @@ func (e *ContainerError) Error() string {
 	return fmt.Sprintf("container error: %s", e.Message)
 }
 
-// NewRuntimeProxy creates a new RuntimeProxy with the specified timeout
-func NewRuntimeProxy(timeout time.Duration) *RuntimeProxy {
-	logger := logrus.New()
-	logger.SetLevel(logrus.InfoLevel)
-	logger.SetFormatter(&logrus.JSONFormatter{})
-
+// NewRuntimeProxy creates a new RuntimeProxy whose runs are capped at
+// runTimeout. Init uses DefaultInitTimeout unless SetInitTimeout changes it
+func NewRuntimeProxy(runTimeout time.Duration, logger *zap.Logger) *RuntimeProxy {
 	return &RuntimeProxy{
+		// Timeouts are applied per request through the context, since init
+		// and run need different budgets
 		httpClient: &http.Client{
-			Timeout: timeout,
 			Transport: &http.Transport{
 				DisableKeepAlives: true, // Disable keep-alive for container isolation
 				DialContext: (&net.Dialer{
@@ -110,36 +178,59 @@ func NewRuntimeProxy(timeout time.Duration) *RuntimeProxy {
 				ExpectContinueTimeout: 1 * time.Second,
 			},
 		},
-		timeout: timeout,
-		logger:  logger,
+		initTimeout: DefaultInitTimeout,
+		runTimeout:  runTimeout,
+		wrapKey:     DefaultResultWrapKey,
+		logger:      logger,
+	}
+}
+
+// timeoutFor derives a run's budget from the action deadline (unix
+// milliseconds, 0 for none), capped at the run timeout
+func (rp *RuntimeProxy) timeoutFor(deadline int64) time.Duration {
+	timeout := rp.runTimeout
+	if deadline > 0 {
+		if untilDeadline := time.Until(time.UnixMilli(deadline)); untilDeadline < timeout {
+			timeout = untilDeadline
+		}
+	}
+	if timeout <= 0 {
+		timeout = minRunTimeout
 	}
+	return timeout
 }
 
 // Init initializes a runtime container with action code
-func (rp *RuntimeProxy) Init(ctx context.Context, containerIP string, initPayload *InitPayload) error {
+func (rp *RuntimeProxy) Init(ctx context.Context, containerIP string, initPayload *InitPayload) (*InitResult, error) {
 	url := fmt.Sprintf("http://%s:8080/init", containerIP)
 
-	rp.logger.WithFields(logrus.Fields{
-		"url":        url,
-		"actionName": initPayload.Name,
-		"main":       initPayload.Main,
-		"binary":     initPayload.Binary,
-	}).Info("Initializing runtime container")
+	rp.logger.Info("Initializing runtime container",
+		zap.String("url", url),
+		zap.String("actionName", initPayload.Name),
+		zap.String("main", initPayload.Main),
+		zap.Bool("binary", initPayload.Binary))
+
+	// Compiling can take far longer than running, so init gets its own budget
+	ctx, cancel := context.WithTimeout(ctx, rp.initTimeout)
+	defer cancel()
 
 	// Create request payload
 	payload := map[string]interface{}{
 		"value": map[string]interface{}{
-			"name":   initPayload.Name,
-			"main":   initPayload.Main,
-			"code":   initPayload.Code,
-			"binary": initPayload.Binary,
-			"env":    initPayload.Env,
+			"name":        initPayload.Name,
+			"main":        initPayload.Main,
+			"code":        initPayload.Code,
+			"binary":      initPayload.Binary,
+			"env":         initPayload.Env,
+			"init_params": initPayload.InitParams,
+			"build_flags": initPayload.BuildFlags,
+			"deadline":    initPayload.Deadline,
 		},
 	}
 
 	payloadBytes, err := json.Marshal(payload)
 	if err != nil {
-		return &InitializationError{
+		return nil, &InitializationError{
 			Message: "failed to marshal init payload",
 		}
 	}
@@ -147,23 +238,26 @@ func (rp *RuntimeProxy) Init(ctx context.Context, containerIP string, initPayloa
 	// Create HTTP request
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payloadBytes))
 	if err != nil {
-		return &InitializationError{
+		return nil, &InitializationError{
 			Message: "failed to create init request",
 		}
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setCorrelationHeaders(req, initPayload.TransactionID, initPayload.TraceParent)
+	req, finishTrace := rp.traceRequest(req, "init")
 
 	// Send request
 	resp, err := rp.httpClient.Do(req)
+	finishTrace()
 	if err != nil {
 		// Check for timeout
 		if ctx.Err() == context.DeadlineExceeded {
-			return &TimeoutError{
+			return nil, &TimeoutError{
 				Message: "init request timed out",
-				Timeout: rp.timeout,
+				Timeout: rp.initTimeout,
 			}
 		}
-		return &ContainerError{
+		return nil, &ContainerError{
 			Message: "failed to connect to runtime container",
 			Cause:   err,
 		}
@@ -173,44 +267,56 @@ func (rp *RuntimeProxy) Init(ctx context.Context, containerIP string, initPayloa
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		rp.logger.WithError(err).Warn("Failed to read init response body")
+		rp.logger.Warn("Failed to read init response body",
+			zap.Error(err))
 		body = []byte{}
 	}
 
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		rp.logger.WithFields(logrus.Fields{
-			"statusCode": resp.StatusCode,
-			"body":       string(body),
-		}).Error("Init request failed")
+		rp.logger.Error("Init request failed",
+			zap.Int("statusCode", resp.StatusCode),
+			zap.String("body", string(body)))
 
-		return &InitializationError{
+		return nil, &InitializationError{
 			Message:    "init request returned non-200 status",
 			StatusCode: resp.StatusCode,
 			Body:       string(body),
 		}
 	}
 
-	rp.logger.WithFields(logrus.Fields{
-		"actionName": initPayload.Name,
-		"statusCode": resp.StatusCode,
-	}).Info("Runtime container initialized successfully")
+	// Parse compile stats; runtimes that don't report them just return {"ok":true}
+	var result InitResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		rp.logger.Warn("Failed to parse init response",
+			zap.Error(err),
+			zap.String("body", string(body)))
+	}
+
+	rp.logger.Info("Runtime container initialized successfully",
+		zap.String("actionName", initPayload.Name),
+		zap.Int("statusCode", resp.StatusCode),
+		zap.Int64("compileMs", result.CompileMs),
+		zap.Int64("binaryBytes", result.BinaryBytes))
 
-	return nil
+	return &result, nil
 }
 
 // Run executes an action in a runtime container
 func (rp *RuntimeProxy) Run(ctx context.Context, containerIP string, runPayload *RunPayload) (*RunResult, error) {
 	url := fmt.Sprintf("http://%s:8080/run", containerIP)
 
-	rp.logger.WithFields(logrus.Fields{
-		"url":           url,
-		"namespace":     runPayload.Namespace,
-		"actionName":    runPayload.ActionName,
-		"activationID":  runPayload.ActivationID,
-		"transactionID": runPayload.TransactionID,
-		"deadline":      runPayload.Deadline,
-	}).Info("Executing action in runtime container")
+	rp.logger.Info("Executing action in runtime container",
+		zap.String("url", url),
+		zap.String("namespace", runPayload.Namespace),
+		zap.String("actionName", runPayload.ActionName),
+		zap.String("activationID", runPayload.ActivationID),
+		zap.String("transactionID", runPayload.TransactionID),
+		zap.Int64("deadline", runPayload.Deadline))
+
+	timeout := rp.timeoutFor(runPayload.Deadline)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
 
 	// Create request payload
 	payloadBytes, err := json.Marshal(runPayload)
@@ -228,15 +334,18 @@ func (rp *RuntimeProxy) Run(ctx context.Context, containerIP string, runPayload
 		}
 	}
 	req.Header.Set("Content-Type", "application/json")
+	setCorrelationHeaders(req, runPayload.TransactionID, runPayload.TraceParent)
+	req, finishTrace := rp.traceRequest(req, "run")
 
 	// Send request
 	resp, err := rp.httpClient.Do(req)
+	finishTrace()
 	if err != nil {
 		// Check for timeout
 		if ctx.Err() == context.DeadlineExceeded {
 			return nil, &TimeoutError{
 				Message: "run request timed out",
-				Timeout: rp.timeout,
+				Timeout: timeout,
 			}
 		}
 		return nil, &ContainerError{
@@ -249,18 +358,32 @@ func (rp *RuntimeProxy) Run(ctx context.Context, containerIP string, runPayload
 	// Read response body
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		rp.logger.WithError(err).Error("Failed to read run response body")
+		rp.logger.Error("Failed to read run response body",
+			zap.Error(err))
 		return nil, &ExecutionError{
 			Message: "failed to read run response",
 		}
 	}
 
+	// A bad gateway means the action process itself failed, which is a
+	// developer error rather than a runtime failure
+	if resp.StatusCode == http.StatusBadGateway {
+		var failed RunResult
+		if err := json.Unmarshal(body, &failed); err == nil && failed.Error != "" {
+			rp.logger.Warn("Action process failed",
+				zap.String("activationID", runPayload.ActivationID),
+				zap.Int("exitCode", failed.ExitCode),
+				zap.String("error", failed.Error))
+			failed.StatusCode = 2
+			return &failed, nil
+		}
+	}
+
 	// Check status code
 	if resp.StatusCode != http.StatusOK {
-		rp.logger.WithFields(logrus.Fields{
-			"statusCode": resp.StatusCode,
-			"body":       string(body),
-		}).Error("Run request failed")
+		rp.logger.Error("Run request failed",
+			zap.Int("statusCode", resp.StatusCode),
+			zap.String("body", string(body)))
 
 		return nil, &ExecutionError{
 			Message:    "run request returned non-200 status",
@@ -272,23 +395,302 @@ func (rp *RuntimeProxy) Run(ctx context.Context, containerIP string, runPayload
 	// Parse response
 	var result RunResult
 	if err := json.Unmarshal(body, &result); err != nil {
-		rp.logger.WithError(err).WithField("body", string(body)).Error("Failed to parse run response")
+		rp.logger.Error("Failed to parse run response",
+			zap.Error(err),
+			zap.String("body", string(body)))
 		return nil, &ExecutionError{
 			Message: "failed to parse run response",
 			Body:    string(body),
 		}
 	}
 
-	rp.logger.WithFields(logrus.Fields{
-		"activationID": runPayload.ActivationID,
-		"statusCode":   result.StatusCode,
-		"hasError":     result.Error != "",
-	}).Info("Action execution completed")
+	rp.logger.Info("Action execution completed",
+		zap.String("activationID", runPayload.ActivationID),
+		zap.Int("statusCode", result.StatusCode),
+		zap.Bool("hasError", result.Error != ""))
 
 	return &result, nil
 }
 
+// RunStream executes an action with partial result streaming, calling
+// onChunk for each partial result before returning the final one
+func (rp *RuntimeProxy) RunStream(ctx context.Context, containerIP string, runPayload *RunPayload, onChunk func(map[string]interface{}) error) (*RunResult, error) {
+	url := fmt.Sprintf("http://%s:8080/run?stream=1", containerIP)
+
+	rp.logger.Info("Executing streaming action in runtime container",
+		zap.String("url", url),
+		zap.String("actionName", runPayload.ActionName),
+		zap.String("activationID", runPayload.ActivationID))
+
+	timeout := rp.timeoutFor(runPayload.Deadline)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	payloadBytes, err := json.Marshal(runPayload)
+	if err != nil {
+		return nil, &ExecutionError{
+			Message: "failed to marshal run payload",
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return nil, &ExecutionError{
+			Message: "failed to create run request",
+		}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setCorrelationHeaders(req, runPayload.TransactionID, runPayload.TraceParent)
+	req, finishTrace := rp.traceRequest(req, "run_stream")
+
+	resp, err := rp.httpClient.Do(req)
+	finishTrace()
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &TimeoutError{
+				Message: "run request timed out",
+				Timeout: timeout,
+			}
+		}
+		return nil, &ContainerError{
+			Message: "failed to connect to runtime container",
+			Cause:   err,
+		}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &ExecutionError{
+			Message:    "run request returned non-200 status",
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+		}
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for scanner.Scan() {
+		var chunk StreamChunk
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			return nil, &ExecutionError{
+				Message: "failed to parse stream chunk",
+				Body:    scanner.Text(),
+			}
+		}
+
+		switch chunk.Type {
+		case "chunk":
+			if err := onChunk(chunk.Data); err != nil {
+				rp.logger.Warn("Failed to forward partial result",
+					zap.Error(err),
+					zap.String("activationID", runPayload.ActivationID))
+			}
+		case "result":
+			return &RunResult{Result: chunk.Data}, nil
+		case "error":
+			return &RunResult{Error: chunk.Error, StatusCode: 2, ExitCode: chunk.ExitCode}, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, &ExecutionError{
+			Message: "failed to read run stream",
+			Body:    err.Error(),
+		}
+	}
+	return nil, &ExecutionError{
+		Message: "run stream ended without a result",
+	}
+}
+
+// ExecRun executes an action in a runtime container that has no HTTP server
+// by running command via docker exec with the params as JSON on stdin
+func (rp *RuntimeProxy) ExecRun(ctx context.Context, containerID string, command []string, runPayload *RunPayload) (*RunResult, error) {
+	if rp.dockerClient == nil {
+		return nil, &ContainerError{
+			Message: "exec transport requires a docker client",
+		}
+	}
+
+	rp.logger.Info("Executing action via container exec",
+		zap.String("containerID", containerID),
+		zap.Strings("command", command),
+		zap.String("actionName", runPayload.ActionName),
+		zap.String("activationID", runPayload.ActivationID))
+
+	timeout := rp.timeoutFor(runPayload.Deadline)
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	paramsBytes, err := json.Marshal(runPayload.Value)
+	if err != nil {
+		return nil, &ExecutionError{
+			Message: "failed to marshal run params",
+		}
+	}
+
+	execResp, err := rp.dockerClient.ContainerExecCreate(ctx, containerID, types.ExecConfig{
+		Cmd:          command,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Env: []string{
+			fmt.Sprintf("__OW_NAMESPACE=%s", runPayload.Namespace),
+			fmt.Sprintf("__OW_ACTION_NAME=%s", runPayload.ActionName),
+			fmt.Sprintf("__OW_ACTIVATION_ID=%s", runPayload.ActivationID),
+			fmt.Sprintf("__OW_TRANSACTION_ID=%s", runPayload.TransactionID),
+			fmt.Sprintf("__OW_DEADLINE=%d", runPayload.Deadline),
+		},
+	})
+	if err != nil {
+		return nil, &ContainerError{
+			Message: "failed to create exec",
+			Cause:   err,
+		}
+	}
+
+	attach, err := rp.dockerClient.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{})
+	if err != nil {
+		return nil, &ContainerError{
+			Message: "failed to attach to exec",
+			Cause:   err,
+		}
+	}
+	defer attach.Close()
+
+	// Write params on stdin then close it so the action sees EOF
+	if _, err := attach.Conn.Write(paramsBytes); err != nil {
+		return nil, &ContainerError{
+			Message: "failed to write exec stdin",
+			Cause:   err,
+		}
+	}
+	if err := attach.CloseWrite(); err != nil {
+		return nil, &ContainerError{
+			Message: "failed to close exec stdin",
+			Cause:   err,
+		}
+	}
+
+	// Demultiplex output, bounded by the context deadline
+	var stdout, stderr bytes.Buffer
+	copyDone := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(&stdout, &stderr, attach.Reader)
+		copyDone <- err
+	}()
+
+	select {
+	case err := <-copyDone:
+		if err != nil {
+			return nil, &ExecutionError{
+				Message: "failed to read exec output",
+				Body:    err.Error(),
+			}
+		}
+	case <-ctx.Done():
+		// Docker can't signal an exec'd process, and closing the attach
+		// leaves it running, so kill the container it runs in
+		rp.killExecContainer(containerID, runPayload.ActivationID)
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, &TimeoutError{
+				Message: "exec run timed out",
+				Timeout: timeout,
+			}
+		}
+		return nil, &ContainerError{
+			Message: "exec run canceled",
+			Cause:   ctx.Err(),
+		}
+	}
+
+	inspect, err := rp.dockerClient.ContainerExecInspect(ctx, execResp.ID)
+	if err != nil {
+		return nil, &ContainerError{
+			Message: "failed to inspect exec",
+			Cause:   err,
+		}
+	}
+
+	// Action errors on non-zero exit are developer errors
+	if inspect.ExitCode != 0 {
+		rp.logger.Error("Exec action exited with error",
+			zap.String("activationID", runPayload.ActivationID),
+			zap.Int("exitCode", inspect.ExitCode),
+			zap.String("stderr", stderr.String()))
+
+		return &RunResult{
+			Error:      fmt.Sprintf("action exited with code %d: %s", inspect.ExitCode, strings.TrimSpace(stderr.String())),
+			StatusCode: 2,
+			ExitCode:   inspect.ExitCode,
+		}, nil
+	}
+
+	// Parse stdout as JSON, wrapping anything else like the HTTP runtimes do
+	result := RunResult{}
+	output := strings.TrimSpace(stdout.String())
+	if output != "" {
+		if err := json.Unmarshal([]byte(output), &result.Result); err != nil {
+			result.Result = map[string]interface{}{
+				rp.wrapKey: output,
+			}
+		}
+	}
+
+	rp.logger.Info("Exec action execution completed",
+		zap.String("activationID", runPayload.ActivationID),
+		zap.Int("statusCode", result.StatusCode))
+
+	return &result, nil
+}
+
+// setCorrelationHeaders tags a runtime request with the activation's
+// transaction and trace context, when known
+func setCorrelationHeaders(req *http.Request, transactionID, traceParent string) {
+	if transactionID != "" {
+		req.Header.Set(TransactionHeader, transactionID)
+	}
+	if traceParent != "" {
+		req.Header.Set(TraceParentHeader, traceParent)
+	}
+}
+
+// SetInitTimeout configures the budget for /init requests
+func (rp *RuntimeProxy) SetInitTimeout(timeout time.Duration) {
+	if timeout > 0 {
+		rp.initTimeout = timeout
+	}
+}
+
+// SetResultWrapKey configures the key non-JSON exec output is wrapped under,
+// to match the RESULT_WRAP_KEY the HTTP runtimes are given
+func (rp *RuntimeProxy) SetResultWrapKey(key string) {
+	if key != "" {
+		rp.wrapKey = key
+	}
+}
+
+// killExecContainer kills a container whose exec run was timed out or
+// canceled, so the action doesn't keep running unobserved
+func (rp *RuntimeProxy) killExecContainer(containerID, activationID string) {
+	ctx, cancel := context.WithTimeout(context.Background(), execKillTimeout)
+	defer cancel()
+
+	if err := rp.dockerClient.ContainerKill(ctx, containerID, "SIGKILL"); err != nil {
+		rp.logger.Warn("Failed to kill exec container",
+			zap.String("containerID", containerID),
+			zap.String("activationID", activationID),
+			zap.Error(err))
+	}
+}
+
+// SetDockerClient sets the Docker client used by the exec transport
+func (rp *RuntimeProxy) SetDockerClient(dockerClient *client.Client) {
+	rp.dockerClient = dockerClient
+}
+
 // SetLogger allows setting a custom logger
-func (rp *RuntimeProxy) SetLogger(logger *logrus.Logger) {
+func (rp *RuntimeProxy) SetLogger(logger *zap.Logger) {
 	rp.logger = logger
 }
@@ -0,0 +1,285 @@
+package runtime
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// newTestProxy returns a proxy whose requests reach handler whatever
+// container IP they are sent to, and the logs it writes
+func newTestProxy(t *testing.T, handler http.Handler) (*RuntimeProxy, *observer.ObservedLogs) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	core, logs := observer.New(zap.DebugLevel)
+	rp := NewRuntimeProxy(time.Minute, zap.New(core))
+	rp.httpClient.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, server.Listener.Addr().String())
+		},
+	}
+	return rp, logs
+}
+
+func TestRunStreamForwardsTraceAndRecordsPhases(t *testing.T) {
+	const traceParent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	headers := make(chan http.Header, 1)
+	rp, logs := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.Write([]byte(`{"type":"chunk","data":{"n":1}}` + "\n"))
+		w.Write([]byte(`{"type":"result","data":{"n":2}}` + "\n"))
+	}))
+
+	var chunks []map[string]interface{}
+	result, err := rp.RunStream(context.Background(), "10.0.0.1", &RunPayload{
+		ActivationID:  "act-1",
+		TransactionID: "tx-1",
+		TraceParent:   traceParent,
+	}, func(chunk map[string]interface{}) error {
+		chunks = append(chunks, chunk)
+		return nil
+	})
+	if err != nil || result.Result["n"] != 2.0 || len(chunks) != 1 {
+		t.Fatalf("RunStream() = %+v, %v after %d chunks", result, err, len(chunks))
+	}
+
+	header := <-headers
+	if got := header.Get(TraceParentHeader); got != traceParent {
+		t.Errorf("%s header = %q, want %q", TraceParentHeader, got, traceParent)
+	}
+	if got := header.Get(TransactionHeader); got != "tx-1" {
+		t.Errorf("%s header = %q, want tx-1", TransactionHeader, got)
+	}
+
+	phases := logs.FilterMessage("Runtime proxy request phases").FilterField(zap.String("operation", "run_stream")).All()
+	if len(phases) != 1 {
+		t.Fatalf("logged %d run_stream phase timings, want 1", len(phases))
+	}
+	if firstByte := phases[0].ContextMap()["firstByte"].(time.Duration); firstByte <= 0 {
+		t.Errorf("first byte phase = %v, want it recorded", firstByte)
+	}
+}
+
+func TestRunSendsStdinOptionsAndActivation(t *testing.T) {
+	// The fields of the go123 runtime's RunRequest
+	var got struct {
+		RawInput      bool   `json:"raw_input"`
+		RawBody       []byte `json:"raw_body"`
+		StdinFormat   string `json:"stdin_format"`
+		StdinProtocol string `json:"stdin_protocol"`
+		Activation    struct {
+			ID         string `json:"activationId"`
+			Namespace  string `json:"namespace"`
+			ActionName string `json:"action_name"`
+			APIHost    string `json:"api_host"`
+			Deadline   int64  `json:"deadline"`
+		} `json:"activation"`
+	}
+	rp, _ := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write([]byte(`{"result":{}}`))
+	}))
+
+	deadline := time.Now().Add(time.Minute).UnixMilli()
+	payload := &RunPayload{
+		ActivationID:  "act-1",
+		Deadline:      deadline,
+		RawInput:      true,
+		RawBody:       []byte{0x89, 'P', 'N', 'G', 0},
+		StdinFormat:   "length-prefixed",
+		StdinProtocol: "actionloop",
+		Activation: ActivationContext{
+			ID:         "act-1",
+			Namespace:  "ns",
+			ActionName: "resize",
+			APIHost:    "https://whisk.example",
+			Deadline:   deadline,
+		},
+	}
+	if _, err := rp.Run(context.Background(), "10.0.0.1", payload); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+
+	if !got.RawInput || string(got.RawBody) != string(payload.RawBody) {
+		t.Errorf("raw input = %v, %q, want %q verbatim", got.RawInput, got.RawBody, payload.RawBody)
+	}
+	if got.StdinFormat != "length-prefixed" || got.StdinProtocol != "actionloop" {
+		t.Errorf("stdin format, protocol = %q, %q", got.StdinFormat, got.StdinProtocol)
+	}
+	activation := got.Activation
+	if activation.ID != "act-1" || activation.Namespace != "ns" || activation.ActionName != "resize" ||
+		activation.APIHost != "https://whisk.example" || activation.Deadline != deadline {
+		t.Errorf("activation = %+v, want the payload's", activation)
+	}
+}
+
+func TestInitAndRunTimeouts(t *testing.T) {
+	// The runtime takes half a second to answer anything
+	rp, _ := newTestProxy(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(500 * time.Millisecond):
+			w.Write([]byte(`{"ok":true,"result":{}}`))
+		case <-r.Context().Done():
+		}
+	}))
+	rp.runTimeout = 100 * time.Millisecond
+
+	// A slow compile outlasting the run budget still fits the init budget
+	rp.SetInitTimeout(2 * time.Second)
+	if _, err := rp.Init(context.Background(), "10.0.0.1", &InitPayload{Name: "slow"}); err != nil {
+		t.Fatalf("Init() = %v within the init timeout", err)
+	}
+
+	rp.SetInitTimeout(100 * time.Millisecond)
+	var timeout *TimeoutError
+	if _, err := rp.Init(context.Background(), "10.0.0.1", &InitPayload{Name: "slow"}); !errors.As(err, &timeout) || timeout.Timeout != 100*time.Millisecond {
+		t.Fatalf("Init() = %v, want the 100ms init timeout", err)
+	}
+
+	// Runs get whatever is left before the action's deadline when that is
+	// sooner than the run timeout
+	rp.runTimeout = time.Minute
+	deadline := time.Now().Add(150 * time.Millisecond)
+	if _, err := rp.Run(context.Background(), "10.0.0.1", &RunPayload{ActivationID: "act-1", Deadline: deadline.UnixMilli()}); !errors.As(err, &timeout) || timeout.Timeout > 150*time.Millisecond {
+		t.Fatalf("Run() = %v, want a timeout at the deadline", err)
+	}
+	if late := time.Since(deadline); late > 300*time.Millisecond {
+		t.Errorf("Run() gave up %v after the deadline", late)
+	}
+}
+
+// fakeExec is a Docker daemon whose execs run "echo", writing their stdin to
+// stdout, or "print", writing their other arguments
+type fakeExec struct {
+	mu       sync.Mutex
+	commands map[string][]string // exec ID -> command
+}
+
+// newTestExecProxy returns a proxy using a fake exec daemon for its exec
+// transport
+func newTestExecProxy(t *testing.T) *RuntimeProxy {
+	t.Helper()
+
+	server := httptest.NewServer(&fakeExec{commands: make(map[string][]string)})
+	t.Cleanup(server.Close)
+	dockerClient, err := client.NewClientWithOpts(client.WithHost("tcp://"+server.Listener.Addr().String()), client.WithVersion("1.41"))
+	if err != nil {
+		t.Fatalf("NewClientWithOpts: %v", err)
+	}
+	t.Cleanup(func() { dockerClient.Close() })
+
+	rp := NewRuntimeProxy(time.Minute, zap.NewNop())
+	rp.SetDockerClient(dockerClient)
+	return rp
+}
+
+func (f *fakeExec) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Drop the /v1.xx version prefix
+	path := r.URL.Path
+	if i := strings.Index(path[1:], "/"); strings.HasPrefix(path, "/v") && i >= 0 {
+		path = path[i+1:]
+	}
+
+	switch {
+	case strings.HasPrefix(path, "/containers/") && strings.HasSuffix(path, "/exec"):
+		var body struct{ Cmd []string }
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		f.mu.Lock()
+		id := fmt.Sprintf("exec-%d", len(f.commands)+1)
+		f.commands[id] = body.Cmd
+		f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]string{"Id": id})
+
+	case strings.HasPrefix(path, "/exec/") && strings.HasSuffix(path, "/start"):
+		f.mu.Lock()
+		command := f.commands[strings.TrimSuffix(strings.TrimPrefix(path, "/exec/"), "/start")]
+		f.mu.Unlock()
+		// The start options precede stdin on the connection
+		io.Copy(io.Discard, r.Body)
+		f.attach(w, command)
+
+	case strings.HasPrefix(path, "/exec/") && strings.HasSuffix(path, "/json"):
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"ExitCode": 0})
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// attach takes over the connection as Docker does for an attached exec,
+// reading stdin to EOF and answering with the command's output
+func (f *fakeExec) attach(w http.ResponseWriter, command []string) {
+	conn, buf, err := w.(http.Hijacker).Hijack()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+	buf.Flush()
+
+	stdin, _ := io.ReadAll(buf)
+	stdout := stdcopy.NewStdWriter(conn, stdcopy.Stdout)
+	switch {
+	case len(command) > 0 && command[0] == "echo":
+		stdout.Write(stdin)
+	case len(command) > 0 && command[0] == "print":
+		stdout.Write([]byte(strings.Join(command[1:], " ")))
+	}
+}
+
+func TestExecRunEchoesParams(t *testing.T) {
+	rp := newTestExecProxy(t)
+
+	result, err := rp.ExecRun(context.Background(), "container", []string{"echo"}, &RunPayload{
+		Value:        map[string]interface{}{"n": 1.0},
+		ActivationID: "act-1",
+	})
+	if err != nil || result.Result["n"] != 1.0 || result.StatusCode != 0 {
+		t.Fatalf("ExecRun() = %+v, %v, want the params echoed", result, err)
+	}
+}
+
+func TestExecRunWrapsNonJSONOutputUnderConfiguredKey(t *testing.T) {
+	for _, key := range []string{"", "output"} {
+		rp := newTestExecProxy(t)
+		rp.SetResultWrapKey(key)
+		want := key
+		if want == "" {
+			want = DefaultResultWrapKey
+		}
+
+		result, err := rp.ExecRun(context.Background(), "container", []string{"print", "not", "json"}, &RunPayload{ActivationID: "act-1"})
+		if err != nil {
+			t.Fatalf("ExecRun(key %q): %v", key, err)
+		}
+		if len(result.Result) != 1 || result.Result[want] != "not json" {
+			t.Errorf("key %q: result = %v, want the output under %q", key, result.Result, want)
+		}
+	}
+}
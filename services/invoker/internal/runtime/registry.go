@@ -0,0 +1,201 @@
+package runtime
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/penguintechinc/penguinwhisk/invoker/pkg/types"
+)
+
+// Transport selects how the invoker talks to a runtime container
+type Transport string
+
+const (
+	// TransportHTTP drives the runtime through its /init and /run endpoints
+	TransportHTTP Transport = "http"
+	// TransportExec runs the action via docker exec with params on stdin
+	TransportExec Transport = "exec"
+)
+
+// KindBlackbox marks actions shipped as their own image implementing the
+// action interface; they carry no code and are never initialized
+const KindBlackbox = "blackbox"
+
+// RuntimeSpec describes how to create and drive containers for a runtime kind
+type RuntimeSpec struct {
+	Kind        string
+	Image       string
+	Transport   Transport
+	ExecCommand []string // command run per activation for TransportExec
+	Digest      string   // optional sha256:<hex> pin, preferred over the image tag
+
+	// Defaults applied when an invocation leaves its limits unset
+	DefaultMemoryMB    int
+	DefaultTimeoutMs   int
+	DefaultConcurrency int // zero leaves concurrency unlimited
+}
+
+// digestPattern matches a pinned image digest
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// ValidateDigest checks that digest is a well-formed sha256 image digest
+func ValidateDigest(digest string) error {
+	if !digestPattern.MatchString(digest) {
+		return fmt.Errorf("invalid image digest %q: expected sha256:<64 hex chars>", digest)
+	}
+	return nil
+}
+
+// ImageRef returns the reference containers are created from: the image
+// repository pinned by digest when set, otherwise the tagged image
+func (s RuntimeSpec) ImageRef() string {
+	if s.Digest == "" {
+		return s.Image
+	}
+
+	repo := s.Image
+	if i := strings.LastIndex(repo, "@"); i >= 0 {
+		repo = repo[:i]
+	}
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+	return repo + "@" + s.Digest
+}
+
+// Registry maps runtime kinds to their specs
+type Registry struct {
+	mu    sync.RWMutex
+	specs map[string]RuntimeSpec
+}
+
+// NewRegistry creates a registry with the given runtime specs
+func NewRegistry(specs ...RuntimeSpec) *Registry {
+	r := &Registry{
+		specs: make(map[string]RuntimeSpec, len(specs)),
+	}
+	for _, spec := range specs {
+		r.Register(spec)
+	}
+	return r
+}
+
+// DefaultRegistry returns a registry with the built-in runtimes
+func DefaultRegistry() *Registry {
+	return NewRegistry(
+		RuntimeSpec{
+			Kind:             types.RuntimeKindNodeJS,
+			Image:            "ghcr.io/penguintechinc/openwhisk-arm/nodejs20:latest",
+			DefaultMemoryMB:  256,
+			DefaultTimeoutMs: 60000,
+		},
+		RuntimeSpec{
+			Kind:             types.RuntimeKindPython,
+			Image:            "ghcr.io/penguintechinc/openwhisk-arm/python312:latest",
+			DefaultMemoryMB:  256,
+			DefaultTimeoutMs: 60000,
+		},
+		RuntimeSpec{
+			Kind:             types.RuntimeKindGo,
+			Image:            "ghcr.io/penguintechinc/openwhisk-arm/go123:latest",
+			DefaultMemoryMB:  128,
+			DefaultTimeoutMs: 60000,
+		},
+	)
+}
+
+// BlackboxSpec returns the spec for a blackbox action image
+func BlackboxSpec(image string) RuntimeSpec {
+	return RuntimeSpec{
+		Kind:             KindBlackbox,
+		Image:            image,
+		Transport:        TransportHTTP,
+		DefaultMemoryMB:  256,
+		DefaultTimeoutMs: 60000,
+	}
+}
+
+// ExecSpec returns the spec for a runtime without an HTTP server, driven by
+// running command in its image via docker exec
+func ExecSpec(kind, image string, command []string) RuntimeSpec {
+	return RuntimeSpec{
+		Kind:             kind,
+		Image:            image,
+		Transport:        TransportExec,
+		ExecCommand:      command,
+		DefaultMemoryMB:  256,
+		DefaultTimeoutMs: 60000,
+	}
+}
+
+// Register adds or replaces a runtime spec, defaulting to the HTTP transport
+func (r *Registry) Register(spec RuntimeSpec) {
+	if spec.Transport == "" {
+		spec.Transport = TransportHTTP
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.specs[spec.Kind] = spec
+}
+
+// Pin pins a registered runtime's image to a digest
+func (r *Registry) Pin(kind, digest string) error {
+	if err := ValidateDigest(digest); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spec, ok := r.specs[kind]
+	if !ok {
+		return fmt.Errorf("unknown runtime kind: %s", kind)
+	}
+	spec.Digest = digest
+	r.specs[kind] = spec
+	return nil
+}
+
+// OverrideImage replaces the image of every registered kind of a language,
+// the part of the kind before ":" (e.g. "go" for "go:1.23")
+func (r *Registry) OverrideImage(language, image string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	overridden := false
+	for kind, spec := range r.specs {
+		if lang, _, _ := strings.Cut(kind, ":"); lang == language {
+			spec.Image = image
+			r.specs[kind] = spec
+			overridden = true
+		}
+	}
+	if !overridden {
+		return fmt.Errorf("no runtime kinds for language: %s", language)
+	}
+	return nil
+}
+
+// Images returns the image reference containers of each runtime kind are
+// created from
+func (r *Registry) Images() map[string]string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	images := make(map[string]string, len(r.specs))
+	for kind, spec := range r.specs {
+		images[kind] = spec.ImageRef()
+	}
+	return images
+}
+
+// Lookup returns the spec for a runtime kind
+func (r *Registry) Lookup(kind string) (RuntimeSpec, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	spec, ok := r.specs[kind]
+	return spec, ok
+}
@@ -0,0 +1,36 @@
+package runtime
+
+import (
+	"testing"
+
+	"github.com/penguintechinc/penguinwhisk/invoker/pkg/types"
+)
+
+func TestPinnedRuntimeCreatedByDigest(t *testing.T) {
+	const digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	r := DefaultRegistry()
+
+	if err := r.Pin(types.RuntimeKindGo, digest); err != nil {
+		t.Fatalf("Pin() = %v", err)
+	}
+	want := "ghcr.io/penguintechinc/openwhisk-arm/go123@" + digest
+	spec, _ := r.Lookup(types.RuntimeKindGo)
+	if ref := spec.ImageRef(); ref != want {
+		t.Errorf("ImageRef() = %s, want %s", ref, want)
+	}
+	if image := r.Images()[types.RuntimeKindGo]; image != want {
+		t.Errorf("containers created from %s, want %s", image, want)
+	}
+	if image := r.Images()[types.RuntimeKindPython]; image != "ghcr.io/penguintechinc/openwhisk-arm/python312:latest" {
+		t.Errorf("unpinned runtime created from %s, want its tag", image)
+	}
+
+	for _, bad := range []string{"sha256:abc", "md5:0123456789abcdef0123456789abcdef", digest + "0", "SHA256:" + digest[7:]} {
+		if err := r.Pin(types.RuntimeKindGo, bad); err == nil {
+			t.Errorf("Pin(%q) accepted a malformed digest", bad)
+		}
+	}
+	if err := r.Pin("cobol:85", digest); err == nil {
+		t.Error("Pin() accepted an unknown runtime kind")
+	}
+}
@@ -0,0 +1,94 @@
+package runtime
+
+import (
+	"net/http"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+)
+
+// proxyPhaseSeconds breaks runtime proxy requests into connection phases so
+// slow cold starts can be told apart as network or compute
+var proxyPhaseSeconds = promauto.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "penguinwhisk",
+		Subsystem: "runtime_proxy",
+		Name:      "phase_seconds",
+		Help:      "Duration of runtime proxy request phases.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 16),
+	},
+	[]string{"operation", "phase"},
+)
+
+// phaseTimings records connection phase durations for one request
+type phaseTimings struct {
+	mu           sync.Mutex
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	dns          time.Duration
+	connect      time.Duration
+	firstByte    time.Duration
+}
+
+// traceRequest attaches an httptrace to req and returns a function that
+// records the observed phases once the response has arrived
+func (rp *RuntimeProxy) traceRequest(req *http.Request, operation string) (*http.Request, func()) {
+	t := &phaseTimings{start: time.Now()}
+
+	trace := &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			t.dnsStart = time.Now()
+			t.mu.Unlock()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			t.dns = time.Since(t.dnsStart)
+			t.mu.Unlock()
+		},
+		ConnectStart: func(string, string) {
+			t.mu.Lock()
+			t.connectStart = time.Now()
+			t.mu.Unlock()
+		},
+		ConnectDone: func(string, string, error) {
+			t.mu.Lock()
+			t.connect = time.Since(t.connectStart)
+			t.mu.Unlock()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			t.firstByte = time.Since(t.start)
+			t.mu.Unlock()
+		},
+	}
+
+	finish := func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+
+		phases := map[string]time.Duration{
+			"dns":        t.dns,
+			"connect":    t.connect,
+			"first_byte": t.firstByte,
+		}
+		for phase, d := range phases {
+			if d > 0 {
+				proxyPhaseSeconds.WithLabelValues(operation, phase).Observe(d.Seconds())
+			}
+		}
+
+		rp.logger.Debug("Runtime proxy request phases",
+			zap.String("operation", operation),
+			zap.Duration("dns", t.dns),
+			zap.Duration("connect", t.connect),
+			zap.Duration("firstByte", t.firstByte))
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace)), finish
+}
@@ -0,0 +1,161 @@
+package sizing
+
+import (
+	"context"
+	"math"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	// SuggestionsKey is the Redis hash of suggested memory limits in MB,
+	// keyed by namespace/action
+	SuggestionsKey = "penguinwhisk:memory_suggestions"
+	// DefaultHeadroom is the fraction added on top of p95 peak memory
+	DefaultHeadroom = 0.2
+
+	suggestionPercentile = 0.95
+	maxSamplesPerAction  = 500
+)
+
+// MemorySampler reports a container's memory usage in bytes
+type MemorySampler interface {
+	MemoryUsage(ctx context.Context, containerID string) (uint64, error)
+}
+
+// Advisor accumulates per-action peak memory samples and periodically
+// publishes suggested memory limits for right-sizing
+type Advisor struct {
+	redisClient *redis.Client
+	sampler     MemorySampler
+	interval    time.Duration
+	headroom    float64
+	logger      *zap.Logger
+
+	mu      sync.Mutex
+	samples map[string][]uint64 // action key -> recent peak bytes
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewAdvisor creates a memory sizing advisor
+func NewAdvisor(redisClient *redis.Client, sampler MemorySampler, interval time.Duration, headroom float64, logger *zap.Logger) *Advisor {
+	if headroom < 0 {
+		headroom = DefaultHeadroom
+	}
+	return &Advisor{
+		redisClient: redisClient,
+		sampler:     sampler,
+		interval:    interval,
+		headroom:    headroom,
+		logger:      logger,
+		samples:     make(map[string][]uint64),
+	}
+}
+
+// Sample reads a container's memory usage after an activation and records it
+// against the action
+func (a *Advisor) Sample(ctx context.Context, action, containerID string) {
+	usage, err := a.sampler.MemoryUsage(ctx, containerID)
+	if err != nil {
+		a.logger.Debug("Failed to sample container memory",
+			zap.Error(err),
+			zap.String("action", action))
+		return
+	}
+	a.Record(action, usage)
+}
+
+// Record adds a peak memory sample for an action, keeping the most recent
+func (a *Advisor) Record(action string, peakBytes uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	samples := append(a.samples[action], peakBytes)
+	if len(samples) > maxSamplesPerAction {
+		samples = samples[len(samples)-maxSamplesPerAction:]
+	}
+	a.samples[action] = samples
+}
+
+// Start publishes suggestions every interval in the background
+func (a *Advisor) Start(ctx context.Context) {
+	ctx, a.cancel = context.WithCancel(ctx)
+
+	a.wg.Add(1)
+	go func() {
+		defer a.wg.Done()
+
+		ticker := time.NewTicker(a.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				a.publish(ctx)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops publishing suggestions
+func (a *Advisor) Stop() {
+	if a.cancel != nil {
+		a.cancel()
+	}
+	a.wg.Wait()
+}
+
+// Suggestions computes the suggested memory limit in MB for every action
+// with samples
+func (a *Advisor) Suggestions() map[string]int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	suggestions := make(map[string]int, len(a.samples))
+	for action, samples := range a.samples {
+		suggestions[action] = SuggestMB(samples, a.headroom)
+	}
+	return suggestions
+}
+
+// publish writes the current suggestions to the suggestions hash
+func (a *Advisor) publish(ctx context.Context) {
+	suggestions := a.Suggestions()
+	if len(suggestions) == 0 {
+		return
+	}
+
+	values := make(map[string]any, len(suggestions))
+	for action, mb := range suggestions {
+		values[action] = strconv.Itoa(mb)
+	}
+
+	if err := a.redisClient.HSet(ctx, SuggestionsKey, values).Err(); err != nil && ctx.Err() == nil {
+		a.logger.Error("Failed to publish memory suggestions", zap.Error(err))
+	}
+}
+
+// SuggestMB returns the p95 of the samples plus headroom, rounded up to MB
+func SuggestMB(samples []uint64, headroom float64) int {
+	if len(samples) == 0 {
+		return 0
+	}
+
+	sorted := append([]uint64(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	// Nearest-rank percentile
+	rank := int(math.Ceil(suggestionPercentile*float64(len(sorted)))) - 1
+	p95 := float64(sorted[rank])
+
+	return int(math.Ceil(p95 * (1 + headroom) / (1024 * 1024)))
+}